@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+const configUsage = `usage: fluxgate config <command>
+
+commands:
+  example   print a fully-populated example config with default values
+  schema    print a JSON Schema for the config file`
+
+func runConfigCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New(configUsage)
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	switch args[0] {
+	case "example":
+		out, err = config.Example()
+	case "schema":
+		out, err = config.JSONSchema()
+	default:
+		return fmt.Errorf("unknown config command '%s'\n\n%s", args[0], configUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(out, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fluxgate/fluxgate/internal/access"
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/logging"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/fluxgate/fluxgate/internal/proxy"
+)
+
+type overrides struct {
+	port        int
+	metricsPort int
+	gossipPort  int
+	join        string
+}
+
+func (o overrides) apply(cfg *config.Config) {
+	if o.port != 0 {
+		cfg.Server.Port = o.port
+	}
+	if o.metricsPort != 0 {
+		cfg.Server.MetricsPort = o.metricsPort
+	}
+	if o.gossipPort != 0 {
+		cfg.Server.GossipPort = o.gossipPort
+	}
+	if o.join != "" {
+		cfg.Cluster.JoinAddress = o.join
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	configFile := flag.String("config", "fluxgate.yaml", "Path to the configuration file; comma-separated files are merged in order, later ones overriding")
+	var o overrides
+	flag.IntVar(&o.port, "port", 0, "Proxy port (overrides config)")
+	flag.IntVar(&o.metricsPort, "metrics-port", 0, "Metrics port (overrides config)")
+	flag.IntVar(&o.gossipPort, "gossip-port", 0, "Gossip port (overrides config)")
+	flag.StringVar(&o.join, "join", "", "Address of a cluster member to join (overrides config)")
+	flag.Parse()
+
+	if err := run(strings.Split(*configFile, ","), o); err != nil {
+		log.Fatalf("FluxGate stopped: %v", err)
+	}
+}
+
+func run(configFiles []string, o overrides) error {
+	manager := config.NewManager()
+	manager.Override(o.apply)
+	if err := manager.Load(configFiles...); err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	cfg := manager.Get()
+	if err := logging.Configure(cfg.Logging); err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	if cfg.Metrics.Namespace != metrics.DefaultNamespace {
+		if err := metrics.SetNamespace(cfg.Metrics.Namespace); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	keys, err := cfg.Cluster.Keys()
+	if err != nil {
+		return fmt.Errorf("gossip keys: %w", err)
+	}
+	disc, err := discovery.New(cfg.Server.GossipPort, cfg.Cluster.JoinAddress, keys...)
+	if err != nil {
+		return fmt.Errorf("starting discovery: %w", err)
+	}
+	disc.SetMaxServices(cfg.Limits.MaxServices)
+
+	snapshot := cfg.Cluster.Snapshot
+	if snapshot.Path != "" {
+		// * a corrupt snapshot only costs the head start, never the startup
+		if restored, err := disc.LoadSnapshot(snapshot.Path, snapshot.MaxAge); err != nil {
+			log.Printf("Failed to restore discovery snapshot: %v", err)
+		} else if restored > 0 {
+			log.Printf("Restored %d service instances from %s", restored, snapshot.Path)
+		}
+		go disc.StartSnapshots(ctx, snapshot.Path, snapshot.Interval)
+	}
+
+	srv, err := proxy.New(cfg, disc, cfg.Server.Port)
+	if err != nil {
+		return fmt.Errorf("creating proxy: %w", err)
+	}
+
+	srv.SetConfigManager(manager)
+	manager.Subscribe(func(cfg *config.Config) {
+		if err := logging.Configure(cfg.Logging); err != nil {
+			log.Printf("Failed to reconfigure logging: %v", err)
+		}
+		if keys, err := cfg.Cluster.Keys(); err != nil {
+			log.Printf("Failed to read gossip keys: %v", err)
+		} else if err := disc.SetKeys(keys); err != nil {
+			log.Printf("Failed to update gossip keys: %v", err)
+		}
+		disc.SetMaxServices(cfg.Limits.MaxServices)
+		srv.UpdateConfig(cfg)
+	})
+
+	if cfg.IsHotReloadEnabled() {
+		watcher, err := config.NewWatcher(manager, configFiles...)
+		if err != nil {
+			log.Printf("Config hot reload disabled: %v", err)
+		} else {
+			watcher.Start()
+			defer watcher.Stop()
+		}
+	}
+
+	metricsAccess, err := access.NewPolicy(cfg.Metrics.BearerToken(), cfg.Metrics.AllowedIPs)
+	if err != nil {
+		return fmt.Errorf("metrics access: %w", err)
+	}
+	if cfg.Metrics.TokenEnv != "" && !metricsAccess.Enabled() {
+		return fmt.Errorf("metrics access: token_env %s is unset", cfg.Metrics.TokenEnv)
+	}
+	metricsServer := metrics.NewServer(cfg.Server.MetricsPort)
+	metricsServer.Protect(metricsAccess)
+
+	log.Printf("Starting metrics server on port %d", cfg.Server.MetricsPort)
+	go metricsServer.Run(ctx)
+
+	err = srv.Start(ctx)
+	if snapshot.Path != "" {
+		// * before leaving, which drops this node's own instances
+		if err := disc.SaveSnapshot(snapshot.Path); err != nil {
+			log.Printf("Failed to save discovery snapshot: %v", err)
+		}
+	}
+	if err := logging.CloseSink(); err != nil {
+		log.Printf("Failed to flush access log sink: %v", err)
+	}
+	log.Printf("Shutting down, leaving cluster")
+	disc.Leave(manager.Get().Cluster.LeaveTimeout)
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
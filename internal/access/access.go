@@ -0,0 +1,106 @@
+// Package access guards internal HTTP endpoints with an optional bearer token
+// and client address allowlist.
+package access
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Policy admits requests that carry Token as a bearer token, when set, and
+// come from an address in Allowed, when not empty. The zero Policy admits
+// everything.
+type Policy struct {
+	Token   string
+	Allowed []netip.Prefix
+}
+
+// NewPolicy parses allowed entries, each an IP address or CIDR range.
+func NewPolicy(token string, allowed []string) (*Policy, error) {
+	p := &Policy{Token: token}
+	for _, entry := range allowed {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.Allowed = append(p.Allowed, prefix)
+	}
+	return p, nil
+}
+
+// ParsePrefix parses an IP address or CIDR range, a single address becoming a
+// prefix that only contains it.
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range '%s'", entry)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address '%s'", entry)
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Enabled reports whether the policy restricts anything.
+func (p *Policy) Enabled() bool {
+	return p != nil && (p.Token != "" || len(p.Allowed) > 0)
+}
+
+// Allows reports whether the client at remoteAddr is on the allowlist. The
+// peer address is used as is; forwarding headers are not trusted.
+func (p *Policy) Allows(remoteAddr string) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.Allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) authorized(header string) bool {
+	if p.Token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) == 1
+}
+
+// Wrap returns next guarded by the policy: 403 for addresses off the
+// allowlist, 401 for a missing or wrong token.
+func (p *Policy) Wrap(next http.Handler) http.Handler {
+	if !p.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Allows(r.RemoteAddr) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !p.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fluxgate"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
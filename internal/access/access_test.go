@@ -0,0 +1,59 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicyWrap(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		token      string
+		allowed    []string
+		remoteAddr string
+		auth       string
+		want       int
+	}{
+		{"disabled", "", nil, "203.0.113.9:1234", "", http.StatusOK},
+		{"allowed address", "", []string{"10.0.0.0/8"}, "10.1.2.3:1234", "", http.StatusOK},
+		{"single allowed address", "", []string{"192.0.2.7"}, "192.0.2.7:1234", "", http.StatusOK},
+		{"address off the list", "", []string{"10.0.0.0/8"}, "203.0.113.9:1234", "", http.StatusForbidden},
+		{"ipv6 address", "", []string{"2001:db8::/32"}, "[2001:db8::1]:1234", "", http.StatusOK},
+		{"valid token", "s3cret", nil, "203.0.113.9:1234", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "s3cret", nil, "203.0.113.9:1234", "Bearer nope", http.StatusUnauthorized},
+		{"missing token", "s3cret", nil, "203.0.113.9:1234", "", http.StatusUnauthorized},
+		{"token from a denied address", "s3cret", []string{"10.0.0.0/8"}, "203.0.113.9:1234", "Bearer s3cret", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.token, tt.allowed)
+			if err != nil {
+				t.Fatalf("NewPolicy: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			policy.Wrap(ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestNewPolicyRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		if _, err := NewPolicy("", []string{entry}); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+}
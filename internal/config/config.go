@@ -1,22 +1,46 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fluxgate/fluxgate/internal/access"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server      ServerConfig  `yaml:"server"`
-	TLS         *TLS          `yaml:"tls,omitempty"`
-	HealthCheck HealthConfig  `yaml:"health_check,omitempty"`
-	Timeouts    TimeoutConfig `yaml:"timeouts,omitempty"`
-	Logging     LoggingConfig `yaml:"logging,omitempty"`
-	Cluster     ClusterConfig `yaml:"cluster,omitempty"`
+	Server       ServerConfig             `yaml:"server"`
+	TLS          *TLS                     `yaml:"tls,omitempty"`
+	HealthCheck  HealthConfig             `yaml:"health_check,omitempty"`
+	Timeouts     TimeoutConfig            `yaml:"timeouts,omitempty"`
+	Logging      LoggingConfig            `yaml:"logging,omitempty"`
+	Cluster      ClusterConfig            `yaml:"cluster,omitempty"`
+	LoadBalancer LoadBalancerConfig       `yaml:"load_balancer,omitempty"`
+	Dial         DialConfig               `yaml:"dial,omitempty"`
+	Transport    TransportConfig          `yaml:"transport,omitempty"`
+	ABTests      map[string]ABTestConfig  `yaml:"ab_tests,omitempty"`
+	Tracing      TracingConfig            `yaml:"tracing,omitempty"`
+	Services     map[string]ServiceConfig `yaml:"services,omitempty"`
+	Static       map[string]StaticConfig  `yaml:"static,omitempty"`
+	RetryBudget  RetryBudgetConfig        `yaml:"retry_budget,omitempty"`
+	Queue        QueueConfig              `yaml:"queue,omitempty"`
+	Metrics      MetricsConfig            `yaml:"metrics,omitempty"`
+	Debug        DebugConfig              `yaml:"debug,omitempty"`
+	ForwardProxy ForwardProxyConfig       `yaml:"forward_proxy,omitempty"`
+	Limits       LimitsConfig             `yaml:"limits,omitempty"`
+	Readiness    ReadinessConfig          `yaml:"readiness,omitempty"`
 }
 
 type ServerConfig struct {
@@ -24,73 +48,1020 @@ type ServerConfig struct {
 	MetricsPort int  `yaml:"metrics_port,omitempty"`
 	GossipPort  int  `yaml:"gossip_port,omitempty"`
 	HotReload   bool `yaml:"hot_reload,omitempty"`
+	// HotReloadDebounce waits this long after the last change to the config
+	// file before reloading, so a burst of writes or an atomic replace
+	// reloads once.
+	HotReloadDebounce time.Duration `yaml:"hot_reload_debounce,omitempty"`
+	// Listeners replaces the single proxy listener on Port with several, e.g.
+	// 8443 with TLS next to 8080 in plaintext, all serving the same routes.
+	Listeners []ListenerConfig `yaml:"listeners,omitempty"`
+	// MaxHops answers 508 Loop Detected once a request has passed through
+	// FluxGate this many times, counted in X-FluxGate-Hops. 0 disables it.
+	MaxHops int `yaml:"max_hops,omitempty"`
+	// TrustedProxies are the addresses or CIDR ranges of proxies in front of
+	// FluxGate whose X-Forwarded-Proto is believed, e.g. a TLS-terminating
+	// load balancer. From any other client the header is ignored.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+}
+
+// ListenerConfig is one proxy port. TLS listeners serve the certificate of
+// the tls section, the others plaintext.
+type ListenerConfig struct {
+	Port int  `yaml:"port"`
+	TLS  bool `yaml:"tls,omitempty"`
 }
 
 type HealthConfig struct {
+	// Enabled probes every backend each Interval, taking those failing out of
+	// rotation. Off by default: backends are then only probed when warming up
+	// or joining a rollout.
+	Enabled  bool          `yaml:"enabled,omitempty"`
 	Interval time.Duration `yaml:"interval,omitempty"`
 	Timeout  time.Duration `yaml:"timeout,omitempty"`
 	Path     string        `yaml:"path,omitempty"`
+	// Paths probes several paths instead of Path. With Require "any" (the
+	// default) a backend is healthy once one of them answers as expected,
+	// with "all" every one must. Each probe gets its own Timeout.
+	Paths   []string `yaml:"paths,omitempty"`
+	Require string   `yaml:"require,omitempty"`
+	// Jitter randomly delays each endpoint's probe by up to this duration
+	Jitter        time.Duration `yaml:"jitter,omitempty"`
+	JitterInitial bool          `yaml:"jitter_initial,omitempty"`
+	// WarmupGrace keeps backends learned from other nodes out of rotation until
+	// a local probe passes or the grace expires, 0 routes to them immediately
+	WarmupGrace time.Duration `yaml:"warmup_grace,omitempty"`
+	// UnhealthyOnTLSError takes a backend out of rotation when its certificate
+	// fails verification, until a health check passes again
+	UnhealthyOnTLSError bool `yaml:"unhealthy_on_tls_error,omitempty"`
+	// UseTrafficPool probes backends over the pooled connections proxied
+	// requests use, so connections a backend silently drops fail the check
+	// rather than only client requests.
+	UseTrafficPool bool `yaml:"use_traffic_pool,omitempty"`
+	// LatencyThreshold sheds weighted traffic from slow but healthy backends:
+	// above it, a backend's weight is scaled by threshold/probe latency, down
+	// to MinWeightFactor. 0 disables it.
+	LatencyThreshold time.Duration `yaml:"latency_threshold,omitempty"`
+	MinWeightFactor  float64       `yaml:"min_weight_factor,omitempty"`
 }
 
 type TimeoutConfig struct {
 	Read  time.Duration `yaml:"read,omitempty"`
 	Write time.Duration `yaml:"write,omitempty"`
 	Idle  time.Duration `yaml:"idle,omitempty"`
+	// Connect bounds establishing a backend connection
+	Connect time.Duration `yaml:"connect,omitempty"`
+	// Response bounds waiting for the backend's response headers (TTFB), 0 disables it
+	Response time.Duration `yaml:"response,omitempty"`
+	// Request bounds handling a whole proxied request, body transfer included,
+	// 0 disables it. WebSocket and streaming services are exempt.
+	Request time.Duration `yaml:"request,omitempty"`
+	// ExpectContinue is how long a request with "Expect: 100-continue" waits
+	// for the backend's interim response before its body is sent anyway
+	// (default 1s). Negative sends the body right away.
+	ExpectContinue time.Duration `yaml:"expect_continue,omitempty"`
 }
 
 type LoggingConfig struct {
 	Level  string `yaml:"level,omitempty"`
 	Format string `yaml:"format,omitempty"`
+	// Outputs lists log destinations: stderr, stdout or a file path
+	Outputs  []string     `yaml:"outputs,omitempty"`
+	Rotation RotateConfig `yaml:"rotation,omitempty"`
+	// AccessLog emits one line per proxied request
+	AccessLog bool `yaml:"access_log,omitempty"`
+	// MalformedRequests is the level at which requests the HTTP server
+	// rejects before routing, e.g. garbled request lines, and failed TLS
+	// handshakes are logged (default debug). They are always counted.
+	MalformedRequests string `yaml:"malformed_requests,omitempty"`
+	// SlowRequestThreshold logs every proxied request taking longer than it
+	// as a warning naming the backend that served it, whether or not access
+	// logging is on. 0 disables it.
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold,omitempty"`
+	// AccessLogSink ships access log entries to a log aggregator instead of
+	// the log outputs
+	AccessLogSink *AccessLogSinkConfig `yaml:"access_log_sink,omitempty"`
+}
+
+// AccessLogSinkConfig selects where access log entries are shipped. Type is
+// "http", which POSTs JSON arrays of up to BatchSize (default 100) entries to
+// URL at least every FlushInterval (default 1s), or a sink registered by an
+// embedder. Up to BufferSize (default 10000) entries wait in memory, further
+// ones are dropped; a failed batch is retried MaxRetries (default 3) times
+// with backoff before it is dropped.
+type AccessLogSinkConfig struct {
+	Type          string        `yaml:"type"`
+	URL           string        `yaml:"url,omitempty"`
+	Token         string        `yaml:"token,omitempty"`
+	TokenEnv      string        `yaml:"token_env,omitempty"`
+	BatchSize     int           `yaml:"batch_size,omitempty"`
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"`
+	BufferSize    int           `yaml:"buffer_size,omitempty"`
+	MaxRetries    int           `yaml:"max_retries,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+}
+
+// BearerToken returns the token sent to the sink from its configured source,
+// empty for none.
+func (a AccessLogSinkConfig) BearerToken() string {
+	if a.TokenEnv != "" {
+		return os.Getenv(a.TokenEnv)
+	}
+	return a.Token
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// Logs reports whether messages at level pass the configured log level.
+func (l LoggingConfig) Logs(level string) bool {
+	return logLevels[strings.ToLower(level)] >= logLevels[strings.ToLower(l.Level)]
+}
+
+// RotateConfig controls rotation of file log outputs, zero values disable a limit.
+type RotateConfig struct {
+	MaxSizeMB  int           `yaml:"max_size_mb,omitempty"`
+	MaxAge     time.Duration `yaml:"max_age,omitempty"`
+	MaxBackups int           `yaml:"max_backups,omitempty"`
+}
+
+// DialConfig tunes the TCP connections opened to backends.
+type DialConfig struct {
+	// KeepAlive is the TCP keep-alive probe interval, negative disables probing
+	KeepAlive time.Duration `yaml:"keep_alive,omitempty"`
+	// NoDelay toggles TCP_NODELAY (Nagle's algorithm disabled), defaults to true
+	NoDelay *bool `yaml:"tcp_nodelay,omitempty"`
+	// FallbackDelay is the RFC 6555 dual-stack fallback delay, negative disables it
+	FallbackDelay time.Duration `yaml:"fallback_delay,omitempty"`
+}
+
+func (d DialConfig) TCPNoDelay() bool {
+	return d.NoDelay == nil || *d.NoDelay
+}
+
+// TransportConfig tunes connection pooling towards backends. Each backend gets
+// its own pool, so age and flush settings apply per backend.
+type TransportConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout,omitempty"`
+	// MaxPoolAge replaces a backend's whole connection pool once the pool is
+	// this old, whatever the age of its connections, 0 disables it
+	MaxPoolAge time.Duration `yaml:"max_pool_age,omitempty"`
+	// IdleFlushInterval periodically closes all idle backend connections, 0 disables it
+	IdleFlushInterval time.Duration `yaml:"idle_flush_interval,omitempty"`
+	// MaxResponseHeaders caps the number of backend response header fields,
+	// 0 means unlimited. MaxResponseHeaderBytes caps their total size (default
+	// 1 MiB). Responses over either limit are answered with 502.
+	MaxResponseHeaders     int   `yaml:"max_response_headers,omitempty"`
+	MaxResponseHeaderBytes int64 `yaml:"max_response_header_bytes,omitempty"`
+	// Scheme is how backends are reached, http (default) or https, unless an
+	// instance sets metadata["scheme"]
+	Scheme string `yaml:"scheme,omitempty"`
+}
+
+type LoadBalancerConfig struct {
+	// Algorithm is round_robin (default), least_connection, least_load,
+	// weighted_random or the name of a registered custom algorithm
+	Algorithm string `yaml:"algorithm,omitempty"`
+	// MaxConnections caps concurrent connections per backend, 0 means unlimited.
+	// Instances can override it with metadata["max_connections"].
+	MaxConnections int `yaml:"max_connections,omitempty"`
+}
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// ServiceConfig holds per-service overrides keyed by service name.
+type ServiceConfig struct {
+	// RewriteLocation maps redirects to backend-internal hosts back onto the
+	// gateway host and service prefix
+	RewriteLocation bool `yaml:"rewrite_location,omitempty"`
+	// RewriteCookies scopes Set-Cookie paths under the service prefix and
+	// replaces the Domain with CookieDomain, dropping it when empty
+	RewriteCookies bool   `yaml:"rewrite_cookies,omitempty"`
+	CookieDomain   string `yaml:"cookie_domain,omitempty"`
+	// LoadBalancer overrides load_balancer.algorithm for the service
+	LoadBalancer string `yaml:"load_balancer,omitempty"`
+	// Weights overrides the weight of backends, keyed by "address:port", over
+	// the weight instances register in metadata["weight"] and the weight API.
+	// Reloading the config retunes backends already in rotation.
+	Weights map[string]int `yaml:"weights,omitempty"`
+	// LoadHeader names a response header in which backends report their
+	// current load, e.g. queue depth, as a non-negative number. Its moving
+	// average biases the least_load algorithm.
+	LoadHeader string `yaml:"load_header,omitempty"`
+	// RequestTimeout overrides timeouts.request for the service
+	RequestTimeout time.Duration `yaml:"request_timeout,omitempty"`
+	// Streaming exempts the service from the request deadline, for long-lived
+	// responses such as server-sent events
+	Streaming bool `yaml:"streaming,omitempty"`
+	// Retries is how many other backends a bodyless request is retried on when
+	// connecting to its backend fails, subject to the retry budget
+	Retries int `yaml:"retries,omitempty"`
+	// RetryOn lists backend response statuses, e.g. [502, 503, 504], that are
+	// retried like connect failures. The response is discarded before any of
+	// it reaches the client; the last attempt's response is passed through.
+	RetryOn []int `yaml:"retry_on,omitempty"`
+	// RetryTimeout bounds a request across all its attempts: once it's spent
+	// no further attempt is made and the client gets 504, even with retries
+	// left. TryTimeout bounds each attempt's wait for response headers; an
+	// attempt that runs out is retried like a connect failure. 0 disables
+	// either.
+	RetryTimeout time.Duration `yaml:"retry_timeout,omitempty"`
+	TryTimeout   time.Duration `yaml:"try_timeout,omitempty"`
+	// DecodeResponses decompresses gzip backend responses for clients whose
+	// Accept-Encoding doesn't allow gzip. Off keeps responses byte-for-byte.
+	DecodeResponses bool `yaml:"decode_responses,omitempty"`
+	// DecodeRequests decompresses gzip request bodies before forwarding, for
+	// backends that can't. Bodies decoding to more than MaxDecodedBody bytes
+	// (default 10 MiB) are rejected with 413.
+	DecodeRequests bool  `yaml:"decode_requests,omitempty"`
+	MaxDecodedBody int64 `yaml:"max_decoded_body,omitempty"`
+	// HostHeader sets the Host sent to backends: "preserve" (default) forwards
+	// the client's Host, "backend" uses the backend address, anything else is
+	// sent as-is
+	HostHeader string `yaml:"host_header,omitempty"`
+	// TrailingSlash sets the form of the path sent to backends: "preserve"
+	// (default) forwards it as the client sent it, "strip" removes trailing
+	// slashes and "add" appends one. With TrailingSlashRedirect, clients
+	// using the other form are redirected to the canonical path instead.
+	TrailingSlash         string `yaml:"trailing_slash,omitempty"`
+	TrailingSlashRedirect bool   `yaml:"trailing_slash_redirect,omitempty"`
+	// Signing signs forwarded requests so backends can verify they came
+	// through the gateway
+	Signing *SigningConfig `yaml:"signing,omitempty"`
+	// Static answers every request with the named static responder instead of
+	// proxying, e.g. a maintenance page during deploys
+	Static string `yaml:"static,omitempty"`
+	// ParamHeaders forwards path parameters captured by the service's routes
+	// as headers named by the template, with {name} replaced by the parameter
+	// name, e.g. "X-Route-Param-{name}". Empty disables forwarding.
+	ParamHeaders string `yaml:"param_headers,omitempty"`
+	// QueueDepth and QueueTimeout override queue.max_depth and queue.timeout
+	// for the service
+	QueueDepth   int           `yaml:"queue_depth,omitempty"`
+	QueueTimeout time.Duration `yaml:"queue_timeout,omitempty"`
+	// FairQueue shares the service's connection slots between tenants while
+	// requests queue for them, instead of first come first served
+	FairQueue *FairQueueConfig `yaml:"fair_queue,omitempty"`
+	// Pools lists backend pools (instance metadata "pool") in priority order.
+	// Traffic goes to the first pool with a healthy backend and fails back as
+	// soon as a higher pool recovers. Instances in unlisted pools come last.
+	Pools []string `yaml:"pools,omitempty"`
+	// MethodRewrite forwards requests of one method as another, e.g.
+	// {PUT: POST} for backends that don't understand PUT. Metrics keep the
+	// client's method.
+	MethodRewrite map[string]string `yaml:"method_rewrite,omitempty"`
+	// PreDial opens up to this many connections to a newly discovered backend
+	// with concurrent health probes before it joins the rotation, so the
+	// first requests don't pay for TCP and TLS handshakes. Capped by the
+	// backend's max_connections and transport.max_idle_conns_per_host.
+	PreDial int `yaml:"pre_dial,omitempty"`
+	// HeaderCase lists header names to send to backends spelled exactly as
+	// given, e.g. "SOAPAction", for legacy backends that are sensitive to
+	// casing. Other headers keep Go's canonical form. HTTP/1 only, HTTP/2
+	// lowercases every header.
+	HeaderCase []string `yaml:"header_case,omitempty"`
+	// Compression gzips uncompressed responses for clients that accept gzip
+	Compression *CompressionConfig `yaml:"compression,omitempty"`
+	// OnUnavailable decides what answers requests while the service has no
+	// healthy backend
+	OnUnavailable UnavailableConfig `yaml:"on_unavailable,omitempty"`
+	// DisabledStatus is what requests get while the service is disabled
+	// through the management API, 503 by default
+	DisabledStatus int `yaml:"disabled_status,omitempty"`
+	// AutoOptions answers OPTIONS requests at the gateway with 204 and an
+	// Allow header listing the route's methods, for backends that don't
+	AutoOptions bool `yaml:"auto_options,omitempty"`
+	// Idempotency forwards requests carrying an idempotency key at most once
+	// per key, replaying the response to duplicates
+	Idempotency *IdempotencyConfig `yaml:"idempotency,omitempty"`
+	// Coalesce shares one upstream request among identical concurrent GETs
+	Coalesce *CoalesceConfig `yaml:"coalesce,omitempty"`
+	// Affinity pins requests to backends by the value of a request header
+	Affinity *AffinityConfig `yaml:"affinity,omitempty"`
+	// AccessLog selects what logging.access_log records for the service
+	AccessLog *AccessLogConfig `yaml:"access_log,omitempty"`
+	// DebugBodies adds request and response bodies to the requests kept for
+	// /api/v1/debug/lastrequest. Expensive, enable it briefly.
+	DebugBodies *DebugBodiesConfig `yaml:"debug_bodies,omitempty"`
+	// LatencySLO shifts weighted traffic away from backends whose upstream
+	// latency drifts above an objective, before they fail outright
+	LatencySLO *LatencySLOConfig `yaml:"latency_slo,omitempty"`
+}
+
+// LatencySLOConfig compares each backend's Percentile (default 99) of time to
+// response headers over the last Window (default 1m) against Threshold. A
+// backend above it has its weight scaled by threshold/latency, down to
+// MinWeightFactor (default 0.1), until it recovers. Backends with fewer than
+// MinSamples (default 20) requests in the window keep their current weight.
+// Only weighted balancing (weighted_random, least_load) follows weights.
+type LatencySLOConfig struct {
+	Threshold       time.Duration `yaml:"threshold"`
+	Percentile      float64       `yaml:"percentile,omitempty"`
+	Window          time.Duration `yaml:"window,omitempty"`
+	MinSamples      int           `yaml:"min_samples,omitempty"`
+	MinWeightFactor float64       `yaml:"min_weight_factor,omitempty"`
+}
+
+// DebugBodiesConfig captures up to MaxBytes (default 64 KiB) of the request
+// and/or response body of a service's requests as they stream past, for the
+// debug endpoints. JSON and form bodies have the service's access_log redact
+// fields masked; ones that can't be parsed, e.g. when truncated, are withheld.
+// Requires debug.enabled.
+type DebugBodiesConfig struct {
+	Request  bool  `yaml:"request,omitempty"`
+	Response bool  `yaml:"response,omitempty"`
+	MaxBytes int64 `yaml:"max_bytes,omitempty"`
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogFields are the access log fields logged when a service doesn't
+// list its own.
+var AccessLogFields = []string{"service", "method", "path", "status", "duration", "trace_id"}
+
+// AccessLogConfig picks the fields of a service's access log lines and which
+// values are masked in them.
+type AccessLogConfig struct {
+	// Fields lists the fields to log, in order: service, method, path, query,
+	// status, duration, trace_id, remote_addr or header:<Name>. Defaults to
+	// AccessLogFields.
+	Fields []string `yaml:"fields,omitempty"`
+	// Redact lists header and query parameter names, case-insensitive, whose
+	// values are logged as ***. Credential headers such as Authorization and
+	// Cookie are always redacted.
+	Redact []string `yaml:"redact,omitempty"`
+}
+
+var accessLogFields = map[string]bool{
+	"service": true, "method": true, "path": true, "query": true,
+	"status": true, "duration": true, "trace_id": true, "remote_addr": true,
+}
+
+// AffinityConfig hashes the value of Header to pick the same backend for
+// every request carrying it, e.g. a tenant ID for cache locality. A key only
+// moves when its backend becomes unhealthy, leaves or is at capacity.
+type AffinityConfig struct {
+	Header string `yaml:"header"`
+	// Missing is what happens to requests without the header: "balance"
+	// (default) uses the service's load balancer, "reject" answers 400
+	Missing string `yaml:"missing,omitempty"`
+}
+
+// IdempotencyConfig keys requests by service and the value of Header. The
+// first request with a key is forwarded; duplicates arriving while it is in
+// flight wait for it, and later ones within TTL get its response replayed.
+type IdempotencyConfig struct {
+	// Header defaults to Idempotency-Key
+	Header string `yaml:"header,omitempty"`
+	// TTL is how long a response is replayed after it completes, default 10m
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// CoalesceConfig keys GET requests by service, request URI and the Vary
+// headers. While one is in flight, identical ones wait for it and get its
+// response instead of reaching the backend.
+type CoalesceConfig struct {
+	// Vary lists request headers whose values must also match, such as
+	// Accept-Encoding. Authorization and Cookie always must.
+	Vary []string `yaml:"vary,omitempty"`
+}
+
+// UnavailableConfig is the behavior of a service without healthy backends.
+// Action is one of:
+//   - "error" (default): 503 No healthy backends
+//   - "stale": the last successful response to the same GET, if cached within
+//     StaleMaxAge (default 1h), else 503
+//   - "static": the static responder named by Static
+//   - "fallback": proxy to the service named by Fallback, else 503
+type UnavailableConfig struct {
+	Action      string        `yaml:"action,omitempty"`
+	Static      string        `yaml:"static,omitempty"`
+	Fallback    string        `yaml:"fallback,omitempty"`
+	StaleMaxAge time.Duration `yaml:"stale_max_age,omitempty"`
+}
+
+// SigningConfig adds X-FluxGate-Timestamp (unix seconds) and
+// X-FluxGate-Signature (hex HMAC) to forwarded requests. The signed string is
+// "METHOD\nREQUEST_URI\nTIMESTAMP" where REQUEST_URI is the path and query
+// as sent to the backend.
+type SigningConfig struct {
+	Secret string `yaml:"secret,omitempty"`
+	// SecretEnv names an environment variable holding the secret instead
+	SecretEnv string `yaml:"secret_env,omitempty"`
+	// Algorithm is "sha256" (default) or "sha512"
+	Algorithm string `yaml:"algorithm,omitempty"`
+}
+
+// CompressionConfig selects which responses are gzipped and how hard.
+// ContentTypes and ExcludeContentTypes hold media types, optionally with a
+// "text/*" style wildcard; exclusions win.
+type CompressionConfig struct {
+	// Level is the gzip level, 1 (fastest) to 9 (smallest), default 6
+	Level int `yaml:"level,omitempty"`
+	// ContentTypes defaults to text-like types: text/*, JSON, JavaScript,
+	// XML and SVG
+	ContentTypes []string `yaml:"content_types,omitempty"`
+	// ExcludeContentTypes defaults to text/event-stream, which must not be
+	// buffered
+	ExcludeContentTypes []string `yaml:"exclude_content_types,omitempty"`
+	// MinSize skips responses with a known length below it, default 1024
+	MinSize int64 `yaml:"min_size,omitempty"`
+}
+
+var (
+	defaultCompressedTypes   = []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+	defaultUncompressedTypes = []string{"text/event-stream"}
+)
+
+// Compresses reports whether responses of mediaType are compressed.
+func (c *CompressionConfig) Compresses(mediaType string) bool {
+	return matchesMediaType(c.ContentTypes, mediaType) && !matchesMediaType(c.ExcludeContentTypes, mediaType)
+}
+
+func matchesMediaType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if prefix, wildcard := strings.CutSuffix(pattern, "/*"); wildcard {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the signing secret from its configured source.
+func (s *SigningConfig) Key() []byte {
+	if s.SecretEnv != "" {
+		return []byte(os.Getenv(s.SecretEnv))
+	}
+	return []byte(s.Secret)
+}
+
+// RetryBudgetConfig caps retries across all services to Ratio of the requests
+// seen in each Window, with MinRetries always allowed so low traffic can retry.
+type RetryBudgetConfig struct {
+	Ratio      float64       `yaml:"ratio,omitempty"`
+	Window     time.Duration `yaml:"window,omitempty"`
+	MinRetries int           `yaml:"min_retries,omitempty"`
+}
+
+// MetricsConfig controls the Prometheus exposition. Namespace prefixes every
+// metric name (default "fluxgate"). Like the access settings it only takes
+// effect on restart.
+type MetricsConfig struct {
+	Namespace string `yaml:"namespace,omitempty"`
+	// Token requires "Authorization: Bearer <token>" to scrape, TokenEnv
+	// names an environment variable holding it instead
+	Token    string `yaml:"token,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// AllowedIPs restricts scraping to these addresses or CIDR ranges
+	AllowedIPs []string `yaml:"allowed_ips,omitempty"`
+	// Hosts are the host names per-host metrics label by name; any other
+	// Host header is counted as "other", so clients can't create series
+	Hosts []string `yaml:"hosts,omitempty"`
+}
+
+// BearerToken returns the scrape token from its configured source, empty when
+// scraping is unauthenticated.
+func (m MetricsConfig) BearerToken() string {
+	if m.TokenEnv != "" {
+		return os.Getenv(m.TokenEnv)
+	}
+	return m.Token
+}
+
+// ReadinessConfig fails /api/v1/ready while a critical service is degraded,
+// so an L4 load balancer can route around this node. A service is degraded
+// when more than MaxUnhealthyPercent (default 50) of its backends are
+// unhealthy or ejected; one without backends counts as fully unhealthy.
+type ReadinessConfig struct {
+	CriticalServices    []string `yaml:"critical_services,omitempty"`
+	MaxUnhealthyPercent float64  `yaml:"max_unhealthy_percent,omitempty"`
+}
+
+// LimitsConfig caps what the gateway holds, as a safety valve against
+// runaway registrations or poisoned gossip. 0 means unlimited.
+type LimitsConfig struct {
+	// MaxServices caps the services with at least one instance, registrations
+	// of further services through the API or gossip are refused
+	MaxServices int `yaml:"max_services,omitempty"`
+	// MaxRoutes caps the route table; a service whose routes don't fit keeps
+	// only the ones that do, and a new one gets none
+	MaxRoutes int `yaml:"max_routes,omitempty"`
+}
+
+// ForwardProxyConfig lets clients open CONNECT tunnels through FluxGate to
+// allowlisted targets. It is off unless Enabled, and never an open proxy:
+// CONNECT to any target not in AllowedTargets is refused.
+type ForwardProxyConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// AllowedTargets lists host:port pairs, the host optionally a
+	// "*.example.com" wildcard matching its subdomains
+	AllowedTargets []string `yaml:"allowed_targets,omitempty"`
+	// AllowedIPs restricts which clients may tunnel to these addresses or
+	// CIDR ranges, empty allowing any client
+	AllowedIPs []string `yaml:"allowed_ips,omitempty"`
+}
+
+// Allows reports whether target, a CONNECT host:port, is allowlisted.
+func (f ForwardProxyConfig) Allows(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range f.AllowedTargets {
+		allowedHost, allowedPort, err := net.SplitHostPort(allowed)
+		if err != nil || allowedPort != port {
+			continue
+		}
+		allowedHost = strings.ToLower(allowedHost)
+		if suffix, ok := strings.CutPrefix(allowedHost, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == allowedHost {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ForwardProxyConfig) validate() error {
+	if f.Enabled && len(f.AllowedTargets) == 0 {
+		return fmt.Errorf("forward_proxy requires allowed_targets")
+	}
+	for _, target := range f.AllowedTargets {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" {
+			return fmt.Errorf("forward_proxy allowed_targets entry '%s' must be host:port", target)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("forward_proxy allowed_targets entry '%s' needs a numeric port", target)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("forward_proxy allowed_targets entry '%s' may only use a leading *. wildcard", target)
+		}
+	}
+	for _, entry := range f.AllowedIPs {
+		if _, err := access.ParsePrefix(entry); err != nil {
+			return fmt.Errorf("forward_proxy allowed_ips: %w", err)
+		}
+	}
+	return nil
+}
+
+// DebugConfig enables the /api/v1/debug endpoints, which expose what was
+// forwarded to backends. They are off unless Enabled and then require a token,
+// an address allowlist or both.
+type DebugConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Token requires "Authorization: Bearer <token>", TokenEnv names an
+	// environment variable holding it instead
+	Token    string `yaml:"token,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// AllowedIPs restricts access to these addresses or CIDR ranges
+	AllowedIPs []string `yaml:"allowed_ips,omitempty"`
+	// Captured is how many forwarded requests are kept per service, default 20
+	Captured int `yaml:"captured,omitempty"`
+}
+
+// BearerToken returns the debug token from its configured source.
+func (d DebugConfig) BearerToken() string {
+	if d.TokenEnv != "" {
+		return os.Getenv(d.TokenEnv)
+	}
+	return d.Token
+}
+
+var metricNamespacePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// QueueConfig lets requests wait in a per-service FIFO queue for a backend
+// connection slot (see load_balancer.max_connections) instead of failing at
+// once. MaxDepth 0 disables queueing.
+type QueueConfig struct {
+	MaxDepth int           `yaml:"max_depth,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+}
+
+// FairQueueConfig identifies the tenant of a request by the value of Header.
+// While requests queue for a connection slot, each tenant is woken in
+// proportion to its weight in Weights, DefaultWeight (default 1) for tenants
+// not listed and requests without the header, so a surge from one tenant
+// can't starve the others. Tenants not listed are reported as "other" in
+// metrics. The queue depth is shared by all tenants.
+type FairQueueConfig struct {
+	Header        string         `yaml:"header"`
+	Weights       map[string]int `yaml:"weights,omitempty"`
+	DefaultWeight int            `yaml:"default_weight,omitempty"`
+}
+
+// Weight returns the share of a tenant.
+func (f *FairQueueConfig) Weight(tenant string) int {
+	if weight, ok := f.Weights[tenant]; ok {
+		return weight
+	}
+	if f.DefaultWeight > 0 {
+		return f.DefaultWeight
+	}
+	return 1
+}
+
+// TenantLabel returns the tenant as reported in metrics, "other" for tenants
+// without a configured weight to bound the label values.
+func (f *FairQueueConfig) TenantLabel(tenant string) string {
+	if _, ok := f.Weights[tenant]; ok {
+		return tenant
+	}
+	return "other"
+}
+
+// StaticConfig is a fixed response served in place of a service. Static
+// responders can also be used as A/B test bucket services.
+type StaticConfig struct {
+	Status      int    `yaml:"status,omitempty"`
+	ContentType string `yaml:"content_type,omitempty"`
+	Body        string `yaml:"body,omitempty"`
+}
+
+// ABTestConfig buckets clients into variant services by a sticky cookie.
+// The test is exposed under /{name}/* like a discovered service.
+type ABTestConfig struct {
+	CookieName string        `yaml:"cookie_name,omitempty"`
+	CookieTTL  time.Duration `yaml:"cookie_ttl,omitempty"`
+	Buckets    []ABBucket    `yaml:"buckets"`
+}
+
+type ABBucket struct {
+	Name    string `yaml:"name"`
+	Service string `yaml:"service"`
+	Weight  int    `yaml:"weight"`
+}
+
+// TracingConfig controls request correlation. Propagation is "w3c" (traceparent
+// plus request ID header), "request-id" (request ID header only) or "none".
+type TracingConfig struct {
+	Propagation     string `yaml:"propagation,omitempty"`
+	RequestIDHeader string `yaml:"request_id_header,omitempty"`
 }
 
 type ClusterConfig struct {
 	JoinAddress string `yaml:"join_address,omitempty"`
+	// LeaveTimeout bounds deregistering local instances and leaving on shutdown
+	LeaveTimeout time.Duration `yaml:"leave_timeout,omitempty"`
+	// ReadOnly makes the node observe-only: it routes from gossiped state but
+	// refuses registration and deregistration through its API
+	ReadOnly bool `yaml:"read_only,omitempty"`
+	// ReservedNames and ReservedPrefixes refuse registrations of matching
+	// service names, on top of the built-in api, health, metrics, v1 and
+	// names starting with "_"
+	ReservedNames    []string `yaml:"reserved_names,omitempty"`
+	ReservedPrefixes []string `yaml:"reserved_prefixes,omitempty"`
+	// StartupGrace keeps /api/v1/ready failing after start until discovery has
+	// synced with the cluster or the grace expires, 0 disables it. With
+	// StartupReject, proxied requests get 503 and Retry-After meanwhile.
+	StartupGrace  time.Duration `yaml:"startup_grace,omitempty"`
+	StartupReject bool          `yaml:"startup_reject,omitempty"`
+	// Snapshot persists the discovered services so a full cluster restart
+	// doesn't start with an empty routing table
+	Snapshot SnapshotConfig `yaml:"snapshot,omitempty"`
+	// GossipKeys encrypts gossip between nodes, each key base64 of 16, 24 or
+	// 32 bytes (AES-128, -192 or -256). The first key encrypts, the others are
+	// only accepted when decrypting, so a key can be rotated without downtime:
+	// add the new key on every node, move it first, then drop the old one.
+	// GossipKeysEnv names an environment variable holding the keys,
+	// comma-separated, instead. Encryption can't be turned on or off by a
+	// reload, only the keys changed.
+	GossipKeys    []string `yaml:"gossip_keys,omitempty"`
+	GossipKeysEnv string   `yaml:"gossip_keys_env,omitempty"`
+}
+
+// Keys returns the decoded gossip keys from their configured source, primary
+// first, or nil when gossip isn't encrypted.
+func (c ClusterConfig) Keys() ([][]byte, error) {
+	encoded := c.GossipKeys
+	if c.GossipKeysEnv != "" {
+		encoded = nil
+		for _, key := range strings.Split(os.Getenv(c.GossipKeysEnv), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				encoded = append(encoded, key)
+			}
+		}
+	}
+
+	keys := make([][]byte, 0, len(encoded))
+	for i, key := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("gossip key %d is not valid base64", i+1)
+		}
+		if n := len(decoded); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("gossip key %d must be 16, 24 or 32 bytes, got %d", i+1, n)
+		}
+		keys = append(keys, decoded)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return keys, nil
 }
 
+// SnapshotConfig saves the services map to Path every Interval (default 30s)
+// and loads it on startup unless it is older than MaxAge (default 24h, negative
+// never expires). An empty Path disables snapshots.
+type SnapshotConfig struct {
+	Path     string        `yaml:"path,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	MaxAge   time.Duration `yaml:"max_age,omitempty"`
+}
+
+// TLS takes the certificate and key from exactly one source: files, inline
+// PEM, or environment variables holding PEM.
 type TLS struct {
-	CertFile string `yaml:"cert_file"`
-	KeyFile  string `yaml:"key_file"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	CertPEM  string `yaml:"cert_pem,omitempty"`
+	KeyPEM   string `yaml:"key_pem,omitempty"`
+	CertEnv  string `yaml:"cert_env,omitempty"`
+	KeyEnv   string `yaml:"key_env,omitempty"`
+	// MinVersion is "1.2" (default) or "1.3"
+	MinVersion string `yaml:"min_version,omitempty"`
+	// CipherSuites restricts TLS 1.2 cipher suites by Go name, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 suites aren't configurable.
+	CipherSuites []string `yaml:"cipher_suites,omitempty"`
+	// OCSPStapling fetches OCSP responses for the certificate from its
+	// responder and staples them to handshakes. The certificate chain must
+	// include the issuer.
+	OCSPStapling bool `yaml:"ocsp_stapling,omitempty"`
+	// ClientCAFile turns on mTLS: client certificates are verified against
+	// the CAs in it. ClientAuth is "request" (default), which verifies a
+	// certificate when one is presented, or "require".
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+	ClientAuth   string `yaml:"client_auth,omitempty"`
+	// ClientCertHeaders forwards the verified client certificate to backends
+	ClientCertHeaders *ClientCertHeadersConfig `yaml:"client_cert_headers,omitempty"`
+}
+
+// ClientCertHeadersConfig names the headers the verified client certificate
+// is forwarded in; empty ones aren't sent. Cert is the URL-encoded PEM,
+// Subject the RFC 2253 distinguished name, SANs the comma-separated subject
+// alternative names prefixed by type, e.g. "DNS:api.example.com,IP:10.0.0.1",
+// Serial the hex serial number and
+// Fingerprint the hex SHA-256 of the DER certificate. The headers are always
+// removed from client requests so they can't be spoofed.
+type ClientCertHeadersConfig struct {
+	Cert        string `yaml:"cert,omitempty"`
+	Subject     string `yaml:"subject,omitempty"`
+	SANs        string `yaml:"sans,omitempty"`
+	Serial      string `yaml:"serial,omitempty"`
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+}
+
+// Names returns the configured header names.
+func (c ClientCertHeadersConfig) Names() []string {
+	var names []string
+	for _, name := range []string{c.Cert, c.Subject, c.SANs, c.Serial, c.Fingerprint} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Version returns the minimum TLS version, TLS 1.2 unless configured.
+func (t *TLS) Version() uint16 {
+	if version, ok := tlsVersions[t.MinVersion]; ok {
+		return version
+	}
+	return tls.VersionTLS12
+}
+
+// CipherSuiteIDs returns the configured cipher suites, nil when unset.
+func (t *TLS) CipherSuiteIDs() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		if suite := cipherSuite(name); suite != nil {
+			ids = append(ids, suite.ID)
+		}
+	}
+	return ids
+}
+
+func cipherSuite(name string) *tls.CipherSuite {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite
+		}
+	}
+	return nil
+}
+
+func isInsecureCipherSuite(name string) bool {
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TLS) validateProtocol() error {
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+	case "1.0", "1.1":
+		return fmt.Errorf("tls min_version %s is not allowed, use 1.2 or 1.3", t.MinVersion)
+	default:
+		return fmt.Errorf("tls min_version must be 1.2 or 1.3, got '%s'", t.MinVersion)
+	}
+
+	if len(t.CipherSuites) > 0 && t.MinVersion == "1.3" {
+		return fmt.Errorf("tls cipher_suites only apply to TLS 1.2 and can't be combined with min_version 1.3")
+	}
+	for _, name := range t.CipherSuites {
+		if isInsecureCipherSuite(name) {
+			return fmt.Errorf("tls cipher suite %s is insecure", name)
+		}
+		suite := cipherSuite(name)
+		if suite == nil {
+			return fmt.Errorf("unknown tls cipher suite '%s'", name)
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return fmt.Errorf("tls cipher suite %s is TLS 1.3 only and can't be configured", name)
+		}
+	}
+	return nil
+}
+
+// ClientAuthType returns how client certificates are handled, not at all
+// without a client CA.
+func (t *TLS) ClientAuthType() tls.ClientAuthType {
+	switch {
+	case t.ClientCAFile == "":
+		return tls.NoClientCert
+	case t.ClientAuth == "require":
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.VerifyClientCertIfGiven
+	}
+}
+
+// ClientCAs loads the CAs client certificates are verified against, nil
+// without mTLS.
+func (t *TLS) ClientCAs() (*x509.CertPool, error) {
+	if t.ClientCAFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", t.ClientCAFile)
+	}
+	return pool, nil
+}
+
+// Source describes where the key pair comes from, for logging.
+func (t *TLS) Source() string {
+	switch {
+	case t.CertPEM != "" || t.KeyPEM != "":
+		return "inline PEM"
+	case t.CertEnv != "" || t.KeyEnv != "":
+		return fmt.Sprintf("environment variables %s and %s", t.CertEnv, t.KeyEnv)
+	default:
+		return t.CertFile
+	}
+}
+
+// KeyPair loads the certificate and key from the configured source.
+func (t *TLS) KeyPair() (tls.Certificate, error) {
+	switch {
+	case t.CertPEM != "" || t.KeyPEM != "":
+		return tls.X509KeyPair([]byte(t.CertPEM), []byte(t.KeyPEM))
+	case t.CertEnv != "" || t.KeyEnv != "":
+		return tls.X509KeyPair([]byte(os.Getenv(t.CertEnv)), []byte(os.Getenv(t.KeyEnv)))
+	default:
+		return tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	}
+}
+
+func (t *TLS) validate() error {
+	sources := 0
+	for _, pair := range [][2]string{{t.CertFile, t.KeyFile}, {t.CertPEM, t.KeyPEM}, {t.CertEnv, t.KeyEnv}} {
+		if pair[0] == "" && pair[1] == "" {
+			continue
+		}
+		if pair[0] == "" || pair[1] == "" {
+			return fmt.Errorf("tls requires both a certificate and a key from the same source")
+		}
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("tls requires exactly one of cert_file/key_file, cert_pem/key_pem or cert_env/key_env, got %d", sources)
+	}
+	if err := t.validateProtocol(); err != nil {
+		return err
+	}
+	switch t.ClientAuth {
+	case "", "request", "require":
+	default:
+		return fmt.Errorf("tls client_auth must be request or require, got '%s'", t.ClientAuth)
+	}
+	if headers := t.ClientCertHeaders; headers != nil {
+		if t.ClientCAFile == "" {
+			return fmt.Errorf("tls client_cert_headers requires client_ca_file")
+		}
+		for _, name := range headers.Names() {
+			if !validHeaderName(name) {
+				return fmt.Errorf("tls client_cert_headers must be header names, got '%s'", name)
+			}
+		}
+	}
+
+	// * files may be provisioned after validation, inline sources must parse now
+	if t.CertFile == "" {
+		if _, err := t.KeyPair(); err != nil {
+			return fmt.Errorf("tls certificate from %s: %w", t.Source(), err)
+		}
+	}
+	return nil
 }
 
 type Manager struct {
 	config    *Config
 	mu        sync.RWMutex
 	listeners []func(*Config)
+	// files are the config files last loaded, overrides adjust every
+	// configuration loaded from them
+	files     []string
+	overrides []func(*Config)
 }
 
 func Load(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &Config{
-				Server: ServerConfig{
-					Port:        8080,
-					MetricsPort: 9090,
-					GossipPort:  7946,
-					HotReload:   true,
-				},
-				HealthCheck: HealthConfig{
-					Interval: 10 * time.Second,
-					Timeout:  5 * time.Second,
-					Path:     "/health",
-				},
-				Timeouts: TimeoutConfig{
-					Read:  30 * time.Second,
-					Write: 30 * time.Second,
-					Idle:  120 * time.Second,
-				},
-				Logging: LoggingConfig{
-					Level:  "info",
-					Format: "text",
-				},
-			}, nil
-		}
-		return nil, fmt.Errorf("reading config file: %w", err)
+	return LoadFiles([]string{filename})
+}
+
+// LoadFiles reads the config files in order, each deep-merged over the ones
+// before it, and validates the result as a whole: mappings are merged key by
+// key, any other value, lists included, replaces the earlier one. A lone file
+// that does not exist yields the defaults; with several, each must exist.
+func LoadFiles(filenames []string) (*Config, error) {
+	var merged *yaml.Node
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			if os.IsNotExist(err) && len(filenames) == 1 {
+				return Default(), nil
+			}
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", filename, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		merged = mergeNodes(merged, doc.Content[0])
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing config: %w", err)
+	if merged != nil {
+		if err := merged.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("parsing config: %w", err)
+		}
 	}
 
 	cfg.setDefaults()
@@ -102,6 +1073,43 @@ func Load(filename string) (*Config, error) {
 	return &cfg, nil
 }
 
+// mergeNodes merges the YAML value layer over base. Mappings present in both
+// are merged recursively; otherwise layer wins.
+func mergeNodes(base, layer *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || layer.Kind != yaml.MappingNode {
+		return layer
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	for i := 0; i+1 < len(layer.Content); i += 2 {
+		key, value := layer.Content[i], layer.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return &merged
+}
+
+// Default returns the configuration used when no config file exists.
+func Default() *Config {
+	cfg := &Config{
+		Server: ServerConfig{
+			HotReload: true,
+		},
+	}
+	cfg.setDefaults()
+	return cfg
+}
+
 func (c *Config) setDefaults() {
 	if c.Server.Port == 0 {
 		c.Server.Port = 8080
@@ -112,6 +1120,9 @@ func (c *Config) setDefaults() {
 	if c.Server.GossipPort == 0 {
 		c.Server.GossipPort = 7946
 	}
+	if c.Server.HotReloadDebounce == 0 {
+		c.Server.HotReloadDebounce = defaultHotReloadDebounce
+	}
 
 	if c.HealthCheck.Interval == 0 {
 		c.HealthCheck.Interval = 10 * time.Second
@@ -122,6 +1133,12 @@ func (c *Config) setDefaults() {
 	if c.HealthCheck.Path == "" {
 		c.HealthCheck.Path = "/health"
 	}
+	if c.HealthCheck.Require == "" {
+		c.HealthCheck.Require = "any"
+	}
+	if c.HealthCheck.MinWeightFactor == 0 {
+		c.HealthCheck.MinWeightFactor = 0.1
+	}
 
 	if c.Timeouts.Read == 0 {
 		c.Timeouts.Read = 30 * time.Second
@@ -132,6 +1149,177 @@ func (c *Config) setDefaults() {
 	if c.Timeouts.Idle == 0 {
 		c.Timeouts.Idle = 120 * time.Second
 	}
+	if c.Timeouts.Connect == 0 {
+		c.Timeouts.Connect = 10 * time.Second
+	}
+	if c.Timeouts.ExpectContinue == 0 {
+		c.Timeouts.ExpectContinue = time.Second
+	}
+
+	if c.Dial.KeepAlive == 0 {
+		c.Dial.KeepAlive = 30 * time.Second
+	}
+	if c.Dial.NoDelay == nil {
+		noDelay := true
+		c.Dial.NoDelay = &noDelay
+	}
+
+	if c.LoadBalancer.Algorithm == "" {
+		c.LoadBalancer.Algorithm = "round_robin"
+	}
+
+	if c.Transport.MaxIdleConns == 0 {
+		c.Transport.MaxIdleConns = 100
+	}
+	if c.Transport.MaxIdleConnsPerHost == 0 {
+		c.Transport.MaxIdleConnsPerHost = 10
+	}
+	if c.Transport.IdleConnTimeout == 0 {
+		c.Transport.IdleConnTimeout = 90 * time.Second
+	}
+	if c.Transport.MaxResponseHeaderBytes == 0 {
+		c.Transport.MaxResponseHeaderBytes = 1 << 20
+	}
+	if c.Transport.Scheme == "" {
+		c.Transport.Scheme = "http"
+	}
+
+	for name, test := range c.ABTests {
+		if test.CookieName == "" {
+			test.CookieName = "fluxgate_ab_" + name
+		}
+		if test.CookieTTL == 0 {
+			test.CookieTTL = 30 * 24 * time.Hour
+		}
+		c.ABTests[name] = test
+	}
+
+	for name, service := range c.Services {
+		if service.Signing != nil && service.Signing.Algorithm == "" {
+			signing := *service.Signing
+			signing.Algorithm = "sha256"
+			service.Signing = &signing
+		}
+		if service.DecodeRequests && service.MaxDecodedBody == 0 {
+			service.MaxDecodedBody = 10 << 20
+		}
+		if service.OnUnavailable.Action == "" {
+			service.OnUnavailable.Action = "error"
+		}
+		if service.OnUnavailable.Action == "stale" && service.OnUnavailable.StaleMaxAge == 0 {
+			service.OnUnavailable.StaleMaxAge = time.Hour
+		}
+		if service.Compression != nil {
+			compression := *service.Compression
+			if compression.Level == 0 {
+				compression.Level = 6
+			}
+			if compression.ContentTypes == nil {
+				compression.ContentTypes = defaultCompressedTypes
+			}
+			if compression.ExcludeContentTypes == nil {
+				compression.ExcludeContentTypes = defaultUncompressedTypes
+			}
+			if compression.MinSize == 0 {
+				compression.MinSize = 1024
+			}
+			service.Compression = &compression
+		}
+		if service.AccessLog != nil && service.AccessLog.Fields == nil {
+			accessLog := *service.AccessLog
+			accessLog.Fields = AccessLogFields
+			service.AccessLog = &accessLog
+		}
+		if service.Affinity != nil && service.Affinity.Missing == "" {
+			affinity := *service.Affinity
+			affinity.Missing = "balance"
+			service.Affinity = &affinity
+		}
+		if service.Idempotency != nil {
+			idempotency := *service.Idempotency
+			if idempotency.Header == "" {
+				idempotency.Header = "Idempotency-Key"
+			}
+			if idempotency.TTL == 0 {
+				idempotency.TTL = 10 * time.Minute
+			}
+			service.Idempotency = &idempotency
+		}
+		if service.DebugBodies != nil && service.DebugBodies.MaxBytes == 0 {
+			debugBodies := *service.DebugBodies
+			debugBodies.MaxBytes = 64 << 10
+			service.DebugBodies = &debugBodies
+		}
+		if service.LatencySLO != nil {
+			slo := *service.LatencySLO
+			if slo.Percentile == 0 {
+				slo.Percentile = 99
+			}
+			if slo.Window == 0 {
+				slo.Window = time.Minute
+			}
+			if slo.MinSamples == 0 {
+				slo.MinSamples = 20
+			}
+			if slo.MinWeightFactor == 0 {
+				slo.MinWeightFactor = 0.1
+			}
+			service.LatencySLO = &slo
+		}
+		c.Services[name] = service
+	}
+
+	for name, static := range c.Static {
+		if static.Status == 0 {
+			static.Status = 503
+		}
+		if static.ContentType == "" {
+			static.ContentType = "text/plain; charset=utf-8"
+		}
+		c.Static[name] = static
+	}
+
+	if c.RetryBudget.Ratio == 0 {
+		c.RetryBudget.Ratio = 0.2
+	}
+	if c.RetryBudget.Window == 0 {
+		c.RetryBudget.Window = 10 * time.Second
+	}
+	if c.RetryBudget.MinRetries == 0 {
+		c.RetryBudget.MinRetries = 3
+	}
+
+	if c.Metrics.Namespace == "" {
+		c.Metrics.Namespace = "fluxgate"
+	}
+
+	if c.Queue.Timeout == 0 {
+		c.Queue.Timeout = time.Second
+	}
+
+	if c.Debug.Captured == 0 {
+		c.Debug.Captured = 20
+	}
+
+	if c.Tracing.Propagation == "" {
+		c.Tracing.Propagation = "w3c"
+	}
+	if c.Tracing.RequestIDHeader == "" {
+		c.Tracing.RequestIDHeader = "X-Request-ID"
+	}
+
+	if c.Cluster.LeaveTimeout == 0 {
+		c.Cluster.LeaveTimeout = 5 * time.Second
+	}
+	if c.Readiness.MaxUnhealthyPercent == 0 {
+		c.Readiness.MaxUnhealthyPercent = 50
+	}
+	if c.Cluster.Snapshot.Interval == 0 {
+		c.Cluster.Snapshot.Interval = 30 * time.Second
+	}
+	if c.Cluster.Snapshot.MaxAge == 0 {
+		c.Cluster.Snapshot.MaxAge = 24 * time.Hour
+	}
 
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
@@ -139,6 +1327,31 @@ func (c *Config) setDefaults() {
 	if c.Logging.Format == "" {
 		c.Logging.Format = "text"
 	}
+	if c.Logging.MalformedRequests == "" {
+		c.Logging.MalformedRequests = "debug"
+	}
+	if len(c.Logging.Outputs) == 0 {
+		c.Logging.Outputs = []string{"stderr"}
+	}
+	if c.Logging.AccessLogSink != nil {
+		sink := *c.Logging.AccessLogSink
+		if sink.BatchSize == 0 {
+			sink.BatchSize = 100
+		}
+		if sink.FlushInterval == 0 {
+			sink.FlushInterval = time.Second
+		}
+		if sink.BufferSize == 0 {
+			sink.BufferSize = 10000
+		}
+		if sink.MaxRetries == 0 {
+			sink.MaxRetries = 3
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 5 * time.Second
+		}
+		c.Logging.AccessLogSink = &sink
+	}
 }
 
 func (c *Config) Validate() error {
@@ -162,6 +1375,26 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("metrics port and gossip port cannot be the same: %d", c.Server.MetricsPort)
 	}
 
+	listenerPorts := make(map[int]bool, len(c.Server.Listeners))
+	for _, listener := range c.Server.Listeners {
+		if listener.Port < 1 || listener.Port > 65535 {
+			return fmt.Errorf("server listener port must be between 1 and 65535, got %d", listener.Port)
+		}
+		if listener.Port == c.Server.MetricsPort || listener.Port == c.Server.GossipPort {
+			return fmt.Errorf("server listener port %d is taken by the metrics or gossip port", listener.Port)
+		}
+		if listenerPorts[listener.Port] {
+			return fmt.Errorf("server listener port %d is listed twice", listener.Port)
+		}
+		listenerPorts[listener.Port] = true
+		if listener.TLS && c.TLS == nil {
+			return fmt.Errorf("server listener port %d uses tls, which requires a tls section", listener.Port)
+		}
+	}
+	if c.Server.HotReloadDebounce < 0 {
+		return fmt.Errorf("server hot_reload_debounce cannot be negative, got %v", c.Server.HotReloadDebounce)
+	}
+
 	if c.HealthCheck.Interval < time.Second {
 		return fmt.Errorf("health check interval must be at least 1s, got %v", c.HealthCheck.Interval)
 	}
@@ -172,6 +1405,21 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("health check timeout (%v) must be less than interval (%v)", c.HealthCheck.Timeout, c.HealthCheck.Interval)
 	}
 
+	if c.HealthCheck.Jitter < 0 || c.HealthCheck.Jitter > c.HealthCheck.Interval {
+		return fmt.Errorf("health check jitter must be between 0 and the interval (%v), got %v", c.HealthCheck.Interval, c.HealthCheck.Jitter)
+	}
+
+	if c.HealthCheck.WarmupGrace < 0 {
+		return fmt.Errorf("health check warmup grace cannot be negative, got %v", c.HealthCheck.WarmupGrace)
+	}
+	// * both hold backends out until a later probe passes, which needs probing
+	if c.HealthCheck.WarmupGrace > 0 && !c.HealthCheck.Enabled {
+		return fmt.Errorf("health check warmup_grace needs health_check.enabled")
+	}
+	if c.HealthCheck.UnhealthyOnTLSError && !c.HealthCheck.Enabled {
+		return fmt.Errorf("health check unhealthy_on_tls_error needs health_check.enabled")
+	}
+
 	if c.Timeouts.Read < time.Second {
 		return fmt.Errorf("read timeout must be at least 1s, got %v", c.Timeouts.Read)
 	}
@@ -181,13 +1429,41 @@ func (c *Config) Validate() error {
 	if c.Timeouts.Idle < time.Second {
 		return fmt.Errorf("idle timeout must be at least 1s, got %v", c.Timeouts.Idle)
 	}
+	if c.Timeouts.Connect < 0 {
+		return fmt.Errorf("connect timeout cannot be negative, got %v", c.Timeouts.Connect)
+	}
+	if c.Timeouts.Response < 0 {
+		return fmt.Errorf("response timeout cannot be negative, got %v", c.Timeouts.Response)
+	}
+	if c.Timeouts.Request < 0 {
+		return fmt.Errorf("request timeout cannot be negative, got %v", c.Timeouts.Request)
+	}
 
-	validLevels := map[string]bool{
-		"debug": true, "info": true, "warn": true, "error": true,
+	if c.Transport.Scheme != "http" && c.Transport.Scheme != "https" {
+		return fmt.Errorf("transport scheme must be http or https, got '%s'", c.Transport.Scheme)
+	}
+	if c.Transport.MaxIdleConns < 0 || c.Transport.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("transport idle connection limits cannot be negative")
 	}
-	if !validLevels[strings.ToLower(c.Logging.Level)] {
+	if c.Transport.IdleConnTimeout < 0 {
+		return fmt.Errorf("transport idle connection timeout cannot be negative, got %v", c.Transport.IdleConnTimeout)
+	}
+	if c.Transport.MaxPoolAge < 0 {
+		return fmt.Errorf("transport max pool age cannot be negative, got %v", c.Transport.MaxPoolAge)
+	}
+	if c.Transport.IdleFlushInterval < 0 {
+		return fmt.Errorf("transport idle flush interval cannot be negative, got %v", c.Transport.IdleFlushInterval)
+	}
+	if c.Transport.MaxResponseHeaders < 0 || c.Transport.MaxResponseHeaderBytes < 0 {
+		return fmt.Errorf("transport response header limits cannot be negative")
+	}
+
+	if _, ok := logLevels[strings.ToLower(c.Logging.Level)]; !ok {
 		return fmt.Errorf("invalid log level '%s', must be one of: debug, info, warn, error", c.Logging.Level)
 	}
+	if _, ok := logLevels[strings.ToLower(c.Logging.MalformedRequests)]; !ok {
+		return fmt.Errorf("invalid malformed_requests log level '%s', must be one of: debug, info, warn, error", c.Logging.MalformedRequests)
+	}
 
 	validFormats := map[string]bool{
 		"text": true, "json": true,
@@ -196,18 +1472,392 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log format '%s', must be one of: text, json", c.Logging.Format)
 	}
 
+	for _, output := range c.Logging.Outputs {
+		if strings.TrimSpace(output) == "" {
+			return fmt.Errorf("log outputs cannot contain empty entries")
+		}
+	}
+	if c.Logging.Rotation.MaxSizeMB < 0 || c.Logging.Rotation.MaxAge < 0 || c.Logging.Rotation.MaxBackups < 0 {
+		return fmt.Errorf("log rotation limits cannot be negative")
+	}
+	if sink := c.Logging.AccessLogSink; sink != nil {
+		if sink.Type == "" {
+			return fmt.Errorf("access_log_sink requires a type")
+		}
+		if sink.Type == "http" {
+			if u, err := url.Parse(sink.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("access_log_sink url must be an http or https URL, got '%s'", sink.URL)
+			}
+		}
+		if sink.BatchSize < 0 || sink.FlushInterval < 0 || sink.BufferSize < 0 || sink.MaxRetries < 0 || sink.Timeout < 0 {
+			return fmt.Errorf("access_log_sink sizes, intervals and retries cannot be negative")
+		}
+	}
+
+	if c.Logging.SlowRequestThreshold < 0 {
+		return fmt.Errorf("logging slow_request_threshold cannot be negative, got %v", c.Logging.SlowRequestThreshold)
+	}
+	if c.Cluster.LeaveTimeout < 0 {
+		return fmt.Errorf("cluster leave timeout cannot be negative, got %v", c.Cluster.LeaveTimeout)
+	}
+	for _, name := range append(slices.Clone(c.Cluster.ReservedNames), c.Cluster.ReservedPrefixes...) {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("cluster reserved names and prefixes must be non-empty and without '/', got '%s'", name)
+		}
+	}
+	if c.Server.MaxHops < 0 {
+		return fmt.Errorf("server max hops cannot be negative, got %d", c.Server.MaxHops)
+	}
+	for _, entry := range c.Server.TrustedProxies {
+		if _, err := access.ParsePrefix(entry); err != nil {
+			return fmt.Errorf("server trusted_proxies: %w", err)
+		}
+	}
+	if c.Cluster.Snapshot.Path != "" && c.Cluster.Snapshot.Interval <= 0 {
+		return fmt.Errorf("cluster snapshot interval must be positive, got %v", c.Cluster.Snapshot.Interval)
+	}
+	if c.Limits.MaxServices < 0 || c.Limits.MaxRoutes < 0 {
+		return fmt.Errorf("limits cannot be negative, got max_services %d and max_routes %d", c.Limits.MaxServices, c.Limits.MaxRoutes)
+	}
+	if c.Readiness.MaxUnhealthyPercent < 0 || c.Readiness.MaxUnhealthyPercent > 100 {
+		return fmt.Errorf("readiness max_unhealthy_percent must be between 0 and 100, got %v", c.Readiness.MaxUnhealthyPercent)
+	}
+	for i, service := range c.Readiness.CriticalServices {
+		if service == "" || slices.Contains(c.Readiness.CriticalServices[:i], service) {
+			return fmt.Errorf("readiness critical_services must be unique and non-empty, got %v", c.Readiness.CriticalServices)
+		}
+	}
+	if len(c.Cluster.GossipKeys) > 0 && c.Cluster.GossipKeysEnv != "" {
+		return fmt.Errorf("cluster gossip_keys and gossip_keys_env are mutually exclusive")
+	}
+	if _, err := c.Cluster.Keys(); err != nil {
+		return fmt.Errorf("cluster %w", err)
+	}
+	if c.Cluster.StartupGrace < 0 {
+		return fmt.Errorf("cluster startup grace cannot be negative, got %v", c.Cluster.StartupGrace)
+	}
+
+	validPropagation := map[string]bool{
+		"w3c": true, "request-id": true, "none": true,
+	}
+	if !validPropagation[c.Tracing.Propagation] {
+		return fmt.Errorf("invalid tracing propagation '%s', must be one of: w3c, request-id, none", c.Tracing.Propagation)
+	}
+
+	if c.LoadBalancer.MaxConnections < 0 {
+		return fmt.Errorf("load balancer max_connections cannot be negative, got %d", c.LoadBalancer.MaxConnections)
+	}
+
+	for name, test := range c.ABTests {
+		if err := test.validate(name); err != nil {
+			return err
+		}
+	}
+
+	for name, static := range c.Static {
+		if static.Status < 100 || static.Status > 599 {
+			return fmt.Errorf("static '%s' status must be between 100 and 599, got %d", name, static.Status)
+		}
+	}
+	if c.RetryBudget.Ratio < 0 || c.RetryBudget.Ratio > 1 {
+		return fmt.Errorf("retry budget ratio must be between 0 and 1, got %v", c.RetryBudget.Ratio)
+	}
+	if c.RetryBudget.Window < 0 || c.RetryBudget.MinRetries < 0 {
+		return fmt.Errorf("retry budget window and min_retries cannot be negative")
+	}
+	if c.HealthCheck.Require != "any" && c.HealthCheck.Require != "all" {
+		return fmt.Errorf("health check require must be any or all, got '%s'", c.HealthCheck.Require)
+	}
+	for _, path := range c.HealthCheck.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("health check paths must start with /, got '%s'", path)
+		}
+	}
+	if c.HealthCheck.LatencyThreshold < 0 {
+		return fmt.Errorf("health check latency_threshold cannot be negative, got %v", c.HealthCheck.LatencyThreshold)
+	}
+	if c.HealthCheck.MinWeightFactor <= 0 || c.HealthCheck.MinWeightFactor > 1 {
+		return fmt.Errorf("health check min_weight_factor must be in (0, 1], got %v", c.HealthCheck.MinWeightFactor)
+	}
+	if !metricNamespacePattern.MatchString(c.Metrics.Namespace) {
+		return fmt.Errorf("metrics namespace must match %s, got '%s'", metricNamespacePattern, c.Metrics.Namespace)
+	}
+	if c.Metrics.Token != "" && c.Metrics.TokenEnv != "" {
+		return fmt.Errorf("metrics token and token_env are mutually exclusive")
+	}
+	if c.Metrics.TokenEnv != "" && c.Metrics.BearerToken() == "" && len(c.Metrics.AllowedIPs) == 0 {
+		return fmt.Errorf("metrics token_env %s is unset and no allowed_ips are configured", c.Metrics.TokenEnv)
+	}
+	for _, entry := range c.Metrics.AllowedIPs {
+		if _, err := access.ParsePrefix(entry); err != nil {
+			return fmt.Errorf("metrics allowed_ips: %w", err)
+		}
+	}
+	if err := c.Debug.validate(); err != nil {
+		return err
+	}
+	if err := c.ForwardProxy.validate(); err != nil {
+		return err
+	}
+	if c.Queue.MaxDepth < 0 || c.Queue.Timeout < 0 {
+		return fmt.Errorf("queue max_depth and timeout cannot be negative")
+	}
+
+	for name, service := range c.Services {
+		if signing := service.Signing; signing != nil {
+			if (signing.Secret == "") == (signing.SecretEnv == "") {
+				return fmt.Errorf("service '%s' signing requires exactly one of secret or secret_env", name)
+			}
+			if signing.Algorithm != "sha256" && signing.Algorithm != "sha512" {
+				return fmt.Errorf("service '%s' signing algorithm must be sha256 or sha512, got '%s'", name, signing.Algorithm)
+			}
+		}
+		if debugBodies := service.DebugBodies; debugBodies != nil {
+			if !c.Debug.Enabled {
+				return fmt.Errorf("service '%s' debug_bodies requires debug.enabled", name)
+			}
+			if debugBodies.MaxBytes < 0 {
+				return fmt.Errorf("service '%s' debug_bodies max_bytes cannot be negative, got %d", name, debugBodies.MaxBytes)
+			}
+		}
+		if slo := service.LatencySLO; slo != nil {
+			if slo.Threshold <= 0 || slo.Window <= 0 {
+				return fmt.Errorf("service '%s' latency_slo threshold and window must be positive", name)
+			}
+			if slo.Percentile <= 0 || slo.Percentile > 100 {
+				return fmt.Errorf("service '%s' latency_slo percentile must be in (0, 100], got %v", name, slo.Percentile)
+			}
+			if slo.MinSamples < 0 {
+				return fmt.Errorf("service '%s' latency_slo min_samples cannot be negative, got %d", name, slo.MinSamples)
+			}
+			if slo.MinWeightFactor <= 0 || slo.MinWeightFactor > 1 {
+				return fmt.Errorf("service '%s' latency_slo min_weight_factor must be in (0, 1], got %v", name, slo.MinWeightFactor)
+			}
+		}
+		if service.RequestTimeout < 0 {
+			return fmt.Errorf("service '%s' request_timeout cannot be negative, got %v", name, service.RequestTimeout)
+		}
+		if fairQueue := service.FairQueue; fairQueue != nil {
+			if !validHeaderName(fairQueue.Header) {
+				return fmt.Errorf("service '%s' fair_queue header must be a header name, got '%s'", name, fairQueue.Header)
+			}
+			if fairQueue.DefaultWeight < 0 {
+				return fmt.Errorf("service '%s' fair_queue default_weight cannot be negative, got %d", name, fairQueue.DefaultWeight)
+			}
+			for tenant, weight := range fairQueue.Weights {
+				if weight <= 0 {
+					return fmt.Errorf("service '%s' fair_queue weight of tenant '%s' must be positive, got %d", name, tenant, weight)
+				}
+			}
+			if c.QueueFor(name).MaxDepth == 0 {
+				return fmt.Errorf("service '%s' fair_queue requires queueing, set queue.max_depth or queue_depth", name)
+			}
+		}
+		if service.RetryTimeout < 0 || service.TryTimeout < 0 {
+			return fmt.Errorf("service '%s' retry_timeout and try_timeout cannot be negative", name)
+		}
+		if service.Retries < 0 {
+			return fmt.Errorf("service '%s' retries cannot be negative, got %d", name, service.Retries)
+		}
+		if _, exists := c.Static[service.Static]; service.Static != "" && !exists {
+			return fmt.Errorf("service '%s' references unknown static '%s'", name, service.Static)
+		}
+		if service.MaxDecodedBody < 0 {
+			return fmt.Errorf("service '%s' max_decoded_body cannot be negative, got %d", name, service.MaxDecodedBody)
+		}
+		if service.PreDial < 0 {
+			return fmt.Errorf("service '%s' pre_dial cannot be negative, got %d", name, service.PreDial)
+		}
+		if service.QueueDepth < 0 || service.QueueTimeout < 0 {
+			return fmt.Errorf("service '%s' queue_depth and queue_timeout cannot be negative", name)
+		}
+		if service.ParamHeaders != "" && !strings.Contains(service.ParamHeaders, "{name}") {
+			return fmt.Errorf("service '%s' param_headers must contain {name}, got '%s'", name, service.ParamHeaders)
+		}
+		if err := service.OnUnavailable.validate(name, c.Static); err != nil {
+			return err
+		}
+		if compression := service.Compression; compression != nil {
+			if compression.Level < 1 || compression.Level > 9 {
+				return fmt.Errorf("service '%s' compression level must be between 1 and 9, got %d", name, compression.Level)
+			}
+			if compression.MinSize < 0 {
+				return fmt.Errorf("service '%s' compression min_size cannot be negative, got %d", name, compression.MinSize)
+			}
+		}
+		if accessLog := service.AccessLog; accessLog != nil {
+			for _, field := range accessLog.Fields {
+				header, isHeader := strings.CutPrefix(field, "header:")
+				if !accessLogFields[field] && (!isHeader || header == "") {
+					return fmt.Errorf("service '%s' access_log has unknown field '%s'", name, field)
+				}
+			}
+		}
+		if affinity := service.Affinity; affinity != nil {
+			if affinity.Header == "" {
+				return fmt.Errorf("service '%s' affinity requires a header", name)
+			}
+			if affinity.Missing != "" && affinity.Missing != "balance" && affinity.Missing != "reject" {
+				return fmt.Errorf("service '%s' affinity missing must be balance or reject, got '%s'", name, affinity.Missing)
+			}
+		}
+		if idempotency := service.Idempotency; idempotency != nil && idempotency.TTL < 0 {
+			return fmt.Errorf("service '%s' idempotency ttl cannot be negative, got %v", name, idempotency.TTL)
+		}
+		if service.DisabledStatus != 0 && (service.DisabledStatus < 400 || service.DisabledStatus > 599) {
+			return fmt.Errorf("service '%s' disabled_status must be a 4xx or 5xx status, got %d", name, service.DisabledStatus)
+		}
+		if coalesce := service.Coalesce; coalesce != nil {
+			for _, header := range coalesce.Vary {
+				if !validHeaderName(header) {
+					return fmt.Errorf("service '%s' coalesce vary must list header names, got '%s'", name, header)
+				}
+			}
+		}
+		for backend, weight := range service.Weights {
+			if _, port, err := net.SplitHostPort(backend); err != nil || port == "" {
+				return fmt.Errorf("service '%s' weights key must be address:port, got %q", name, backend)
+			}
+			if weight < 0 {
+				return fmt.Errorf("service '%s' weight of %s cannot be negative, got %d", name, backend, weight)
+			}
+		}
+		switch service.TrailingSlash {
+		case "", "preserve", "strip", "add":
+		default:
+			return fmt.Errorf("service '%s' trailing_slash must be preserve, strip or add, got '%s'", name, service.TrailingSlash)
+		}
+		if service.TrailingSlashRedirect && service.TrailingSlash != "strip" && service.TrailingSlash != "add" {
+			return fmt.Errorf("service '%s' trailing_slash_redirect needs trailing_slash strip or add", name)
+		}
+		for _, status := range service.RetryOn {
+			if status < 400 || status > 599 {
+				return fmt.Errorf("service '%s' retry_on must list 4xx or 5xx statuses, got %d", name, status)
+			}
+		}
+		for from, to := range service.MethodRewrite {
+			if !knownMethods[from] || !knownMethods[to] {
+				return fmt.Errorf("service '%s' method_rewrite %s -> %s must use known uppercase HTTP methods", name, from, to)
+			}
+		}
+		for i, header := range service.HeaderCase {
+			if !validHeaderName(header) || slices.ContainsFunc(service.HeaderCase[:i], func(other string) bool {
+				return strings.EqualFold(other, header)
+			}) {
+				return fmt.Errorf("service '%s' header_case must list unique header names, got '%s'", name, header)
+			}
+		}
+		for i, pool := range service.Pools {
+			if pool == "" || slices.Contains(service.Pools[:i], pool) {
+				return fmt.Errorf("service '%s' pools must be unique and non-empty, got %v", name, service.Pools)
+			}
+		}
+	}
+
 	if c.TLS != nil {
-		if c.TLS.CertFile == "" {
-			return fmt.Errorf("tls cert_file is required when TLS is enabled")
+		if err := c.TLS.validate(); err != nil {
+			return err
 		}
-		if c.TLS.KeyFile == "" {
-			return fmt.Errorf("tls key_file is required when TLS is enabled")
+	}
+
+	return nil
+}
+
+func (d DebugConfig) validate() error {
+	if d.Token != "" && d.TokenEnv != "" {
+		return fmt.Errorf("debug token and token_env are mutually exclusive")
+	}
+	for _, entry := range d.AllowedIPs {
+		if _, err := access.ParsePrefix(entry); err != nil {
+			return fmt.Errorf("debug allowed_ips: %w", err)
 		}
 	}
+	if d.Captured < 0 {
+		return fmt.Errorf("debug captured cannot be negative, got %d", d.Captured)
+	}
+	if d.Enabled && d.Token == "" && d.TokenEnv == "" && len(d.AllowedIPs) == 0 {
+		return fmt.Errorf("debug endpoints require a token, token_env or allowed_ips")
+	}
+	// * an unset token_env would otherwise leave the endpoints open
+	if d.Enabled && d.BearerToken() == "" && len(d.AllowedIPs) == 0 {
+		return fmt.Errorf("debug token_env %s is unset and no allowed_ips are configured", d.TokenEnv)
+	}
+	return nil
+}
 
+func (u UnavailableConfig) validate(service string, statics map[string]StaticConfig) error {
+	switch u.Action {
+	case "", "error":
+	case "stale":
+		if u.StaleMaxAge < 0 {
+			return fmt.Errorf("service '%s' on_unavailable stale_max_age cannot be negative, got %v", service, u.StaleMaxAge)
+		}
+	case "static":
+		if _, exists := statics[u.Static]; !exists {
+			return fmt.Errorf("service '%s' on_unavailable references unknown static '%s'", service, u.Static)
+		}
+	case "fallback":
+		if u.Fallback == "" || u.Fallback == service {
+			return fmt.Errorf("service '%s' on_unavailable fallback must name another service", service)
+		}
+	default:
+		return fmt.Errorf("service '%s' on_unavailable action must be error, stale, static or fallback, got '%s'", service, u.Action)
+	}
 	return nil
 }
 
+func (t ABTestConfig) validate(name string) error {
+	if name == "" || strings.ContainsAny(name, "/*") {
+		return fmt.Errorf("invalid ab test name '%s'", name)
+	}
+	if len(t.Buckets) == 0 {
+		return fmt.Errorf("ab test '%s' must define at least one bucket", name)
+	}
+	if t.CookieTTL < 0 {
+		return fmt.Errorf("ab test '%s' cookie_ttl cannot be negative, got %v", name, t.CookieTTL)
+	}
+
+	seen := make(map[string]bool)
+	totalWeight := 0
+	for _, bucket := range t.Buckets {
+		if bucket.Name == "" || bucket.Service == "" {
+			return fmt.Errorf("ab test '%s' buckets require a name and service", name)
+		}
+		if seen[bucket.Name] {
+			return fmt.Errorf("ab test '%s' has duplicate bucket '%s'", name, bucket.Name)
+		}
+		seen[bucket.Name] = true
+		if bucket.Weight < 0 {
+			return fmt.Errorf("ab test '%s' bucket '%s' weight cannot be negative", name, bucket.Name)
+		}
+		totalWeight += bucket.Weight
+	}
+	if totalWeight == 0 {
+		return fmt.Errorf("ab test '%s' must have a bucket with positive weight", name)
+	}
+
+	return nil
+}
+
+// Service returns the overrides for a service, zero-valued when none are configured.
+func (c *Config) Service(name string) ServiceConfig {
+	return c.Services[name]
+}
+
+// QueueFor returns the request queue settings of a service, with its
+// overrides applied.
+func (c *Config) QueueFor(name string) QueueConfig {
+	queue := c.Queue
+	service := c.Service(name)
+	if service.QueueDepth > 0 {
+		queue.MaxDepth = service.QueueDepth
+	}
+	if service.QueueTimeout > 0 {
+		queue.Timeout = service.QueueTimeout
+	}
+	return queue
+}
+
 func (c *Config) GetPort() int {
 	return c.Server.Port
 }
@@ -239,14 +1889,17 @@ func NewManager() *Manager {
 	}
 }
 
-func (m *Manager) Load(filename string) error {
-	cfg, err := Load(filename)
+// Load loads the config files, merged in order as by LoadFiles, and notifies
+// subscribers.
+func (m *Manager) Load(filenames ...string) error {
+	cfg, err := m.load(filenames)
 	if err != nil {
 		return err
 	}
 
 	m.mu.Lock()
 	m.config = cfg
+	m.files = filenames
 	listeners := m.listeners
 	m.mu.Unlock()
 
@@ -257,6 +1910,57 @@ func (m *Manager) Load(filename string) error {
 	return nil
 }
 
+// load loads filenames with the overrides applied.
+func (m *Manager) load(filenames []string) (*Config, error) {
+	cfg, err := LoadFiles(filenames)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	overrides := m.overrides
+	m.mu.RUnlock()
+	if len(overrides) == 0 {
+		return cfg, nil
+	}
+	for _, override := range overrides {
+		override(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Override registers fn to adjust every configuration loaded from now on,
+// before it is validated and subscribers see it, e.g. for command line flags.
+func (m *Manager) Override(fn func(*Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides = append(m.overrides, fn)
+}
+
+// DryRun loads and validates filenames, or the files last loaded when none
+// are given, and returns how they differ from the current configuration
+// without applying them.
+func (m *Manager) DryRun(filenames ...string) ([]Change, error) {
+	m.mu.RLock()
+	current := m.config
+	if len(filenames) == 0 {
+		filenames = m.files
+	}
+	m.mu.RUnlock()
+
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no config files loaded")
+	}
+	candidate, err := m.load(filenames)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(current, candidate)
+}
+
 func (m *Manager) Subscribe(listener func(*Config)) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
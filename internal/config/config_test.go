@@ -1,8 +1,18 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -152,6 +162,88 @@ func TestLoadConfigDefaults(t *testing.T) {
 	}
 }
 
+func TestLoadDialConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "test.yaml")
+
+	configContent := `
+dial:
+  keep_alive: 15s
+  tcp_nodelay: false
+  fallback_delay: -1s
+`
+
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Dial.KeepAlive != 15*time.Second {
+		t.Errorf("Expected keep-alive 15s, got %v", cfg.Dial.KeepAlive)
+	}
+	if cfg.Dial.TCPNoDelay() {
+		t.Error("Expected TCP_NODELAY to be disabled")
+	}
+	if cfg.Dial.FallbackDelay != -time.Second {
+		t.Errorf("Expected fallback delay -1s, got %v", cfg.Dial.FallbackDelay)
+	}
+
+	defaults, err := Load("non-existent-file.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	if defaults.Dial.KeepAlive != 30*time.Second {
+		t.Errorf("Expected default keep-alive 30s, got %v", defaults.Dial.KeepAlive)
+	}
+	if !defaults.Dial.TCPNoDelay() {
+		t.Error("Expected TCP_NODELAY to be enabled by default")
+	}
+}
+
+func TestLoadABTestConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "test.yaml")
+
+	configContent := `
+ab_tests:
+  checkout:
+    buckets:
+      - name: control
+        service: checkout-v1
+        weight: 80
+      - name: variant
+        service: checkout-v2
+        weight: 20
+`
+
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	test, exists := cfg.ABTests["checkout"]
+	if !exists {
+		t.Fatal("Expected checkout ab test")
+	}
+	if test.CookieName != "fluxgate_ab_checkout" {
+		t.Errorf("Expected default cookie name fluxgate_ab_checkout, got %s", test.CookieName)
+	}
+	if test.CookieTTL != 30*24*time.Hour {
+		t.Errorf("Expected default cookie ttl 720h, got %v", test.CookieTTL)
+	}
+	if len(test.Buckets) != 2 || test.Buckets[1].Service != "checkout-v2" || test.Buckets[1].Weight != 20 {
+		t.Errorf("Unexpected buckets: %+v", test.Buckets)
+	}
+}
+
 func TestConfigValidation(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -159,153 +251,774 @@ func TestConfigValidation(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "valid default config",
+			name: "valid default config",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval: 10 * time.Second,
+					Timeout:  5 * time.Second,
+				},
+				Timeouts: TimeoutConfig{
+					Read:  30 * time.Second,
+					Write: 30 * time.Second,
+					Idle:  120 * time.Second,
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "text",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid server port - too low",
+			config: Config{
+				Server: ServerConfig{
+					Port:        -1,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid server port - too high",
+			config: Config{
+				Server: ServerConfig{
+					Port:        70000,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port conflict - server and metrics",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 8080,
+					GossipPort:  7946,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port conflict - server and gossip",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  8080,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port conflict - metrics and gossip",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  9090,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check interval too short",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval: 500 * time.Millisecond,
+					Timeout:  5 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check timeout too short",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval: 10 * time.Second,
+					Timeout:  500 * time.Millisecond,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check timeout >= interval",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval: 5 * time.Second,
+					Timeout:  5 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check jitter exceeds interval",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval: 10 * time.Second,
+					Timeout:  5 * time.Second,
+					Jitter:   15 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "warmup grace without active health checks",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{
+					Interval:    10 * time.Second,
+					Timeout:     5 * time.Second,
+					WarmupGrace: 30 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid log level",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Logging: LoggingConfig{
+					Level: "invalid",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid log format",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Logging: LoggingConfig{
+					Format: "invalid",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max connections",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				LoadBalancer: LoadBalancerConfig{
+					MaxConnections: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ab test without buckets",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				ABTests: map[string]ABTestConfig{
+					"checkout": {},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ab test with zero total weight",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				ABTests: map[string]ABTestConfig{
+					"checkout": {Buckets: []ABBucket{{Name: "a", Service: "checkout-v1"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ab test with duplicate bucket",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				ABTests: map[string]ABTestConfig{
+					"checkout": {Buckets: []ABBucket{
+						{Name: "a", Service: "checkout-v1", Weight: 1},
+						{Name: "a", Service: "checkout-v2", Weight: 1},
+					}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid metrics namespace",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Metrics: MetricsConfig{Namespace: "edge-gateway"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid metrics allowlist entry",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Metrics: MetricsConfig{Namespace: "fluxgate", AllowedIPs: []string{"10.0.0.0/40"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "param headers without placeholder",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"users": {ParamHeaders: "X-Route-Param"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unavailable fallback to itself",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"search": {OnUnavailable: UnavailableConfig{Action: "fallback", Fallback: "search"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "compression level out of range",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"web": {Compression: &CompressionConfig{Level: 12}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "retry on success status",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"api": {Retries: 1, RetryOn: []int{503, 200}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative weight override",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"api": {Weights: map[string]int{"10.0.0.1:8080": -1}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "weight override without port",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"api": {Weights: map[string]int{"10.0.0.1": 2}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "gossip key of wrong length",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Cluster: ClusterConfig{GossipKeys: []string{"c2hvcnQ="}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "trailing slash redirect without canonical form",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"api": {TrailingSlash: "preserve", TrailingSlashRedirect: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown backend scheme",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Transport: TransportConfig{Scheme: "ftp"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown malformed request log level",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Logging: LoggingConfig{MalformedRequests: "verbose"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate header case",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"legacy": {HeaderCase: []string{"SOAPAction", "soapaction"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid header case name",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"legacy": {HeaderCase: []string{"X-Api Key"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "readiness threshold above 100 percent",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Readiness: ReadinessConfig{CriticalServices: []string{"payments"}, MaxUnhealthyPercent: 150},
+			},
+			wantErr: true,
+		},
+		{
+			name: "latency slo without threshold",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"orders": {LatencySLO: &LatencySLOConfig{Percentile: 95}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown health check require",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				HealthCheck: HealthConfig{Paths: []string{"/live", "/ready"}, Require: "most"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http access log sink without url",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				HealthCheck: HealthConfig{
-					Interval: 10 * time.Second,
-					Timeout:  5 * time.Second,
+				Logging: LoggingConfig{AccessLogSink: &AccessLogSinkConfig{Type: "http"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "disabled status not an error",
+			config: Config{
+				Server: ServerConfig{Port: 8080, MetricsPort: 9090, GossipPort: 7946},
+				Services: map[string]ServiceConfig{
+					"catalog": {DisabledStatus: 200},
 				},
-				Timeouts: TimeoutConfig{
-					Read:  30 * time.Second,
-					Write: 30 * time.Second,
-					Idle:  120 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty reserved prefix",
+			config: Config{
+				Server:  ServerConfig{Port: 8080, MetricsPort: 9090, GossipPort: 7946},
+				Cluster: ClusterConfig{ReservedPrefixes: []string{""}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "client cert headers without client ca",
+			config: Config{
+				Server: ServerConfig{Port: 8080, MetricsPort: 9090, GossipPort: 7946},
+				TLS: &TLS{
+					CertFile:          "cert.pem",
+					KeyFile:           "key.pem",
+					ClientCertHeaders: &ClientCertHeadersConfig{Subject: "X-Client-Subject"},
 				},
-				Logging: LoggingConfig{
-					Level:  "info",
-					Format: "text",
+			},
+			wantErr: true,
+		},
+		{
+			name: "coalesce invalid vary header",
+			config: Config{
+				Server: ServerConfig{Port: 8080, MetricsPort: 9090, GossipPort: 7946},
+				Services: map[string]ServiceConfig{
+					"catalog": {Coalesce: &CoalesceConfig{Vary: []string{"Accept Language"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "forward proxy without allowlist",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				ForwardProxy: ForwardProxyConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "forward proxy target wildcard",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				ForwardProxy: ForwardProxyConfig{Enabled: true, AllowedTargets: []string{"*:443"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "debug enabled without protection",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Debug: DebugConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "debug token_env unset",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Debug: DebugConfig{Enabled: true, TokenEnv: "FLUXGATE_TEST_UNSET_DEBUG_TOKEN"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metrics token_env unset",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Metrics: MetricsConfig{TokenEnv: "FLUXGATE_TEST_UNSET_METRICS_TOKEN"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "debug enabled with allowlist",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
 				},
+				Debug: DebugConfig{Enabled: true, AllowedIPs: []string{"127.0.0.1"}},
 			},
 			wantErr: false,
 		},
 		{
-			name: "invalid server port - too low",
+			name: "unknown access log field",
 			config: Config{
 				Server: ServerConfig{
-					Port:        -1,
+					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
+				Services: map[string]ServiceConfig{
+					"api": {AccessLog: &AccessLogConfig{Fields: []string{"path", "body"}}},
+				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "invalid server port - too high",
+			name: "access log header field",
 			config: Config{
 				Server: ServerConfig{
-					Port:        70000,
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				Services: map[string]ServiceConfig{
+					"api": {AccessLog: &AccessLogConfig{Fields: []string{"path", "header:X-Tenant-ID"}, Redact: []string{"token"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "affinity without header",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
+				Services: map[string]ServiceConfig{
+					"api": {Affinity: &AffinityConfig{Missing: "balance"}},
+				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "port conflict - server and metrics",
+			name: "unknown affinity missing behavior",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
-					MetricsPort: 8080,
+					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
+				Services: map[string]ServiceConfig{
+					"api": {Affinity: &AffinityConfig{Header: "X-Tenant-ID", Missing: "drop"}},
+				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "port conflict - server and gossip",
+			name: "tls listener without a tls section",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
-					GossipPort:  8080,
+					GossipPort:  7946,
+					Listeners:   []ListenerConfig{{Port: 8080}, {Port: 8443, TLS: true}},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "port conflict - metrics and gossip",
+			name: "listener on the metrics port",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
-					GossipPort:  9090,
+					GossipPort:  7946,
+					Listeners:   []ListenerConfig{{Port: 9090}},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "health check interval too short",
+			name: "negative idempotency ttl",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				HealthCheck: HealthConfig{
-					Interval: 500 * time.Millisecond,
-					Timeout:  5 * time.Second,
+				Services: map[string]ServiceConfig{
+					"payments": {Idempotency: &IdempotencyConfig{TTL: -time.Second}},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "health check timeout too short",
+			name: "method rewrite to unknown method",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				HealthCheck: HealthConfig{
-					Interval: 10 * time.Second,
-					Timeout:  500 * time.Millisecond,
+				Services: map[string]ServiceConfig{
+					"legacy": {MethodRewrite: map[string]string{"PUT": "post"}},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "health check timeout >= interval",
+			name: "duplicate failover pool",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				HealthCheck: HealthConfig{
-					Interval: 5 * time.Second,
-					Timeout:  5 * time.Second,
+				Services: map[string]ServiceConfig{
+					"users": {Pools: []string{"local", "dr", "local"}},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "invalid log level",
+			name: "service references unknown static",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				Logging: LoggingConfig{
-					Level: "invalid",
+				Services: map[string]ServiceConfig{
+					"api": {Static: "maintenance"},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "invalid log format",
+			name: "signing without a secret",
 			config: Config{
 				Server: ServerConfig{
 					Port:        8080,
 					MetricsPort: 9090,
 					GossipPort:  7946,
 				},
-				Logging: LoggingConfig{
-					Format: "invalid",
+				Services: map[string]ServiceConfig{
+					"api": {Signing: &SigningConfig{Algorithm: "sha256"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "TLS with two sources",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				TLS: &TLS{
+					CertFile: "cert.pem",
+					KeyFile:  "key.pem",
+					CertEnv:  "TLS_CERT",
+					KeyEnv:   "TLS_KEY",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "TLS inline PEM that does not parse",
+			config: Config{
+				Server: ServerConfig{
+					Port:        8080,
+					MetricsPort: 9090,
+					GossipPort:  7946,
+				},
+				TLS: &TLS{
+					CertPEM: "not a certificate",
+					KeyPEM:  "not a key",
 				},
 			},
 			wantErr: true,
@@ -352,6 +1065,99 @@ func TestConfigValidation(t *testing.T) {
 	}
 }
 
+func TestTLSFromEnvironment(t *testing.T) {
+	certPEM, keyPEM := generateKeyPair(t)
+	t.Setenv("FLUXGATE_TEST_CERT", string(certPEM))
+	t.Setenv("FLUXGATE_TEST_KEY", string(keyPEM))
+
+	tlsConfig := &TLS{CertEnv: "FLUXGATE_TEST_CERT", KeyEnv: "FLUXGATE_TEST_KEY"}
+	if err := tlsConfig.validate(); err != nil {
+		t.Fatalf("Expected env TLS source to validate, got %v", err)
+	}
+
+	inline := &TLS{CertPEM: string(certPEM), KeyPEM: string(keyPEM)}
+	if _, err := inline.KeyPair(); err != nil {
+		t.Errorf("Expected inline PEM to load, got %v", err)
+	}
+}
+
+func TestTLSProtocolSettings(t *testing.T) {
+	certPEM, keyPEM := generateKeyPair(t)
+
+	tests := []struct {
+		name         string
+		minVersion   string
+		cipherSuites []string
+		wantErr      string
+	}{
+		{name: "defaults"},
+		{name: "tls 1.3 only", minVersion: "1.3"},
+		{name: "allowed cipher", cipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+		{name: "tls 1.1", minVersion: "1.1", wantErr: "not allowed"},
+		{name: "unknown version", minVersion: "2", wantErr: "must be 1.2 or 1.3"},
+		{name: "unknown cipher", cipherSuites: []string{"TLS_FAKE"}, wantErr: "unknown tls cipher suite"},
+		{name: "insecure cipher", cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: "insecure"},
+		{name: "tls 1.3 cipher", cipherSuites: []string{"TLS_AES_128_GCM_SHA256"}, wantErr: "TLS 1.3 only"},
+		{name: "ciphers with tls 1.3", minVersion: "1.3", cipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, wantErr: "only apply to TLS 1.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig := &TLS{
+				CertPEM:      string(certPEM),
+				KeyPEM:       string(keyPEM),
+				MinVersion:   tt.minVersion,
+				CipherSuites: tt.cipherSuites,
+			}
+			err := tlsConfig.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	tlsConfig := &TLS{MinVersion: "1.3"}
+	if tlsConfig.Version() != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %x", tlsConfig.Version())
+	}
+	if ids := (&TLS{}).CipherSuiteIDs(); ids != nil {
+		t.Errorf("Expected no cipher suites by default, got %v", ids)
+	}
+}
+
+func generateKeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fluxgate-test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
 func TestConfigManager(t *testing.T) {
 	manager := NewManager()
 
@@ -397,6 +1203,126 @@ server:
 	}
 }
 
+func TestLoadFilesMerged(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	base := write("base.yaml", `
+server:
+  port: 8080
+  metrics_port: 9090
+logging:
+  level: info
+  outputs: [stderr, /var/log/fluxgate.log]
+services:
+  orders:
+    retries: 1
+    retry_on: [502]
+`)
+	prod := write("prod.yaml", `
+server:
+  port: 8443
+logging:
+  outputs: [stdout]
+services:
+  orders:
+    retry_on: [503]
+  billing:
+    retries: 2
+`)
+
+	cfg, err := LoadFiles([]string{base, prod})
+	if err != nil {
+		t.Fatalf("Failed to load merged config: %v", err)
+	}
+	if cfg.Server.Port != 8443 || cfg.Server.MetricsPort != 9090 {
+		t.Errorf("Expected port 8443 over the base metrics port 9090, got %d and %d", cfg.Server.Port, cfg.Server.MetricsPort)
+	}
+	if cfg.Logging.Level != "info" || !reflect.DeepEqual(cfg.Logging.Outputs, []string{"stdout"}) {
+		t.Errorf("Expected lists replaced and other keys kept, got level %q outputs %v", cfg.Logging.Level, cfg.Logging.Outputs)
+	}
+	orders := cfg.Services["orders"]
+	if orders.Retries != 1 || !reflect.DeepEqual(orders.RetryOn, []int{503}) {
+		t.Errorf("Expected orders merged key by key, got %+v", orders)
+	}
+	if cfg.Services["billing"].Retries != 2 {
+		t.Errorf("Expected billing added by the override, got %+v", cfg.Services["billing"])
+	}
+
+	// * the result is validated as a whole
+	clash := write("clash.yaml", "server:\n  port: 9090\n")
+	if _, err := LoadFiles([]string{base, clash}); err == nil {
+		t.Error("Expected the merged port clash to be rejected")
+	}
+	if _, err := LoadFiles([]string{base, filepath.Join(tmpDir, "missing.yaml")}); err == nil {
+		t.Error("Expected a missing override file to be an error")
+	}
+}
+
+func TestManagerDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fluxgate.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	write(`
+timeouts:
+  read: 30s
+metrics:
+  token: old-token
+services:
+  orders:
+    retries: 1
+`)
+
+	manager := NewManager()
+	manager.Override(func(cfg *Config) { cfg.Server.Port = 8181 })
+	if err := manager.Load(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	write(`
+timeouts:
+  read: 45s
+metrics:
+  token: new-token
+services:
+  orders:
+    retries: 2
+  billing:
+    retries: 1
+`)
+	changes, err := manager.DryRun()
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	want := []Change{
+		{Path: "metrics.token", Old: "***", New: "***"},
+		{Path: "services.billing.on_unavailable.action", New: "error"},
+		{Path: "services.billing.retries", New: 1},
+		{Path: "services.orders.retries", Old: 1, New: 2},
+		{Path: "timeouts.read", Old: "30s", New: "45s"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected changes %+v, got %+v", want, changes)
+	}
+	if manager.Get().Timeouts.Read != 30*time.Second {
+		t.Errorf("Expected the dry run to leave the current config alone, got read timeout %v", manager.Get().Timeouts.Read)
+	}
+
+	write("server:\n  metrics_port: 8181\n")
+	if _, err := manager.DryRun(); err == nil {
+		t.Error("Expected an invalid candidate to be reported")
+	}
+}
+
 func TestConvenienceMethods(t *testing.T) {
 	cfg := &Config{
 		Server: ServerConfig{
@@ -430,3 +1356,27 @@ func TestConvenienceMethods(t *testing.T) {
 		t.Errorf("Expected health check timeout 10s, got %v", cfg.GetHealthCheckTimeout())
 	}
 }
+
+func TestForwardProxyAllows(t *testing.T) {
+	f := ForwardProxyConfig{AllowedTargets: []string{"api.example.com:443", "*.internal.example:8443"}}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"api.example.com:443", true},
+		{"API.example.com:443", true},
+		{"api.example.com:80", false},
+		{"evil.com:443", false},
+		{"db.internal.example:8443", true},
+		{"a.b.internal.example:8443", true},
+		{"internal.example:8443", false},
+		{"xinternal.example:8443", false},
+		{"api.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := f.Allows(tt.target); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
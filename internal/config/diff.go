@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedKeys hold credentials, whose values a Change never shows.
+var redactedKeys = map[string]bool{"token": true, "secret": true, "key_pem": true, "gossip_keys": true}
+
+// Change is one setting that differs between two configurations, by its
+// dotted YAML path such as "services.orders.retries". Old or New is absent
+// when the setting is only set on one side; lists change as a whole.
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Diff returns the settings that differ from old to new, sorted by path.
+func Diff(old, new *Config) ([]Change, error) {
+	oldValues, err := settings(old)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := settings(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffValues("", oldValues, newValues, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// settings returns cfg as the generic values it marshals to.
+func settings(cfg *Config) (map[string]any, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling config: %w", err)
+	}
+	values := make(map[string]any)
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("marshaling config: %w", err)
+	}
+	return values, nil
+}
+
+func diffValues(prefix string, old, new map[string]any, changes *[]Change) {
+	keys := make(map[string]bool, len(old)+len(new))
+	for key := range old {
+		keys[key] = true
+	}
+	for key := range new {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		oldValue, newValue := old[key], new[key]
+		oldMap, oldIsMap := oldValue.(map[string]any)
+		newMap, newIsMap := newValue.(map[string]any)
+		switch {
+		case oldIsMap && newIsMap:
+			diffValues(path, oldMap, newMap, changes)
+		case oldValue == nil && newIsMap:
+			diffValues(path, nil, newMap, changes)
+		case oldIsMap && newValue == nil:
+			diffValues(path, oldMap, nil, changes)
+		case !reflect.DeepEqual(oldValue, newValue):
+			if redactedKeys[key] {
+				oldValue, newValue = redacted(oldValue), redacted(newValue)
+			}
+			*changes = append(*changes, Change{Path: path, Old: oldValue, New: newValue})
+		}
+	}
+}
+
+func redacted(value any) any {
+	if value == nil {
+		return nil
+	}
+	return "***"
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Example renders the default configuration as YAML with every field present,
+// including the ones omitted by omitempty, so it doubles as a field reference.
+func Example() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(exampleNode(reflect.ValueOf(Default()).Elem())); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JSONSchema generates a JSON Schema for the config file from the Config
+// struct tags, with defaults taken from Default.
+func JSONSchema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Config{}), reflect.ValueOf(*Default()))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "FluxGate configuration"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// yamlFields returns the exported fields of a struct type with their yaml keys.
+func yamlFields(t reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || yamlName(field) == "-" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func exampleNode(v reflect.Value) *yaml.Node {
+	if v.Type() == durationType {
+		return scalarNode("!!str", time.Duration(v.Int()).String())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return exampleNode(reflect.New(v.Type().Elem()).Elem())
+		}
+		return exampleNode(v.Elem())
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range yamlFields(v.Type()) {
+			fv := v.FieldByIndex(field.Index)
+			var value *yaml.Node
+			if fv.Kind() == reflect.Pointer && fv.IsNil() && fv.Type().Elem().Kind() == reflect.Struct {
+				// * optional sections stay disabled, their keys are listed instead
+				keys := make([]string, 0)
+				for _, sub := range yamlFields(fv.Type().Elem()) {
+					keys = append(keys, yamlName(sub))
+				}
+				value = scalarNode("!!null", "null")
+				value.LineComment = "optional: " + strings.Join(keys, ", ")
+			} else {
+				value = exampleNode(fv)
+			}
+			node.Content = append(node.Content, scalarNode("!!str", yamlName(field)), value)
+		}
+		return node
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+		iter := v.MapRange()
+		for iter.Next() {
+			node.Content = append(node.Content, scalarNode("!!str", fmt.Sprint(iter.Key().Interface())), exampleNode(iter.Value()))
+		}
+		return node
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if v.Len() == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		for i := 0; i < v.Len(); i++ {
+			node.Content = append(node.Content, exampleNode(v.Index(i)))
+		}
+		return node
+	case reflect.Bool:
+		return scalarNode("!!bool", strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return scalarNode("!!int", strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return scalarNode("!!int", strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return scalarNode("!!float", strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	default:
+		return scalarNode("!!str", v.String())
+	}
+}
+
+func scalarNode(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// schemaFor describes t, attaching the value of def as the default when set.
+func schemaFor(t reflect.Type, def reflect.Value) map[string]any {
+	if t == durationType {
+		schema := map[string]any{
+			"type":    "string",
+			"pattern": `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`,
+		}
+		if def.IsValid() && def.Int() != 0 {
+			schema["default"] = time.Duration(def.Int()).String()
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if def.IsValid() && !def.IsNil() {
+			return schemaFor(t.Elem(), def.Elem())
+		}
+		return schemaFor(t.Elem(), reflect.Value{})
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for _, field := range yamlFields(t) {
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.FieldByIndex(field.Index)
+			}
+			properties[yamlName(field)] = schemaFor(field.Type, fieldDef)
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaFor(t.Elem(), reflect.Value{}),
+		}
+	case reflect.Slice:
+		return map[string]any{
+			"type":  "array",
+			"items": schemaFor(t.Elem(), reflect.Value{}),
+		}
+	}
+
+	schema := make(map[string]any)
+	switch t.Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	default:
+		schema["type"] = "string"
+	}
+
+	if def.IsValid() && !def.IsZero() {
+		schema["default"] = def.Interface()
+	}
+	return schema
+}
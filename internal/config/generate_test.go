@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExampleRoundTrips(t *testing.T) {
+	data, err := Example()
+	if err != nil {
+		t.Fatalf("Failed to generate example config: %v", err)
+	}
+
+	for _, key := range []string{"server:", "tls: null # optional: cert_file, key_file", "load_balancer:", "max_connections:", "join_address:", "tcp_nodelay: true"} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("Expected example config to contain %q", key)
+		}
+	}
+
+	configFile := filepath.Join(t.TempDir(), "example.yaml")
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write example config: %v", err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load generated example: %v", err)
+	}
+	if cfg.TLS != nil {
+		t.Error("Expected optional tls section to stay disabled")
+	}
+	if cfg.Server.Port != 8080 {
+		t.Errorf("Expected port 8080, got %d", cfg.Server.Port)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	var schema struct {
+		Type       string `json:"type"`
+		Properties map[string]struct {
+			Properties map[string]map[string]any `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	if schema.Type != "object" {
+		t.Errorf("Expected object schema, got %s", schema.Type)
+	}
+
+	port, exists := schema.Properties["server"].Properties["port"]
+	if !exists {
+		t.Fatal("Expected server.port in schema")
+	}
+	if port["type"] != "integer" || port["default"] != float64(8080) {
+		t.Errorf("Unexpected server.port schema: %v", port)
+	}
+
+	interval := schema.Properties["health_check"].Properties["interval"]
+	if interval["type"] != "string" || interval["default"] != "10s" {
+		t.Errorf("Unexpected health_check.interval schema: %v", interval)
+	}
+}
@@ -2,34 +2,53 @@ package config
 
 import (
 	"log"
+	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// defaultHotReloadDebounce is server.hot_reload_debounce when unset.
+const defaultHotReloadDebounce = 100 * time.Millisecond
+
+// rearmInterval is how often a watch whose directory disappeared is retried.
+const rearmInterval = time.Second
+
+// configMapData is the symlink Kubernetes swaps to update a mounted ConfigMap;
+// the config file is a symlink through it into a timestamped directory.
+const configMapData = "..data"
+
+// Watcher reloads the config files into a Manager when any of them changes.
 type Watcher struct {
-	manager  *Manager
-	watcher  *fsnotify.Watcher
-	filename string
-	done     chan struct{}
+	manager   *Manager
+	watcher   *fsnotify.Watcher
+	filenames []string
+	// targets maps each file to itself with symlinks resolved, dirs holds the
+	// watched directories
+	targets map[string]string
+	dirs    map[string]bool
+	done    chan struct{}
 }
 
-func NewWatcher(manager *Manager, filename string) (*Watcher, error) {
+func NewWatcher(manager *Manager, filenames ...string) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 
 	w := &Watcher{
-		manager:  manager,
-		watcher:  watcher,
-		filename: filename,
-		done:     make(chan struct{}),
+		manager: manager,
+		watcher: watcher,
+		targets: make(map[string]string),
+		dirs:    make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	for _, filename := range filenames {
+		w.filenames = append(w.filenames, filepath.Clean(filename))
 	}
 
-	dir := filepath.Dir(filename)
-	if err := watcher.Add(dir); err != nil {
+	if _, err := w.arm(); err != nil {
 		watcher.Close()
 		return nil, err
 	}
@@ -46,9 +65,109 @@ func (w *Watcher) Stop() {
 	w.watcher.Close()
 }
 
+// arm watches the directory of each config file and, when it is a symlink, the
+// directory of the file it resolves to, dropping directories no longer
+// involved. It returns the files that now resolve elsewhere.
+func (w *Watcher) arm() ([]string, error) {
+	var changed []string
+	wanted := make(map[string]bool)
+	for _, filename := range w.filenames {
+		target, err := filepath.EvalSymlinks(filename)
+		if err != nil {
+			target = filename
+		}
+		if w.targets[filename] != target {
+			changed = append(changed, filename)
+		}
+		w.targets[filename] = target
+		wanted[filepath.Dir(filename)] = true
+		wanted[filepath.Dir(target)] = true
+	}
+
+	for dir := range w.dirs {
+		if !wanted[dir] {
+			w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range wanted {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			return nil, err
+		}
+		w.dirs[dir] = true
+	}
+	return changed, nil
+}
+
+// watches reports whether name is one of the config files or what one
+// resolves to, and whether it is a config file itself.
+func (w *Watcher) watches(name string) (watched, file bool) {
+	for _, filename := range w.filenames {
+		if name == filename {
+			return true, true
+		}
+		if name == w.targets[filename] {
+			watched = true
+		}
+	}
+	return watched, false
+}
+
+// inConfigDir reports whether name is directly in a config file's directory.
+func (w *Watcher) inConfigDir(name string) bool {
+	for _, filename := range w.filenames {
+		if filepath.Dir(name) == filepath.Dir(filename) {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *Watcher) watch() {
-	debounce := time.NewTimer(0)
-	<-debounce.C
+	var reload *time.Timer
+	var rearm *time.Ticker
+	var rearmC <-chan time.Time
+	defer func() {
+		if reload != nil {
+			reload.Stop()
+		}
+		if rearm != nil {
+			rearm.Stop()
+		}
+	}()
+
+	scheduleReload := func() {
+		if reload != nil {
+			reload.Stop()
+		}
+		reload = time.AfterFunc(w.debounce(), w.reload)
+	}
+	// * re-resolves the config files, retrying until their directories exist
+	rearmWatch := func(reason string) {
+		changed, err := w.arm()
+		if err != nil {
+			if rearm == nil {
+				log.Printf("Config watch lost (%s), retrying: %v", reason, err)
+				rearm = time.NewTicker(rearmInterval)
+				rearmC = rearm.C
+			}
+			return
+		}
+		if rearm != nil {
+			rearm.Stop()
+			rearm, rearmC = nil, nil
+			changed = w.filenames
+		}
+		for _, filename := range changed {
+			log.Printf("Config watch re-armed (%s), %s resolves to %s", reason, filename, w.targets[filename])
+		}
+		if len(changed) > 0 {
+			scheduleReload()
+		}
+	}
 
 	for {
 		select {
@@ -56,22 +175,37 @@ func (w *Watcher) watch() {
 			if !ok {
 				return
 			}
+			name := filepath.Clean(event.Name)
+			watched, file := w.watches(name)
 
-			if filepath.Clean(event.Name) == filepath.Clean(w.filename) {
-				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
-					debounce.Stop()
-					debounce = time.NewTimer(100 * time.Millisecond)
-					
-					go func() {
-						<-debounce.C
-						log.Printf("Configuration file changed, reloading...")
-						if err := w.manager.Load(w.filename); err != nil {
-							log.Printf("Failed to reload configuration: %v", err)
-						}
-					}()
+			switch {
+			case w.dirs[name] && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				// * the watched directory itself was replaced, its watch is stale
+				w.watcher.Remove(name)
+				delete(w.dirs, name)
+				rearmWatch(name + " replaced")
+
+			case filepath.Base(name) == configMapData && w.inConfigDir(name):
+				// * a ConfigMap update swaps ..data to a new timestamped directory
+				if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					rearmWatch(configMapData + " swapped")
+				}
+
+			case watched:
+				// * editors and kubectl cp replace the file atomically, which
+				// * shows up as Rename or Remove of the old file and Create of
+				// * the new one; a replaced symlink may point somewhere new
+				if file && event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					rearmWatch(name + " replaced")
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					scheduleReload()
 				}
 			}
 
+		case <-rearmC:
+			rearmWatch("retry")
+
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
 				return
@@ -82,4 +216,34 @@ func (w *Watcher) watch() {
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+// debounce returns how long to wait for the config file to settle.
+func (w *Watcher) debounce() time.Duration {
+	if d := w.manager.Get().Server.HotReloadDebounce; d > 0 {
+		return d
+	}
+	return defaultHotReloadDebounce
+}
+
+// reload loads the config files once they have settled. A file that is gone
+// keeps the current configuration, rather than falling back to the defaults.
+func (w *Watcher) reload() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	for _, filename := range w.filenames {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			log.Printf("Configuration file %s removed, keeping the current configuration until it is recreated", filename)
+			return
+		}
+	}
+
+	log.Printf("Configuration file changed, reloading...")
+	if err := w.manager.Load(w.filenames...); err != nil {
+		log.Printf("Failed to reload configuration: %v", err)
+	}
+}
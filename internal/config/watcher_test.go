@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatcherAtomicReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fluxgate.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  port: 8080\n  hot_reload_debounce: 50ms\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	manager := NewManager()
+	if err := manager.Load(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var reloads atomic.Int32
+	manager.Subscribe(func(*Config) { reloads.Add(1) })
+
+	w, err := NewWatcher(manager, path)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	// * the way vim does it: move the old file aside, write a new one in place
+	if err := os.Rename(path, path+"~"); err != nil {
+		t.Fatalf("Failed to move config: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("server:\n  port: 8081\n  hot_reload_debounce: 50ms\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.Get().Server.Port != 8081 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if port := manager.Get().Server.Port; port != 8081 {
+		t.Fatalf("Expected the replaced config to be loaded, got port %d", port)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := reloads.Load(); n != 1 {
+		t.Errorf("Expected exactly one reload, got %d", n)
+	}
+
+	// * a removed file keeps the current configuration
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove config: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if port := manager.Get().Server.Port; port != 8081 {
+		t.Errorf("Expected the configuration to survive removal, got port %d", port)
+	}
+	if n := reloads.Load(); n != 1 {
+		t.Errorf("Expected no reload for a removed file, got %d", n)
+	}
+}
+
+// waitForPort polls until the managed config has port, failing after 3s.
+func waitForPort(t *testing.T, manager *Manager, port int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for manager.Get().Server.Port != port && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := manager.Get().Server.Port; got != port {
+		t.Fatalf("Expected port %d after the change, got %d", port, got)
+	}
+}
+
+func TestWatcherConfigMapSwap(t *testing.T) {
+	dir := t.TempDir()
+	writeVersion := func(version string, port int) {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", version, err)
+		}
+		data := []byte("server:\n  port: " + strconv.Itoa(port) + "\n  hot_reload_debounce: 20ms\n")
+		if err := os.WriteFile(filepath.Join(dir, version, "fluxgate.yaml"), data, 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	// * the layout the kubelet uses for a mounted ConfigMap
+	writeVersion("..2024_01_01", 8080)
+	if err := os.Symlink("..2024_01_01", filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("Failed to link ..data: %v", err)
+	}
+	path := filepath.Join(dir, "fluxgate.yaml")
+	if err := os.Symlink(filepath.Join("..data", "fluxgate.yaml"), path); err != nil {
+		t.Fatalf("Failed to link config: %v", err)
+	}
+
+	manager := NewManager()
+	if err := manager.Load(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	w, err := NewWatcher(manager, path)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	for i, port := range []int{8081, 8082} {
+		version := "..2024_01_0" + strconv.Itoa(i+2)
+		writeVersion(version, port)
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatalf("Failed to link ..data_tmp: %v", err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatalf("Failed to swap ..data: %v", err)
+		}
+		waitForPort(t, manager, port)
+	}
+}
+
+func TestWatcherDirectoryReplaced(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "conf")
+	path := filepath.Join(dir, "fluxgate.yaml")
+	writeConfig := func(port int) {
+		data := []byte("server:\n  port: " + strconv.Itoa(port) + "\n  hot_reload_debounce: 20ms\n")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	os.Mkdir(dir, 0755)
+	writeConfig(8080)
+
+	manager := NewManager()
+	if err := manager.Load(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	w, err := NewWatcher(manager, path)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	if err := os.Rename(dir, filepath.Join(root, "conf.old")); err != nil {
+		t.Fatalf("Failed to move the directory: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	os.Mkdir(dir, 0755)
+	writeConfig(8081)
+	waitForPort(t, manager, 8081)
+
+	// * the re-armed watch keeps picking up changes
+	writeConfig(8082)
+	waitForPort(t, manager, 8082)
+}
+
+func TestWatcherMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	override := filepath.Join(dir, "prod.yaml")
+	if err := os.WriteFile(base, []byte("server:\n  port: 8080\n  hot_reload_debounce: 20ms\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.WriteFile(override, []byte("server:\n  port: 8081\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	manager := NewManager()
+	if err := manager.Load(base, override); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	w, err := NewWatcher(manager, base, override)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	if err := os.WriteFile(override, []byte("server:\n  port: 8082\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	waitForPort(t, manager, 8082)
+
+	if err := os.WriteFile(base, []byte("server:\n  port: 8080\n  metrics_port: 9191\n  hot_reload_debounce: 20ms\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for manager.Get().Server.MetricsPort != 9191 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cfg := manager.Get(); cfg.Server.MetricsPort != 9191 || cfg.Server.Port != 8082 {
+		t.Errorf("Expected the base change merged under the override, got port %d metrics port %d", cfg.Server.Port, cfg.Server.MetricsPort)
+	}
+}
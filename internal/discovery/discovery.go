@@ -5,16 +5,52 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/fluxgate/fluxgate/internal/metrics"
 	"github.com/hashicorp/memberlist"
 )
 
+const (
+	// maxStateBytes caps the push/pull state, below the 20 MiB memberlist
+	// accepts from a remote node
+	maxStateBytes = 16 << 20
+	// stateWarnRatio of maxStateBytes logs a warning before the cap is hit
+	stateWarnRatio = 0.8
+	// broadcastWarnDepth queued broadcasts mean changes are made faster than
+	// they are gossiped, or not gossiped at all
+	broadcastWarnDepth = 256
+)
+
 type Service struct {
+	name       string
 	list       *memberlist.Memberlist
 	broadcasts *memberlist.TransmitLimitedQueue
 	services   map[string][]ServiceInstance
+	owned      map[string]bool
 	mu         sync.RWMutex
 	onChange   []func(services map[string][]ServiceInstance)
+	// stateLimit is the push/pull state cap, maxStateBytes outside tests
+	stateLimit int
+	// stateWarned and metaWarned keep size warnings to one per crossing
+	stateWarned atomic.Bool
+	metaWarned  atomic.Bool
+	// broadcastWarnDepth is the broadcast backlog warned about,
+	// broadcastWarnDepth outside tests. broadcastWarned keeps the warning to
+	// one per crossing.
+	broadcastWarnDepth int
+	broadcastWarned    atomic.Bool
+	// hadPeers is set once another node was seen; a node alone from the
+	// start has no one to gossip to and its backlog is expected
+	hadPeers atomic.Bool
+	// synced is set once remote state has been merged, or right away for a
+	// node that joins no one
+	synced atomic.Bool
+	// keyring holds the gossip encryption keys, nil when gossip is plaintext
+	keyring *memberlist.Keyring
+	// maxServices caps the services with instances, 0 means unlimited
+	maxServices int
 }
 
 type ServiceInstance struct {
@@ -30,17 +66,32 @@ type broadcast struct {
 	notify chan<- struct{}
 }
 
-func New(port int, joinAddr string) (*Service, error) {
+// New starts a gossip node on port, joining joinAddr if set. With keys, gossip
+// is encrypted with the first key and any of them is accepted on receipt.
+func New(port int, joinAddr string, keys ...[]byte) (*Service, error) {
 	s := &Service{
-		services: make(map[string][]ServiceInstance),
-		onChange: make([]func(map[string][]ServiceInstance), 0),
+		services:   make(map[string][]ServiceInstance),
+		owned:      make(map[string]bool),
+		onChange:   make([]func(map[string][]ServiceInstance), 0),
+		stateLimit: maxStateBytes,
+		name:       fmt.Sprintf("fluxgate-%d", port),
+
+		broadcastWarnDepth: broadcastWarnDepth,
 	}
 
 	config := memberlist.DefaultLocalConfig()
 	config.BindPort = port
-	config.Name = fmt.Sprintf("fluxgate-%d", port)
+	config.Name = s.name
 	config.Delegate = s
 	config.Events = s
+	if len(keys) > 0 {
+		keyring, err := memberlist.NewKeyring(keys, keys[0])
+		if err != nil {
+			return nil, fmt.Errorf("creating gossip keyring: %w", err)
+		}
+		config.Keyring = keyring
+		s.keyring = keyring
+	}
 
 	list, err := memberlist.Create(config)
 	if err != nil {
@@ -60,15 +111,23 @@ func New(port int, joinAddr string) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("joining cluster: %w", err)
 		}
+	} else {
+		s.synced.Store(true)
 	}
 
 	return s, nil
 }
 
+// Register adds or updates an instance and gossips it. A new instance is owned
+// by this node; updating one keeps its owner, so an instance learned from
+// another node is not deregistered by this node's Leave.
 func (s *Service) Register(instance ServiceInstance) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if !s.admitService(instance.Service) {
+		return ErrServiceLimit
+	}
 	if s.services[instance.Service] == nil {
 		s.services[instance.Service] = make([]ServiceInstance, 0)
 	}
@@ -84,6 +143,7 @@ func (s *Service) Register(instance ServiceInstance) error {
 
 	if !exists {
 		s.services[instance.Service] = append(s.services[instance.Service], instance)
+		s.owned[instance.ID] = true
 	}
 
 	data, err := json.Marshal(map[string]any{
@@ -94,7 +154,7 @@ func (s *Service) Register(instance ServiceInstance) error {
 		return err
 	}
 
-	s.broadcasts.QueueBroadcast(&broadcast{
+	s.queueBroadcast(&broadcast{
 		msg: data,
 	})
 
@@ -106,30 +166,134 @@ func (s *Service) Deregister(serviceID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if !s.removeInstance(serviceID) {
+		return fmt.Errorf("service instance not found: %s", serviceID)
+	}
+
+	if err := s.queueDeregistration(serviceID, nil); err != nil {
+		return err
+	}
+
+	s.notifyListeners()
+	return nil
+}
+
+// removeInstance drops an instance from the local registry, callers hold s.mu.
+func (s *Service) removeInstance(serviceID string) bool {
 	for service, instances := range s.services {
 		for i, inst := range instances {
 			if inst.ID == serviceID {
 				s.services[service] = append(instances[:i], instances[i+1:]...)
+				delete(s.owned, serviceID)
+				return true
+			}
+		}
+	}
+	return false
+}
 
-				data, err := json.Marshal(map[string]any{
-					"action":     "deregister",
-					"service_id": serviceID,
-				})
-				if err != nil {
-					return err
-				}
+func (s *Service) queueDeregistration(serviceID string, notify chan<- struct{}) error {
+	data, err := json.Marshal(map[string]any{
+		"action":     "deregister",
+		"service_id": serviceID,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.queueBroadcast(&broadcast{
+		msg:    data,
+		notify: notify,
+	})
+	return nil
+}
 
-				s.broadcasts.QueueBroadcast(&broadcast{
-					msg: data,
-				})
+// queueBroadcast queues a message for gossip. Nothing confirms its delivery,
+// so the backlog is reported instead: it only grows when the node changes
+// state faster than it can gossip, or can't reach its peers at all.
+func (s *Service) queueBroadcast(b *broadcast) {
+	s.broadcasts.QueueBroadcast(b)
+	s.reportBroadcasts()
+}
 
-				s.notifyListeners()
-				return nil
+// reportBroadcasts publishes the broadcast backlog and warns once it backs up
+// on a node that has seen peers.
+func (s *Service) reportBroadcasts() {
+	depth := s.broadcasts.NumQueued()
+	metrics.GossipBroadcastQueue.Set(float64(depth))
+
+	if depth < s.broadcastWarnDepth {
+		s.broadcastWarned.Store(false)
+		return
+	}
+	if s.hadPeers.Load() && !s.broadcastWarned.Swap(true) {
+		log.Printf("WARNING: %d gossip broadcasts queued, local registrations may not be reaching the cluster; check connectivity to the other nodes", depth)
+	}
+}
+
+// Leave deregisters the instances registered on this node, waits for the
+// deregistrations to be gossiped and leaves the cluster, all within timeout.
+// It reports whether everything completed before the deadline.
+func (s *Service) Leave(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	s.mu.Lock()
+	pending := make([]chan struct{}, 0, len(s.owned))
+	for serviceID := range s.owned {
+		s.removeInstance(serviceID)
+		notify := make(chan struct{})
+		if err := s.queueDeregistration(serviceID, notify); err != nil {
+			log.Printf("Failed to queue deregistration of %s: %v", serviceID, err)
+			continue
+		}
+		pending = append(pending, notify)
+	}
+	if len(pending) > 0 {
+		s.notifyListeners()
+	}
+	s.mu.Unlock()
+
+	completed := true
+	// * with no peers the broadcasts are never transmitted, nothing to wait for
+	if s.list.NumMembers() > 1 {
+		for _, notify := range pending {
+			select {
+			case <-notify:
+			case <-time.After(time.Until(deadline)):
+				completed = false
 			}
 		}
 	}
 
-	return fmt.Errorf("service instance not found: %s", serviceID)
+	if err := s.list.Leave(time.Until(deadline)); err != nil {
+		log.Printf("Failed to leave cluster gracefully: %v", err)
+		completed = false
+	}
+	if err := s.list.Shutdown(); err != nil {
+		log.Printf("Failed to shut down memberlist: %v", err)
+	}
+
+	result := "completed"
+	if !completed {
+		result = "timeout"
+	}
+	metrics.GracefulLeaves.WithLabelValues(result).Inc()
+	log.Printf("Left cluster (%s), deregistered %d local instances", result, len(pending))
+
+	return completed
+}
+
+// NumMembers returns the number of live nodes in the cluster, this one included.
+func (s *Service) NumMembers() int {
+	return s.list.NumMembers()
+}
+
+// IsLocal reports whether an instance was registered on this node rather than
+// learned from the cluster.
+func (s *Service) IsLocal(serviceID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.owned[serviceID]
 }
 
 func (s *Service) GetInstances(service string) []ServiceInstance {
@@ -181,9 +345,15 @@ func (s *Service) NodeMeta(limit int) []byte {
 	defer s.mu.RUnlock()
 
 	data, _ := json.Marshal(s.services)
+	metrics.GossipPayloadBytes.WithLabelValues("meta").Set(float64(len(data)))
 	if len(data) > limit {
+		// * peers only read services from the push/pull state, so nothing is lost
+		if !s.metaWarned.Swap(true) {
+			log.Printf("Gossip node metadata of %d bytes exceeds memberlist's %d byte limit, sending none; services are still shared through state sync", len(data), limit)
+		}
 		return nil
 	}
+	s.metaWarned.Store(false)
 	return data
 }
 
@@ -207,7 +377,7 @@ func (s *Service) NotifyMsg(msg []byte) {
 		if instanceData, ok := message["instance"].(map[string]any); ok {
 			var instance ServiceInstance
 			data, _ := json.Marshal(instanceData)
-			if err := json.Unmarshal(data, &instance); err == nil {
+			if err := json.Unmarshal(data, &instance); err == nil && s.admitService(instance.Service) {
 				if s.services[instance.Service] == nil {
 					s.services[instance.Service] = make([]ServiceInstance, 0)
 				}
@@ -244,7 +414,9 @@ func (s *Service) NotifyMsg(msg []byte) {
 }
 
 func (s *Service) GetBroadcasts(overhead, limit int) [][]byte {
-	return s.broadcasts.GetBroadcasts(overhead, limit)
+	broadcasts := s.broadcasts.GetBroadcasts(overhead, limit)
+	s.reportBroadcasts()
+	return broadcasts
 }
 
 func (s *Service) LocalState(join bool) []byte {
@@ -252,10 +424,50 @@ func (s *Service) LocalState(join bool) []byte {
 	defer s.mu.RUnlock()
 
 	data, _ := json.Marshal(s.services)
+	metrics.GossipPayloadBytes.WithLabelValues("state").Set(float64(len(data)))
+
+	if len(data) > s.stateLimit {
+		// * peers still learn the rest from the nodes owning those instances
+		owned := s.ownedServices()
+		data, _ = json.Marshal(owned)
+		log.Printf("ERROR: gossip state exceeds the %d byte cap, pushing only the %d bytes of locally registered instances", s.stateLimit, len(data))
+		if len(data) > s.stateLimit {
+			log.Printf("ERROR: locally registered instances exceed the %d byte gossip state cap, pushing no state", s.stateLimit)
+			return nil
+		}
+		return data
+	}
+
+	if float64(len(data)) > stateWarnRatio*float64(s.stateLimit) {
+		if !s.stateWarned.Swap(true) {
+			log.Printf("WARNING: gossip state is %d bytes, approaching the %d byte cap", len(data), s.stateLimit)
+		}
+	} else {
+		s.stateWarned.Store(false)
+	}
 	return data
 }
 
+// ownedServices returns the instances registered on this node. The caller
+// must hold s.mu.
+func (s *Service) ownedServices() map[string][]ServiceInstance {
+	owned := make(map[string][]ServiceInstance)
+	for service, instances := range s.services {
+		for _, instance := range instances {
+			if s.owned[instance.ID] {
+				owned[service] = append(owned[service], instance)
+			}
+		}
+	}
+	return owned
+}
+
 func (s *Service) MergeRemoteState(buf []byte, join bool) {
+	if len(buf) == 0 {
+		// * the peer's state was over the cap
+		return
+	}
+
 	var remoteServices map[string][]ServiceInstance
 	if err := json.Unmarshal(buf, &remoteServices); err != nil {
 		log.Printf("Failed to unmarshal remote state: %v", err)
@@ -266,6 +478,9 @@ func (s *Service) MergeRemoteState(buf []byte, join bool) {
 	defer s.mu.Unlock()
 
 	for service, instances := range remoteServices {
+		if len(instances) > 0 && !s.admitService(service) {
+			continue
+		}
 		if s.services[service] == nil {
 			s.services[service] = instances
 		} else {
@@ -285,10 +500,20 @@ func (s *Service) MergeRemoteState(buf []byte, join bool) {
 		}
 	}
 
+	s.synced.Store(true)
 	s.notifyListeners()
 }
 
+// Synced reports whether the node has received the cluster's state at least
+// once. A node started without a join address has nothing to wait for.
+func (s *Service) Synced() bool {
+	return s.synced.Load()
+}
+
 func (s *Service) NotifyJoin(node *memberlist.Node) {
+	if node.Name != s.name {
+		s.hadPeers.Store(true)
+	}
 	log.Printf("Node joined: %s", node.Name)
 }
 
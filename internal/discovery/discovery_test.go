@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLeaveDeregistersOwnedInstances(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+
+	if err := s.Register(ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+
+	// * instances learned through gossip are not owned by this node
+	s.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+
+	// * updating a remote instance doesn't claim it
+	remote := ServiceInstance{ID: "api-2", Service: "api", Address: "10.0.0.2", Port: 8080, Metadata: map[string]string{"weight": "5"}}
+	if err := s.Register(remote); err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	if s.IsLocal("api-2") {
+		t.Error("Expected the remote instance to stay owned by its node after an update")
+	}
+
+	if !s.Leave(time.Second) {
+		t.Error("Expected a single-node leave to complete before the deadline")
+	}
+
+	instances := s.GetInstances("api")
+	if len(instances) != 1 || instances[0].ID != "api-2" {
+		t.Errorf("Expected only the remote instance to remain, got %+v", instances)
+	}
+}
+
+func TestLocalStateCap(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+
+	if err := s.Register(ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	s.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+
+	full := s.LocalState(false)
+	var state map[string][]ServiceInstance
+	if err := json.Unmarshal(full, &state); err != nil || len(state["api"]) != 2 {
+		t.Fatalf("Expected both instances within the cap, got %s", full)
+	}
+
+	// * over the cap only locally registered instances are pushed
+	s.stateLimit = len(full) - 1
+	if err := json.Unmarshal(s.LocalState(false), &state); err != nil {
+		t.Fatalf("Expected valid capped state: %v", err)
+	}
+	if len(state["api"]) != 1 || state["api"][0].ID != "api-1" {
+		t.Errorf("Expected only the local instance, got %+v", state)
+	}
+
+	s.stateLimit = 1
+	if data := s.LocalState(false); data != nil {
+		t.Errorf("Expected no state when local instances exceed the cap, got %s", data)
+	}
+
+	// * an empty push from a capped peer changes nothing
+	s.MergeRemoteState(nil, false)
+	if len(s.GetInstances("api")) != 2 {
+		t.Errorf("Expected instances to survive an empty remote state")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	s.Register(ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080})
+	s.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+	if err := s.SaveSnapshot(path); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+	s.Leave(time.Second)
+
+	restarted, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer restarted.Leave(time.Second)
+
+	// * live state wins over the snapshot
+	restarted.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.9","port":8080}]}`), false)
+
+	restored, err := restarted.LoadSnapshot(path, time.Hour)
+	if err != nil || restored != 1 {
+		t.Fatalf("Expected one restored instance, got %d: %v", restored, err)
+	}
+	for _, instance := range restarted.GetInstances("api") {
+		if instance.ID == "api-2" && instance.Address != "10.0.0.9" {
+			t.Errorf("Expected the live instance to be kept, got %+v", instance)
+		}
+	}
+	if len(restarted.GetInstances("api")) != 2 {
+		t.Errorf("Expected 2 instances, got %+v", restarted.GetInstances("api"))
+	}
+}
+
+func TestLoadSnapshotRejectsStaleAndCorruptFiles(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+
+	dir := t.TempDir()
+	if restored, err := s.LoadSnapshot(filepath.Join(dir, "missing.json"), time.Hour); err != nil || restored != 0 {
+		t.Errorf("Expected a missing snapshot to restore nothing, got %d: %v", restored, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte(`{"saved_at":`), 0o644)
+	if _, err := s.LoadSnapshot(corrupt, time.Hour); err == nil {
+		t.Error("Expected an error for a truncated snapshot")
+	}
+
+	stale := filepath.Join(dir, "stale.json")
+	os.WriteFile(stale, []byte(`{"saved_at":"2020-01-01T00:00:00Z","services":{"api":[{"id":"api-1","service":"api","address":"10.0.0.1","port":8080}]}}`), 0o644)
+	if restored, _ := s.LoadSnapshot(stale, time.Hour); restored != 0 {
+		t.Errorf("Expected a stale snapshot to be ignored, restored %d", restored)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"saved_at":"`+time.Now().Format(time.RFC3339)+`","services":{"api":[{"id":"","service":"api"},{"id":"api-1","service":"api","address":"10.0.0.1","port":8080}]}}`), 0o644)
+	if restored, err := s.LoadSnapshot(invalid, time.Hour); err != nil || restored != 1 {
+		t.Errorf("Expected invalid entries to be skipped, restored %d: %v", restored, err)
+	}
+}
+
+func TestBroadcastBacklog(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	s.broadcastWarnDepth = 2
+	register := func(id string) {
+		t.Helper()
+		if err := s.Register(ServiceInstance{ID: id, Service: "api", Address: "10.0.0.1", Port: 8080}); err != nil {
+			t.Fatalf("Failed to register instance: %v", err)
+		}
+	}
+
+	// * alone from the start, nobody is there to gossip to
+	register("api-1")
+	register("api-2")
+	if strings.Contains(logs.String(), "broadcasts queued") {
+		t.Errorf("Expected no backlog warning without peers, got %q", logs.String())
+	}
+
+	s.hadPeers.Store(true)
+	register("api-3")
+	register("api-4")
+	if n := strings.Count(logs.String(), "broadcasts queued"); n != 1 {
+		t.Errorf("Expected one backlog warning per crossing, got %d in %q", n, logs.String())
+	}
+	if depth := testutil.ToFloat64(metrics.GossipBroadcastQueue); depth != 4 {
+		t.Errorf("Expected a broadcast queue depth of 4, got %v", depth)
+	}
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrNotEncrypted is returned when changing the keys of a node started
+// without gossip encryption, which memberlist can't enable at runtime.
+var ErrNotEncrypted = errors.New("gossip encryption is not enabled, restart the node with keys")
+
+// SetKeys replaces the gossip keys, the first becoming the primary that
+// encrypts. New keys are installed before the primary moves and old ones
+// removed after, so messages in flight keep decrypting.
+func (s *Service) SetKeys(keys [][]byte) error {
+	if s.keyring == nil {
+		if len(keys) == 0 {
+			return nil
+		}
+		return ErrNotEncrypted
+	}
+	if len(keys) == 0 {
+		return errors.New("gossip encryption can't be disabled at runtime, restart the node without keys")
+	}
+
+	for _, key := range keys {
+		if err := s.keyring.AddKey(key); err != nil {
+			return fmt.Errorf("adding gossip key %s: %w", fingerprint(key), err)
+		}
+	}
+	primary := s.keyring.GetPrimaryKey()
+	if !bytes.Equal(primary, keys[0]) {
+		if err := s.keyring.UseKey(keys[0]); err != nil {
+			return fmt.Errorf("using gossip key %s: %w", fingerprint(keys[0]), err)
+		}
+		log.Printf("Gossip primary key changed: %s -> %s", fingerprint(primary), fingerprint(keys[0]))
+	}
+	for _, installed := range s.keyring.GetKeys() {
+		if !containsKey(keys, installed) {
+			if err := s.keyring.RemoveKey(installed); err != nil {
+				return fmt.Errorf("removing gossip key %s: %w", fingerprint(installed), err)
+			}
+			log.Printf("Gossip key %s removed", fingerprint(installed))
+		}
+	}
+	return nil
+}
+
+// KeyFingerprints identifies the installed gossip keys without revealing them:
+// the primary's fingerprint and every key's, primary first. Both are empty
+// when gossip isn't encrypted.
+func (s *Service) KeyFingerprints() (primary string, keys []string) {
+	if s.keyring == nil {
+		return "", nil
+	}
+	for _, key := range s.keyring.GetKeys() {
+		keys = append(keys, fingerprint(key))
+	}
+	return fingerprint(s.keyring.GetPrimaryKey()), keys
+}
+
+// fingerprint is the first 8 bytes of the key's SHA-256, hex-encoded.
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
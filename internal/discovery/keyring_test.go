@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	s, err := New(0, "", oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+
+	steps := []struct {
+		keys        [][]byte
+		wantPrimary []byte
+		wantKeys    [][]byte
+	}{
+		{[][]byte{oldKey, newKey}, oldKey, [][]byte{oldKey, newKey}},
+		{[][]byte{newKey, oldKey}, newKey, [][]byte{newKey, oldKey}},
+		{[][]byte{newKey}, newKey, [][]byte{newKey}},
+	}
+	for i, step := range steps {
+		if err := s.SetKeys(step.keys); err != nil {
+			t.Fatalf("Step %d: failed to set keys: %v", i, err)
+		}
+		primary, keys := s.KeyFingerprints()
+		if primary != fingerprint(step.wantPrimary) {
+			t.Errorf("Step %d: expected primary %s, got %s", i, fingerprint(step.wantPrimary), primary)
+		}
+		var want []string
+		for _, key := range step.wantKeys {
+			want = append(want, fingerprint(key))
+		}
+		slices.Sort(keys)
+		slices.Sort(want)
+		if !slices.Equal(keys, want) {
+			t.Errorf("Step %d: expected keys %v, got %v", i, want, keys)
+		}
+	}
+}
+
+func TestSetKeysWithoutEncryption(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+
+	if primary, _ := s.KeyFingerprints(); primary != "" {
+		t.Errorf("Expected no fingerprint without encryption, got %s", primary)
+	}
+	if err := s.SetKeys(nil); err != nil {
+		t.Errorf("Expected no keys to stay a no-op, got %v", err)
+	}
+	if err := s.SetKeys([][]byte{bytes.Repeat([]byte{1}, 16)}); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package discovery
+
+import (
+	"errors"
+	"log"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// ErrServiceLimit is returned by Register when the instance would add a
+// service beyond the limit set with SetMaxServices.
+var ErrServiceLimit = errors.New("service limit reached")
+
+// SetMaxServices caps the services with at least one instance, 0 removes the
+// cap. Services already known are kept when the cap is lowered below them.
+func (s *Service) SetMaxServices(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxServices = n
+}
+
+// admitService reports whether an instance of service fits the service cap,
+// counting the refusal otherwise. The caller must hold s.mu.
+func (s *Service) admitService(service string) bool {
+	if s.maxServices == 0 || len(s.services[service]) > 0 {
+		return true
+	}
+
+	// * deregistered services leave empty entries behind, they don't count
+	count := 0
+	for _, instances := range s.services {
+		if len(instances) > 0 {
+			count++
+		}
+	}
+	if count < s.maxServices {
+		return true
+	}
+	metrics.LimitRejections.WithLabelValues("services").Inc()
+	log.Printf("Refusing service %s: limit of %d services reached", service, s.maxServices)
+	return false
+}
@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxServices(t *testing.T) {
+	s, err := New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer s.Leave(time.Second)
+	s.SetMaxServices(1)
+
+	if err := s.Register(ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	if err := s.Register(ServiceInstance{ID: "api-2", Service: "api", Address: "10.0.0.2", Port: 8080}); err != nil {
+		t.Errorf("Expected more instances of a known service to fit, got %v", err)
+	}
+	if err := s.Register(ServiceInstance{ID: "web-1", Service: "web", Address: "10.0.0.3", Port: 8080}); !errors.Is(err, ErrServiceLimit) {
+		t.Errorf("Expected ErrServiceLimit for a second service, got %v", err)
+	}
+
+	s.MergeRemoteState([]byte(`{"web":[{"id":"web-2","service":"web","address":"10.0.0.4","port":8080}]}`), false)
+	s.NotifyMsg([]byte(`{"action":"register","instance":{"id":"web-3","service":"web","address":"10.0.0.5","port":8080}}`))
+	if instances := s.GetInstances("web"); len(instances) != 0 {
+		t.Errorf("Expected gossiped services over the limit to be refused, got %+v", instances)
+	}
+
+	s.Deregister("api-1")
+	s.Deregister("api-2")
+	if err := s.Register(ServiceInstance{ID: "web-1", Service: "web", Address: "10.0.0.3", Port: 8080}); err != nil {
+		t.Errorf("Expected room once the first service is gone, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type snapshot struct {
+	SavedAt  time.Time                    `json:"saved_at"`
+	Services map[string][]ServiceInstance `json:"services"`
+}
+
+// SaveSnapshot writes the known services to path. The file is replaced
+// atomically, so a crash mid-write leaves the previous snapshot intact.
+func (s *Service) SaveSnapshot(path string) error {
+	s.mu.RLock()
+	data, err := json.Marshal(snapshot{SavedAt: time.Now(), Services: s.services})
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot merges the services saved at path into the registry and
+// returns how many instances it restored. Instances already known win, and
+// invalid entries are skipped. A missing file, or one older than maxAge when
+// maxAge is positive, restores nothing.
+func (s *Service) LoadSnapshot(path string, maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if age := time.Since(snap.SavedAt); maxAge > 0 && age > maxAge {
+		log.Printf("Ignoring discovery snapshot %s saved %s ago, older than %s", path, age.Round(time.Second), maxAge)
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known := make(map[string]bool)
+	for _, instances := range s.services {
+		for _, instance := range instances {
+			known[instance.ID] = true
+		}
+	}
+
+	restored := 0
+	for service, instances := range snap.Services {
+		for _, instance := range instances {
+			if instance.ID == "" || instance.Service != service || instance.Address == "" || instance.Port <= 0 || known[instance.ID] {
+				continue
+			}
+			if !s.admitService(service) {
+				break
+			}
+			s.services[service] = append(s.services[service], instance)
+			known[instance.ID] = true
+			restored++
+		}
+	}
+
+	if restored > 0 {
+		s.notifyListeners()
+	}
+	return restored, nil
+}
+
+// StartSnapshots saves a snapshot to path every interval until ctx is
+// cancelled. Failures are logged and retried on the next tick.
+func (s *Service) StartSnapshots(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.SaveSnapshot(path); err != nil {
+				log.Printf("Failed to save discovery snapshot: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package loadbalancer
+
+import (
+	"log"
+	"net/url"
+	"sync/atomic"
+)
+
+// Failover spreads backends over pools in priority order, each balanced by its
+// own LoadBalancer. Next only selects from the first pool with a healthy
+// backend, so lower pools take traffic only while every higher pool is down
+// and give it back as soon as one recovers.
+type Failover struct {
+	names []string
+	pools []LoadBalancer
+	// active is the index of the pool last selected from, for logging changes
+	active atomic.Int32
+}
+
+// NewFailover creates a Failover for the named pools, in priority order, with
+// backends of unlisted pools in a final catch-all pool.
+func NewFailover(pools []string, factory Factory) *Failover {
+	f := &Failover{
+		names: append(append([]string(nil), pools...), ""),
+		pools: make([]LoadBalancer, len(pools)+1),
+	}
+	for i := range f.pools {
+		f.pools[i] = factory()
+	}
+	f.active.Store(-1)
+	return f
+}
+
+// poolOf returns the load balancer that holds backends of the named pool.
+func (f *Failover) poolOf(name string) LoadBalancer {
+	for i, pool := range f.names[:len(f.names)-1] {
+		if pool == name {
+			return f.pools[i]
+		}
+	}
+	return f.pools[len(f.pools)-1]
+}
+
+// owner returns the load balancer holding the backend with u, if any. Backends
+// at hand are found directly with poolOf(backend.Pool).
+func (f *Failover) owner(u *url.URL) LoadBalancer {
+	for _, pool := range f.pools {
+		for _, b := range pool.Backends() {
+			if b.URL.String() == u.String() {
+				return pool
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Failover) Add(backend *Backend) {
+	f.poolOf(backend.Pool).Add(backend)
+}
+
+func (f *Failover) Remove(url *url.URL) {
+	if pool := f.owner(url); pool != nil {
+		pool.Remove(url)
+	}
+}
+
+func (f *Failover) Next() *Backend {
+	for i, pool := range f.pools {
+		if len(activeBackends(pool.Backends())) == 0 {
+			continue
+		}
+		f.switchTo(i)
+		// * stay on this pool even when it is at capacity, failover is for outages
+		return pool.Next()
+	}
+	return nil
+}
+
+func (f *Failover) switchTo(i int) {
+	if prev := int(f.active.Swap(int32(i))); prev >= 0 && prev != i {
+		log.Printf("Failover: switching from pool %s to pool %s", f.poolName(prev), f.poolName(i))
+	}
+}
+
+func (f *Failover) poolName(i int) string {
+	if f.names[i] == "" {
+		return "(unlisted)"
+	}
+	return f.names[i]
+}
+
+func (f *Failover) MarkHealthy(backend *Backend) {
+	f.poolOf(backend.Pool).MarkHealthy(backend)
+}
+
+func (f *Failover) MarkUnhealthy(backend *Backend) {
+	f.poolOf(backend.Pool).MarkUnhealthy(backend)
+}
+
+func (f *Failover) ReleaseConnection(backend *Backend) {
+	f.poolOf(backend.Pool).ReleaseConnection(backend)
+}
+
+func (f *Failover) Backends() []*Backend {
+	var backends []*Backend
+	for _, pool := range f.pools {
+		backends = append(backends, pool.Backends()...)
+	}
+	return backends
+}
+
+func (f *Failover) SetWeight(url *url.URL, weight int) bool {
+	if pool := f.owner(url); pool != nil {
+		return pool.SetWeight(url, weight)
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package loadbalancer
+
+import "testing"
+
+func TestFailover(t *testing.T) {
+	f := NewFailover([]string{"local", "dr"}, NewRoundRobin)
+
+	local := &Backend{URL: parseURL("http://local:8080"), Weight: 1, Active: true, Pool: "local"}
+	dr := &Backend{URL: parseURL("http://dr:8080"), Weight: 1, Active: true, Pool: "dr"}
+	other := &Backend{URL: parseURL("http://other:8080"), Weight: 1, Active: true}
+	f.Add(other)
+	f.Add(dr)
+	f.Add(local)
+
+	next := func() *Backend {
+		b := f.Next()
+		if b != nil {
+			f.ReleaseConnection(b)
+		}
+		return b
+	}
+
+	for i := 0; i < 5; i++ {
+		if b := next(); b != local {
+			t.Fatalf("Expected primary pool while healthy, got %v", b)
+		}
+	}
+
+	f.MarkUnhealthy(local)
+	if b := next(); b != dr {
+		t.Fatalf("Expected failover to dr pool, got %v", b)
+	}
+
+	f.MarkUnhealthy(dr)
+	if b := next(); b != other {
+		t.Fatalf("Expected unlisted pool last, got %v", b)
+	}
+
+	f.MarkHealthy(local)
+	if b := next(); b != local {
+		t.Fatalf("Expected failback to primary pool, got %v", b)
+	}
+
+	if len(f.Backends()) != 3 {
+		t.Errorf("Expected 3 backends, got %d", len(f.Backends()))
+	}
+	if !f.SetWeight(dr.URL, 3) || dr.Weight != 3 {
+		t.Error("Expected weight update on dr backend")
+	}
+	f.Remove(local.URL)
+	if b := next(); b != other {
+		t.Errorf("Expected unlisted pool after removing primary, got %v", b)
+	}
+}
+
+func TestFailoverStaysOnSaturatedPool(t *testing.T) {
+	f := NewFailover([]string{"local", "dr"}, NewRoundRobin)
+
+	local := &Backend{URL: parseURL("http://local:8080"), Weight: 1, Active: true, Pool: "local", MaxConnections: 1}
+	f.Add(local)
+	f.Add(&Backend{URL: parseURL("http://dr:8080"), Weight: 1, Active: true, Pool: "dr"})
+
+	if b := f.Next(); b != local {
+		t.Fatalf("Expected primary backend, got %v", b)
+	}
+	if b := f.Next(); b != nil {
+		t.Errorf("Expected no backend while primary is saturated, got %v", b.URL)
+	}
+}
@@ -0,0 +1,67 @@
+package loadbalancer
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// keyedLoadBalancer is implemented by load balancers that restrict which of
+// their backends NextForKey may pick, such as Failover.
+type keyedLoadBalancer interface {
+	NextForKey(key string) *Backend
+}
+
+// NextForKey picks a backend of lb consistently for key, using rendezvous
+// hashing: the same key keeps its backend while that backend stays eligible,
+// and adding or removing a backend only moves the keys that hash to it. If
+// the preferred backend is at capacity, the next in the key's order is used.
+// The backend must be released with lb.ReleaseConnection as with Next.
+func NextForKey(lb LoadBalancer, key string) *Backend {
+	if keyed, ok := lb.(keyedLoadBalancer); ok {
+		return keyed.NextForKey(key)
+	}
+	return nextByHash(activeBackends(lb.Backends()), key)
+}
+
+func nextByHash(candidates []*Backend, key string) *Backend {
+	scores := make(map[*Backend]uint64, len(candidates))
+	for _, b := range candidates {
+		scores[b] = hashScore(key, b.URL.String())
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+
+	for _, b := range candidates {
+		if b.acquire() {
+			return b
+		}
+		recordCapRejection(b)
+	}
+	return nil
+}
+
+func hashScore(key, backend string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(backend))
+	// * fnv's low bits are weak for similar inputs, mix them before comparing
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
+
+// NextForKey picks consistently for key within the pool Next would use.
+func (f *Failover) NextForKey(key string) *Backend {
+	for i, pool := range f.pools {
+		if len(activeBackends(pool.Backends())) == 0 {
+			continue
+		}
+		f.switchTo(i)
+		return NextForKey(pool, key)
+	}
+	return nil
+}
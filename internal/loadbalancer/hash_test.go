@@ -0,0 +1,81 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNextForKeyIsConsistent(t *testing.T) {
+	lb := NewRoundRobin()
+	backends := make([]*Backend, 4)
+	for i := range backends {
+		backends[i] = &Backend{URL: parseURL(fmt.Sprintf("http://backend%d:8080", i)), Weight: 1, Active: true}
+		lb.Add(backends[i])
+	}
+
+	pinned := make(map[string]*Backend)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		b := NextForKey(lb, key)
+		lb.ReleaseConnection(b)
+		if again := NextForKey(lb, key); again != b {
+			t.Fatalf("Expected %s to stay on %s, got %s", key, b.URL, again.URL)
+		} else {
+			lb.ReleaseConnection(again)
+		}
+		pinned[key] = b
+	}
+
+	used := make(map[*Backend]bool)
+	for _, b := range pinned {
+		used[b] = true
+	}
+	if len(used) != len(backends) {
+		t.Errorf("Expected keys to spread over all %d backends, got %d", len(backends), len(used))
+	}
+
+	// * only the keys of a removed backend move
+	lb.MarkUnhealthy(backends[0])
+	for key, b := range pinned {
+		got := NextForKey(lb, key)
+		lb.ReleaseConnection(got)
+		if b != backends[0] && got != b {
+			t.Errorf("Expected %s to stay on %s when another backend left, got %s", key, b.URL, got.URL)
+		}
+		if got == backends[0] {
+			t.Errorf("Expected %s to move off the unhealthy backend", key)
+		}
+	}
+}
+
+func TestNextForKeySkipsBackendAtCapacity(t *testing.T) {
+	lb := NewRoundRobin()
+	for i := 0; i < 2; i++ {
+		lb.Add(&Backend{URL: parseURL(fmt.Sprintf("http://backend%d:8080", i)), Weight: 1, Active: true, MaxConnections: 1})
+	}
+
+	first := NextForKey(lb, "tenant")
+	second := NextForKey(lb, "tenant")
+	if first == nil || second == nil || first == second {
+		t.Fatalf("Expected the key to spill to the other backend at capacity, got %v and %v", first, second)
+	}
+	if third := NextForKey(lb, "tenant"); third != nil {
+		t.Errorf("Expected nil with every backend at capacity, got %s", third.URL)
+	}
+}
+
+func TestFailoverNextForKeyStaysInActivePool(t *testing.T) {
+	f := NewFailover([]string{"primary", "dr"}, NewRoundRobin)
+	primary := &Backend{URL: parseURL("http://primary:8080"), Weight: 1, Active: true, Pool: "primary"}
+	dr := &Backend{URL: parseURL("http://dr:8080"), Weight: 1, Active: true, Pool: "dr"}
+	f.Add(primary)
+	f.Add(dr)
+
+	for i := 0; i < 10; i++ {
+		b := NextForKey(f, fmt.Sprintf("tenant-%d", i))
+		if b != primary {
+			t.Fatalf("Expected the primary pool, got %s", b.URL)
+		}
+		f.ReleaseConnection(b)
+	}
+}
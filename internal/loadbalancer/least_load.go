@@ -0,0 +1,51 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// LeastLoad is least connection biased by the load backends report about
+// themselves (see Backend.ReportLoad). Each backend scores its connections
+// plus its load average, divided by its effective weight, and the lowest
+// score wins. Backends that never report load are ranked by connections only.
+type LeastLoad struct {
+	LeastConnection
+}
+
+func NewLeastLoad() LoadBalancer {
+	return &LeastLoad{
+		LeastConnection: LeastConnection{backends: make([]*Backend, 0)},
+	}
+}
+
+func (ll *LeastLoad) Next() *Backend {
+	ll.mu.RLock()
+	defer ll.mu.RUnlock()
+
+	for {
+		var selected *Backend
+		minScore := math.Inf(1)
+
+		for _, b := range activeBackends(ll.backends) {
+			if b.AtCapacity() {
+				recordCapRejection(b)
+				continue
+			}
+			score := (float64(atomic.LoadInt64(&b.Connections)) + b.Load()) / b.EffectiveWeight()
+			if score < minScore {
+				selected = b
+				minScore = score
+			}
+		}
+
+		if selected == nil {
+			return nil
+		}
+
+		// * another request may have taken the last slot since the scan
+		if selected.acquire() {
+			return selected
+		}
+	}
+}
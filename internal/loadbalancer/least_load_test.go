@@ -0,0 +1,64 @@
+package loadbalancer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBackendReportLoad(t *testing.T) {
+	b := &Backend{URL: parseURL("http://backend1:8080")}
+
+	b.ReportLoad(10)
+	if load := b.Load(); load != 10 {
+		t.Fatalf("Expected first sample to seed the average, got %v", load)
+	}
+
+	b.ReportLoad(20)
+	if load := b.Load(); math.Abs(load-13) > 1e-9 {
+		t.Errorf("Expected average 13, got %v", load)
+	}
+
+	b.ReportLoad(-1)
+	b.ReportLoad(math.NaN())
+	if load := b.Load(); math.Abs(load-13) > 1e-9 {
+		t.Errorf("Expected invalid samples to be ignored, got %v", load)
+	}
+}
+
+func TestLeastLoadPrefersLessLoadedBackends(t *testing.T) {
+	ll := NewLeastLoad()
+
+	busy := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+	idle := &Backend{URL: parseURL("http://backend2:8080"), Weight: 1, Active: true}
+	ll.Add(busy)
+	ll.Add(idle)
+
+	busy.ReportLoad(5)
+	idle.ReportLoad(1)
+
+	// * idle takes connections until its score catches up with busy's load
+	for i := 0; i < 4; i++ {
+		if backend := ll.Next(); backend != idle {
+			t.Fatalf("Pick %d: expected the less loaded backend, got %s", i, backend.URL)
+		}
+	}
+	if backend := ll.Next(); backend != busy {
+		t.Errorf("Expected the busy backend once idle has more outstanding work, got %s", backend.URL)
+	}
+}
+
+func TestLeastLoadWeighsByEffectiveWeight(t *testing.T) {
+	ll := NewLeastLoad()
+
+	small := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+	large := &Backend{URL: parseURL("http://backend2:8080"), Weight: 4, Active: true}
+	ll.Add(small)
+	ll.Add(large)
+
+	small.ReportLoad(2)
+	large.ReportLoad(4)
+
+	if backend := ll.Next(); backend != large {
+		t.Errorf("Expected the higher-weight backend to absorb more load, got %s", backend.URL)
+	}
+}
@@ -1,9 +1,12 @@
 package loadbalancer
 
 import (
+	"math"
 	"net/url"
 	"sync"
 	"sync/atomic"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
 )
 
 type Backend struct {
@@ -11,6 +14,96 @@ type Backend struct {
 	Weight      int
 	Active      bool
 	Connections int64
+	// MaxConnections caps concurrent connections to the backend, 0 means unlimited
+	MaxConnections int64
+	// Pool names the failover pool the backend belongs to, if any
+	Pool string
+	// weightFactor holds the float64 bits of the multiplier applied to Weight,
+	// zero meaning 1
+	weightFactor uint64
+	// sloFactor holds the float64 bits of the multiplier applied while the
+	// backend misses its latency SLO, zero meaning 1
+	sloFactor uint64
+	// load holds the float64 bits of the moving average of reported load
+	load uint64
+}
+
+// WeightFactor returns the multiplier applied to the backend's weight, 1
+// unless lowered with SetWeightFactor.
+func (b *Backend) WeightFactor() float64 {
+	return loadFactor(&b.weightFactor)
+}
+
+// SetWeightFactor scales the backend's share of weighted traffic, e.g. to
+// shed load from a slow backend. factor is clamped to (0, 1].
+func (b *Backend) SetWeightFactor(factor float64) {
+	storeFactor(&b.weightFactor, factor)
+}
+
+// SLOFactor returns the multiplier applied to the backend's weight for
+// missing its latency SLO, 1 unless lowered with SetSLOFactor.
+func (b *Backend) SLOFactor() float64 {
+	return loadFactor(&b.sloFactor)
+}
+
+// SetSLOFactor scales the backend's share of weighted traffic while it misses
+// its latency SLO, on top of the weight factor. factor is clamped to (0, 1].
+func (b *Backend) SetSLOFactor(factor float64) {
+	storeFactor(&b.sloFactor, factor)
+}
+
+func loadFactor(addr *uint64) float64 {
+	bits := atomic.LoadUint64(addr)
+	if bits == 0 {
+		return 1
+	}
+	return math.Float64frombits(bits)
+}
+
+func storeFactor(addr *uint64, factor float64) {
+	if factor <= 0 || factor >= 1 || math.IsNaN(factor) {
+		atomic.StoreUint64(addr, 0)
+		return
+	}
+	atomic.StoreUint64(addr, math.Float64bits(factor))
+}
+
+// loadSmoothing is the weight of the newest sample in the load average.
+const loadSmoothing = 0.3
+
+// Load returns the moving average of the load the backend reported, 0 until
+// it reports any.
+func (b *Backend) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&b.load))
+}
+
+// ReportLoad folds a load sample reported by the backend, e.g. its queue
+// depth, into its moving average. Negative samples are ignored.
+func (b *Backend) ReportLoad(sample float64) {
+	if sample < 0 || math.IsNaN(sample) || math.IsInf(sample, 0) {
+		return
+	}
+	for {
+		bits := atomic.LoadUint64(&b.load)
+		smoothed := sample
+		if previous := math.Float64frombits(bits); previous > 0 {
+			smoothed = loadSmoothing*sample + (1-loadSmoothing)*previous
+		}
+		if atomic.CompareAndSwapUint64(&b.load, bits, math.Float64bits(smoothed)) {
+			metrics.BackendLoad.WithLabelValues(b.URL.String()).Set(smoothed)
+			return
+		}
+	}
+}
+
+// EffectiveWeight is the weight weighted balancing uses: Weight, or 1 for a
+// standby backend in use, times the weight and SLO factors.
+func (b *Backend) EffectiveWeight() float64 {
+	weight := b.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return float64(weight) * b.WeightFactor() * b.SLOFactor()
 }
 
 type LoadBalancer interface {
@@ -19,6 +112,77 @@ type LoadBalancer interface {
 	Next() *Backend
 	MarkHealthy(backend *Backend)
 	MarkUnhealthy(backend *Backend)
+	ReleaseConnection(backend *Backend)
+	Backends() []*Backend
+	SetWeight(url *url.URL, weight int) bool
+}
+
+func (b *Backend) AtCapacity() bool {
+	return b.MaxConnections > 0 && atomic.LoadInt64(&b.Connections) >= b.MaxConnections
+}
+
+// acquire reserves a connection slot on the backend, failing if it is at its cap.
+func (b *Backend) acquire() bool {
+	for {
+		n := atomic.LoadInt64(&b.Connections)
+		if b.MaxConnections > 0 && n >= b.MaxConnections {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.Connections, n, n+1) {
+			return true
+		}
+	}
+}
+
+// activeBackends returns the healthy backends eligible for selection. Zero-weight
+// backends are standby: they are only selected when no weighted backend is healthy.
+func activeBackends(backends []*Backend) []*Backend {
+	weighted := make([]*Backend, 0, len(backends))
+	standby := make([]*Backend, 0)
+	for _, b := range backends {
+		if !b.Active {
+			continue
+		}
+		if b.Weight > 0 {
+			weighted = append(weighted, b)
+		} else {
+			standby = append(standby, b)
+		}
+	}
+
+	if len(weighted) > 0 {
+		return weighted
+	}
+	return standby
+}
+
+// NextExcluding is lb.Next skipping the backends excluded reports true for,
+// such as those a request already failed on. Skipped picks are released again,
+// and when the balancer keeps returning excluded backends the remaining active
+// ones are tried in order. It returns nil once none is left.
+func NextExcluding(lb LoadBalancer, excluded func(*Backend) bool) *Backend {
+	backends := lb.Backends()
+	for range backends {
+		b := lb.Next()
+		if b == nil {
+			return nil
+		}
+		if !excluded(b) {
+			return b
+		}
+		lb.ReleaseConnection(b)
+	}
+
+	for _, b := range activeBackends(backends) {
+		if !excluded(b) && b.acquire() {
+			return b
+		}
+	}
+	return nil
+}
+
+func recordCapRejection(b *Backend) {
+	metrics.BackendCapRejections.WithLabelValues(b.URL.String()).Inc()
 }
 
 type RoundRobin struct {
@@ -60,19 +224,21 @@ func (rr *RoundRobin) Next() *Backend {
 		return nil
 	}
 
-	activeBackends := make([]*Backend, 0)
-	for _, b := range rr.backends {
-		if b.Active {
-			activeBackends = append(activeBackends, b)
-		}
-	}
-
-	if len(activeBackends) == 0 {
+	candidates := activeBackends(rr.backends)
+	if len(candidates) == 0 {
 		return nil
 	}
 
 	n := atomic.AddUint64(&rr.current, 1)
-	return activeBackends[n%uint64(len(activeBackends))]
+	for i := 0; i < len(candidates); i++ {
+		b := candidates[(n+uint64(i))%uint64(len(candidates))]
+		if b.acquire() {
+			return b
+		}
+		recordCapRejection(b)
+	}
+
+	return nil
 }
 
 func (rr *RoundRobin) MarkHealthy(backend *Backend) {
@@ -87,6 +253,33 @@ func (rr *RoundRobin) MarkUnhealthy(backend *Backend) {
 	backend.Active = false
 }
 
+func (rr *RoundRobin) ReleaseConnection(backend *Backend) {
+	atomic.AddInt64(&backend.Connections, -1)
+}
+
+func (rr *RoundRobin) Backends() []*Backend {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	backends := make([]*Backend, len(rr.backends))
+	copy(backends, rr.backends)
+	return backends
+}
+
+// SetWeight updates the weight of the backend with the given URL in place.
+func (rr *RoundRobin) SetWeight(url *url.URL, weight int) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for _, b := range rr.backends {
+		if b.URL.String() == url.String() {
+			b.Weight = weight
+			return true
+		}
+	}
+	return false
+}
+
 type LeastConnection struct {
 	backends []*Backend
 	mu       sync.RWMutex
@@ -102,7 +295,6 @@ func (lc *LeastConnection) Add(backend *Backend) {
 	lc.mu.Lock()
 	defer lc.mu.Unlock()
 
-	backend.Active = true
 	lc.backends = append(lc.backends, backend)
 }
 
@@ -122,21 +314,30 @@ func (lc *LeastConnection) Next() *Backend {
 	lc.mu.RLock()
 	defer lc.mu.RUnlock()
 
-	var selected *Backend
-	minConnections := int64(^uint64(0) >> 1)
+	for {
+		var selected *Backend
+		minConnections := int64(^uint64(0) >> 1)
 
-	for _, b := range lc.backends {
-		if b.Active && b.Connections < minConnections {
-			selected = b
-			minConnections = b.Connections
+		for _, b := range activeBackends(lc.backends) {
+			if b.AtCapacity() {
+				recordCapRejection(b)
+				continue
+			}
+			if conns := atomic.LoadInt64(&b.Connections); conns < minConnections {
+				selected = b
+				minConnections = conns
+			}
 		}
-	}
 
-	if selected != nil {
-		atomic.AddInt64(&selected.Connections, 1)
-	}
+		if selected == nil {
+			return nil
+		}
 
-	return selected
+		// * another request may have taken the last slot since the scan
+		if selected.acquire() {
+			return selected
+		}
+	}
 }
 
 func (lc *LeastConnection) MarkHealthy(backend *Backend) {
@@ -154,3 +355,26 @@ func (lc *LeastConnection) MarkUnhealthy(backend *Backend) {
 func (lc *LeastConnection) ReleaseConnection(backend *Backend) {
 	atomic.AddInt64(&backend.Connections, -1)
 }
+
+func (lc *LeastConnection) Backends() []*Backend {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	backends := make([]*Backend, len(lc.backends))
+	copy(backends, lc.backends)
+	return backends
+}
+
+// SetWeight updates the weight of the backend with the given URL in place.
+func (lc *LeastConnection) SetWeight(url *url.URL, weight int) bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for _, b := range lc.backends {
+		if b.URL.String() == url.String() {
+			b.Weight = weight
+			return true
+		}
+	}
+	return false
+}
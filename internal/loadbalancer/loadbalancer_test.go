@@ -135,6 +135,21 @@ func TestHealthStateChanges(t *testing.T) {
 	}
 }
 
+func TestAddKeepsHealthState(t *testing.T) {
+	for name, factory := range builtins {
+		lb := factory()
+		down := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1}
+		lb.Add(down)
+
+		if b := lb.Next(); b != nil {
+			t.Errorf("%s: expected a backend added inactive to stay out of rotation", name)
+		}
+		if down.Active {
+			t.Errorf("%s: expected Add to keep the backend inactive", name)
+		}
+	}
+}
+
 func TestRoundRobinRemoveBackend(t *testing.T) {
 	rr := NewRoundRobin()
 
@@ -215,7 +230,175 @@ func TestLoadBalancerWithNoBackends(t *testing.T) {
 	}
 }
 
+func TestMaxConnectionsCap(t *testing.T) {
+	rr := NewRoundRobin()
+
+	backend1 := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true, MaxConnections: 1}
+	backend2 := &Backend{URL: parseURL("http://backend2:8080"), Weight: 1, Active: true, MaxConnections: 1}
+
+	rr.Add(backend1)
+	rr.Add(backend2)
+
+	first := rr.Next()
+	second := rr.Next()
+	if first == nil || second == nil {
+		t.Fatal("Expected two backends before reaching the cap")
+	}
+	if first == second {
+		t.Error("Expected capped backend to be skipped")
+	}
+
+	if backend := rr.Next(); backend != nil {
+		t.Errorf("Expected nil when all backends are at their cap, got %s", backend.URL.String())
+	}
+
+	rr.ReleaseConnection(first)
+
+	if backend := rr.Next(); backend != first {
+		t.Error("Expected released backend to be selectable again")
+	}
+}
+
+func TestLeastConnectionSkipsCappedBackend(t *testing.T) {
+	lc := NewLeastConnection()
+
+	backend1 := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true, Connections: 2, MaxConnections: 2}
+	backend2 := &Backend{URL: parseURL("http://backend2:8080"), Weight: 1, Active: true, Connections: 5}
+
+	lc.Add(backend1)
+	lc.Add(backend2)
+
+	backend := lc.Next()
+	if backend == nil {
+		t.Fatal("Expected backend, got nil")
+	}
+	if backend.URL.String() != "http://backend2:8080" {
+		t.Errorf("Expected backend2 since backend1 is capped, got %s", backend.URL.String())
+	}
+
+	lc.Remove(backend2.URL)
+
+	if backend := lc.Next(); backend != nil {
+		t.Error("Expected nil when the only backend is capped")
+	}
+}
+
+func TestZeroWeightBackendsMixed(t *testing.T) {
+	rr := NewRoundRobin()
+	lc := NewLeastConnection()
+
+	weighted := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+	standby := &Backend{URL: parseURL("http://backend2:8080"), Weight: 0, Active: true}
+
+	for _, lb := range []LoadBalancer{rr, lc} {
+		lb.Add(weighted)
+		lb.Add(standby)
+
+		for i := 0; i < 10; i++ {
+			backend := lb.Next()
+			if backend == nil {
+				t.Fatal("Expected backend, got nil")
+			}
+			if backend == standby {
+				t.Error("Zero-weight backend selected while a weighted backend is healthy")
+			}
+			lb.ReleaseConnection(backend)
+		}
+
+		lb.MarkUnhealthy(weighted)
+
+		if backend := lb.Next(); backend != standby {
+			t.Error("Expected zero-weight backend once all weighted backends are down")
+		}
+
+		lb.MarkHealthy(weighted)
+	}
+}
+
+func TestZeroWeightBackendsAllZero(t *testing.T) {
+	rr := NewRoundRobin()
+
+	backend1 := &Backend{URL: parseURL("http://backend1:8080"), Weight: 0, Active: true}
+	backend2 := &Backend{URL: parseURL("http://backend2:8080"), Weight: 0, Active: true}
+
+	rr.Add(backend1)
+	rr.Add(backend2)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		backend := rr.Next()
+		if backend == nil {
+			t.Fatal("Expected zero-weight backend when no weighted backends exist")
+		}
+		seen[backend.URL.String()] = true
+	}
+
+	if len(seen) != 2 {
+		t.Errorf("Expected traffic spread over 2 zero-weight backends, got %d", len(seen))
+	}
+
+	rr.MarkUnhealthy(backend1)
+	rr.MarkUnhealthy(backend2)
+
+	if backend := rr.Next(); backend != nil {
+		t.Error("Expected nil when all zero-weight backends are down")
+	}
+}
+
+func TestSetWeightInPlace(t *testing.T) {
+	rr := NewRoundRobin()
+
+	backend1 := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true, Connections: 3}
+	rr.Add(backend1)
+
+	if !rr.SetWeight(parseURL("http://backend1:8080"), 5) {
+		t.Fatal("Expected weight update to find the backend")
+	}
+	if backend1.Weight != 5 {
+		t.Errorf("Expected weight 5, got %d", backend1.Weight)
+	}
+	if backend1.Connections != 3 {
+		t.Errorf("Expected connection count to be preserved, got %d", backend1.Connections)
+	}
+	if backends := rr.Backends(); len(backends) != 1 || backends[0] != backend1 {
+		t.Error("Expected the same backend instance after a weight update")
+	}
+
+	if rr.SetWeight(parseURL("http://unknown:8080"), 2) {
+		t.Error("Expected weight update of an unknown backend to fail")
+	}
+}
+
 func parseURL(urlStr string) *url.URL {
 	u, _ := url.Parse(urlStr)
 	return u
 }
+
+func TestNextExcluding(t *testing.T) {
+	for _, lb := range []LoadBalancer{NewRoundRobin(), NewLeastConnection(), NewSeededWeightedRandom(1)} {
+		backend1 := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+		backend2 := &Backend{URL: parseURL("http://backend2:8080"), Weight: 100, Active: true}
+		backend3 := &Backend{URL: parseURL("http://backend3:8080"), Weight: 1, Active: false}
+		lb.Add(backend1)
+		lb.Add(backend2)
+		lb.Add(backend3)
+
+		tried := map[*Backend]bool{backend2: true}
+		excluded := func(b *Backend) bool { return tried[b] }
+		for i := 0; i < 20; i++ {
+			b := NextExcluding(lb, excluded)
+			if b != backend1 {
+				t.Fatalf("%T: expected the only untried healthy backend, got %v", lb, b)
+			}
+			lb.ReleaseConnection(b)
+		}
+		if backend1.Connections != 0 || backend2.Connections != 0 {
+			t.Errorf("%T: expected skipped picks to be released, got %d and %d connections", lb, backend1.Connections, backend2.Connections)
+		}
+
+		tried[backend1] = true
+		if b := NextExcluding(lb, excluded); b != nil {
+			t.Errorf("%T: expected nil once every healthy backend was tried, got %v", lb, b.URL)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Factory creates an empty LoadBalancer for one service.
+type Factory func() LoadBalancer
+
+var builtins = map[string]Factory{
+	"round_robin":      NewRoundRobin,
+	"least_connection": NewLeastConnection,
+	"least_load":       NewLeastLoad,
+	"weighted_random":  NewWeightedRandom,
+}
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+// Register makes a custom algorithm available under name, so services can
+// select it in config. Registered names take precedence over built-ins.
+func Register(name string, factory Factory) {
+	if name == "" || factory == nil {
+		panic("loadbalancer: Register requires a name and a factory")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// New creates a load balancer for the named algorithm, looking at registered
+// algorithms first and the built-ins second.
+func New(name string) (LoadBalancer, error) {
+	registryMu.RLock()
+	factory, exists := registry[name]
+	registryMu.RUnlock()
+
+	if !exists {
+		factory, exists = builtins[name]
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown load balancer algorithm '%s'", name)
+	}
+	return factory(), nil
+}
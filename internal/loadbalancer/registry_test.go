@@ -0,0 +1,39 @@
+package loadbalancer
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNewBuiltins(t *testing.T) {
+	for _, name := range []string{"round_robin", "least_connection", "least_load", "weighted_random"} {
+		if lb, err := New(name); err != nil || lb == nil {
+			t.Errorf("Expected built-in %s, got %v", name, err)
+		}
+	}
+
+	if _, err := New("does-not-exist"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestRegisterCustomAlgorithm(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Register("custom-test", NewLeastConnection)
+			New("custom-test")
+		}()
+	}
+	wg.Wait()
+
+	lb, err := New("custom-test")
+	if err != nil {
+		t.Fatalf("Expected registered algorithm, got %v", err)
+	}
+	if _, ok := lb.(*LeastConnection); !ok {
+		t.Errorf("Expected the registered factory to be used, got %T", lb)
+	}
+}
@@ -0,0 +1,131 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WeightedRandom picks each backend with probability proportional to its
+// weight, avoiding the lockstep pattern of round robin. Zero-weight backends
+// are standby as with the other balancers and share traffic evenly when used.
+type WeightedRandom struct {
+	backends []*Backend
+	mu       sync.RWMutex
+	rng      *rand.Rand
+	rngMu    sync.Mutex
+}
+
+func NewWeightedRandom() LoadBalancer {
+	return NewSeededWeightedRandom(time.Now().UnixNano())
+}
+
+// NewSeededWeightedRandom returns a WeightedRandom with a fixed seed, so the
+// sequence of picks is reproducible.
+func NewSeededWeightedRandom(seed int64) LoadBalancer {
+	return &WeightedRandom{
+		backends: make([]*Backend, 0),
+		rng:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (wr *WeightedRandom) Add(backend *Backend) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.backends = append(wr.backends, backend)
+}
+
+func (wr *WeightedRandom) Remove(url *url.URL) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	for i, b := range wr.backends {
+		if b.URL.String() == url.String() {
+			wr.backends = append(wr.backends[:i], wr.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+func (wr *WeightedRandom) Next() *Backend {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	candidates := activeBackends(wr.backends)
+	for len(candidates) > 0 {
+		i := wr.pick(candidates)
+		b := candidates[i]
+		if b.acquire() {
+			return b
+		}
+		recordCapRejection(b)
+
+		// * draw again among the rest, keeping the remaining proportions
+		candidates = append(candidates[:i:i], candidates[i+1:]...)
+	}
+
+	return nil
+}
+
+func (wr *WeightedRandom) pick(candidates []*Backend) int {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, b := range candidates {
+		weights[i] = b.EffectiveWeight()
+		total += weights[i]
+	}
+
+	wr.rngMu.Lock()
+	n := wr.rng.Float64() * total
+	wr.rngMu.Unlock()
+
+	for i, weight := range weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+	return len(candidates) - 1
+}
+
+func (wr *WeightedRandom) MarkHealthy(backend *Backend) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	backend.Active = true
+}
+
+func (wr *WeightedRandom) MarkUnhealthy(backend *Backend) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	backend.Active = false
+}
+
+func (wr *WeightedRandom) ReleaseConnection(backend *Backend) {
+	atomic.AddInt64(&backend.Connections, -1)
+}
+
+func (wr *WeightedRandom) Backends() []*Backend {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	backends := make([]*Backend, len(wr.backends))
+	copy(backends, wr.backends)
+	return backends
+}
+
+// SetWeight updates the weight of the backend with the given URL in place.
+func (wr *WeightedRandom) SetWeight(url *url.URL, weight int) bool {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	for _, b := range wr.backends {
+		if b.URL.String() == url.String() {
+			b.Weight = weight
+			return true
+		}
+	}
+	return false
+}
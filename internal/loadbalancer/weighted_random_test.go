@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	wr := NewSeededWeightedRandom(1)
+
+	weights := map[string]int{
+		"http://backend1:8080": 1,
+		"http://backend2:8080": 3,
+		"http://backend3:8080": 6,
+	}
+	for u, w := range weights {
+		wr.Add(&Backend{URL: parseURL(u), Weight: w, Active: true})
+	}
+
+	const picks = 10000
+	counts := make(map[string]int)
+	for i := 0; i < picks; i++ {
+		backend := wr.Next()
+		if backend == nil {
+			t.Fatal("Expected backend, got nil")
+		}
+		counts[backend.URL.String()]++
+		wr.ReleaseConnection(backend)
+	}
+
+	for u, w := range weights {
+		expected := float64(picks) * float64(w) / 10
+		if math.Abs(float64(counts[u])-expected) > expected*0.1 {
+			t.Errorf("Backend %s: expected ~%.0f picks, got %d", u, expected, counts[u])
+		}
+	}
+}
+
+func TestWeightedRandomSkipsInactiveAndZeroWeight(t *testing.T) {
+	wr := NewSeededWeightedRandom(1)
+
+	active := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+	inactive := &Backend{URL: parseURL("http://backend2:8080"), Weight: 5, Active: true}
+	standby := &Backend{URL: parseURL("http://backend3:8080"), Weight: 0, Active: true}
+	wr.Add(active)
+	wr.Add(inactive)
+	wr.Add(standby)
+	wr.MarkUnhealthy(inactive)
+
+	for i := 0; i < 100; i++ {
+		if backend := wr.Next(); backend != active {
+			t.Fatalf("Expected only the active weighted backend, got %s", backend.URL)
+		}
+	}
+
+	wr.MarkUnhealthy(active)
+	if backend := wr.Next(); backend != standby {
+		t.Error("Expected the standby backend once no weighted backend is healthy")
+	}
+}
+
+func TestWeightedRandomSeedIsDeterministic(t *testing.T) {
+	a := NewSeededWeightedRandom(42)
+	b := NewSeededWeightedRandom(42)
+	for _, lb := range []LoadBalancer{a, b} {
+		lb.Add(&Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true})
+		lb.Add(&Backend{URL: parseURL("http://backend2:8080"), Weight: 1, Active: true})
+	}
+
+	for i := 0; i < 50; i++ {
+		if a.Next().URL.String() != b.Next().URL.String() {
+			t.Fatal("Expected identical picks for identical seeds")
+		}
+	}
+}
+
+func TestWeightedRandomWeightFactor(t *testing.T) {
+	wr := NewSeededWeightedRandom(1)
+
+	fast := &Backend{URL: parseURL("http://backend1:8080"), Weight: 1, Active: true}
+	slow := &Backend{URL: parseURL("http://backend2:8080"), Weight: 1, Active: true}
+	slow.SetWeightFactor(0.25)
+	wr.Add(fast)
+	wr.Add(slow)
+
+	if slow.EffectiveWeight() != 0.25 {
+		t.Fatalf("Expected effective weight 0.25, got %v", slow.EffectiveWeight())
+	}
+
+	const picks = 10000
+	slowPicks := 0
+	for i := 0; i < picks; i++ {
+		backend := wr.Next()
+		if backend == slow {
+			slowPicks++
+		}
+		wr.ReleaseConnection(backend)
+	}
+
+	expected := float64(picks) * 0.2
+	if math.Abs(float64(slowPicks)-expected) > expected*0.1 {
+		t.Errorf("Expected ~%.0f picks of the slow backend, got %d", expected, slowPicks)
+	}
+
+	slow.SetWeightFactor(1)
+	if slow.WeightFactor() != 1 {
+		t.Errorf("Expected the factor to reset to 1, got %v", slow.WeightFactor())
+	}
+}
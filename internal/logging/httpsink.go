@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// maxSinkBackoff caps the wait between retries of a batch.
+const maxSinkBackoff = 10 * time.Second
+
+// HTTPSink POSTs access log entries as JSON arrays, batching them in the
+// background so Send never waits on the network.
+type HTTPSink struct {
+	cfg       config.AccessLogSinkConfig
+	client    *http.Client
+	entries   chan map[string]string
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewHTTPSink starts an HTTP batch sink for cfg.
+func NewHTTPSink(cfg config.AccessLogSinkConfig) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("http access log sink requires a url")
+	}
+
+	s := &HTTPSink{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		entries: make(chan map[string]string, cfg.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Send queues entry, dropping it when the buffer is full.
+func (s *HTTPSink) Send(entry map[string]string) {
+	select {
+	case s.entries <- entry:
+	default:
+		metrics.AccessLogDropped.WithLabelValues("buffer_full").Inc()
+	}
+}
+
+// Close sends what is buffered, without retrying, and stops the sink.
+func (s *HTTPSink) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	<-s.done
+	return nil
+}
+
+func (s *HTTPSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]map[string]string, 0, s.cfg.BatchSize)
+	send := func() {
+		if len(batch) > 0 {
+			s.flush(batch)
+			batch = make([]map[string]string, 0, s.cfg.BatchSize)
+		}
+	}
+	add := func(entry map[string]string) {
+		batch = append(batch, entry)
+		if len(batch) >= s.cfg.BatchSize {
+			send()
+		}
+	}
+
+	for {
+		select {
+		case entry := <-s.entries:
+			add(entry)
+		case <-ticker.C:
+			send()
+		case <-s.stop:
+			// * only this goroutine receives, so the queue can only shrink
+			for len(s.entries) > 0 {
+				add(<-s.entries)
+			}
+			send()
+			return
+		}
+	}
+}
+
+// flush sends batch, retrying with exponential backoff while the sink runs.
+func (s *HTTPSink) flush(batch []map[string]string) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		metrics.AccessLogDropped.WithLabelValues("send_failed").Add(float64(len(batch)))
+		return
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= s.cfg.MaxRetries || s.stopping() {
+			log.Printf("Dropping %d access log entries: %v", len(batch), err)
+			metrics.AccessLogDropped.WithLabelValues("send_failed").Add(float64(len(batch)))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+		}
+		backoff = min(2*backoff, maxSinkBackoff)
+	}
+}
+
+func (s *HTTPSink) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// post sends one batch, reporting whether a failure is worth retrying.
+func (s *HTTPSink) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := s.cfg.BearerToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("sink answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("sink answered %s", resp.Status)
+	}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+var (
+	mu      sync.Mutex
+	closers []io.Closer
+)
+
+// Configure points the standard logger at the configured outputs and format,
+// and access log entries at the configured sink. It is safe to call again on
+// config reload; previously opened files are closed once the new destinations
+// are in place, and the sink is only replaced when its config changed.
+func Configure(cfg config.LoggingConfig) error {
+	writers := make([]io.Writer, 0, len(cfg.Outputs))
+	opened := make([]io.Closer, 0)
+
+	for _, output := range cfg.Outputs {
+		switch strings.ToLower(output) {
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		default:
+			file, err := newRotatingFile(output, cfg.Rotation)
+			if err != nil {
+				for _, c := range opened {
+					c.Close()
+				}
+				return fmt.Errorf("opening log output %s: %w", output, err)
+			}
+			writers = append(writers, file)
+			opened = append(opened, file)
+		}
+	}
+
+	var out io.Writer = io.MultiWriter(writers...)
+	flags := log.LstdFlags
+	if strings.EqualFold(cfg.Format, "json") {
+		out = &jsonWriter{out: out}
+		flags = 0
+	}
+
+	mu.Lock()
+	previous := closers
+	closers = opened
+	log.SetFlags(flags)
+	log.SetOutput(out)
+	mu.Unlock()
+
+	for _, c := range previous {
+		c.Close()
+	}
+
+	if err := configureSink(cfg.AccessLogSink); err != nil {
+		return fmt.Errorf("configuring access log sink: %w", err)
+	}
+	return nil
+}
+
+// jsonWriter wraps each log line into a JSON object.
+type jsonWriter struct {
+	out io.Writer
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	line, err := json.Marshal(map[string]string{
+		"time": time.Now().Format(time.RFC3339Nano),
+		"msg":  strings.TrimRight(string(p), "\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+func TestConfigureFileOutputJSON(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "fluxgate.log")
+
+	err := Configure(config.LoggingConfig{Format: "json", Outputs: []string{logFile}})
+	if err != nil {
+		t.Fatalf("Failed to configure logging: %v", err)
+	}
+	defer Configure(config.LoggingConfig{Format: "text", Outputs: []string{"stderr"}})
+
+	log.Printf("hello %s", "world")
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	var entry map[string]string
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected a JSON log line, got %q: %v", data, err)
+	}
+	if entry["msg"] != "hello world" {
+		t.Errorf("Expected msg 'hello world', got %q", entry["msg"])
+	}
+	if entry["time"] == "" {
+		t.Error("Expected a timestamp in the log entry")
+	}
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "fluxgate.log")
+
+	f, err := newRotatingFile(logFile, config.RotateConfig{MaxBackups: 1})
+	if err != nil {
+		t.Fatalf("Failed to open rotating file: %v", err)
+	}
+	defer f.Close()
+	f.maxSize = 10
+
+	line := []byte(strings.Repeat("x", 8) + "\n")
+	for i := 0; i < 3; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	backups, _ := filepath.Glob(logFile + ".*")
+	if len(backups) != 1 {
+		t.Errorf("Expected 1 retained backup, got %d", len(backups))
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if string(data) != string(line) {
+		t.Errorf("Expected current file to hold only the last write, got %q", data)
+	}
+}
+
+func TestRotatingFileFailedReopen(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "fluxgate.log")
+
+	f, err := newRotatingFile(logFile, config.RotateConfig{})
+	if err != nil {
+		t.Fatalf("Failed to open rotating file: %v", err)
+	}
+	defer f.Close()
+	f.maxSize = 10
+
+	line := []byte(strings.Repeat("x", 8) + "\n")
+	f.Write(line)
+
+	// * the replacement can't be opened, writes keep going to the current file
+	f.openFile = func(string, int, os.FileMode) (*os.File, error) {
+		return nil, os.ErrPermission
+	}
+	if n, err := f.Write(line); err != nil || n != len(line) {
+		t.Fatalf("Expected the write to succeed on the current file, got %d %v", n, err)
+	}
+	if data, _ := os.ReadFile(logFile); string(data) != strings.Repeat(string(line), 2) {
+		t.Errorf("Expected both writes in the current file, got %q", data)
+	}
+	if backups, _ := filepath.Glob(logFile + ".*"); len(backups) != 0 {
+		t.Errorf("Expected the failed rotation to be undone, got backups %v", backups)
+	}
+
+	// * the rotation is retried once opening works again
+	f.openFile = os.OpenFile
+	f.Write(line)
+	if data, _ := os.ReadFile(logFile); string(data) != string(line) {
+		t.Errorf("Expected the retried rotation to start a new file, got %q", data)
+	}
+	if backups, _ := filepath.Glob(logFile + ".*"); len(backups) != 1 {
+		t.Errorf("Expected one backup after the retried rotation, got %v", backups)
+	}
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// rotatingFile is a log file that is moved aside once it exceeds the
+// configured size or age.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	// openFile opens the log file, os.OpenFile outside of tests
+	openFile func(name string, flag int, perm os.FileMode) (*os.File, error)
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newRotatingFile(path string, cfg config.RotateConfig) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    int64(cfg.MaxSizeMB) * 1024 * 1024,
+		maxAge:     cfg.MaxAge,
+		maxBackups: cfg.MaxBackups,
+		openFile:   os.OpenFile,
+	}
+	file, size, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	f.file, f.size, f.openedAt = file, size, time.Now()
+	return f, nil
+}
+
+// open opens the log file for appending, returning it with its current size.
+func (f *rotatingFile) open() (*os.File, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return nil, 0, err
+	}
+
+	file, err := f.openFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// * a failed rotation keeps writing to the current file and is retried on
+	// * the next write, rather than losing the log
+	if f.shouldRotate(int64(len(p))) {
+		f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) shouldRotate(next int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+next > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.openedAt) > f.maxAge
+}
+
+// rotate moves the log file aside and switches to a new one. The current file
+// is only closed once its replacement is open; when that fails the move is
+// undone and the current file stays in use.
+func (f *rotatingFile) rotate() error {
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	file, size, err := f.open()
+	if err != nil {
+		os.Rename(backup, f.path)
+		return err
+	}
+	f.file.Close()
+	f.file, f.size, f.openedAt = file, size, time.Now()
+
+	f.pruneBackups()
+	return nil
+}
+
+func (f *rotatingFile) pruneBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil || len(backups) <= f.maxBackups {
+		return
+	}
+
+	// * timestamps sort lexically, oldest first
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-f.maxBackups] {
+		os.Remove(old)
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// Sink ships structured access log entries to a log aggregator. Send is
+// called while handling requests and must never block.
+type Sink interface {
+	Send(entry map[string]string)
+	// Close flushes buffered entries as far as it can and stops the sink.
+	Close() error
+}
+
+// SinkFactory creates a sink from logging.access_log_sink.
+type SinkFactory func(cfg config.AccessLogSinkConfig) (Sink, error)
+
+var builtinSinks = map[string]SinkFactory{
+	"http": NewHTTPSink,
+}
+
+var (
+	sinkRegistry   = make(map[string]SinkFactory)
+	sinkRegistryMu sync.RWMutex
+)
+
+// RegisterSink makes a custom sink available under name, so
+// logging.access_log_sink.type can select it. Registered names take
+// precedence over built-ins.
+func RegisterSink(name string, factory SinkFactory) {
+	if name == "" || factory == nil {
+		panic("logging: RegisterSink requires a name and a factory")
+	}
+
+	sinkRegistryMu.Lock()
+	defer sinkRegistryMu.Unlock()
+	sinkRegistry[name] = factory
+}
+
+func newSink(cfg config.AccessLogSinkConfig) (Sink, error) {
+	sinkRegistryMu.RLock()
+	factory, exists := sinkRegistry[cfg.Type]
+	sinkRegistryMu.RUnlock()
+
+	if !exists {
+		factory, exists = builtinSinks[cfg.Type]
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown access log sink '%s'", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+var (
+	sinkMu     sync.RWMutex
+	sink       Sink
+	sinkConfig *config.AccessLogSinkConfig
+)
+
+// configureSink replaces the access log sink when its config changed, closing
+// the previous one.
+func configureSink(cfg *config.AccessLogSinkConfig) error {
+	sinkMu.RLock()
+	unchanged := reflect.DeepEqual(cfg, sinkConfig)
+	sinkMu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	var next Sink
+	if cfg != nil {
+		var err error
+		if next, err = newSink(*cfg); err != nil {
+			return err
+		}
+	}
+
+	sinkMu.Lock()
+	previous := sink
+	sink, sinkConfig = next, cfg
+	sinkMu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// ShipAccess hands an access log entry to the configured sink, reporting
+// false when there is none and the entry should be logged instead.
+func ShipAccess(entry map[string]string) bool {
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+
+	if sink == nil {
+		return false
+	}
+	sink.Send(entry)
+	return true
+}
+
+// CloseSink flushes and stops the access log sink, on shutdown.
+func CloseSink() error {
+	sinkMu.Lock()
+	previous := sink
+	sink, sinkConfig = nil, nil
+	sinkMu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
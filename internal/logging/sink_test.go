@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func httpSinkConfig(url string) config.AccessLogSinkConfig {
+	return config.AccessLogSinkConfig{
+		Type: "http", URL: url, BatchSize: 2, FlushInterval: time.Hour,
+		BufferSize: 100, MaxRetries: 3, Timeout: time.Second,
+	}
+}
+
+func TestHTTPSinkBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]map[string]string
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]string
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		batches = append(batches, batch)
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := httpSinkConfig(server.URL)
+	cfg.Token = "secret"
+	sink, err := NewHTTPSink(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		sink.Send(map[string]string{"path": path})
+	}
+	// * the third entry is below batch_size and only goes out on close
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0]["path"] != "/c" {
+		t.Errorf("Expected a full batch and the rest on close, got %v", batches)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Expected the bearer token to be sent, got %q", auth)
+	}
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dropped := testutil.ToFloat64(metrics.AccessLogDropped.WithLabelValues("send_failed"))
+	sink, _ := NewHTTPSink(httpSinkConfig(server.URL))
+	sink.Send(map[string]string{"path": "/a"})
+	sink.Send(map[string]string{"path": "/b"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := attempts >= 3
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sink.Close()
+
+	if attempts != 3 {
+		t.Errorf("Expected the batch to succeed on the third attempt, got %d attempts", attempts)
+	}
+	if got := testutil.ToFloat64(metrics.AccessLogDropped.WithLabelValues("send_failed")) - dropped; got != 0 {
+		t.Errorf("Expected nothing dropped, got %v", got)
+	}
+
+	// * client errors aren't retried
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	sink, _ = NewHTTPSink(httpSinkConfig(server.URL))
+	sink.Send(map[string]string{"path": "/c"})
+	sink.Close()
+	if got := testutil.ToFloat64(metrics.AccessLogDropped.WithLabelValues("send_failed")) - dropped; got != 1 {
+		t.Errorf("Expected the rejected entry to be dropped, got %v", got)
+	}
+}
+
+func TestHTTPSinkNeverBlocks(t *testing.T) {
+	// * no goroutine drains this sink, so its one-entry buffer stays full
+	sink := &HTTPSink{entries: make(chan map[string]string, 1)}
+	dropped := testutil.ToFloat64(metrics.AccessLogDropped.WithLabelValues("buffer_full"))
+
+	sink.Send(map[string]string{"path": "/a"})
+	sink.Send(map[string]string{"path": "/b"})
+	if got := testutil.ToFloat64(metrics.AccessLogDropped.WithLabelValues("buffer_full")) - dropped; got != 1 {
+		t.Errorf("Expected 1 entry dropped on a full buffer, got %v", got)
+	}
+}
+
+type memorySink struct {
+	entries []map[string]string
+	closed  bool
+}
+
+func (m *memorySink) Send(entry map[string]string) { m.entries = append(m.entries, entry) }
+func (m *memorySink) Close() error                 { m.closed = true; return nil }
+
+func TestConfigureRegisteredSink(t *testing.T) {
+	var created []*memorySink
+	RegisterSink("memory", func(cfg config.AccessLogSinkConfig) (Sink, error) {
+		sink := &memorySink{}
+		created = append(created, sink)
+		return sink, nil
+	})
+	base := config.LoggingConfig{Format: "text", Outputs: []string{"stderr"}}
+	defer Configure(base)
+
+	if ShipAccess(map[string]string{"path": "/a"}) {
+		t.Fatal("Expected no sink before one is configured")
+	}
+
+	withSink := base
+	withSink.AccessLogSink = &config.AccessLogSinkConfig{Type: "memory"}
+	if err := Configure(withSink); err != nil {
+		t.Fatalf("Failed to configure logging: %v", err)
+	}
+	if !ShipAccess(map[string]string{"path": "/b"}) {
+		t.Fatal("Expected the entry to be shipped")
+	}
+
+	// * an unchanged sink config keeps the sink and what it buffered
+	if err := Configure(withSink); err != nil || len(created) != 1 {
+		t.Fatalf("Expected the sink to be kept on reload, got %d sinks: %v", len(created), err)
+	}
+
+	if err := Configure(base); err != nil {
+		t.Fatalf("Failed to configure logging: %v", err)
+	}
+	if !created[0].closed || len(created[0].entries) != 1 {
+		t.Errorf("Expected the removed sink to be closed holding 1 entry, got %+v", created[0])
+	}
+
+	if err := Configure(config.LoggingConfig{Format: "text", Outputs: []string{"stderr"}, AccessLogSink: &config.AccessLogSinkConfig{Type: "kafka"}}); err == nil {
+		t.Error("Expected an unknown sink type to fail")
+	}
+}
@@ -1,84 +1,566 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/fluxgate/fluxgate/internal/access"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var (
+	RequestsTotal          *prometheus.CounterVec
+	RequestDuration        *prometheus.HistogramVec
+	ActiveConnections      *prometheus.GaugeVec
+	BackendHealth          *prometheus.GaugeVec
+	BackendEffectiveWeight *prometheus.GaugeVec
+	BackendLoad            *prometheus.GaugeVec
+	BackendCapRejections   *prometheus.CounterVec
+	BackendErrors          *prometheus.CounterVec
+	BackendTLSErrors       *prometheus.CounterVec
+	GossipNodes            prometheus.Gauge
+	GossipPayloadBytes     *prometheus.GaugeVec
+	GracefulLeaves         *prometheus.CounterVec
+	Retries                *prometheus.CounterVec
+	RetryBudgetUtilization prometheus.Gauge
+	QueueDepth             *prometheus.GaugeVec
+	QueueWait              *prometheus.HistogramVec
+	ConfigReloads          prometheus.Counter
+	ClientConnsAccepted    prometheus.Counter
+	ClientConnsActive      prometheus.Gauge
+	ClientConnsClosed      prometheus.Counter
+	ClientAcceptErrors     prometheus.Counter
+	LimitRejections        *prometheus.CounterVec
+	MalformedRequests      *prometheus.CounterVec
+	Ready                  prometheus.Gauge
+	CriticalUnhealthy      *prometheus.GaugeVec
+	BackendSLOCompliant    *prometheus.GaugeVec
+	AccessLogDropped       *prometheus.CounterVec
+	CoalescedRequests      *prometheus.CounterVec
+	TenantQueueDepth       *prometheus.GaugeVec
+	GossipBroadcastQueue   prometheus.Gauge
+	TenantAdmitted         *prometheus.CounterVec
+	NoRoute                *prometheus.CounterVec
+	NoBackend              *prometheus.CounterVec
+	UpstreamTruncated      *prometheus.CounterVec
+	RegistrationRejected   *prometheus.CounterVec
+)
+
+// newCollectors creates every metric with names prefixed by namespace.
+func newCollectors(namespace string) []prometheus.Collector {
 	RequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "fluxgate_requests_total",
-			Help: "Total number of HTTP requests",
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests",
 		},
 		[]string{"service", "method", "status"},
 	)
 
 	RequestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "fluxgate_request_duration_seconds",
-			Help:    "Duration of HTTP requests in seconds",
-			Buckets: prometheus.DefBuckets,
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of HTTP requests in seconds",
+			Buckets:   prometheus.DefBuckets,
 		},
 		[]string{"service", "method"},
 	)
 
 	ActiveConnections = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "fluxgate_active_connections",
-			Help: "Number of active connections per backend",
+			Namespace: namespace,
+			Name:      "active_connections",
+			Help:      "Number of active connections per backend",
 		},
 		[]string{"backend"},
 	)
 
 	BackendHealth = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "fluxgate_backend_health",
-			Help: "Health status of backends (1 = healthy, 0 = unhealthy)",
+			Namespace: namespace,
+			Name:      "backend_health",
+			Help:      "Health status of backends (1 = healthy, 0 = unhealthy)",
 		},
 		[]string{"backend"},
 	)
 
+	BackendEffectiveWeight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_effective_weight",
+			Help:      "Backend weight after latency-based shedding",
+		},
+		[]string{"backend"},
+	)
+
+	BackendLoad = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_load",
+			Help:      "Moving average of the load backends report in their service's load header",
+		},
+		[]string{"backend"},
+	)
+
+	BackendCapRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_max_connections_rejections_total",
+			Help:      "Number of times a backend was skipped because it reached its connection cap",
+		},
+		[]string{"backend"},
+	)
+
+	BackendErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Backend request failures by reason (connect_timeout, connect_error, response_timeout, response_header_limit, other)",
+		},
+		[]string{"backend", "reason"},
+	)
+
+	BackendTLSErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_tls_errors_total",
+			Help:      "Backend TLS certificate verification failures by problem (unknown_authority, expired, hostname_mismatch, invalid)",
+		},
+		[]string{"backend", "problem"},
+	)
+
 	GossipNodes = prometheus.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "fluxgate_gossip_nodes",
-			Help: "Number of nodes in the gossip cluster",
+			Namespace: namespace,
+			Name:      "gossip_nodes",
+			Help:      "Number of nodes in the gossip cluster",
+		},
+	)
+
+	GossipPayloadBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gossip_payload_bytes",
+			Help:      "Size of the last gossip payload built by kind (state = push/pull state, meta = node metadata)",
+		},
+		[]string{"payload"},
+	)
+
+	GracefulLeaves = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cluster_leaves_total",
+			Help:      "Cluster leaves on shutdown by result (completed = before the leave timeout, timeout)",
+		},
+		[]string{"result"},
+	)
+
+	Retries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Backend retries by result (attempted, budget_exhausted, timeout)",
+		},
+		[]string{"service", "result"},
+	)
+
+	RetryBudgetUtilization = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "retry_budget_utilization",
+			Help:      "Fraction of the current window's retry budget in use",
 		},
 	)
 
+	QueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Requests waiting for a backend connection slot",
+		},
+		[]string{"service"},
+	)
+
+	QueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time requests spent queued by result (admitted, timeout, rejected, cancelled)",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"service", "result"},
+	)
+
 	ConfigReloads = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "fluxgate_config_reloads_total",
-			Help: "Total number of configuration reloads",
+			Namespace: namespace,
+			Name:      "config_reloads_total",
+			Help:      "Total number of configuration reloads",
 		},
 	)
-)
 
-func init() {
-	prometheus.MustRegister(
+	ClientConnsAccepted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_connections_accepted_total",
+			Help:      "Client connections accepted by the proxy listener",
+		},
+	)
+
+	ClientConnsActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "client_connections_active",
+			Help:      "Client connections currently open, idle or serving a request",
+		},
+	)
+
+	ClientConnsClosed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_connections_closed_total",
+			Help:      "Client connections closed or handed off (WebSocket upgrades)",
+		},
+	)
+
+	ClientAcceptErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_accept_errors_total",
+			Help:      "Errors accepting client connections, e.g. file descriptor exhaustion",
+		},
+	)
+
+	LimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "limit_rejections_total",
+			Help:      "Services and routes refused for exceeding limits.max_services or limits.max_routes, by limit",
+		},
+		[]string{"limit"},
+	)
+
+	MalformedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "malformed_requests_total",
+			Help:      "Requests rejected by the HTTP server before routing, by the status it answered with, and failed TLS handshakes (tls_handshake)",
+		},
+		[]string{"reason"},
+	)
+
+	Ready = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ready",
+			Help:      "Whether /api/v1/ready reports the node ready (1) or starting or degraded (0)",
+		},
+	)
+
+	CriticalUnhealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "critical_service_unhealthy_ratio",
+			Help:      "Share of a readiness critical service's backends that are unhealthy or ejected",
+		},
+		[]string{"service"},
+	)
+
+	BackendSLOCompliant = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_latency_slo_compliant",
+			Help:      "Whether a backend's upstream latency percentile is within its service's latency_slo (1) or not (0)",
+		},
+		[]string{"backend"},
+	)
+
+	AccessLogDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "access_log_dropped_total",
+			Help:      "Access log entries the access_log_sink dropped, by reason (buffer_full, send_failed)",
+		},
+		[]string{"reason"},
+	)
+
+	CoalescedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "coalesced_requests_total",
+			Help:      "GET requests answered with the response to an identical in-flight request",
+		},
+		[]string{"service"},
+	)
+
+	TenantQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "tenant_queue_depth",
+			Help:      "Requests of a tenant waiting for a backend connection slot of a service with fair queueing",
+		},
+		[]string{"service", "tenant"},
+	)
+
+	TenantAdmitted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tenant_admitted_total",
+			Help:      "Requests of a tenant that got a backend connection slot of a service with fair queueing",
+		},
+		[]string{"service", "tenant"},
+	)
+
+	GossipBroadcastQueue = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gossip_broadcast_queue_depth",
+			Help:      "Registration and deregistration broadcasts waiting to be gossiped",
+		},
+	)
+
+	NoRoute = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "no_route_total",
+			Help:      "Requests that matched no route, by reason and host (metrics.hosts, or other)",
+		},
+		[]string{"reason", "host"},
+	)
+
+	NoBackend = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "no_backend_total",
+			Help:      "Requests routed to a service with no available backend",
+		},
+		[]string{"service"},
+	)
+
+	UpstreamTruncated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_truncated_total",
+			Help:      "Backend responses cut short by a lost connection, before or after the body started",
+		},
+		[]string{"service", "phase"},
+	)
+
+	RegistrationRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registration_rejected_total",
+			Help:      "Service registrations refused by validation, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	return []prometheus.Collector{
 		RequestsTotal,
 		RequestDuration,
 		ActiveConnections,
 		BackendHealth,
+		BackendEffectiveWeight,
+		BackendLoad,
+		BackendCapRejections,
+		BackendErrors,
+		BackendTLSErrors,
 		GossipNodes,
+		GossipPayloadBytes,
+		GracefulLeaves,
+		Retries,
+		RetryBudgetUtilization,
+		QueueDepth,
+		QueueWait,
 		ConfigReloads,
-	)
+		ClientConnsAccepted,
+		ClientConnsActive,
+		ClientConnsClosed,
+		ClientAcceptErrors,
+		LimitRejections,
+		MalformedRequests,
+		Ready,
+		CriticalUnhealthy,
+		BackendSLOCompliant,
+		AccessLogDropped,
+		CoalescedRequests,
+		TenantQueueDepth,
+		TenantAdmitted,
+		GossipBroadcastQueue,
+		NoRoute,
+		NoBackend,
+		UpstreamTruncated,
+		RegistrationRejected,
+	}
+}
+
+// DefaultNamespace prefixes metric names unless SetNamespace chooses another.
+const DefaultNamespace = "fluxgate"
+
+var (
+	collectors   []prometheus.Collector
+	collectorsMu sync.Mutex
+)
+
+func init() {
+	collectors = newCollectors(DefaultNamespace)
+	prometheus.MustRegister(collectors...)
+}
+
+// SetNamespace recreates all metrics under namespace, e.g. "edge_gateway" for
+// edge_gateway_requests_total, replacing the registered ones. Call it at
+// startup: values recorded before are dropped, and collectors must not be in
+// use concurrently.
+func SetNamespace(namespace string) error {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	for _, c := range collectors {
+		prometheus.Unregister(c)
+	}
+	collectors = newCollectors(namespace)
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			return fmt.Errorf("registering metrics under namespace %q: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// RequestCount is a service's request total and how many of them failed with
+// a 5xx status.
+type RequestCount struct {
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+}
+
+// RequestCounts sums the requests_total counter per service.
+func RequestCounts() map[string]RequestCount {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		RequestsTotal.Collect(ch)
+		close(ch)
+	}()
+
+	counts := make(map[string]RequestCount)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+
+		var service, status string
+		for _, label := range pb.GetLabel() {
+			switch label.GetName() {
+			case "service":
+				service = label.GetValue()
+			case "status":
+				status = label.GetValue()
+			}
+		}
+
+		count := counts[service]
+		count.Requests += pb.GetCounter().GetValue()
+		if strings.HasPrefix(status, "5") {
+			count.Errors += pb.GetCounter().GetValue()
+		}
+		counts[service] = count
+	}
+	return counts
+}
+
+// Metrics server states reported by ServerState.
+const (
+	ServerDisabled = "disabled"
+	ServerUp       = "up"
+	ServerDown     = "down"
+)
+
+var serverState atomic.Value
+
+// ServerState reports whether the metrics endpoint is currently being served.
+func ServerState() string {
+	if state, ok := serverState.Load().(string); ok {
+		return state
+	}
+	return ServerDisabled
 }
 
+const (
+	minBindBackoff = time.Second
+	maxBindBackoff = time.Minute
+)
+
 type Server struct {
-	port int
+	port   int
+	access *access.Policy
 }
 
 func NewServer(port int) *Server {
 	return &Server{port: port}
 }
 
-func (s *Server) Start() error {
+// Protect guards /metrics with p. Call it before Start or Run.
+func (s *Server) Protect(p *access.Policy) {
+	s.access = p
+}
+
+func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), mux)
-}
\ No newline at end of file
+	mux.Handle("/metrics", s.access.Wrap(promhttp.Handler()))
+	return mux
+}
+
+func (s *Server) Start() error {
+	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), s.handler())
+}
+
+// Run serves metrics until ctx is cancelled. Failing to bind or serve never
+// returns: the error is logged, the state goes to ServerDown and binding is
+// retried with exponential backoff, so a port conflict leaves the proxy running.
+func (s *Server) Run(ctx context.Context) {
+	srv := &http.Server{Handler: s.handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	backoff := minBindBackoff
+	for {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+		if err == nil {
+			log.Printf("Metrics server listening on port %d", s.port)
+			serverState.Store(ServerUp)
+			backoff = minBindBackoff
+			err = srv.Serve(ln)
+		}
+		if ctx.Err() != nil {
+			serverState.Store(ServerDisabled)
+			return
+		}
+
+		serverState.Store(ServerDown)
+		log.Printf("ERROR: metrics server on port %d unavailable, retrying in %s: %v", s.port, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			serverState.Store(ServerDisabled)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBindBackoff {
+			backoff = maxBindBackoff
+		}
+	}
+}
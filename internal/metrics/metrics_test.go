@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func waitForState(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for ServerState() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics server state = %q, want %q", ServerState(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRetriesBindFailure(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := occupied.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewServer(port).Run(ctx)
+		close(done)
+	}()
+
+	waitForState(t, ServerDown)
+
+	occupied.Close()
+	waitForState(t, ServerUp)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if state := ServerState(); state != ServerDisabled {
+		t.Errorf("state after shutdown = %q, want %q", state, ServerDisabled)
+	}
+}
+
+func TestSetNamespace(t *testing.T) {
+	if err := SetNamespace("edge_gateway"); err != nil {
+		t.Fatalf("SetNamespace failed: %v", err)
+	}
+	defer SetNamespace(DefaultNamespace)
+
+	RequestsTotal.WithLabelValues("api", "GET", "200").Inc()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	if !names["edge_gateway_requests_total"] {
+		t.Error("Expected edge_gateway_requests_total to be registered")
+	}
+	if names["fluxgate_requests_total"] {
+		t.Error("Expected the default-namespace metrics to be unregistered")
+	}
+}
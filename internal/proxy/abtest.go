@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// resolveABTest maps a request for an A/B test to the variant service the client
+// is bucketed into, assigning a bucket and setting the cookie on first contact.
+func (s *Server) resolveABTest(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	s.mu.RLock()
+	test, exists := s.config.ABTests[name]
+	s.mu.RUnlock()
+
+	if !exists {
+		return "", false
+	}
+
+	if cookie, err := r.Cookie(test.CookieName); err == nil {
+		for _, bucket := range test.Buckets {
+			if bucket.Name == cookie.Value {
+				return bucket.Service, true
+			}
+		}
+	}
+
+	bucket := pickABBucket(test.Buckets)
+	http.SetCookie(w, &http.Cookie{
+		Name:     test.CookieName,
+		Value:    bucket.Name,
+		Path:     "/",
+		MaxAge:   int(test.CookieTTL.Seconds()),
+		HttpOnly: true,
+	})
+
+	return bucket.Service, true
+}
+
+func pickABBucket(buckets []config.ABBucket) config.ABBucket {
+	total := 0
+	for _, bucket := range buckets {
+		total += bucket.Weight
+	}
+
+	n := rand.Intn(total)
+	for _, bucket := range buckets {
+		if n < bucket.Weight {
+			return bucket
+		}
+		n -= bucket.Weight
+	}
+
+	return buckets[len(buckets)-1]
+}
+
+// syncABTestRoutes adds and removes the /{name}/* routes of A/B tests. A
+// discovered service of the same name already routes that path and keeps it.
+// The caller must hold s.mu, which orders route changes with load balancer ones.
+func (s *Server) syncABTestRoutes(oldTests, newTests map[string]config.ABTestConfig) {
+	for name := range oldTests {
+		if _, exists := newTests[name]; exists {
+			continue
+		}
+		if _, discovered := s.loadBalancers[name]; discovered {
+			continue
+		}
+		s.router.RemoveRoute("/"+name+"/*", name)
+		log.Printf("Removed A/B test route: /%s/*", name)
+	}
+
+	for name := range newTests {
+		if _, exists := oldTests[name]; exists {
+			continue
+		}
+		if _, discovered := s.loadBalancers[name]; discovered {
+			continue
+		}
+		s.router.AddRoute("/"+name+"/*", name, defaultRouteMethods)
+		log.Printf("Added A/B test route: /%s/*", name)
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func newABTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	s := newTestServer(t)
+	s.config.ABTests = map[string]config.ABTestConfig{
+		"checkout": {
+			CookieName: "ab_checkout",
+			CookieTTL:  time.Hour,
+			Buckets: []config.ABBucket{
+				{Name: "control", Service: "checkout-v1", Weight: 1},
+				{Name: "variant", Service: "checkout-v2", Weight: 1},
+			},
+		},
+	}
+	for _, service := range []string{"checkout-v1", "checkout-v2"} {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(service))
+		}))
+		t.Cleanup(backend.Close)
+		s.UpdateServiceInstances(service, []discovery.ServiceInstance{backendInstance(t, service, backend.URL)})
+	}
+	return s
+}
+
+func TestABTestBucketing(t *testing.T) {
+	s := newABTestServer(t)
+	services := map[string]string{"control": "checkout-v1", "variant": "checkout-v2"}
+
+	get := func(cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/checkout/cart", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "ab_checkout", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	bucketCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "ab_checkout" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// * first contact picks a bucket and remembers it in the cookie
+	rec := get("")
+	cookie := bucketCookie(rec)
+	if cookie == nil {
+		t.Fatalf("Expected the first request to set the bucket cookie, got %v", rec.Header())
+	}
+	if cookie.MaxAge != 3600 || cookie.Path != "/" || !cookie.HttpOnly {
+		t.Errorf("Expected an hour-long HttpOnly cookie on /, got %+v", cookie)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != services[cookie.Value] {
+		t.Fatalf("Expected the response of bucket %s, got %d %q", cookie.Value, rec.Code, rec.Body.String())
+	}
+
+	// * a known cookie keeps its variant and isn't set again
+	for range 10 {
+		rec := get(cookie.Value)
+		if rec.Body.String() != services[cookie.Value] {
+			t.Fatalf("Expected bucket %s to stick, got %q", cookie.Value, rec.Body.String())
+		}
+		if bucketCookie(rec) != nil {
+			t.Errorf("Expected no new cookie for a known bucket, got %v", rec.Header().Values("Set-Cookie"))
+		}
+	}
+
+	// * an unknown bucket, e.g. from a removed variant, is bucketed again
+	rec = get("retired")
+	replaced := bucketCookie(rec)
+	if replaced == nil || services[replaced.Value] == "" {
+		t.Fatalf("Expected the unknown cookie to be replaced by a known bucket, got %v", rec.Header().Values("Set-Cookie"))
+	}
+	if rec.Body.String() != services[replaced.Value] {
+		t.Errorf("Expected the response of the new bucket %s, got %q", replaced.Value, rec.Body.String())
+	}
+}
+
+func TestABTestRemovalKeepsServiceRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("checkout"))
+	}))
+	defer backend.Close()
+
+	s := newABTestServer(t)
+	handler := s.Handler()
+	s.UpdateServiceInstances("checkout", []discovery.ServiceInstance{backendInstance(t, "checkout", backend.URL)})
+
+	withoutTest := *s.config
+	withoutTest.ABTests = nil
+	s.UpdateConfig(&withoutTest)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/checkout/cart", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "checkout" {
+		t.Errorf("Expected the discovered service to keep its route, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/logging"
+)
+
+// redactedValue replaces redacted header and query parameter values.
+const redactedValue = "***"
+
+// alwaysRedacted headers carry credentials and are never logged in the clear.
+var alwaysRedacted = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactor masks the values of sensitive header and query parameter names.
+type redactor map[string]bool
+
+func newRedactor(names []string) redactor {
+	r := make(redactor, len(names)+len(alwaysRedacted))
+	for _, name := range alwaysRedacted {
+		r[strings.ToLower(name)] = true
+	}
+	for _, name := range names {
+		r[strings.ToLower(name)] = true
+	}
+	return r
+}
+
+// header returns the value of the named header as it may be logged.
+func (r redactor) header(h http.Header, name string) string {
+	value := strings.Join(h.Values(name), ", ")
+	if value != "" && r[strings.ToLower(name)] {
+		return redactedValue
+	}
+	return value
+}
+
+// query returns rawQuery with the values of redacted parameters masked,
+// keeping everything else as sent.
+func (r redactor) query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if r[strings.ToLower(name)] {
+			params[i] = url.QueryEscape(name) + "=" + redactedValue
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// logValue quotes values that would otherwise break up the key=value line.
+func logValue(value string) string {
+	if strings.ContainsAny(value, " \"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// logAccess writes the access log line for a request to service when access
+// logging is on, with the fields and redaction the service configures. path
+// is the client's path, before any prefix stripping. With an access_log_sink
+// the fields are shipped there as an entry instead.
+func (s *Server) logAccess(service string, r *http.Request, path string, status int, duration time.Duration, traceID string) {
+	s.mu.RLock()
+	enabled := s.config.Logging.AccessLog
+	accessLog := s.config.Service(service).AccessLog
+	s.mu.RUnlock()
+
+	if !enabled {
+		return
+	}
+
+	fields := config.AccessLogFields
+	var redact []string
+	if accessLog != nil {
+		fields, redact = accessLog.Fields, accessLog.Redact
+	}
+	redactor := newRedactor(redact)
+
+	entry := map[string]string{"time": time.Now().Format(time.RFC3339Nano)}
+	var line strings.Builder
+	line.WriteString("access")
+	for _, field := range fields {
+		var value string
+		switch field {
+		case "service":
+			value = service
+		case "method":
+			value = r.Method
+		case "path":
+			value = path
+		case "query":
+			value = redactor.query(r.URL.RawQuery)
+		case "status":
+			value = strconv.Itoa(status)
+		case "duration":
+			value = duration.String()
+		case "trace_id":
+			value = traceID
+		case "remote_addr":
+			value = r.RemoteAddr
+		default:
+			name, ok := strings.CutPrefix(field, "header:")
+			if !ok {
+				continue
+			}
+			value = redactor.header(r.Header, name)
+		}
+		entry[field] = value
+		line.WriteString(" " + field + "=" + logValue(value))
+	}
+	if logging.ShipAccess(entry) {
+		return
+	}
+	log.Print(line.String())
+}
+
+// logSlowRequest logs a request that took longer than
+// logging.slow_request_threshold, with the backend that served it, apart from
+// the access log and its sampling.
+func (s *Server) logSlowRequest(service string, r *http.Request, path string, backend *loadbalancer.Backend, status int, duration time.Duration, traceID string) {
+	s.mu.RLock()
+	threshold := s.config.Logging.SlowRequestThreshold
+	s.mu.RUnlock()
+
+	if threshold <= 0 || duration <= threshold {
+		return
+	}
+	log.Printf("WARNING: slow request service=%s method=%s path=%s backend=%s status=%d duration=%s threshold=%s trace_id=%s",
+		logValue(service), r.Method, logValue(path), backend.URL, status, duration, threshold, logValue(traceID))
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/logging"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+func accessLine(t *testing.T, logs string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(logs, "\n") {
+		if _, entry, ok := strings.Cut(line, " access "); ok {
+			return "access " + entry
+		}
+	}
+	t.Fatalf("Expected an access log line, got %q", logs)
+	return ""
+}
+
+func TestAccessLogFieldsAndRedaction(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Logging.AccessLog = true
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {AccessLog: &config.AccessLogConfig{
+			Fields: []string{"method", "path", "query", "status", "header:X-Tenant-ID", "header:X-Api-Key", "header:Authorization"},
+			Redact: []string{"token", "x-api-key"},
+		}},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	logs := captureLog(t)
+	req := httptest.NewRequest("GET", "/api/users?id=7&Token=s3cret&q=a+b", nil)
+	req.Header.Set("X-Tenant-ID", "acme corp")
+	req.Header.Set("X-Api-Key", "k-123")
+	req.Header.Set("Authorization", "Bearer abc")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	line := accessLine(t, logs.String())
+	want := `access method=GET path=/api/users query=id=7&Token=***&q=a+b status=200 header:X-Tenant-ID="acme corp" header:X-Api-Key=*** header:Authorization=***`
+	if line != want {
+		t.Errorf("Unexpected access log line\n got: %s\nwant: %s", line, want)
+	}
+	for _, secret := range []string{"s3cret", "k-123", "abc"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("Expected %q to never be logged", secret)
+		}
+	}
+}
+
+func TestAccessLogDefaultFields(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Logging.AccessLog = true
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	logs := captureLog(t)
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users?token=s3cret", nil))
+
+	line := accessLine(t, logs.String())
+	if !strings.HasPrefix(line, "access service=api method=GET path=/api/users status=200 duration=") || !strings.Contains(line, " trace_id=") {
+		t.Errorf("Unexpected default access log line: %s", line)
+	}
+	if strings.Contains(line, "s3cret") {
+		t.Error("Expected the query to be left out by default")
+	}
+}
+
+func TestAccessLogSink(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	shipped := make(chan []map[string]string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]string
+		json.NewDecoder(r.Body).Decode(&batch)
+		shipped <- batch
+	}))
+	defer collector.Close()
+
+	s := newTestServer(t)
+	s.config.Logging.AccessLog = true
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {AccessLog: &config.AccessLogConfig{Fields: []string{"service", "status", "header:X-Api-Key"}, Redact: []string{"x-api-key"}}},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	logs := captureLog(t)
+	err := logging.Configure(config.LoggingConfig{Format: "text", AccessLogSink: &config.AccessLogSinkConfig{
+		Type: "http", URL: collector.URL, BatchSize: 10, FlushInterval: time.Hour, BufferSize: 10, Timeout: time.Second,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to configure the sink: %v", err)
+	}
+	t.Cleanup(func() { logging.Configure(config.LoggingConfig{Format: "text", Outputs: []string{"stderr"}}) })
+	log.SetOutput(logs)
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("X-Api-Key", "k-123")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	logging.CloseSink()
+
+	batch := <-shipped
+	if len(batch) != 1 || batch[0]["service"] != "api" || batch[0]["status"] != "200" || batch[0]["header:X-Api-Key"] != "***" || batch[0]["time"] == "" {
+		t.Errorf("Unexpected shipped entries: %v", batch)
+	}
+	if strings.Contains(logs.String(), " access ") {
+		t.Errorf("Expected shipped entries to stay out of the log, got %q", logs.String())
+	}
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Logging.SlowRequestThreshold = 30 * time.Millisecond
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	logs := captureLog(t)
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/fast", nil))
+	if strings.Contains(logs.String(), "slow request") {
+		t.Errorf("Expected no slow request log under the threshold, got %q", logs.String())
+	}
+
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/slow", nil))
+	want := "WARNING: slow request service=api method=GET path=/api/slow backend=" + backend.URL + " status=200"
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("Expected %q in the log, got %q", want, logs.String())
+	}
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import "net/http"
+
+// affinityKey returns the value of the service's affinity header to pick r's
+// backend by, empty to balance r normally. reject reports that the header is
+// missing and the service refuses such requests.
+func (s *Server) affinityKey(r *http.Request, serviceName string) (key string, reject bool) {
+	s.mu.RLock()
+	affinity := s.config.Service(serviceName).Affinity
+	s.mu.RUnlock()
+
+	if affinity == nil {
+		return "", false
+	}
+	key = r.Header.Get(affinity.Header)
+	return key, key == "" && affinity.Missing == "reject"
+}
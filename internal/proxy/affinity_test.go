@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestHeaderAffinity(t *testing.T) {
+	var instances []discovery.ServiceInstance
+	for i := 0; i < 3; i++ {
+		name := string(rune('a' + i))
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer backend.Close()
+		instance := backendInstance(t, "tenants", backend.URL)
+		instance.ID = "tenants-" + name
+		instances = append(instances, instance)
+	}
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"tenants": {Affinity: &config.AffinityConfig{Header: "X-Tenant-ID", Missing: "balance"}},
+	}
+	s.UpdateServiceInstances("tenants", instances)
+
+	get := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/tenants/data", nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	pinned := get("acme").Body.String()
+	for i := 0; i < 10; i++ {
+		if got := get("acme").Body.String(); got != pinned {
+			t.Fatalf("Expected tenant acme to stay on backend %s, got %s", pinned, got)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		seen[get("").Body.String()] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected requests without the header to be balanced, got %v", seen)
+	}
+
+	s.config.Services["tenants"] = config.ServiceConfig{
+		Affinity: &config.AffinityConfig{Header: "X-Tenant-ID", Missing: "reject"},
+	}
+	if rec := get(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without the header when missing is reject, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// handleBackendBreaker lets operators override a backend's health state:
+// "open" takes it out of rotation until closed, "close" hands control back to
+// the health checks and "reset" also puts it back in rotation immediately.
+func (s *Server) handleBackendBreaker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Service string `json:"service"`
+		Backend string `json:"backend"`
+		Action  string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Service == "" || req.Backend == "" || req.Action == "" {
+		http.Error(w, "Missing required fields: service, backend, action", http.StatusBadRequest)
+		return
+	}
+	if req.Action != "open" && req.Action != "close" && req.Action != "reset" {
+		http.Error(w, "Action must be one of: open, close, reset", http.StatusBadRequest)
+		return
+	}
+
+	parsedURL, err := url.Parse(req.Backend)
+	if err != nil || parsedURL.Host == "" {
+		http.Error(w, "Invalid backend URL", http.StatusBadRequest)
+		return
+	}
+	backendURL := (&url.URL{Scheme: parsedURL.Scheme, Host: parsedURL.Host}).String()
+
+	lb := s.GetLoadBalancer(req.Service)
+	if lb == nil {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	found := false
+	for _, backend := range lb.Backends() {
+		if backend.URL.String() == backendURL {
+			found = true
+			break
+		}
+	}
+
+	if found {
+		switch req.Action {
+		case "open":
+			found = s.healthChecker.SetEjected(backendURL, true)
+		case "close":
+			found = s.healthChecker.SetEjected(backendURL, false)
+		case "reset":
+			found = s.healthChecker.Reset(backendURL)
+		}
+	}
+	if !found {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+
+	state := "closed"
+	if s.healthChecker.Ejected(backendURL) {
+		state = "open"
+	}
+
+	log.Printf("Manual breaker %s on backend %s of service %s from %s, breaker now %s", req.Action, backendURL, req.Service, r.RemoteAddr, state)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"service":   req.Service,
+		"backend":   backendURL,
+		"action":    req.Action,
+		"state":     state,
+		"timestamp": time.Now().Unix(),
+	})
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// setClientCertHeaders replaces the tls.client_cert_headers of an outbound
+// request with the fields of the client certificate verified during the
+// handshake. Values sent by the client are always dropped.
+func (s *Server) setClientCertHeaders(req *http.Request) {
+	s.mu.RLock()
+	tlsCfg := s.config.TLS
+	s.mu.RUnlock()
+
+	if tlsCfg == nil || tlsCfg.ClientCertHeaders == nil {
+		return
+	}
+	headers := tlsCfg.ClientCertHeaders
+	for _, name := range headers.Names() {
+		req.Header.Del(name)
+	}
+
+	// * only certificates that chained to a client CA are forwarded
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.PeerCertificates) == 0 {
+		return
+	}
+	cert := req.TLS.PeerCertificates[0]
+
+	if headers.Cert != "" {
+		req.Header.Set(headers.Cert, url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+	}
+	if headers.Subject != "" {
+		req.Header.Set(headers.Subject, cert.Subject.String())
+	}
+	if headers.SANs != "" {
+		if sans := certificateSANs(cert); len(sans) > 0 {
+			req.Header.Set(headers.SANs, strings.Join(sans, ","))
+		}
+	}
+	if headers.Serial != "" {
+		req.Header.Set(headers.Serial, cert.SerialNumber.Text(16))
+	}
+	if headers.Fingerprint != "" {
+		fingerprint := sha256.Sum256(cert.Raw)
+		req.Header.Set(headers.Fingerprint, hex.EncodeToString(fingerprint[:]))
+	}
+}
+
+// certificateSANs lists the subject alternative names of cert, prefixed by
+// their type as in "DNS:api.example.com" or "IP:10.0.0.1".
+func certificateSANs(cert *x509.Certificate) []string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestClientCertHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xbeef),
+		Subject:      pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:     []string{"billing.internal"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.7")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	s := newTestServer(t)
+	s.config.TLS = &config.TLS{ClientCertHeaders: &config.ClientCertHeadersConfig{
+		Cert:        "X-Client-Cert",
+		Subject:     "X-Client-Subject",
+		SANs:        "X-Client-SANs",
+		Serial:      "X-Client-Serial",
+		Fingerprint: "X-Client-Fingerprint",
+	}}
+	s.UpdateServiceInstances("billing", []discovery.ServiceInstance{backendInstance(t, "billing", backend.URL)})
+
+	send := func(state *tls.ConnectionState) http.Header {
+		req := httptest.NewRequest("GET", "/billing/invoices", nil)
+		req.Header.Set("X-Client-Subject", "CN=admin")
+		req.Header.Set("X-Client-Fingerprint", "spoofed")
+		req.TLS = state
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		return <-received
+	}
+
+	header := send(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}})
+	fingerprint := sha256.Sum256(der)
+	want := map[string]string{
+		"X-Client-Subject":     "CN=billing,O=Example",
+		"X-Client-SANs":        "DNS:billing.internal,IP:10.0.0.7",
+		"X-Client-Serial":      "beef",
+		"X-Client-Fingerprint": hex.EncodeToString(fingerprint[:]),
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got)
+		}
+	}
+	certPEM, err := url.QueryUnescape(header.Get("X-Client-Cert"))
+	if block, _ := pem.Decode([]byte(certPEM)); err != nil || block == nil || string(block.Bytes) != string(der) {
+		t.Errorf("Expected the URL-encoded PEM certificate, got %q", header.Get("X-Client-Cert"))
+	}
+
+	// * unverified certificates and plaintext requests only lose the spoofed headers
+	for _, state := range []*tls.ConnectionState{{PeerCertificates: []*x509.Certificate{cert}}, nil} {
+		header = send(state)
+		for _, name := range []string{"X-Client-Cert", "X-Client-Subject", "X-Client-Fingerprint"} {
+			if got := header.Get(name); got != "" {
+				t.Errorf("Expected %s to be stripped, got %q", name, got)
+			}
+		}
+	}
+}
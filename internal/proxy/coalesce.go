@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// coalescedResponse is the outcome of an in-flight GET that identical ones
+// wait for. Its fields are written by that request and may only be read by
+// the others once done is closed.
+type coalescedResponse struct {
+	done chan struct{}
+
+	recordedResponse
+}
+
+// coalescer tracks the in-flight GETs of services with coalesce enabled by
+// coalesceKey. Responses are only shared while in flight, never cached.
+type coalescer struct {
+	mu       sync.Mutex
+	inflight map[string]*coalescedResponse
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{inflight: make(map[string]*coalescedResponse)}
+}
+
+// begin returns the in-flight response for key and whether the caller is the
+// first request with it, which must forward the request and then complete it.
+func (c *coalescer) begin(key string) (*coalescedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry := c.inflight[key]; entry != nil {
+		return entry, false
+	}
+	entry := &coalescedResponse{done: make(chan struct{})}
+	c.inflight[key] = entry
+	return entry, true
+}
+
+// complete publishes the first request's response to the requests waiting for
+// it; requests arriving afterwards are forwarded again.
+func (c *coalescer) complete(key string, entry *coalescedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight[key] == entry {
+		delete(c.inflight, key)
+	}
+	close(entry.done)
+}
+
+// shareable reports whether the response can be given to the requests that
+// waited for it: it completed, fit staleBodyLimit and isn't tied to a client.
+func (entry *coalescedResponse) shareable() bool {
+	return entry.status != 0 &&
+		!entry.overflow &&
+		len(entry.header.Values("Set-Cookie")) == 0 &&
+		!strings.Contains(strings.ToLower(entry.header.Get("Cache-Control")), "private")
+}
+
+// coalesceKey identifies identical requests by the response cache's key, the
+// host and scheme a rewritten Location points back at, and the values of the
+// vary headers, Authorization and Cookie.
+func coalesceKey(serviceName string, r *http.Request, scheme string, vary []string) string {
+	var key strings.Builder
+	key.WriteString(staleKey(serviceName, r))
+	key.WriteString("\n" + scheme + "://" + r.Host)
+	for _, name := range append([]string{"Authorization", "Cookie"}, vary...) {
+		key.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+	return key.String()
+}
+
+// beginCoalesced looks up the in-flight request identical to r when its
+// service has coalesce enabled, returning nil when it doesn't apply.
+func (s *Server) beginCoalesced(r *http.Request, serviceName string) (*coalescedResponse, string, bool) {
+	s.mu.RLock()
+	coalesce := s.config.Service(serviceName).Coalesce
+	s.mu.RUnlock()
+
+	if coalesce == nil || r.Method != http.MethodGet || isUpgradeRequest(r) {
+		return nil, "", false
+	}
+	key := coalesceKey(serviceName, r, s.requestScheme(r), coalesce.Vary)
+	entry, first := s.coalescer.begin(key)
+	return entry, key, first
+}
+
+// replayCoalesced waits for the identical in-flight request and answers r with
+// its response, reporting whether it did. When the response can't be shared,
+// r must be forwarded itself.
+func (s *Server) replayCoalesced(w http.ResponseWriter, r *http.Request, serviceName string, entry *coalescedResponse, start time.Time, traceID string) bool {
+	select {
+	case <-entry.done:
+	case <-r.Context().Done():
+		return true
+	}
+	if !entry.shareable() {
+		return false
+	}
+
+	for name, values := range entry.header {
+		if _, exists := w.Header()[name]; !exists {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+	}
+	w.Header().Set("X-FluxGate-Coalesced", "true")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+
+	metrics.CoalescedRequests.WithLabelValues(serviceName).Inc()
+	metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(time.Since(start).Seconds())
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(entry.status)).Inc()
+	s.logAccess(serviceName, r, r.URL.Path, entry.status, time.Since(start), traceID)
+	return true
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+func TestCoalesceIdenticalGets(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		<-release
+		w.Write([]byte(r.Header.Get("Accept-Language") + " " + strconv.Itoa(int(n))))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"catalog": {Coalesce: &config.CoalesceConfig{Vary: []string{"Accept-Language"}}},
+	}
+	s.UpdateServiceInstances("catalog", []discovery.ServiceInstance{backendInstance(t, "catalog", backend.URL)})
+
+	get := func(path, language string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/catalog"+path, nil)
+		req.Header.Set("Accept-Language", language)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	// herd sends concurrent GETs, releasing the backend once they are all in
+	herd := func(paths, languages []string) []*httptest.ResponseRecorder {
+		recs := make([]*httptest.ResponseRecorder, len(paths))
+		var wg sync.WaitGroup
+		for i := range recs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				recs[i] = get(paths[i], languages[i])
+			}(i)
+		}
+		time.Sleep(100 * time.Millisecond)
+		release <- struct{}{}
+		wg.Wait()
+		return recs
+	}
+
+	coalescedBefore := testutil.ToFloat64(metrics.CoalescedRequests.WithLabelValues("catalog"))
+	recs := herd([]string{"/items", "/items", "/items", "/items", "/items"}, []string{"en", "en", "en", "en", "en"})
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("Expected identical GETs to reach the backend once, got %d", n)
+	}
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "en 1" {
+			t.Errorf("Expected every request to get the shared response, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	// * every waiter gets its own copy of the shared header values
+	var replayed []*httptest.ResponseRecorder
+	for _, rec := range recs {
+		if rec.Header().Get("X-FluxGate-Coalesced") == "true" {
+			replayed = append(replayed, rec)
+		}
+	}
+	if len(replayed) != len(recs)-1 {
+		t.Fatalf("Expected %d coalesced responses, got %d", len(recs)-1, len(replayed))
+	}
+	replayed[0].Header()["Content-Type"][0] = "changed"
+	for _, rec := range replayed[1:] {
+		if rec.Header().Get("Content-Type") == "changed" {
+			t.Fatalf("Expected coalesced responses not to share header slices")
+		}
+	}
+	if got := testutil.ToFloat64(metrics.CoalescedRequests.WithLabelValues("catalog")) - coalescedBefore; got != float64(len(recs)-1) {
+		t.Errorf("Expected %d coalesced requests counted, got %v", len(recs)-1, got)
+	}
+
+	close(release)
+	if rec := get("/items", "en"); rec.Body.String() != "en 2" {
+		t.Errorf("Expected a completed response not to be reused, got %q", rec.Body.String())
+	}
+	if rec := get("/items", "de"); rec.Body.String() != "de 3" {
+		t.Errorf("Expected a different vary header to be forwarded, got %q", rec.Body.String())
+	}
+}
+
+func TestCoalesceSkipsClientResponses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			<-release
+		}
+		w.Header().Set("Set-Cookie", "session="+strconv.Itoa(int(n)))
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"catalog": {Coalesce: &config.CoalesceConfig{}},
+	}
+	s.UpdateServiceInstances("catalog", []discovery.ServiceInstance{backendInstance(t, "catalog", backend.URL)})
+
+	recs := make([]*httptest.ResponseRecorder, 3)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = httptest.NewRecorder()
+			s.Handler().ServeHTTP(recs[i], httptest.NewRequest("GET", "/catalog/login", nil))
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != int32(len(recs)) {
+		t.Errorf("Expected requests waiting on a Set-Cookie response to be forwarded, got %d backend calls", n)
+	}
+	for _, rec := range recs {
+		if rec.Header().Get("X-FluxGate-Coalesced") != "" {
+			t.Errorf("Expected a Set-Cookie response not to be shared, got %v", rec.Header())
+		}
+	}
+}
+
+func TestCoalesceKeysOnHostAndScheme(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	var backend *httptest.Server
+	backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		http.Redirect(w, r, backend.URL+"/next", http.StatusFound)
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"catalog": {Coalesce: &config.CoalesceConfig{}, RewriteLocation: true},
+	}
+	// * httptest requests come from 192.0.2.1
+	s.config.Server.TrustedProxies = []string{"192.0.2.0/24"}
+	s.UpdateServiceInstances("catalog", []discovery.ServiceInstance{backendInstance(t, "catalog", backend.URL)})
+
+	origins := []struct{ host, proto string }{
+		{"a.example.com", "http"},
+		{"b.example.com", "http"},
+		{"a.example.com", "https"},
+	}
+	recs := make([]*httptest.ResponseRecorder, len(origins))
+	var wg sync.WaitGroup
+	for i, origin := range origins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/catalog/items", nil)
+			req.Host = origin.host
+			req.Header.Set("X-Forwarded-Proto", origin.proto)
+			recs[i] = httptest.NewRecorder()
+			s.Handler().ServeHTTP(recs[i], req)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != int32(len(origins)) {
+		t.Errorf("Expected requests for different origins not to be coalesced, got %d backend calls", n)
+	}
+	for i, origin := range origins {
+		want := origin.proto + "://" + origin.host + "/catalog/next"
+		if location := recs[i].Header().Get("Location"); location != want {
+			t.Errorf("Expected Location %s, got %s", want, location)
+		}
+	}
+}
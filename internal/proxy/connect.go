@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/access"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// forwardProxyService labels CONNECT tunnels in request metrics.
+const forwardProxyService = "forward_proxy"
+
+// withForwardProxy answers CONNECT requests with a tunnel when forward_proxy
+// is enabled, leaving everything else to next.
+func (s *Server) withForwardProxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		forward := s.config.ForwardProxy
+		s.mu.RUnlock()
+
+		if r.Method != http.MethodConnect || !forward.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy, err := access.NewPolicy("", forward.AllowedIPs)
+		if err != nil {
+			log.Printf("Forward proxy access policy: %v", err)
+			http.Error(w, "Forward proxy misconfigured", http.StatusInternalServerError)
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		policy.Wrap(http.HandlerFunc(s.tunnel)).ServeHTTP(rw, r)
+		metrics.RequestsTotal.WithLabelValues(forwardProxyService, r.Method, strconv.Itoa(rw.statusCode)).Inc()
+	})
+}
+
+// tunnel connects the client to the CONNECT target and relays bytes both ways
+// until either side closes.
+func (s *Server) tunnel(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	forward := s.config.ForwardProxy
+	dialer := newBackendDialer(s.config.Dial, s.config.Timeouts.Connect)
+	s.mu.RUnlock()
+
+	target := r.Host
+	if !forward.Allows(target) {
+		log.Printf("CONNECT to %s from %s refused: target not allowlisted", target, r.RemoteAddr)
+		http.Error(w, "CONNECT target not allowed", http.StatusForbidden)
+		return
+	}
+
+	targetConn, err := dialer.DialContext(r.Context(), "tcp", target)
+	if err != nil {
+		log.Printf("CONNECT to %s from %s failed: %v", target, r.RemoteAddr, err)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer targetConn.Close()
+
+	clientConn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "CONNECT tunnels require HTTP/1.1", http.StatusNotImplemented)
+		return
+	}
+	defer clientConn.Close()
+	if rw, ok := w.(*responseWriter); ok {
+		rw.statusCode = http.StatusOK
+	}
+
+	// * the server's read and write timeouts would cut long-lived tunnels
+	clientConn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+	// * the client may have sent tunnel data right behind the request
+	if n := buffered.Reader.Buffered(); n > 0 {
+		data, _ := buffered.Reader.Peek(n)
+		if _, err := targetConn.Write(data); err != nil {
+			return
+		}
+	}
+
+	start := time.Now()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(targetConn, clientConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, targetConn)
+		done <- struct{}{}
+	}()
+	<-done
+
+	log.Printf("CONNECT tunnel to %s from %s closed after %s", target, r.RemoteAddr, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/access"
+)
+
+// capturedRequest is a request as it was sent to a backend, with the
+// service's access log redaction applied. Status and the bodies are only
+// filled in with the service's debug_bodies on.
+type capturedRequest struct {
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	URL           string        `json:"url"`
+	Host          string        `json:"host"`
+	Header        http.Header   `json:"headers"`
+	ContentLength int64         `json:"content_length"`
+	RequestBody   *capturedBody `json:"request_body,omitempty"`
+	Status        int           `json:"status,omitempty"`
+	ResponseBody  *capturedBody `json:"response_body,omitempty"`
+}
+
+// requestCapture keeps the last forwarded requests of each service in a ring.
+type requestCapture struct {
+	mu        sync.Mutex
+	byService map[string]*captureRing
+}
+
+type captureRing struct {
+	entries []capturedRequest
+	next    int
+}
+
+func newRequestCapture() *requestCapture {
+	return &requestCapture{byService: make(map[string]*captureRing)}
+}
+
+func (c *requestCapture) add(service string, req capturedRequest, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ring := c.byService[service]
+	if ring == nil {
+		ring = &captureRing{}
+		c.byService[service] = ring
+	}
+	if ring.next > 0 && len(ring.entries) != size {
+		// * captured changed on reload, lay the ring out oldest first again
+		ring.entries = append(append([]capturedRequest(nil), ring.entries[ring.next:]...), ring.entries[:ring.next]...)
+		ring.next = 0
+	}
+	if len(ring.entries) > size {
+		ring.entries = ring.entries[len(ring.entries)-size:]
+	}
+	if len(ring.entries) < size {
+		ring.entries = append(ring.entries, req)
+		return
+	}
+	ring.entries[ring.next] = req
+	ring.next = (ring.next + 1) % size
+}
+
+// recent returns up to n captured requests of service, newest first.
+func (c *requestCapture) recent(service string, n int) []capturedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ring := c.byService[service]
+	if ring == nil {
+		return nil
+	}
+	return ring.recent(n)
+}
+
+func (r *captureRing) recent(n int) []capturedRequest {
+	if n > len(r.entries) {
+		n = len(r.entries)
+	}
+	recent := make([]capturedRequest, 0, n)
+	// * once full, next is the oldest entry and next-1 the newest
+	newest := len(r.entries) - 1
+	if r.next > 0 {
+		newest = r.next - 1
+	}
+	for i := 0; i < n; i++ {
+		recent = append(recent, r.entries[(newest-i+len(r.entries))%len(r.entries)])
+	}
+	return recent
+}
+
+// capturingTransport records requests for the debug endpoints right before
+// they go to the backend, after every rewrite FluxGate applies including
+// header_case, and times backend responses for latency_slo.
+type capturingTransport struct {
+	s    *Server
+	next http.RoundTripper
+}
+
+func (t *capturingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = t.s.applyHeaderCase(req)
+	start := time.Now()
+	var resp *http.Response
+	var err error
+	if capture := t.s.captureRequest(req); capture != nil {
+		resp, err = capture.roundTrip(t.next, req)
+	} else {
+		resp, err = t.next.RoundTrip(req)
+	}
+	if info := requestInfoFrom(req.Context()); info != nil && err == nil {
+		t.s.recordLatency(info.service, req.URL.Scheme+"://"+req.URL.Host, time.Since(start))
+	}
+	return resp, err
+}
+
+// captureRequest starts capturing req when the debug endpoints are on,
+// returning nil otherwise.
+func (s *Server) captureRequest(req *http.Request) *pendingCapture {
+	info := requestInfoFrom(req.Context())
+	if info == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	debug := s.config.Debug
+	accessLog := s.config.Service(info.service).AccessLog
+	bodies := s.config.Service(info.service).DebugBodies
+	s.mu.RUnlock()
+
+	if !debug.Enabled || debug.Captured == 0 {
+		return nil
+	}
+
+	var redact []string
+	if accessLog != nil {
+		redact = accessLog.Redact
+	}
+	redactor := newRedactor(redact)
+
+	header := make(http.Header, len(req.Header))
+	for name := range req.Header {
+		if redactor[strings.ToLower(name)] {
+			header[name] = []string{redactedValue}
+			continue
+		}
+		header[name] = append([]string(nil), req.Header[name]...)
+	}
+
+	target := *req.URL
+	target.RawQuery = redactor.query(req.URL.RawQuery)
+
+	return &pendingCapture{
+		s:        s,
+		service:  info.service,
+		size:     debug.Captured,
+		bodies:   bodies,
+		redactor: redactor,
+		entry: capturedRequest{
+			Time:          time.Now(),
+			Method:        req.Method,
+			URL:           target.String(),
+			Host:          req.Host,
+			Header:        header,
+			ContentLength: req.ContentLength,
+		},
+	}
+}
+
+// handleDebugLastRequest returns the most recent requests forwarded to a
+// service, newest first: ?service=<name>&count=<n>, count defaulting to 1.
+func (s *Server) handleDebugLastRequest(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	debug := s.config.Debug
+	s.mu.RUnlock()
+
+	if !debug.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	policy, err := access.NewPolicy(debug.BearerToken(), debug.AllowedIPs)
+	if err != nil {
+		log.Printf("Debug endpoint access policy: %v", err)
+		http.Error(w, "Debug endpoint misconfigured", http.StatusInternalServerError)
+		return
+	}
+	// * never serve captured requests unprotected, e.g. with token_env unset
+	if !policy.Enabled() {
+		http.Error(w, "Debug endpoint has no token or allowed_ips", http.StatusForbidden)
+		return
+	}
+
+	policy.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		service := r.URL.Query().Get("service")
+		if service == "" {
+			http.Error(w, "Missing required parameter: service", http.StatusBadRequest)
+			return
+		}
+		count := 1
+		if raw := r.URL.Query().Get("count"); raw != "" {
+			count, err = strconv.Atoi(raw)
+			if err != nil || count < 1 {
+				http.Error(w, "count must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		requests := s.captured.recent(service, count)
+		if len(requests) == 0 {
+			http.Error(w, "No request captured for service", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"service":   service,
+			"requests":  requests,
+			"timestamp": time.Now().Unix(),
+		})
+	})).ServeHTTP(w, r)
+}
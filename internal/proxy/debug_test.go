@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestRequestCaptureRing(t *testing.T) {
+	c := newRequestCapture()
+	for i := 1; i <= 5; i++ {
+		c.add("api", capturedRequest{URL: strconv.Itoa(i)}, 3)
+	}
+
+	urls := func(requests []capturedRequest) string {
+		var s string
+		for _, r := range requests {
+			s += r.URL
+		}
+		return s
+	}
+	if got := urls(c.recent("api", 10)); got != "543" {
+		t.Errorf("Expected the 3 newest requests newest first, got %s", got)
+	}
+
+	// * a reload can change the size of a wrapped ring
+	c.add("api", capturedRequest{URL: "6"}, 4)
+	if got := urls(c.recent("api", 10)); got != "6543" {
+		t.Errorf("Expected the ring to grow keeping its order, got %s", got)
+	}
+	c.add("api", capturedRequest{URL: "7"}, 2)
+	if got := urls(c.recent("api", 10)); got != "76" {
+		t.Errorf("Expected the ring to shrink to the newest, got %s", got)
+	}
+}
+
+func TestDebugLastRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {AccessLog: &config.AccessLogConfig{Redact: []string{"token", "X-Api-Key"}}},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	forward := func() {
+		req := httptest.NewRequest("GET", "/api/users?id=7&token=s3cret", nil)
+		req.Header.Set("X-Api-Key", "k-123")
+		req.Header.Set("X-Tenant-ID", "acme")
+		s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	lastRequest := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/debug/lastrequest?service=api", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	forward()
+	if rec := lastRequest(""); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected the endpoint to be off by default, got %d", rec.Code)
+	}
+
+	// * a token_env naming an unset variable must not open the endpoint
+	s.config.Debug = config.DebugConfig{Enabled: true, TokenEnv: "FLUXGATE_TEST_UNSET_DEBUG_TOKEN"}
+	if rec := lastRequest(""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 with no token resolved, got %d", rec.Code)
+	}
+
+	s.config.Debug = config.DebugConfig{Enabled: true, Token: "debug-token", Captured: 5}
+	if rec := lastRequest(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", rec.Code)
+	}
+	if rec := lastRequest("debug-token"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected nothing captured while debug was off, got %d", rec.Code)
+	}
+
+	forward()
+	rec := lastRequest("debug-token")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Requests []capturedRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Requests) != 1 {
+		t.Fatalf("Expected one captured request, got %s: %v", rec.Body.String(), err)
+	}
+	captured := resp.Requests[0]
+	if captured.URL != backend.URL+"/users?id=7&token=***" {
+		t.Errorf("Expected the forwarded URL with the token redacted, got %s", captured.URL)
+	}
+	if captured.Header.Get("X-Api-Key") != "***" || captured.Header.Get("X-Tenant-ID") != "acme" {
+		t.Errorf("Expected X-Api-Key redacted and X-Tenant-ID kept, got %v", captured.Header)
+	}
+	if captured.Header.Get("X-Forwarded-For") == "" {
+		t.Errorf("Expected headers as forwarded, including X-Forwarded-For, got %v", captured.Header)
+	}
+}
+
+func TestDebugBodies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo":` + string(body) + `,"session":"abc"}`))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Debug = config.DebugConfig{Enabled: true, Token: "debug-token", Captured: 5}
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {
+			AccessLog:   &config.AccessLogConfig{Redact: []string{"password", "session"}},
+			DebugBodies: &config.DebugBodiesConfig{Request: true, Response: true, MaxBytes: 1024},
+		},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	lastRequest := func() capturedRequest {
+		req := httptest.NewRequest("GET", "/api/v1/debug/lastrequest?service=api", nil)
+		req.Header.Set("Authorization", "Bearer debug-token")
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		var got struct {
+			Requests []capturedRequest `json:"requests"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got.Requests) == 0 {
+			t.Fatalf("Expected a captured request, got %d %s", rec.Code, rec.Body.String())
+		}
+		return got.Requests[0]
+	}
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"user":"ann","password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "hunter2") {
+		t.Fatalf("Expected capture not to alter the bodies, got %s", rec.Body.String())
+	}
+
+	got := lastRequest()
+	if got.Status != http.StatusOK || got.RequestBody == nil || got.ResponseBody == nil {
+		t.Fatalf("Expected status and both bodies, got %+v", got)
+	}
+	if body := got.RequestBody.Body; !strings.Contains(body, `"user":"ann"`) || strings.Contains(body, "hunter2") {
+		t.Errorf("Expected the request body with the password redacted, got %s", body)
+	}
+	if body := got.ResponseBody.Body; strings.Contains(body, "hunter2") || strings.Contains(body, "abc") {
+		t.Errorf("Expected the response body redacted, got %s", body)
+	}
+
+	// * a truncated JSON body can't be redacted reliably
+	s.config.Services["api"].DebugBodies.MaxBytes = 10
+	req = httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"user":"ann","password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	got = lastRequest()
+	if body := got.RequestBody; !body.Truncated || !body.Withheld || body.Body != "" || body.Size != 35 {
+		t.Errorf("Expected the truncated body withheld, got %+v", body)
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// capturedBody is the start of a request or response body as it streamed
+// between FluxGate and the backend.
+type capturedBody struct {
+	Body string `json:"body"`
+	// Encoding is "base64" for bodies that aren't valid UTF-8
+	Encoding string `json:"encoding,omitempty"`
+	// Size counts every byte that streamed past, captured or not
+	Size      int64 `json:"size"`
+	Truncated bool  `json:"truncated,omitempty"`
+	// Withheld marks a JSON or form body that couldn't be parsed for
+	// redaction, e.g. because it was truncated, and so isn't shown
+	Withheld bool `json:"withheld,omitempty"`
+}
+
+// pendingCapture is a captured request waiting for its bodies to stream past
+// before it is added to the service's ring.
+type pendingCapture struct {
+	s        *Server
+	service  string
+	size     int
+	bodies   *config.DebugBodiesConfig
+	redactor redactor
+	entry    capturedRequest
+
+	requestBody, responseBody     *bodyBuffer
+	requestHeader, responseHeader http.Header
+	once                          sync.Once
+}
+
+// roundTrip sends req through next, teeing the bodies debug_bodies asks for.
+// The entry is added once the response body is closed, or right away when
+// it isn't captured.
+func (c *pendingCapture) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if c.bodies == nil {
+		c.finish()
+		return next.RoundTrip(req)
+	}
+
+	if c.bodies.Request && req.Body != nil && req.Body != http.NoBody {
+		c.requestBody = &bodyBuffer{limit: c.bodies.MaxBytes}
+		c.requestHeader = http.Header{"Content-Type": req.Header.Values("Content-Type"), "Content-Encoding": req.Header.Values("Content-Encoding")}
+		// * a RoundTripper must not modify the request it is given
+		req = req.Clone(req.Context())
+		req.Body = &teeBody{ReadCloser: req.Body, buf: c.requestBody}
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		c.finish()
+		return resp, err
+	}
+	c.entry.Status = resp.StatusCode
+	if !c.bodies.Response {
+		c.finish()
+		return resp, nil
+	}
+
+	c.responseBody = &bodyBuffer{limit: c.bodies.MaxBytes}
+	c.responseHeader = http.Header{"Content-Type": resp.Header.Values("Content-Type"), "Content-Encoding": resp.Header.Values("Content-Encoding")}
+	resp.Body = &teeBody{ReadCloser: resp.Body, buf: c.responseBody, onClose: c.finish}
+	return resp, nil
+}
+
+func (c *pendingCapture) finish() {
+	c.once.Do(func() {
+		if c.requestBody != nil {
+			c.entry.RequestBody = c.requestBody.captured(c.requestHeader, c.redactor)
+		}
+		if c.responseBody != nil {
+			c.entry.ResponseBody = c.responseBody.captured(c.responseHeader, c.redactor)
+		}
+		c.s.captured.add(c.service, c.entry, c.size)
+	})
+}
+
+// bodyBuffer keeps the first limit bytes written to it. The transport may
+// still be reading a request body while the capture finishes, hence the lock.
+type bodyBuffer struct {
+	mu    sync.Mutex
+	data  []byte
+	size  int64
+	limit int64
+}
+
+func (b *bodyBuffer) write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.size += int64(len(p))
+	if room := b.limit - int64(len(b.data)); room > 0 {
+		b.data = append(b.data, p[:min(room, int64(len(p)))]...)
+	}
+}
+
+// captured returns the buffered body as shown by the debug endpoints, with
+// redaction applied according to its headers.
+func (b *bodyBuffer) captured(header http.Header, r redactor) *capturedBody {
+	b.mu.Lock()
+	data := append([]byte(nil), b.data...)
+	body := &capturedBody{Size: b.size, Truncated: b.size > int64(len(b.data))}
+	b.mu.Unlock()
+
+	data, ok := r.body(data, header)
+	switch {
+	case !ok:
+		body.Withheld = true
+	case utf8.Valid(data):
+		body.Body = string(data)
+	default:
+		body.Body = base64.StdEncoding.EncodeToString(data)
+		body.Encoding = "base64"
+	}
+	return body
+}
+
+// teeBody copies what is read from a body into a bodyBuffer.
+type teeBody struct {
+	io.ReadCloser
+	buf     *bodyBuffer
+	onClose func()
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.buf.write(p[:n])
+	}
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	err := t.ReadCloser.Close()
+	if t.onClose != nil {
+		t.onClose()
+	}
+	return err
+}
+
+// body masks the redacted fields of JSON and form bodies. It reports false
+// for such a body that can't be parsed, e.g. truncated or compressed, which
+// can't be shown safely.
+func (r redactor) body(data []byte, header http.Header) ([]byte, bool) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	isForm := mediaType == "application/x-www-form-urlencoded"
+	if !isJSON && !isForm {
+		return data, true
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, false
+	}
+
+	if isForm {
+		return []byte(r.query(string(data))), true
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false
+	}
+	if !r.json(value) {
+		return data, true
+	}
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	return redacted, true
+}
+
+// json masks redacted keys throughout a decoded JSON value in place,
+// reporting whether it masked any.
+func (r redactor) json(value any) bool {
+	masked := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if r[strings.ToLower(key)] {
+				v[key] = redactedValue
+				masked = true
+			} else if r.json(field) {
+				masked = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if r.json(item) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
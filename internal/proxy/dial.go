@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+type backendDialer struct {
+	dialer  *net.Dialer
+	noDelay bool
+}
+
+func newBackendDialer(cfg config.DialConfig, timeout time.Duration) *backendDialer {
+	return &backendDialer{
+		dialer: &net.Dialer{
+			Timeout:       timeout,
+			KeepAlive:     cfg.KeepAlive,
+			FallbackDelay: cfg.FallbackDelay,
+		},
+		noDelay: cfg.TCPNoDelay(),
+	}
+}
+
+func (d *backendDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(d.noDelay); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// handleServiceToggle takes a whole service offline with
+// POST /api/v1/services/{name}/disable and back with .../enable. The flag
+// lives in memory, independent of discovery, so registrations stay untouched
+// and updates don't clear it.
+func (s *Server) handleServiceToggle(w http.ResponseWriter, r *http.Request) {
+	serviceName, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/services/"), "/")
+	if !ok || serviceName == "" || (action != "disable" && action != "enable") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	if _, exists := s.loadBalancers[serviceName]; !exists {
+		s.mu.Unlock()
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	if action == "disable" {
+		s.disabled[serviceName] = true
+	} else {
+		delete(s.disabled, serviceName)
+	}
+	s.mu.Unlock()
+
+	log.Printf("Service %s %sd from %s", serviceName, action, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"service":   serviceName,
+		"disabled":  action == "disable",
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// isDisabled reports whether a service was taken offline through the
+// management API.
+func (s *Server) isDisabled(serviceName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.disabled[serviceName]
+}
+
+// serveDisabled answers a request to a disabled service with its
+// disabled_status, 503 by default.
+func (s *Server) serveDisabled(w http.ResponseWriter, r *http.Request, serviceName string, start time.Time, traceID string) {
+	s.mu.RLock()
+	status := s.config.Service(serviceName).DisabledStatus
+	s.mu.RUnlock()
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, "Service disabled", status)
+
+	metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(time.Since(start).Seconds())
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(status)).Inc()
+	s.logAccess(serviceName, r, r.URL.Path, status, time.Since(start), traceID)
+}
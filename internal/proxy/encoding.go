@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipReadCloser closes both the decoder and the backend body.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// decodeGzipResponse replaces a gzip-encoded backend body with its decoded
+// content, dropping the encoding headers that no longer apply.
+func decodeGzipResponse(resp *http.Response) error {
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = &gzipReadCloser{Reader: reader, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+var errDecodedBodyTooLarge = errors.New("decoded request body too large")
+
+// decodeGzipRequest replaces a gzip-encoded request body with its decoded
+// content. The body is decoded up front, at most limit bytes of it, so the
+// forwarded request carries an exact Content-Length.
+func decodeGzipRequest(r *http.Request, limit int64) error {
+	if r.Body == nil || r.Body == http.NoBody || !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(decoded)) > limit {
+		return errDecodedBodyTooLarge
+	}
+	r.Body.Close()
+
+	r.Body = io.NopCloser(bytes.NewReader(decoded))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(decoded)), nil
+	}
+	r.ContentLength = int64(len(decoded))
+	r.Header.Del("Content-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(len(decoded)))
+	return nil
+}
+
+// gzipPipeBody is a response body gzipped on the fly from the backend body.
+type gzipPipeBody struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+func (g *gzipPipeBody) Close() error {
+	g.PipeReader.Close()
+	return g.body.Close()
+}
+
+// shouldCompress reports whether resp is worth gzipping under cfg for a client
+// sending acceptEncoding.
+func shouldCompress(resp *http.Response, cfg *config.CompressionConfig, acceptEncoding string) bool {
+	switch {
+	case resp.Request.Method == http.MethodHead,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified,
+		resp.StatusCode == http.StatusPartialContent,
+		resp.Header.Get("Content-Encoding") != "",
+		resp.ContentLength >= 0 && resp.ContentLength < cfg.MinSize,
+		!acceptsGzip(acceptEncoding):
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && cfg.Compresses(strings.ToLower(mediaType))
+}
+
+// compressResponse replaces the body of resp with its gzip encoding at level.
+// Strong ETags are weakened since the bytes no longer match.
+func compressResponse(resp *http.Response, level int) error {
+	pr, pw := io.Pipe()
+	writer, err := gzip.NewWriterLevel(pw, level)
+	if err != nil {
+		return err
+	}
+
+	body := resp.Body
+	go func() {
+		_, err := io.Copy(writer, body)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp.Body = &gzipPipeBody{PipeReader: pr, body: body}
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Del("Content-Length")
+	if !strings.Contains(strings.ToLower(strings.Join(resp.Header.Values("Vary"), ",")), "accept-encoding") {
+		resp.Header.Add("Vary", "Accept-Encoding")
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	resp.ContentLength = -1
+	return nil
+}
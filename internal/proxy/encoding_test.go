@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"br, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"identity", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeResponses(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {DecodeResponses: true}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+	if rec.Body.String() != "hello" || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected a decoded body for a client without gzip, got %q (%s)", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+
+	req := httptest.NewRequest("GET", "/api/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()) {
+		t.Error("Expected gzip-capable clients to get the backend bytes unchanged")
+	}
+}
+
+func TestDecodeRequests(t *testing.T) {
+	type received struct {
+		body          string
+		encoding      string
+		contentLength int64
+	}
+	got := make(chan received, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{string(body), r.Header.Get("Content-Encoding"), r.ContentLength}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {DecodeRequests: true, MaxDecodedBody: 8}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	post := func(content string) *httptest.ResponseRecorder {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write([]byte(content))
+		zw.Close()
+
+		req := httptest.NewRequest("POST", "/api/", &compressed)
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("hello"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if r := <-got; r.body != "hello" || r.encoding != "" || r.contentLength != 5 {
+		t.Errorf("Expected a decoded body with length 5, got %+v", r)
+	}
+
+	if rec := post("far more than eight bytes"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 over the decode limit, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/api/", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a corrupt gzip body, got %d", rec.Code)
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Query().Get("small") != "" {
+			w.Write([]byte("tiny"))
+			return
+		}
+		w.Write([]byte(large))
+	}))
+	defer backend.Close()
+
+	// * loaded from YAML so the compression defaults apply
+	path := filepath.Join(t.TempDir(), "fluxgate.yaml")
+	os.WriteFile(path, []byte("services:\n  api:\n    compression:\n      level: 1\n"), 0o644)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	s := newTestServer(t)
+	s.config.Services = cfg.Services
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	get := func(query string, acceptGzip bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/?"+query, nil)
+		if acceptGzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("type=text/html%3B+charset=utf-8", true)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") != `W/"v1"` {
+		t.Fatalf("Expected a gzipped response with a weak ETag, got %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Expected a gzip body: %v", err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != large {
+		t.Error("Expected the gzipped body to decode to the backend body")
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		acceptGzip bool
+	}{
+		{"client without gzip", "type=text/html", false},
+		{"binary type", "type=image/png", true},
+		{"excluded type", "type=text/event-stream", true},
+		{"below min size", "type=text/html&small=1", true},
+	}
+	for _, tt := range tests {
+		if rec := get(tt.query, tt.acceptGzip); rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected an uncompressed response", tt.name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// applyHeaderCase respells the headers listed in the service's header_case
+// for the backend. http.Transport writes HTTP/1 header keys verbatim, so the
+// values move from the canonical key to the configured one. The request
+// passed in is left untouched.
+func (s *Server) applyHeaderCase(req *http.Request) *http.Request {
+	info := requestInfoFrom(req.Context())
+	if info == nil {
+		return req
+	}
+
+	s.mu.RLock()
+	names := s.config.Service(info.service).HeaderCase
+	s.mu.RUnlock()
+
+	var out *http.Request
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if _, ok := req.Header[canonical]; !ok || canonical == name {
+			continue
+		}
+		if out == nil {
+			out = req.Clone(req.Context())
+		}
+		out.Header[name] = out.Header[canonical]
+		delete(out.Header, canonical)
+	}
+	if out == nil {
+		return req
+	}
+	return out
+}
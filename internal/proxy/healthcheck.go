@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fluxgate/fluxgate/internal/loadbalancer"
@@ -13,18 +16,56 @@ import (
 )
 
 type HealthChecker struct {
-	client    *http.Client
-	interval  time.Duration
-	timeout   time.Duration
+	client        *http.Client
+	interval      time.Duration
+	timeout       time.Duration
+	jitter        time.Duration
+	jitterInitial bool
+	// delay picks an endpoint's offset in [0, jitter) for a jittered round
+	delay func(jitter time.Duration) time.Duration
+	// latencyThreshold and minWeightFactor configure latency-based shedding
+	latencyThreshold time.Duration
+	minWeightFactor  float64
+	// transport, when set, returns the RoundTripper probes of an endpoint use
+	transport func(*url.URL) http.RoundTripper
 	endpoints map[string]*HealthEndpoint
+	mu        sync.RWMutex
+}
+
+// HealthProbe describes how an endpoint is checked. Paths, when set, are
+// probed instead of Path: the endpoint passes when any of them does, or only
+// when all do with RequireAll.
+type HealthProbe struct {
+	Path         string
+	Paths        []string
+	RequireAll   bool
+	Method       string
+	ExpectedCode int
+}
+
+// paths returns the paths the probe checks.
+func (p HealthProbe) paths() []string {
+	if len(p.Paths) > 0 {
+		return p.Paths
+	}
+	return []string{p.Path}
 }
 
 type HealthEndpoint struct {
-	URL           *url.URL
-	Path          string
-	ExpectedCode  int
-	LoadBalancer  loadbalancer.LoadBalancer
-	Backend       *loadbalancer.Backend
+	URL          *url.URL
+	Path         string
+	Paths        []string
+	RequireAll   bool
+	Method       string
+	ExpectedCode int
+	LoadBalancer loadbalancer.LoadBalancer
+	Backend      *loadbalancer.Backend
+	// pending is set until the first probe of a warming up endpoint completes
+	pending atomic.Bool
+	// latency is the smoothed duration of passing probes, in nanoseconds
+	latency atomic.Int64
+	// ejected holds the backend out of rotation whatever its probes say
+	ejected atomic.Bool
 }
 
 func NewHealthChecker(interval, timeout time.Duration) *HealthChecker {
@@ -37,78 +78,320 @@ func NewHealthChecker(interval, timeout time.Duration) *HealthChecker {
 		},
 		interval:  interval,
 		timeout:   timeout,
+		delay:     randomDelay,
 		endpoints: make(map[string]*HealthEndpoint),
 	}
 }
 
-func (h *HealthChecker) AddEndpoint(backend *loadbalancer.Backend, lb loadbalancer.LoadBalancer, healthPath string) {
-	endpoint := &HealthEndpoint{
+// randomDelay is a uniformly random duration in [0, jitter).
+func randomDelay(jitter time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// SetJitter spreads probes by delaying each endpoint's check by a random offset
+// up to jitter. The immediate first round is only jittered when initial is set.
+func (h *HealthChecker) SetJitter(jitter time.Duration, initial bool) {
+	h.jitter = jitter
+	h.jitterInitial = initial
+}
+
+// SetLatencyShedding scales the weight of backends whose probes are slower
+// than threshold by threshold/latency, never below minFactor. A zero
+// threshold disables it.
+func (h *HealthChecker) SetLatencyShedding(threshold time.Duration, minFactor float64) {
+	h.latencyThreshold = threshold
+	h.minWeightFactor = minFactor
+}
+
+// SetTransport sends probes through the RoundTripper fn returns for an
+// endpoint's URL, such as the connection pool its traffic uses, falling back
+// to the checker's own client when it returns nil.
+func (h *HealthChecker) SetTransport(fn func(*url.URL) http.RoundTripper) {
+	h.transport = fn
+}
+
+func (h *HealthChecker) AddEndpoint(backend *loadbalancer.Backend, lb loadbalancer.LoadBalancer, probe HealthProbe) {
+	endpoint := newHealthEndpoint(backend, lb, probe)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.endpoints[backend.URL.String()] = endpoint
+}
+
+func newHealthEndpoint(backend *loadbalancer.Backend, lb loadbalancer.LoadBalancer, probe HealthProbe) *HealthEndpoint {
+	return &HealthEndpoint{
 		URL:          backend.URL,
-		Path:         healthPath,
-		ExpectedCode: http.StatusOK,
+		Path:         probe.Path,
+		Paths:        probe.Paths,
+		RequireAll:   probe.RequireAll,
+		Method:       probe.Method,
+		ExpectedCode: probe.ExpectedCode,
 		LoadBalancer: lb,
 		Backend:      backend,
 	}
-	
-	h.endpoints[backend.URL.String()] = endpoint
+}
+
+// SetProbe changes how an existing endpoint is checked.
+func (h *HealthChecker) SetProbe(backendURL string, probe HealthProbe) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if endpoint, exists := h.endpoints[backendURL]; exists {
+		endpoint.Path = probe.Path
+		endpoint.Paths = probe.Paths
+		endpoint.RequireAll = probe.RequireAll
+		endpoint.Method = probe.Method
+		endpoint.ExpectedCode = probe.ExpectedCode
+	}
+}
+
+// SetLoadBalancer points an existing endpoint's health updates at lb.
+func (h *HealthChecker) SetLoadBalancer(backendURL string, lb loadbalancer.LoadBalancer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if endpoint, exists := h.endpoints[backendURL]; exists {
+		endpoint.LoadBalancer = lb
+	}
 }
 
 func (h *HealthChecker) RemoveEndpoint(backendURL string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	delete(h.endpoints, backendURL)
 }
 
+// AddPendingEndpoint registers a backend that has not been probed from this
+// node yet. The caller adds it inactive; it is probed right away and joins the
+// rotation on the first passing probe, or when grace expires without one. A
+// zero grace waits for a passing probe however long it takes.
+func (h *HealthChecker) AddPendingEndpoint(backend *loadbalancer.Backend, lb loadbalancer.LoadBalancer, probe HealthProbe, grace time.Duration) {
+	endpoint := newHealthEndpoint(backend, lb, probe)
+	endpoint.pending.Store(true)
+
+	h.mu.Lock()
+	h.endpoints[backend.URL.String()] = endpoint
+	h.mu.Unlock()
+
+	if grace > 0 {
+		time.AfterFunc(grace, func() {
+			if endpoint.pending.CompareAndSwap(true, false) {
+				log.Printf("Warmup grace expired for backend %s without a probe result", endpoint.URL.String())
+				h.markHealthy(endpoint)
+			}
+		})
+	}
+	go h.check(endpoint)
+}
+
+// MarkUnhealthy takes a backend out of rotation ahead of its next probe.
+func (h *HealthChecker) MarkUnhealthy(backendURL string) {
+	h.mu.RLock()
+	endpoint, exists := h.endpoints[backendURL]
+	h.mu.RUnlock()
+
+	if exists {
+		h.markUnhealthy(endpoint)
+	}
+}
+
+// MarkHealthy puts a backend back in rotation ahead of its next probe, unless
+// it is ejected.
+func (h *HealthChecker) MarkHealthy(backendURL string) {
+	h.mu.RLock()
+	endpoint, exists := h.endpoints[backendURL]
+	h.mu.RUnlock()
+
+	if exists {
+		h.markHealthy(endpoint)
+	}
+}
+
+// SetEjected forces a backend out of rotation, or lifts that so probes decide
+// again. It reports false for unknown backends.
+func (h *HealthChecker) SetEjected(backendURL string, ejected bool) bool {
+	h.mu.RLock()
+	endpoint, exists := h.endpoints[backendURL]
+	h.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+	endpoint.ejected.Store(ejected)
+	if ejected {
+		h.markUnhealthy(endpoint)
+	}
+	return true
+}
+
+// Reset lifts any ejection and puts the backend back in rotation right away
+// instead of waiting for its next passing probe.
+func (h *HealthChecker) Reset(backendURL string) bool {
+	h.mu.RLock()
+	endpoint, exists := h.endpoints[backendURL]
+	h.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+	endpoint.ejected.Store(false)
+	h.markHealthy(endpoint)
+	return true
+}
+
+// Ejected reports whether a backend is forced out of rotation.
+func (h *HealthChecker) Ejected(backendURL string) bool {
+	h.mu.RLock()
+	endpoint, exists := h.endpoints[backendURL]
+	h.mu.RUnlock()
+
+	return exists && endpoint.ejected.Load()
+}
+
 func (h *HealthChecker) Start(ctx context.Context) {
 	ticker := time.NewTicker(h.interval)
 	defer ticker.Stop()
 
-	h.checkAll()
+	h.checkAll(ctx, h.jitterInitial)
 
 	for {
 		select {
 		case <-ticker.C:
-			h.checkAll()
+			h.checkAll(ctx, true)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (h *HealthChecker) checkAll() {
+func (h *HealthChecker) checkAll(ctx context.Context, jitter bool) {
+	h.mu.RLock()
+	endpoints := make([]*HealthEndpoint, 0, len(h.endpoints))
 	for _, endpoint := range h.endpoints {
-		go h.check(endpoint)
+		endpoints = append(endpoints, endpoint)
+	}
+	h.mu.RUnlock()
+
+	for _, endpoint := range endpoints {
+		if !jitter || h.jitter <= 0 {
+			go h.check(endpoint)
+			continue
+		}
+
+		delay := h.delay(h.jitter)
+		go func(endpoint *HealthEndpoint) {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+				h.check(endpoint)
+			case <-ctx.Done():
+			}
+		}(endpoint)
 	}
 }
 
+// check probes the endpoint's paths in order, stopping at the first that
+// decides the result: a pass unless all are required, a failure if they are.
 func (h *HealthChecker) check(endpoint *HealthEndpoint) {
-	healthURL := fmt.Sprintf("%s%s", endpoint.URL.String(), endpoint.Path)
-	
+	h.mu.RLock()
+	probe := HealthProbe{Path: endpoint.Path, Paths: endpoint.Paths, RequireAll: endpoint.RequireAll}
+	method, expectedCode := endpoint.Method, endpoint.ExpectedCode
+	h.mu.RUnlock()
+
+	// * any completed probe, passing or not, ends the warmup
+	defer endpoint.pending.Store(false)
+
+	client := h.client
+	if h.transport != nil {
+		if transport := h.transport(endpoint.URL); transport != nil {
+			client = &http.Client{Transport: transport, Timeout: h.client.Timeout, CheckRedirect: h.client.CheckRedirect}
+		}
+	}
+
+	var latency time.Duration
+	healthy := probe.RequireAll
+	for _, path := range probe.paths() {
+		took, passed := h.probe(client, fmt.Sprintf("%s%s", endpoint.URL.String(), path), method, expectedCode)
+		if passed {
+			latency = max(latency, took)
+		}
+		if passed != probe.RequireAll {
+			healthy = passed
+			break
+		}
+	}
+
+	if healthy {
+		h.recordLatency(endpoint, latency)
+		h.markHealthy(endpoint)
+	} else {
+		h.markUnhealthy(endpoint)
+	}
+}
+
+// probe requests healthURL with client, reporting how long it took and
+// whether it answered with expectedCode.
+func (h *HealthChecker) probe(client *http.Client, healthURL, method string, expectedCode int) (time.Duration, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
 	defer cancel()
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+
+	req, err := http.NewRequestWithContext(ctx, method, healthURL, nil)
 	if err != nil {
-		h.markUnhealthy(endpoint)
-		return
+		return 0, false
 	}
-	
-	resp, err := h.client.Do(req)
+
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		h.markUnhealthy(endpoint)
-		return
+		return 0, false
 	}
 	defer resp.Body.Close()
-	
-	if resp.StatusCode == endpoint.ExpectedCode {
-		h.markHealthy(endpoint)
-	} else {
-		h.markUnhealthy(endpoint)
+
+	return time.Since(start), resp.StatusCode == expectedCode
+}
+
+// latencySmoothing is the weight of the newest sample in the latency average.
+const latencySmoothing = 0.3
+
+// recordLatency folds a passing probe's latency into the endpoint's average
+// and updates the backend's weight factor from it.
+func (h *HealthChecker) recordLatency(endpoint *HealthEndpoint, latency time.Duration) {
+	if h.latencyThreshold <= 0 {
+		return
+	}
+
+	smoothed := latency
+	if previous := time.Duration(endpoint.latency.Load()); previous > 0 {
+		smoothed = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(previous))
+	}
+	endpoint.latency.Store(int64(smoothed))
+
+	factor := 1.0
+	if smoothed > h.latencyThreshold {
+		factor = float64(h.latencyThreshold) / float64(smoothed)
+		if factor < h.minWeightFactor {
+			factor = h.minWeightFactor
+		}
 	}
+	endpoint.Backend.SetWeightFactor(factor)
+	metrics.BackendEffectiveWeight.WithLabelValues(endpoint.URL.String()).Set(endpoint.Backend.EffectiveWeight())
+}
+
+func (h *HealthChecker) loadBalancerOf(endpoint *HealthEndpoint) loadbalancer.LoadBalancer {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return endpoint.LoadBalancer
 }
 
 func (h *HealthChecker) markHealthy(endpoint *HealthEndpoint) {
+	if endpoint.ejected.Load() {
+		return
+	}
 	if !endpoint.Backend.Active {
 		log.Printf("Backend %s is now healthy", endpoint.URL.String())
-		endpoint.LoadBalancer.MarkHealthy(endpoint.Backend)
+		h.loadBalancerOf(endpoint).MarkHealthy(endpoint.Backend)
 		metrics.BackendHealth.WithLabelValues(endpoint.URL.String()).Set(1)
 	}
 }
@@ -116,7 +399,7 @@ func (h *HealthChecker) markHealthy(endpoint *HealthEndpoint) {
 func (h *HealthChecker) markUnhealthy(endpoint *HealthEndpoint) {
 	if endpoint.Backend.Active {
 		log.Printf("Backend %s is now unhealthy", endpoint.URL.String())
-		endpoint.LoadBalancer.MarkUnhealthy(endpoint.Backend)
+		h.loadBalancerOf(endpoint).MarkUnhealthy(endpoint.Backend)
 		metrics.BackendHealth.WithLabelValues(endpoint.URL.String()).Set(0)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,385 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+)
+
+func pendingBackend(t *testing.T, h *HealthChecker, algorithm, rawURL string, grace time.Duration) loadbalancer.LoadBalancer {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Failed to parse backend URL: %v", err)
+	}
+	backend := &loadbalancer.Backend{URL: u, Weight: 1}
+	lb, err := loadbalancer.New(algorithm)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	lb.Add(backend)
+	h.AddPendingEndpoint(backend, lb, HealthProbe{Path: "/health", Method: http.MethodGet, ExpectedCode: http.StatusOK}, grace)
+	return lb
+}
+
+// selectable reports whether lb hands out its backend, releasing it again.
+func selectable(lb loadbalancer.LoadBalancer) bool {
+	backend := lb.Next()
+	if backend == nil {
+		return false
+	}
+	lb.ReleaseConnection(backend)
+	return true
+}
+
+func waitForSelectable(lb loadbalancer.LoadBalancer) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if selectable(lb) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPendingEndpointJoinsAfterProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	h := NewHealthChecker(time.Minute, time.Second)
+	lb := pendingBackend(t, h, "round_robin", server.URL, time.Hour)
+
+	if !waitForSelectable(lb) {
+		t.Error("Expected the backend to join the rotation after a passing probe")
+	}
+}
+
+func TestPendingEndpointStaysOutAfterFailedProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h := NewHealthChecker(time.Minute, time.Second)
+	lb := pendingBackend(t, h, "round_robin", server.URL, 50*time.Millisecond)
+
+	time.Sleep(200 * time.Millisecond)
+	if selectable(lb) {
+		t.Error("Expected a backend failing its first probe to stay out after the grace")
+	}
+}
+
+func TestPendingEndpointJoinsWhenGraceExpires(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	h := NewHealthChecker(time.Minute, 5*time.Second)
+	lb := pendingBackend(t, h, "round_robin", server.URL, 50*time.Millisecond)
+
+	if !waitForSelectable(lb) {
+		t.Error("Expected the backend to join the rotation once the grace expired")
+	}
+}
+
+func TestPendingEndpointHeldUnderEveryBalancer(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	h := NewHealthChecker(time.Minute, 5*time.Second)
+	lbs := make(map[string]loadbalancer.LoadBalancer)
+	for _, algorithm := range []string{"round_robin", "least_connection", "least_load", "weighted_random"} {
+		lbs[algorithm] = pendingBackend(t, h, algorithm, server.URL, time.Hour)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for algorithm, lb := range lbs {
+		if selectable(lb) {
+			t.Errorf("%s: expected the backend held until its first probe passes", algorithm)
+		}
+	}
+
+	close(release)
+	for algorithm, lb := range lbs {
+		if !waitForSelectable(lb) {
+			t.Errorf("%s: expected the backend to join the rotation after a passing probe", algorithm)
+		}
+	}
+}
+
+func TestInstanceHealthProbeOverrides(t *testing.T) {
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080, Metadata: map[string]string{
+			"health_path":          "/-/ready",
+			"health_method":        "head",
+			"health_expected_code": "204",
+		}},
+		{ID: "api-2", Service: "api", Address: "10.0.0.2", Port: 8080},
+	})
+
+	s.healthChecker.mu.RLock()
+	custom := s.healthChecker.endpoints["http://10.0.0.1:8080"]
+	fallback := s.healthChecker.endpoints["http://10.0.0.2:8080"]
+	s.healthChecker.mu.RUnlock()
+
+	if custom.Path != "/-/ready" || custom.Method != http.MethodHead || custom.ExpectedCode != http.StatusNoContent {
+		t.Errorf("Expected metadata overrides, got %s %s %d", custom.Method, custom.Path, custom.ExpectedCode)
+	}
+	if fallback.Path != s.config.HealthCheck.Path || fallback.Method != http.MethodGet || fallback.ExpectedCode != http.StatusOK {
+		t.Errorf("Expected global defaults, got %s %s %d", fallback.Method, fallback.Path, fallback.ExpectedCode)
+	}
+}
+
+func TestLatencyShedding(t *testing.T) {
+	h := NewHealthChecker(time.Minute, time.Second)
+	h.SetLatencyShedding(100*time.Millisecond, 0.1)
+
+	u, _ := url.Parse("http://10.0.0.1:8080")
+	endpoint := newHealthEndpoint(&loadbalancer.Backend{URL: u, Weight: 4}, loadbalancer.NewWeightedRandom(), HealthProbe{})
+
+	h.recordLatency(endpoint, 50*time.Millisecond)
+	if factor := endpoint.Backend.WeightFactor(); factor != 1 {
+		t.Errorf("Expected full weight under the threshold, got factor %v", factor)
+	}
+
+	endpoint.latency.Store(0)
+	h.recordLatency(endpoint, 400*time.Millisecond)
+	if weight := endpoint.Backend.EffectiveWeight(); weight != 1 {
+		t.Errorf("Expected a 4x slower backend to keep a quarter of its weight, got %v", weight)
+	}
+
+	endpoint.latency.Store(0)
+	h.recordLatency(endpoint, 10*time.Second)
+	if factor := endpoint.Backend.WeightFactor(); factor != 0.1 {
+		t.Errorf("Expected the factor to stop at the minimum, got %v", factor)
+	}
+}
+
+func TestHealthCheckMultiplePaths(t *testing.T) {
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.URL.Path)
+		if r.URL.Path != "/live" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	tests := []struct {
+		name        string
+		probe       HealthProbe
+		wantHealthy bool
+		wantHits    []string
+	}{
+		{"any passes on a later path", HealthProbe{Paths: []string{"/ready", "/live", "/deep"}}, true, []string{"/ready", "/live"}},
+		{"any fails when none pass", HealthProbe{Paths: []string{"/ready", "/deep"}}, false, []string{"/ready", "/deep"}},
+		{"all passes when every path does", HealthProbe{Paths: []string{"/live", "/live"}, RequireAll: true}, true, []string{"/live", "/live"}},
+		{"all fails on the first failure", HealthProbe{Paths: []string{"/live", "/ready", "/deep"}, RequireAll: true}, false, []string{"/live", "/ready"}},
+		{"single path", HealthProbe{Path: "/live"}, true, []string{"/live"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits = nil
+			h := NewHealthChecker(time.Minute, time.Second)
+			backend := &loadbalancer.Backend{URL: u, Weight: 1, Active: !tt.wantHealthy}
+			lb := loadbalancer.NewRoundRobin()
+			lb.Add(backend)
+			tt.probe.Method, tt.probe.ExpectedCode = http.MethodGet, http.StatusOK
+			h.AddEndpoint(backend, lb, tt.probe)
+
+			h.check(h.endpoints[server.URL])
+			if backend.Active != tt.wantHealthy {
+				t.Errorf("Expected healthy %v, got %v", tt.wantHealthy, backend.Active)
+			}
+			if !reflect.DeepEqual(hits, tt.wantHits) {
+				t.Errorf("Expected probes of %v, got %v", tt.wantHits, hits)
+			}
+		})
+	}
+}
+
+func TestHealthCheckTrafficPool(t *testing.T) {
+	var conns atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.HealthCheck.UseTrafficPool = true
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{backendInstance(t, "orders", backend.URL)})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/orders/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	endpoint := s.healthChecker.endpoints[backend.URL]
+	s.healthChecker.check(endpoint)
+	s.healthChecker.check(endpoint)
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected probes to reuse the traffic connection, got %d connections", n)
+	}
+
+	// * without the option probes keep their own connections
+	s.mu.Lock()
+	s.config.HealthCheck.UseTrafficPool = false
+	s.mu.Unlock()
+	s.healthChecker.check(endpoint)
+	if n := conns.Load(); n != 2 {
+		t.Errorf("Expected a separate probe connection, got %d connections", n)
+	}
+}
+
+// probedEndpoints registers n endpoints on h against a backend counting its
+// probes.
+func probedEndpoints(t *testing.T, h *HealthChecker, n int) *atomic.Int32 {
+	t.Helper()
+
+	probes := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	t.Cleanup(server.Close)
+
+	lb, err := loadbalancer.New("round_robin")
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	for i := range n {
+		u, _ := url.Parse(server.URL + "/" + string(rune('a'+i)))
+		backend := &loadbalancer.Backend{URL: u, Weight: 1, Active: true}
+		lb.Add(backend)
+		h.AddEndpoint(backend, lb, HealthProbe{Path: "/health", Method: http.MethodGet, ExpectedCode: http.StatusOK})
+	}
+	return probes
+}
+
+func waitForProbes(probes *atomic.Int32, n int32) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if probes.Load() >= n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestRandomDelayWithinJitter(t *testing.T) {
+	jitter := 10 * time.Millisecond
+	for range 1000 {
+		if delay := randomDelay(jitter); delay < 0 || delay >= jitter {
+			t.Fatalf("Expected a delay in [0, %v), got %v", jitter, delay)
+		}
+	}
+}
+
+func TestHealthCheckJitterDelaysEachEndpoint(t *testing.T) {
+	h := NewHealthChecker(time.Hour, time.Second)
+	h.SetJitter(time.Second, false)
+	var delays []time.Duration
+	var mu sync.Mutex
+	h.delay = func(jitter time.Duration) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if jitter != time.Second {
+			t.Errorf("Expected the configured jitter, got %v", jitter)
+		}
+		delay := randomDelay(jitter / 20)
+		delays = append(delays, delay)
+		return delay
+	}
+	probes := probedEndpoints(t, h, 3)
+
+	h.checkAll(context.Background(), true)
+	if !waitForProbes(probes, 3) {
+		t.Fatalf("Expected every endpoint to be probed, got %d probes", probes.Load())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delays) != 3 {
+		t.Errorf("Expected one delay per endpoint, got %d", len(delays))
+	}
+	for _, delay := range delays {
+		if delay < 0 || delay >= time.Second {
+			t.Errorf("Expected a delay in [0, 1s), got %v", delay)
+		}
+	}
+}
+
+func TestHealthCheckFirstRoundImmediateWithoutInitialJitter(t *testing.T) {
+	h := NewHealthChecker(time.Hour, time.Second)
+	h.SetJitter(time.Hour, false)
+	var delayed atomic.Int32
+	h.delay = func(jitter time.Duration) time.Duration {
+		delayed.Add(1)
+		return jitter
+	}
+	probes := probedEndpoints(t, h, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Start(ctx)
+
+	if !waitForProbes(probes, 2) {
+		t.Fatalf("Expected the first round to probe right away, got %d probes", probes.Load())
+	}
+	if n := delayed.Load(); n != 0 {
+		t.Errorf("Expected no delay on the first round, got %d", n)
+	}
+
+	// * with jitter_initial the first round waits out its delays too
+	h = NewHealthChecker(time.Hour, time.Second)
+	h.SetJitter(time.Hour, true)
+	h.delay = func(jitter time.Duration) time.Duration { return jitter - 1 }
+	probes = probedEndpoints(t, h, 2)
+	go h.Start(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := probes.Load(); n != 0 {
+		t.Errorf("Expected the jittered first round to wait, got %d probes", n)
+	}
+}
+
+func TestHealthCheckCancelStopsDelayedProbes(t *testing.T) {
+	h := NewHealthChecker(time.Hour, time.Second)
+	h.SetJitter(time.Second, true)
+	h.delay = func(time.Duration) time.Duration { return 50 * time.Millisecond }
+	probes := probedEndpoints(t, h, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.checkAll(ctx, true)
+	cancel()
+
+	time.Sleep(200 * time.Millisecond)
+	if n := probes.Load(); n != 0 {
+		t.Errorf("Expected cancellation to stop the delayed probes, got %d probes", n)
+	}
+}
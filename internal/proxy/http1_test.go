@@ -0,0 +1,344 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dialGateway opens a raw connection to a FluxGate server proxying the
+// "upload" service to backend.
+func dialGateway(t *testing.T, backend *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("upload", []discovery.ServiceInstance{backendInstance(t, "upload", backend.URL)})
+	gateway := httptest.NewServer(s.Handler())
+	t.Cleanup(gateway.Close)
+
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func TestExpectContinueRelaysInterimResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	conn, reader := dialGateway(t, backend)
+	io.WriteString(conn, "POST /upload/ HTTP/1.1\r\nHost: gateway\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+
+	// * the body is only sent once the interim response arrived
+	interim, err := http.ReadResponse(reader, nil)
+	if err != nil || interim.StatusCode != http.StatusContinue {
+		t.Fatalf("Expected 100 Continue before the body, got %v: %v", interim, err)
+	}
+
+	io.WriteString(conn, "hello")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read final response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("Expected the uploaded body echoed, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestExpectContinueRejectionSkipsUpload(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+	}))
+	defer backend.Close()
+
+	conn, reader := dialGateway(t, backend)
+	io.WriteString(conn, "POST /upload/ HTTP/1.1\r\nHost: gateway\r\nContent-Length: 1048576\r\nExpect: 100-continue\r\n\r\n")
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Expected a final response without sending the body: %v", err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the backend's 413, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTP10KeepAlive(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	conn, reader := dialGateway(t, backend)
+	for i := 0; i < 2; i++ {
+		io.WriteString(conn, "GET /upload/ HTTP/1.0\r\nHost: gateway\r\nConnection: keep-alive\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Request %d on a kept-alive HTTP/1.0 connection failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Errorf("Expected 200 ok, got %d %q", resp.StatusCode, body)
+		}
+		if !strings.EqualFold(resp.Header.Get("Connection"), "keep-alive") {
+			t.Errorf("Expected the HTTP/1.0 connection to be kept alive, got %q", resp.Header.Get("Connection"))
+		}
+	}
+
+	// * without keep-alive an HTTP/1.0 connection closes after the response
+	io.WriteString(conn, "GET /upload/ HTTP/1.0\r\nHost: gateway\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to close, got %v", err)
+	}
+}
+
+func TestResponseWriterWrapper(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rec, statusCode: http.StatusOK}
+
+	rw.WriteHeader(http.StatusAccepted)
+	rw.WriteHeader(http.StatusInternalServerError)
+	rw.Write([]byte("ok"))
+	rw.Flush()
+
+	if rw.statusCode != http.StatusAccepted || rec.Code != http.StatusAccepted {
+		t.Errorf("Expected the first final status to win, got %d (recorded %d)", rw.statusCode, rec.Code)
+	}
+	if !rec.Flushed {
+		t.Error("Expected Flush to reach the underlying writer")
+	}
+	if _, _, err := rw.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported hijacking a recorder, got %v", err)
+	}
+
+	hijacked := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		conn, _, err := rw.Hijack()
+		if err != nil {
+			t.Errorf("Expected the wrapper to hijack, got %v", err)
+			hijacked <- 0
+			return
+		}
+		conn.Close()
+		hijacked <- rw.statusCode
+	}))
+	defer server.Close()
+
+	http.Get(server.URL)
+	if status := <-hijacked; status != http.StatusSwitchingProtocols {
+		t.Errorf("Expected a hijacked response to report 101, got %d", status)
+	}
+}
+
+func TestStreamingFlushesThroughGateway(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	conn, reader := dialGateway(t, backend)
+	io.WriteString(conn, "GET /upload/events HTTP/1.1\r\nHost: gateway\r\n\r\n")
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("Expected the first event before the stream ends, got %q: %v", line, err)
+	}
+}
+
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestClientConnectionMetrics(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = trackConnState
+	server.Start()
+	defer server.Close()
+
+	accepted := testutil.ToFloat64(metrics.ClientConnsAccepted)
+	active := testutil.ToFloat64(metrics.ClientConnsActive)
+	closed := testutil.ToFloat64(metrics.ClientConnsClosed)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gateway\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.ClientConnsAccepted) - accepted; got != 1 {
+		t.Errorf("Expected 1 accepted connection, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.ClientConnsActive) - active; got != 1 {
+		t.Errorf("Expected 1 active connection, got %v", got)
+	}
+
+	// * abandoning the connection shows up once the server notices
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(metrics.ClientConnsClosed) == closed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(metrics.ClientConnsClosed) - closed; got != 1 {
+		t.Errorf("Expected 1 closed connection, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.ClientConnsActive) - active; got != 0 {
+		t.Errorf("Expected no active connection left, got %v", got)
+	}
+
+	acceptErrors := testutil.ToFloat64(metrics.ClientAcceptErrors)
+	ln := &countingListener{Listener: &failingListener{err: errors.New("too many open files")}}
+	ln.Accept()
+	(&countingListener{Listener: &failingListener{err: net.ErrClosed}}).Accept()
+	if got := testutil.ToFloat64(metrics.ClientAcceptErrors) - acceptErrors; got != 1 {
+		t.Errorf("Expected 1 accept error ignoring shutdown, got %v", got)
+	}
+}
+
+func TestMalformedRequestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = &countingListener{Listener: server.Listener, rejected: s.rejectedRequest}
+	server.Config.ConnState = trackConnState
+	server.Start()
+	defer server.Close()
+
+	rejected := testutil.ToFloat64(metrics.MalformedRequests.WithLabelValues("400"))
+
+	// * a well-formed request on the same kind of connection is not counted
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gateway\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if _, err := http.ReadResponse(reader, nil); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.MalformedRequests.WithLabelValues("400")) - rejected; got != 0 {
+		t.Errorf("Expected no rejected request, got %v", got)
+	}
+
+	io.WriteString(conn, "NOT A REQUEST\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed request, got %d", resp.StatusCode)
+	}
+	if got := testutil.ToFloat64(metrics.MalformedRequests.WithLabelValues("400")) - rejected; got != 1 {
+		t.Errorf("Expected 1 rejected request, got %v", got)
+	}
+
+	handshakes := testutil.ToFloat64(metrics.MalformedRequests.WithLabelValues("tls_handshake"))
+	fmt.Fprintf(serverErrorLog{s: s}, "http: TLS handshake error from 10.0.0.1:4000: EOF\n")
+	if got := testutil.ToFloat64(metrics.MalformedRequests.WithLabelValues("tls_handshake")) - handshakes; got != 1 {
+		t.Errorf("Expected 1 failed handshake, got %v", got)
+	}
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	target := echo.Addr().String()
+
+	s := newTestServer(t)
+	gateway := httptest.NewServer(s.Handler())
+	defer gateway.Close()
+
+	connect := func(target string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial gateway: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read CONNECT response: %v", err)
+		}
+		return conn, reader, resp
+	}
+
+	if _, _, resp := connect(target); resp.StatusCode == http.StatusOK {
+		t.Fatal("Expected CONNECT not to be tunnelled while forward_proxy is disabled")
+	}
+
+	s.config.ForwardProxy = config.ForwardProxyConfig{Enabled: true, AllowedTargets: []string{target}}
+
+	conn, reader, resp := connect(target)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for an allowlisted target, got %d", resp.StatusCode)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("Expected the tunnel to echo, got %q (%v)", line, err)
+	}
+
+	_, port, _ := net.SplitHostPort(target)
+	if _, _, resp := connect("localhost:" + port); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a target off the allowlist, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+const (
+	// idempotencyEntries bounds the keys remembered, the oldest stored is
+	// evicted first
+	idempotencyEntries = 10000
+	// idempotencyBodyLimit is the largest response body kept for replay
+	idempotencyBodyLimit = 1 << 20
+)
+
+// idempotentResponse is the outcome of the first request with an idempotency
+// key. Its fields are written by that request and may only be read by others
+// once done is closed.
+type idempotentResponse struct {
+	key     string
+	request string
+	ttl     time.Duration
+	done    chan struct{}
+	expires time.Time
+
+	recordedResponse
+}
+
+// recordedResponse is a response copied as it is written to the client, to be
+// replayed to other requests.
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	// overflow marks a body over the recorder's limit, which can't be replayed
+	overflow bool
+}
+
+// idempotencyCache remembers responses by service and idempotency key.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	order   []string
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentResponse)}
+}
+
+// begin returns the response for key and whether the caller is the first
+// request with it, which must forward the request and then complete it.
+func (c *idempotencyCache) begin(key, request string, ttl time.Duration) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry := c.entries[key]; entry != nil {
+		select {
+		case <-entry.done:
+			if time.Now().Before(entry.expires) {
+				return entry, false
+			}
+		default:
+			return entry, false
+		}
+	} else {
+		c.order = append(c.order, key)
+		if len(c.order) > idempotencyEntries {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+
+	entry := &idempotentResponse{key: key, request: request, ttl: ttl, done: make(chan struct{})}
+	c.entries[key] = entry
+	return entry, true
+}
+
+// complete publishes the first request's response to the requests waiting for
+// it. Gateway and backend failures aren't remembered past that, so the client
+// can retry them.
+func (c *idempotencyCache) complete(entry *idempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (entry.status == 0 || entry.status >= 500) && c.entries[entry.key] == entry {
+		delete(c.entries, entry.key)
+		if i := slices.Index(c.order, entry.key); i >= 0 {
+			c.order = slices.Delete(c.order, i, i+1)
+		}
+	}
+	entry.expires = time.Now().Add(entry.ttl)
+	close(entry.done)
+}
+
+// replayRecorder copies the response of a request into a recordedResponse as
+// it is written to the client, up to limit body bytes.
+type replayRecorder struct {
+	http.ResponseWriter
+	response *recordedResponse
+	limit    int
+}
+
+func (rec *replayRecorder) WriteHeader(code int) {
+	// * interim 1xx responses are forwarded but not replayed
+	if code >= 200 && rec.response.status == 0 {
+		rec.response.status = code
+		rec.response.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *replayRecorder) Write(p []byte) (int, error) {
+	if rec.response.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.response.overflow {
+		if len(rec.response.body)+len(p) > rec.limit {
+			rec.response.overflow = true
+			rec.response.body = nil
+		} else {
+			rec.response.body = append(rec.response.body, p...)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *replayRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *replayRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// beginIdempotent looks up r's idempotency key when its service has
+// idempotency enabled, returning nil when it doesn't apply.
+func (s *Server) beginIdempotent(r *http.Request, serviceName string) (*idempotentResponse, bool) {
+	s.mu.RLock()
+	idempotency := s.config.Service(serviceName).Idempotency
+	s.mu.RUnlock()
+
+	if idempotency == nil || isUpgradeRequest(r) {
+		return nil, false
+	}
+	key := r.Header.Get(idempotency.Header)
+	if key == "" {
+		return nil, false
+	}
+	return s.idempotency.begin(serviceName+" "+key, r.Method+" "+r.URL.RequestURI(), idempotency.TTL)
+}
+
+// replayIdempotent answers a duplicate request with the response to the first
+// request with its key, waiting for it while it is in flight.
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, serviceName string, entry *idempotentResponse, start time.Time, traceID string) {
+	reused := entry.request != r.Method+" "+r.URL.RequestURI()
+	if !reused {
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	var status int
+	switch {
+	case reused:
+		status = http.StatusUnprocessableEntity
+		http.Error(w, "Idempotency key was used for a different request", status)
+	case entry.status == 0:
+		status = http.StatusBadGateway
+		http.Error(w, "Original request did not complete", status)
+	case entry.overflow:
+		status = http.StatusConflict
+		http.Error(w, "Original response is too large to replay", status)
+	default:
+		status = entry.status
+		for name, values := range entry.header {
+			if _, exists := w.Header()[name]; !exists {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(entry.body)
+		}
+	}
+
+	metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(time.Since(start).Seconds())
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(status)).Inc()
+	s.logAccess(serviceName, r, r.URL.Path, status, time.Since(start), traceID)
+}
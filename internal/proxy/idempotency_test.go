@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestIdempotencyCoalescesDuplicates(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.URL.Path == "/charges" {
+			<-release
+		}
+		w.Header().Set("X-Charge", strconv.Itoa(int(n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("charge " + strconv.Itoa(int(n))))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"payments": {Idempotency: &config.IdempotencyConfig{Header: "Idempotency-Key", TTL: time.Minute}},
+	}
+	s.UpdateServiceInstances("payments", []discovery.ServiceInstance{backendInstance(t, "payments", backend.URL)})
+
+	post := func(key, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/payments"+path, strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	const duplicates = 5
+	recs := make([]*httptest.ResponseRecorder, duplicates)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = post("abc", "/charges")
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("Expected concurrent duplicates to reach the backend once, got %d", n)
+	}
+	replayed := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusCreated || rec.Body.String() != "charge 1" || rec.Header().Get("X-Charge") != "1" {
+			t.Errorf("Expected every duplicate to get the original response, got %d %q", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != duplicates-1 {
+		t.Errorf("Expected %d replayed responses, got %d", duplicates-1, replayed)
+	}
+
+	if rec := post("abc", "/charges"); rec.Body.String() != "charge 1" || calls.Load() != 1 {
+		t.Errorf("Expected a later duplicate to be replayed, got %q", rec.Body.String())
+	}
+	if rec := post("abc", "/refunds"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a key reused on another request, got %d", rec.Code)
+	}
+	if rec := post("def", "/charges"); rec.Body.String() != "charge 2" {
+		t.Errorf("Expected a new key to be forwarded, got %q", rec.Body.String())
+	}
+}
+
+func TestIdempotencyForgetsFailures(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"payments": {Idempotency: &config.IdempotencyConfig{Header: "Idempotency-Key", TTL: time.Minute}},
+	}
+	s.UpdateServiceInstances("payments", []discovery.ServiceInstance{backendInstance(t, "payments", backend.URL)})
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		req := httptest.NewRequest("POST", "/payments/charges", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "abc")
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Expected %d, got %d", want, rec.Code)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected a retry after the failure and a replay after the success, got %d backend calls", n)
+	}
+}
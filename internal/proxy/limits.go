@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+// routeBudget returns how many routes serviceName may have under
+// limits.max_routes, -1 when unlimited. The caller must hold s.mu.
+func (s *Server) routeBudget(serviceName string) int {
+	limit := s.config.Limits.MaxRoutes
+	if limit == 0 {
+		return -1
+	}
+	others := 0
+	for _, route := range s.router.Routes() {
+		if route.ServiceName != serviceName {
+			others++
+		}
+	}
+	return max(0, limit-others)
+}
+
+// exceedsRouteLimit reports whether registering instance would give its
+// service more routes than limits.max_routes leaves room for.
+func (s *Server) exceedsRouteLimit(instance discovery.ServiceInstance) bool {
+	instances := []discovery.ServiceInstance{instance}
+	for _, existing := range s.discovery.GetInstances(instance.Service) {
+		if existing.ID != instance.ID {
+			instances = append(instances, existing)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	budget := s.routeBudget(instance.Service)
+	return budget >= 0 && len(routePaths(instance.Service, instances)) > budget
+}
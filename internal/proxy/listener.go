@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// countingListener counts failed accepts on the proxy listener, other than
+// the listener being closed on shutdown, and wraps accepted connections so
+// requests the http.Server rejects itself are noticed.
+type countingListener struct {
+	net.Listener
+	rejected func(conn net.Conn, status string)
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			metrics.ClientAcceptErrors.Inc()
+		}
+		return conn, err
+	}
+	return &trackedConn{Conn: conn, rejected: l.rejected}, nil
+}
+
+// trackedConn spots the responses http.Server writes straight to the
+// connection for requests too malformed to reach the handler: a status line
+// written while no request is active. Under TLS only the plaintext answer to
+// a non-TLS client is visible, the rest is encrypted.
+type trackedConn struct {
+	net.Conn
+	// state is the http.ConnState trackConnState last saw
+	state    atomic.Int32
+	rejected func(conn net.Conn, status string)
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	state := http.ConnState(c.state.Load())
+	if (state == http.StateNew || state == http.StateIdle) && bytes.HasPrefix(p, []byte("HTTP/1.")) && c.rejected != nil {
+		status := "unknown"
+		if _, rest, ok := bytes.Cut(p, []byte(" ")); ok && len(rest) >= 3 {
+			status = string(rest[:3])
+		}
+		c.rejected(c, status)
+	}
+	return c.Conn.Write(p)
+}
+
+// trackConnState is the proxy server's ConnState hook, keeping the client
+// connection metrics. Hijacked connections, i.e. WebSocket upgrades, leave
+// the server and count as closed.
+func trackConnState(conn net.Conn, state http.ConnState) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if client, ok := conn.(*trackedConn); ok {
+		client.state.Store(int32(state))
+	}
+
+	switch state {
+	case http.StateNew:
+		metrics.ClientConnsAccepted.Inc()
+		metrics.ClientConnsActive.Inc()
+	case http.StateClosed, http.StateHijacked:
+		metrics.ClientConnsActive.Dec()
+		metrics.ClientConnsClosed.Inc()
+	}
+}
+
+// rejectedRequest records a request the http.Server answered with status
+// before routing it.
+func (s *Server) rejectedRequest(conn net.Conn, status string) {
+	metrics.MalformedRequests.WithLabelValues(status).Inc()
+	s.logMalformed("Rejected malformed request from %s with %s", conn.RemoteAddr(), status)
+}
+
+// logMalformed logs at logging.malformed_requests, prefixed like the other
+// warnings and errors.
+func (s *Server) logMalformed(format string, args ...any) {
+	s.mu.RLock()
+	logging := s.config.Logging
+	s.mu.RUnlock()
+
+	level := strings.ToLower(logging.MalformedRequests)
+	if !logging.Logs(level) {
+		return
+	}
+	switch level {
+	case "warn":
+		format = "WARNING: " + format
+	case "error":
+		format = "ERROR: " + format
+	}
+	log.Printf(format, args...)
+}
+
+// serverErrorLog is the proxy server's ErrorLog. It counts failed TLS
+// handshakes, logged at logging.malformed_requests, and passes other server
+// errors on to the standard logger.
+type serverErrorLog struct {
+	s *Server
+}
+
+func (l serverErrorLog) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+	if strings.Contains(message, "TLS handshake error") {
+		metrics.MalformedRequests.WithLabelValues("tls_handshake").Inc()
+		l.s.logMalformed("%s", message)
+		return len(p), nil
+	}
+	log.Print(message)
+	return len(p), nil
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspRetryInterval = 5 * time.Minute
+	ocspMinRefresh    = time.Minute
+	// ocspIdleRefresh is how often an unset staple is reconsidered when
+	// stapling is disabled or the responder gives no NextUpdate
+	ocspIdleRefresh = time.Hour
+)
+
+var ocspClient = &http.Client{Timeout: 10 * time.Second}
+
+// StartOCSPStapling keeps an OCSP response stapled to the served certificate
+// while tls.ocsp_stapling is enabled, refreshing it halfway through its
+// validity and whenever the certificate is reloaded. When the responder is
+// unreachable the last staple is served until it expires, then handshakes
+// continue without one.
+func (m *TLSManager) StartOCSPStapling(ctx context.Context) {
+	for {
+		wait := m.refreshOCSPStaple(ctx)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-m.certChanged:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshOCSPStaple fetches a fresh staple and returns how long to wait before
+// the next refresh.
+func (m *TLSManager) refreshOCSPStaple(ctx context.Context) time.Duration {
+	m.mu.RLock()
+	cert, cfg, expiry := m.cert, m.config, m.stapleExpiry
+	m.mu.RUnlock()
+
+	if cert == nil || cfg == nil || !cfg.OCSPStapling {
+		return ocspIdleRefresh
+	}
+
+	staple, resp, err := fetchOCSPStaple(ctx, cert)
+	if err != nil {
+		log.Printf("OCSP stapling failed, retrying in %s: %v", ocspRetryInterval, err)
+		if !expiry.IsZero() && time.Now().After(expiry) {
+			log.Printf("OCSP staple expired, serving the certificate without one")
+			m.setStaple(cert, nil, time.Time{})
+		}
+		return ocspRetryInterval
+	}
+
+	m.setStaple(cert, staple, resp.NextUpdate)
+	log.Printf("Stapled OCSP response valid until %s", resp.NextUpdate.Format(time.RFC3339))
+
+	if resp.NextUpdate.IsZero() {
+		return ocspIdleRefresh
+	}
+	refresh := time.Until(resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2))
+	if refresh < ocspMinRefresh {
+		refresh = ocspMinRefresh
+	}
+	return refresh
+}
+
+// setStaple attaches staple to cert unless the certificate has been replaced
+// in the meantime. The certificate is copied since handshakes may hold it.
+func (m *TLSManager) setStaple(cert *tls.Certificate, staple []byte, expiry time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cert != cert {
+		return
+	}
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	m.cert = &stapled
+	m.stapleExpiry = expiry
+}
+
+// fetchOCSPStaple asks the certificate's OCSP responder for its status and
+// returns the raw response when the certificate is good.
+func fetchOCSPStaple(ctx context.Context, cert *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, nil, fmt.Errorf("certificate chain has no issuer certificate")
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("certificate has no OCSP responder")
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing issuer certificate: %w", err)
+	}
+
+	body, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := ocspClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying OCSP responder: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned %d", httpResp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading OCSP response: %w", err)
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing OCSP response: %w", err)
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return raw, resp, nil
+	case ocsp.Revoked:
+		return nil, nil, fmt.Errorf("certificate revoked at %s", resp.RevokedAt.Format(time.RFC3339))
+	default:
+		return nil, nil, fmt.Errorf("OCSP responder doesn't know the certificate")
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newOCSPTestCertificate issues a leaf certificate from a fresh CA, pointing
+// its OCSP responder at a test server answering "good".
+func newOCSPTestCertificate(t *testing.T) (*tls.Certificate, *httptest.Server) {
+	t.Helper()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fluxgate-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "fluxgate-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder.URL},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{leafDER, caDER}, PrivateKey: leafKey}, responder
+}
+
+func TestOCSPStapling(t *testing.T) {
+	cert, responder := newOCSPTestCertificate(t)
+	defer responder.Close()
+
+	m := &TLSManager{
+		config:      &config.TLS{OCSPStapling: true},
+		cert:        cert,
+		certChanged: make(chan struct{}, 1),
+	}
+
+	refresh := m.refreshOCSPStaple(context.Background())
+	if refresh < 20*time.Minute || refresh > 31*time.Minute {
+		t.Errorf("Expected a refresh halfway through validity, got %v", refresh)
+	}
+
+	served, err := m.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("Expected a certificate, got %v", err)
+	}
+	if len(served.OCSPStaple) == 0 {
+		t.Fatal("Expected the served certificate to carry an OCSP staple")
+	}
+
+	// * an unreachable responder keeps the staple until it expires
+	responder.Close()
+	if refresh := m.refreshOCSPStaple(context.Background()); refresh != ocspRetryInterval {
+		t.Errorf("Expected a retry after failure, got %v", refresh)
+	}
+	if served, _ := m.getCertificate(nil); len(served.OCSPStaple) == 0 {
+		t.Error("Expected the staple to be kept while still valid")
+	}
+
+	m.stapleExpiry = time.Now().Add(-time.Second)
+	m.refreshOCSPStaple(context.Background())
+	if served, _ := m.getCertificate(nil); len(served.OCSPStaple) != 0 {
+		t.Error("Expected an expired staple to be dropped")
+	}
+}
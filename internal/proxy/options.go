@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/fluxgate/fluxgate/pkg/router"
+)
+
+// autoOptions reports whether the service answers OPTIONS at the gateway.
+func (s *Server) autoOptions(serviceName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Service(serviceName).AutoOptions
+}
+
+// autoOptionsRoute finds the route for an OPTIONS request that no route lists
+// the method for, when that route's service answers OPTIONS itself.
+func (s *Server) autoOptionsRoute(r *http.Request) *router.Route {
+	if r.Method != http.MethodOptions {
+		return nil
+	}
+	route := s.router.MatchAnyMethod(r)
+	if route == nil || !s.autoOptions(route.ServiceName) {
+		return nil
+	}
+	return route
+}
+
+// serveAutoOptions answers an OPTIONS request with 204 and the route's methods
+// in Allow when its service has auto_options, reporting whether it did.
+func (s *Server) serveAutoOptions(w http.ResponseWriter, r *http.Request, route *router.Route, start time.Time, traceID string) bool {
+	if r.Method != http.MethodOptions || !s.autoOptions(route.ServiceName) {
+		return false
+	}
+
+	w.Header().Set("Allow", allowedMethods(route.Methods))
+	w.WriteHeader(http.StatusNoContent)
+
+	metrics.RequestDuration.WithLabelValues(route.ServiceName, r.Method).Observe(time.Since(start).Seconds())
+	metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, "204").Inc()
+	s.logAccess(route.ServiceName, r, r.URL.Path, http.StatusNoContent, time.Since(start), traceID)
+	return true
+}
+
+// allowedMethods is the Allow header for a route's methods: any method when it
+// lists none, HEAD wherever GET is allowed, and always OPTIONS.
+func allowedMethods(methods []string) string {
+	if len(methods) == 0 {
+		methods = defaultRouteMethods
+	}
+
+	var allow []string
+	seen := make(map[string]bool)
+	add := func(method string) {
+		method = strings.ToUpper(method)
+		if !seen[method] {
+			seen[method] = true
+			allow = append(allow, method)
+		}
+	}
+	for _, method := range methods {
+		add(method)
+		if strings.EqualFold(method, http.MethodGet) {
+			add(http.MethodHead)
+		}
+	}
+	add(http.MethodOptions)
+	return strings.Join(allow, ", ")
+}
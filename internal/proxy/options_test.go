@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestAutoOptions(t *testing.T) {
+	var forwarded atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api":    {AutoOptions: true},
+		"orders": {AutoOptions: true},
+		"legacy": {},
+	}
+	api := backendInstance(t, "api", backend.URL)
+	api.Metadata = map[string]string{"methods": "GET,POST"}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{api})
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{backendInstance(t, "orders", backend.URL)})
+	s.UpdateServiceInstances("legacy", []discovery.ServiceInstance{backendInstance(t, "legacy", backend.URL)})
+
+	tests := []struct {
+		path   string
+		status int
+		allow  string
+	}{
+		// * OPTIONS isn't among the route's methods, the gateway answers anyway
+		{"/api/items", http.StatusNoContent, "GET, HEAD, POST, OPTIONS"},
+		{"/orders/1", http.StatusNoContent, "GET, HEAD, POST, PUT, DELETE, PATCH, OPTIONS"},
+		{"/legacy/items", http.StatusMethodNotAllowed, ""},
+		{"/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, tt.path, nil))
+		if rec.Code != tt.status || rec.Header().Get("Allow") != tt.allow {
+			t.Errorf("OPTIONS %s: expected %d with Allow %q, got %d with %q", tt.path, tt.status, tt.allow, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+	if n := forwarded.Load(); n != 1 {
+		t.Errorf("Expected only the legacy OPTIONS to reach the backend, got %d requests", n)
+	}
+
+	// * other methods are still forwarded
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to reach the backend, got %d", rec.Code)
+	}
+}
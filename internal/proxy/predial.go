@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+)
+
+// preDialCount caps the connections pre-dialed to backend: n, but no more
+// than the backend may have open or the transport keeps idle.
+func preDialCount(n int, backend *loadbalancer.Backend, transport *http.Transport) int {
+	idle := transport.MaxIdleConnsPerHost
+	if idle <= 0 {
+		idle = http.DefaultMaxIdleConnsPerHost
+	}
+	n = min(n, idle)
+	if transport.MaxConnsPerHost > 0 {
+		n = min(n, transport.MaxConnsPerHost)
+	}
+	if backend.MaxConnections > 0 {
+		n = min(n, int(backend.MaxConnections))
+	}
+	return n
+}
+
+// preDial warms a new backend's connection pool with up to n connections,
+// sending concurrent health probes through its transport so the connections
+// stay idle in the pool afterwards. With activate it then puts the backend in
+// rotation, unless no probe got through, leaving that to its health checks.
+func (s *Server) preDial(serviceName string, backend *loadbalancer.Backend, probe HealthProbe, n int, activate bool) {
+	key := backend.URL.String()
+	s.getOrCreateProxy(backend.URL)
+
+	s.mu.RLock()
+	bp, exists := s.reverseProxies[key]
+	timeout := s.config.HealthCheck.Timeout
+	s.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+	n = preDialCount(n, backend, bp.transport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	var reached atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, probe.Method, key+probe.paths()[0], nil)
+			if err != nil {
+				return
+			}
+			resp, err := bp.transport.RoundTrip(req)
+			if err != nil {
+				return
+			}
+			// * draining lets the connection go back to the pool
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			reached.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("Pre-dialed %d/%d connections to backend %s for service %s in %s", reached.Load(), n, key, serviceName, time.Since(start).Round(time.Millisecond))
+	if activate && reached.Load() > 0 {
+		s.healthChecker.MarkHealthy(key)
+	}
+}
@@ -1,18 +1,25 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math/rand"
 	"net"
 	"net/http"
-	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fluxgate/fluxgate/internal/config"
@@ -27,11 +34,43 @@ type Server struct {
 	discovery      *discovery.Service
 	router         *router.Router
 	loadBalancers  map[string]loadbalancer.LoadBalancer
-	reverseProxies map[string]*httputil.ReverseProxy
+	lbAlgorithms   map[string]string
+	reverseProxies map[string]*backendProxy
 	transport      *http.Transport
 	tlsManager     *TLSManager
+	healthChecker  *HealthChecker
+	retryBudget    *retryBudget
+	queues         map[string]*requestQueue
+	instances      map[string][]discovery.ServiceInstance
+	rollouts       map[string]*rollout
+	generations    map[string]string
+	disabled       map[string]bool
+	configManager  *config.Manager
+	stale          *staleCache
+	idempotency    *idempotencyCache
+	coalescer      *coalescer
+	captured       *requestCapture
+	latencies      *latencyTracker
+	handler        http.Handler
+	handlerOnce    sync.Once
 	mu             sync.RWMutex
 	port           int
+	started        time.Time
+	// synced is set once discovery's cluster state reached the load balancers
+	synced atomic.Bool
+	// readinessStatus is the status /api/v1/ready last evaluated to
+	readinessStatus atomic.Value
+	// remoteWeights holds weights set through the API for instances another
+	// node owns, by service and backend host, since only the owner gossips them
+	remoteWeights map[string]map[string]remoteWeight
+}
+
+// remoteWeight is a weight set on an instance another node owns, along with
+// the weight its owner gossiped at the time, so a later change by the owner
+// takes precedence again.
+type remoteWeight struct {
+	weight int
+	seen   string
 }
 
 var reservedServiceNames = map[string]bool{
@@ -42,114 +81,500 @@ var reservedServiceNames = map[string]bool{
 	"v1":        true,
 }
 
-func isReservedServiceName(name string) bool {
-	return reservedServiceNames[name] || strings.HasPrefix(name, "_")
+var reservedServicePrefixes = []string{"_"}
+
+// reservedConflict describes the reserved name or prefix a service name
+// collides with.
+type reservedConflict struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// reservedServiceName returns what a service name collides with, checking the
+// built-in names and prefixes, then cluster.reserved_names and
+// cluster.reserved_prefixes.
+func (s *Server) reservedServiceName(name string) (reservedConflict, bool) {
+	s.mu.RLock()
+	cluster := s.config.Cluster
+	s.mu.RUnlock()
+
+	if reservedServiceNames[name] || slices.Contains(cluster.ReservedNames, name) {
+		return reservedConflict{Type: "name", Value: name}, true
+	}
+	for _, prefixes := range [][]string{reservedServicePrefixes, cluster.ReservedPrefixes} {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return reservedConflict{Type: "prefix", Value: prefix}, true
+			}
+		}
+	}
+	return reservedConflict{}, false
+}
+
+// rejectRegistration logs and counts a registration refused for reason, so a
+// client registering incorrectly shows up without reading every log line.
+func rejectRegistration(r *http.Request, instance discovery.ServiceInstance, reason string) {
+	metrics.RegistrationRejected.WithLabelValues(reason).Inc()
+	log.Printf("Registration rejected (%s) from %s: service %q id %q address %q port %d",
+		reason, r.RemoteAddr, instance.Service, instance.ID, instance.Address, instance.Port)
+}
+
+// validAddress reports whether address is an IP or a hostname, rejecting
+// URLs, host:port pairs and other strings that can't be dialed as a host.
+// IPv6 literals may be bracketed and carry a zone, as backendHost accepts.
+func validAddress(address string) bool {
+	literal := address
+	if strings.HasPrefix(literal, "[") && strings.HasSuffix(literal, "]") {
+		literal = literal[1 : len(literal)-1]
+	}
+	if _, err := netip.ParseAddr(literal); err == nil {
+		return true
+	}
+	if len(address) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(address, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var defaultRouteMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+
+// routeMethods collects the methods declared by instances in metadata["methods"]
+// (comma-separated), falling back to defaultRouteMethods when none declare any.
+func routeMethods(instances []discovery.ServiceInstance) []string {
+	seen := make(map[string]bool)
+	methods := make([]string, 0)
+	for _, instance := range instances {
+		for _, m := range strings.Split(instance.Metadata["methods"], ",") {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			if m != "" && !seen[m] {
+				seen[m] = true
+				methods = append(methods, m)
+			}
+		}
+	}
+
+	if len(methods) == 0 {
+		return defaultRouteMethods
+	}
+	return methods
+}
+
+// routeMediaTypes collects the media types instances declare in metadata[key]
+// (comma-separated), "content_types" or "accept".
+func routeMediaTypes(instances []discovery.ServiceInstance, key string) []string {
+	seen := make(map[string]bool)
+	var mediaTypes []string
+	for _, instance := range instances {
+		for _, mediaType := range strings.Split(instance.Metadata[key], ",") {
+			mediaType = strings.TrimSpace(mediaType)
+			if mediaType != "" && !seen[mediaType] {
+				seen[mediaType] = true
+				mediaTypes = append(mediaTypes, mediaType)
+			}
+		}
+	}
+	return mediaTypes
+}
+
+// routePaths returns the route paths of a service: /{service}/* plus any
+// aliases instances declare in metadata["prefixes"] (comma-separated).
+func routePaths(serviceName string, instances []discovery.ServiceInstance) []string {
+	paths := []string{"/" + serviceName + "/*"}
+	seen := map[string]bool{paths[0]: true}
+	for _, instance := range instances {
+		for _, prefix := range strings.Split(instance.Metadata["prefixes"], ",") {
+			prefix = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(prefix), "*"), "/")
+			if prefix == "" || !strings.HasPrefix(prefix, "/") {
+				continue
+			}
+			// * keep aliases out of the management API and internal paths
+			if prefix == "/api" || strings.HasPrefix(prefix, "/api/") || strings.HasPrefix(prefix, "/_") {
+				log.Printf("Ignoring reserved path prefix %s for service %s", prefix, serviceName)
+				continue
+			}
+			if path := prefix + "/*"; !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
 }
 
-func New(cfg *config.Config, discovery *discovery.Service, port int) (*Server, error) {
+func New(cfg *config.Config, disc *discovery.Service, port int) (*Server, error) {
 	tlsManager, err := NewTLSManager(cfg.TLS)
 	if err != nil {
 		return nil, fmt.Errorf("creating TLS manager: %w", err)
 	}
 
+	healthChecker := NewHealthChecker(cfg.HealthCheck.Interval, cfg.HealthCheck.Timeout)
+	healthChecker.SetJitter(cfg.HealthCheck.Jitter, cfg.HealthCheck.JitterInitial)
+	healthChecker.SetLatencyShedding(cfg.HealthCheck.LatencyThreshold, cfg.HealthCheck.MinWeightFactor)
+
 	s := &Server{
 		config:         cfg,
-		discovery:      discovery,
+		discovery:      disc,
 		router:         router.New(),
 		loadBalancers:  make(map[string]loadbalancer.LoadBalancer),
-		reverseProxies: make(map[string]*httputil.ReverseProxy),
+		lbAlgorithms:   make(map[string]string),
+		reverseProxies: make(map[string]*backendProxy),
+		queues:         make(map[string]*requestQueue),
+		instances:      make(map[string][]discovery.ServiceInstance),
+		rollouts:       make(map[string]*rollout),
+		generations:    make(map[string]string),
+		disabled:       make(map[string]bool),
+		remoteWeights:  make(map[string]map[string]remoteWeight),
+		stale:          newStaleCache(),
+		idempotency:    newIdempotencyCache(),
+		coalescer:      newCoalescer(),
+		captured:       newRequestCapture(),
+		latencies:      newLatencyTracker(),
 		port:           port,
 		tlsManager:     tlsManager,
-		transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			DisableCompression:  true,
-			DialContext: (&net.Dialer{
-				Timeout:   cfg.Timeouts.Read,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-		},
+		healthChecker:  healthChecker,
+		retryBudget:    &retryBudget{},
+		started:        time.Now(),
+		transport:      newBaseTransport(cfg),
 	}
+	healthChecker.SetTransport(s.healthTransport)
+	// * without discovery the embedder supplies backends, there is nothing to sync
+	s.synced.Store(disc == nil)
 
 	return s, nil
 }
 
-func (s *Server) Start(ctx context.Context) error {
-	s.subscribeToServiceChanges()
+// Handler returns the proxy pipeline (routing, load balancing and the management
+// API) as an http.Handler, so it can be mounted on an externally owned server.
+// When the server was created without a discovery service the service management
+// endpoints are not mounted and backends are fed through UpdateServiceInstances.
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		if s.discovery != nil {
+			s.subscribeToServiceChanges()
+		}
+		s.mu.Lock()
+		s.syncABTestRoutes(nil, s.config.ABTests)
+		s.mu.Unlock()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.handleRequest)
+
+		// Management API
+		mux.HandleFunc("/api/v1/health", s.handleHealthCheck)
+		mux.HandleFunc("/api/v1/ready", s.handleReady)
+		mux.HandleFunc("/api/v1/routes", s.handleRouteList)
+		mux.HandleFunc("/api/v1/routes/match", s.handleRouteMatch)
+		mux.HandleFunc("/api/v1/stats", s.handleStats)
+		mux.HandleFunc("/api/v1/backends/weight", s.handleBackendWeight)
+		mux.HandleFunc("/api/v1/backends/breaker", s.handleBackendBreaker)
+		mux.HandleFunc("/api/v1/rollouts", s.handleRollouts)
+		mux.HandleFunc("/api/v1/debug/lastrequest", s.handleDebugLastRequest)
+		mux.HandleFunc("/api/v1/services/", s.handleServiceToggle)
+		mux.HandleFunc("/api/v1/config/dryrun", s.handleConfigDryRun)
+
+		if s.discovery != nil {
+			mux.HandleFunc("/api/v1/cluster", s.handleCluster)
+			mux.HandleFunc("/api/v1/services", s.handleServiceList)
+			mux.HandleFunc("/api/v1/services/register", s.handleServiceRegistration)
+			mux.HandleFunc("/api/v1/services/deregister", s.handleServiceDeregistration)
+		}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.handleRequest)
+		s.handler = s.withForwardProxy(mux)
+	})
 
-	// Management API
-	mux.HandleFunc("/api/v1/health", s.handleHealthCheck)
-	mux.HandleFunc("/api/v1/services", s.handleServiceList)
-	mux.HandleFunc("/api/v1/services/register", s.handleServiceRegistration)
-	mux.HandleFunc("/api/v1/services/deregister", s.handleServiceDeregistration)
+	return s.handler
+}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      mux,
-		ReadTimeout:  s.config.Timeouts.Read,
-		WriteTimeout: s.config.Timeouts.Write,
-		IdleTimeout:  s.config.Timeouts.Idle,
-		TLSConfig:    s.tlsManager.GetTLSConfig(),
+// UpdateServiceInstances replaces the backends of a service, creating its route
+// on first use. It is what discovery updates go through.
+func (s *Server) UpdateServiceInstances(serviceName string, instances []discovery.ServiceInstance) {
+	s.updateLoadBalancerBackends(serviceName, instances)
+}
+
+// StartHealthChecks probes backends until ctx is cancelled.
+func (s *Server) StartHealthChecks(ctx context.Context) {
+	s.mu.RLock()
+	enabled := s.config.HealthCheck.Enabled
+	s.mu.RUnlock()
+
+	if !enabled {
+		log.Printf("Active health checks disabled (health_check.enabled), backends stay in rotation until they fail requests")
+		return
 	}
+	s.healthChecker.Start(ctx)
+}
 
-	s.tlsManager.Subscribe(func(tlsConfig *tls.Config) {
-		srv.TLSConfig = tlsConfig
-	})
+// StartBackground runs the server's background loops (health checks, idle
+// connection flushing, OCSP stapling, rollouts, readiness and latency SLOs) in
+// their own goroutines until ctx is cancelled. Start calls it; embedders
+// serving Handler on their own server call it instead.
+func (s *Server) StartBackground(ctx context.Context) {
+	go s.StartHealthChecks(ctx)
+	go s.StartIdleFlush(ctx)
+	go s.tlsManager.StartOCSPStapling(ctx)
+	go s.StartRollouts(ctx)
+	go s.StartReadinessChecks(ctx)
+	go s.StartLatencySLO(ctx)
+}
 
-	go func() {
-		<-ctx.Done()
+func (s *Server) Start(ctx context.Context) error {
+	s.StartBackground(ctx)
+
+	// * bind every listener before serving so a taken port is reported as such
+	var servers []*http.Server
+	var listeners []net.Listener
+	for _, lc := range s.listenerConfigs() {
+		srv := &http.Server{
+			Addr:         fmt.Sprintf(":%d", lc.Port),
+			Handler:      s.Handler(),
+			ReadTimeout:  s.config.Timeouts.Read,
+			WriteTimeout: s.config.Timeouts.Write,
+			IdleTimeout:  s.config.Timeouts.Idle,
+			ConnState:    trackConnState,
+			ErrorLog:     log.New(serverErrorLog{s: s}, "", 0),
+		}
+		if lc.TLS {
+			// * reloads only concern the listeners serving TLS
+			srv.TLSConfig = s.tlsManager.GetTLSConfig()
+			s.tlsManager.Subscribe(func(tlsConfig *tls.Config) {
+				srv.TLSConfig = tlsConfig
+			})
+		}
+
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, bound := range listeners {
+				bound.Close()
+			}
+			return fmt.Errorf("binding proxy port %d: %w", lc.Port, err)
+		}
+		servers = append(servers, srv)
+		listeners = append(listeners, &countingListener{Listener: ln, rejected: s.rejectedRequest})
+	}
+
+	shutdown := func() {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		srv.Shutdown(shutdownCtx)
+		for _, srv := range servers {
+			srv.Shutdown(shutdownCtx)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown()
 	}()
 
-	if s.tlsManager.IsEnabled() {
-		log.Printf("Starting HTTPS proxy server on port %d", s.port)
-		return srv.ListenAndServeTLS("", "")
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(srv *http.Server, ln net.Listener) {
+			if srv.TLSConfig != nil {
+				log.Printf("Starting HTTPS proxy server on %s", srv.Addr)
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			log.Printf("Starting HTTP proxy server on %s", srv.Addr)
+			errs <- srv.Serve(ln)
+		}(srv, listeners[i])
 	}
 
-	log.Printf("Starting HTTP proxy server on port %d", s.port)
-	return srv.ListenAndServe()
+	// * one listener failing takes the others down with it
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		shutdown()
+	}
+	return err
 }
 
+// listenerConfigs returns the proxy listeners: server.listeners, or the one
+// port the server was created with, serving TLS when a certificate is loaded.
+func (s *Server) listenerConfigs() []config.ListenerConfig {
+	s.mu.RLock()
+	listeners := s.config.Server.Listeners
+	s.mu.RUnlock()
+
+	if len(listeners) > 0 {
+		return listeners
+	}
+	return []config.ListenerConfig{{Port: s.port, TLS: s.tlsManager.IsEnabled()}}
+}
+
+// routeMissLabels returns why no route matched r, "method_not_allowed" when a
+// route only lacks its method and "no_match" otherwise, and its host. Hosts
+// not listed in metrics.hosts are "other", keeping the series bounded.
+func (s *Server) routeMissLabels(r *http.Request) (string, string) {
+	reason := "no_match"
+	if s.router.MatchAnyMethod(r) != nil {
+		reason = "method_not_allowed"
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, configured := range s.config.Metrics.Hosts {
+		if strings.EqualFold(host, configured) {
+			return reason, strings.ToLower(configured)
+		}
+	}
+	return reason, "other"
+}
+
+// emptyLoadBalancer stands in for services that are routed but have no load
+// balancer, so their requests get the no-healthy-backends handling.
+var emptyLoadBalancer = loadbalancer.NewRoundRobin()
+
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	requestPath := r.URL.Path
+	traceID := s.propagateRequestID(w, r)
+
+	if s.rejectUntilReady(w) {
+		metrics.RequestsTotal.WithLabelValues("unknown", r.Method, "503").Inc()
+		return
+	}
+
+	if s.countHop(r) {
+		log.Printf("Loop detected: %s %s passed through %s times", r.Method, r.URL.Path, r.Header.Get(hopsHeader))
+		metrics.RequestsTotal.WithLabelValues("unknown", r.Method, "508").Inc()
+		http.Error(w, "Loop detected", http.StatusLoopDetected)
+		return
+	}
 
 	route := s.router.Match(r)
+	if route == nil {
+		route = s.autoOptionsRoute(r)
+	}
 	if route == nil {
 		metrics.RequestsTotal.WithLabelValues("unknown", r.Method, "404").Inc()
+		metrics.NoRoute.WithLabelValues(s.routeMissLabels(r)).Inc()
 		http.Error(w, "No route found", http.StatusNotFound)
 		return
 	}
 
+	if s.isDisabled(route.ServiceName) {
+		s.serveDisabled(w, r, route.ServiceName, start, traceID)
+		return
+	}
+
+	if s.serveAutoOptions(w, r, route, start, traceID) {
+		return
+	}
+
+	serviceName := route.ServiceName
+	if variant, ok := s.resolveABTest(w, r, route.ServiceName); ok {
+		serviceName = variant
+	}
+
+	if s.redirectTrailingSlash(w, r, serviceName) {
+		return
+	}
+
+	if static, ok := s.staticFor(serviceName); ok {
+		s.serveStatic(w, r, serviceName, static, start, traceID)
+		return
+	}
+
+	if status, message := s.decodeRequestBody(r, serviceName); status != 0 {
+		metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(status)).Inc()
+		http.Error(w, message, status)
+		return
+	}
+
+	if entry, first := s.beginIdempotent(r, serviceName); entry != nil {
+		if !first {
+			s.replayIdempotent(w, r, serviceName, entry, start, traceID)
+			return
+		}
+		w = &replayRecorder{ResponseWriter: w, response: &entry.recordedResponse, limit: idempotencyBodyLimit}
+		defer s.idempotency.complete(entry)
+	}
+
+	if entry, key, first := s.beginCoalesced(r, serviceName); entry != nil {
+		if !first {
+			if s.replayCoalesced(w, r, serviceName, entry, start, traceID) {
+				return
+			}
+		} else {
+			w = &replayRecorder{ResponseWriter: w, response: &entry.recordedResponse, limit: staleBodyLimit}
+			defer s.coalescer.complete(key, entry)
+		}
+	}
+
 	s.mu.RLock()
-	lb, exists := s.loadBalancers[route.ServiceName]
+	lb, exists := s.loadBalancers[serviceName]
+	if ro := s.rollouts[serviceName]; ro != nil && rand.Float64() < ro.share(time.Now()) {
+		lb = ro.lb
+	}
 	s.mu.RUnlock()
 
 	if !exists {
-		metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, "500").Inc()
-		http.Error(w, "Service not configured", http.StatusInternalServerError)
+		// * routed to a service with no backends yet, e.g. an A/B bucket whose
+		// * service hasn't registered or a test removed mid-request
+		log.Printf("No load balancer for service %s, answering as unavailable", serviceName)
+		lb = emptyLoadBalancer
+	}
+
+	affinityKey, reject := s.affinityKey(r, serviceName)
+	if reject {
+		metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, "400").Inc()
+		http.Error(w, "Missing affinity header", http.StatusBadRequest)
 		return
 	}
 
-	backend := lb.Next()
+	var backend *loadbalancer.Backend
+	if affinityKey != "" {
+		backend = loadbalancer.NextForKey(lb, affinityKey)
+	} else {
+		backend = lb.Next()
+	}
+	var queueErr error
+	if backend == nil {
+		backend, queueErr = s.waitForBackend(r, serviceName, lb)
+	}
+	if backend == nil && queueErr == nil {
+		metrics.NoBackend.WithLabelValues(serviceName).Inc()
+		if fallback, fallbackLB, fallbackBackend := s.fallbackFor(serviceName); fallbackBackend != nil {
+			serviceName, lb, backend = fallback, fallbackLB, fallbackBackend
+		} else if s.serveUnavailable(w, r, serviceName, start, traceID) {
+			return
+		}
+	}
 	if backend == nil {
-		metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, "503").Inc()
-		http.Error(w, "No healthy backends", http.StatusServiceUnavailable)
+		metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, "503").Inc()
+		switch queueErr {
+		case errQueueFull:
+			http.Error(w, "Request queue full", http.StatusServiceUnavailable)
+		case errQueueTimeout:
+			http.Error(w, "Timed out waiting for a backend", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "No healthy backends", http.StatusServiceUnavailable)
+		}
 		return
 	}
+	defer s.releaseBackend(serviceName, lb, backend)
+	s.countTenantAdmission(r, serviceName)
 
 	metrics.ActiveConnections.WithLabelValues(backend.URL.String()).Inc()
 	defer metrics.ActiveConnections.WithLabelValues(backend.URL.String()).Dec()
 
-	// strip the service prefix from the path before forwarding
+	cacheKey := staleKey(serviceName, r)
+
+	// strip the matched route prefix from the path before forwarding
 	originalPath := r.URL.Path
-	servicePath := "/" + route.ServiceName
-	if strings.HasPrefix(originalPath, servicePath) {
+	servicePath := route.MatchedPrefix(originalPath)
+	if servicePath != "/" && strings.HasPrefix(originalPath, servicePath) {
 		strippedPath := strings.TrimPrefix(originalPath, servicePath)
 		if strippedPath == "" {
 			strippedPath = "/"
@@ -157,57 +582,308 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strippedPath
 		log.Printf("Path rewrite: %s -> %s for service %s", originalPath, strippedPath, route.ServiceName)
 	}
+	s.mu.RLock()
+	trailingSlash := s.config.Service(serviceName).TrailingSlash
+	s.mu.RUnlock()
+	r.URL.Path = slashPath(r.URL.Path, trailingSlash)
 
-	if isWebSocketRequest(r) {
-		if err := s.handleWebSocket(w, r, backend.URL.String()); err != nil {
-			log.Printf("WebSocket proxy error: %v", err)
-			metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, "502").Inc()
-		} else {
-			metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, "101").Inc()
+	s.setParamHeaders(r, serviceName, route.Params)
+
+	r = s.withRequestInfo(r, serviceName, servicePath)
+	requestInfoFrom(r.Context()).staleKey = cacheKey
+
+	if isUpgradeRequest(r) {
+		status, err := s.handleUpgrade(w, r, backend.URL)
+		if err != nil {
+			log.Printf("Upgrade to %s proxy error: %v", r.Header.Get("Upgrade"), err)
+			if status == 0 {
+				status = http.StatusBadGateway
+				http.Error(w, "Bad gateway", status)
+			}
 		}
+		metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(status)).Inc()
+		s.logAccess(serviceName, r, requestPath, status, time.Since(start), traceID)
 		return
 	}
 
-	proxy := s.getOrCreateProxy(backend.URL)
+	if timeout := s.requestTimeout(serviceName); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 
 	wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-	proxy.ServeHTTP(wrappedWriter, r)
+	backend = s.serveWithRetries(wrappedWriter, r, serviceName, lb, backend)
 
 	duration := time.Since(start).Seconds()
-	metrics.RequestDuration.WithLabelValues(route.ServiceName, r.Method).Observe(duration)
-	metrics.RequestsTotal.WithLabelValues(route.ServiceName, r.Method, fmt.Sprintf("%d", wrappedWriter.statusCode)).Inc()
-}
+	metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(duration)
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, fmt.Sprintf("%d", wrappedWriter.statusCode)).Inc()
 
-func (s *Server) getOrCreateProxy(target *url.URL) *httputil.ReverseProxy {
-	key := target.String()
+	s.logAccess(serviceName, r, requestPath, wrappedWriter.statusCode, time.Since(start), traceID)
+	s.logSlowRequest(serviceName, r, requestPath, backend, wrappedWriter.statusCode, time.Since(start), traceID)
+}
 
+// decodeRequestBody decompresses the request body for services with
+// decode_requests set, returning the status and message to answer with when
+// that fails.
+func (s *Server) decodeRequestBody(r *http.Request, serviceName string) (int, string) {
 	s.mu.RLock()
-	proxy, exists := s.reverseProxies[key]
+	serviceCfg := s.config.Service(serviceName)
 	s.mu.RUnlock()
 
-	if exists {
-		return proxy
+	if !serviceCfg.DecodeRequests {
+		return 0, ""
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	err := decodeGzipRequest(r, serviceCfg.MaxDecodedBody)
+	switch {
+	case err == nil:
+		return 0, ""
+	case errors.Is(err, errDecodedBodyTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Decoded request body exceeds %d bytes", serviceCfg.MaxDecodedBody)
+	default:
+		return http.StatusBadRequest, "Invalid gzip request body"
+	}
+}
 
-	proxy = httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = s.transport
-	proxy.ErrorHandler = s.proxyErrorHandler
-	proxy.ModifyResponse = s.modifyResponse
-	s.reverseProxies[key] = proxy
+// requestTimeout returns the total deadline for a service's requests, 0 when
+// none applies.
+func (s *Server) requestTimeout(serviceName string) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return proxy
+	serviceCfg := s.config.Service(serviceName)
+	switch {
+	case serviceCfg.Streaming:
+		return 0
+	case serviceCfg.RequestTimeout > 0:
+		return serviceCfg.RequestTimeout
+	default:
+		return s.config.Timeouts.Request
+	}
 }
 
+// StatusClientClosedRequest is recorded when the client goes away before the
+// backend responds; the upstream request is cancelled through its context.
+const StatusClientClosedRequest = 499
+
 func (s *Server) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	log.Printf("Proxy error: %v", err)
+	// * takeStatusRetry already claimed the retry, nothing was written
+	if errors.Is(err, errRetryStatus) {
+		return
+	}
+
+	if errors.Is(context.Cause(r.Context()), errRetryTimeout) {
+		log.Printf("Retry timeout exceeded: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	if errors.Is(context.Cause(r.Context()), errTryTimeout) {
+		metrics.BackendErrors.WithLabelValues(r.URL.Host, "try_timeout").Inc()
+		log.Printf("Try timeout exceeded: %s %s on %s", r.Method, r.URL.Path, r.URL.Host)
+		if s.takeRetry(r, "try_timeout", err) {
+			return
+		}
+		http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		log.Printf("Client closed request: %s %s", r.Method, r.URL.Path)
+		if rw, ok := w.(*responseWriter); ok {
+			rw.statusCode = StatusClientClosedRequest
+		}
+		return
+	}
+
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		log.Printf("Request deadline exceeded: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "Request deadline exceeded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if problem, ok := tlsVerificationProblem(err); ok {
+		s.handleBackendTLSError(r, problem, err)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+
+	reason := classifyProxyError(err)
+	metrics.BackendErrors.WithLabelValues(r.URL.Host, reason).Inc()
+	log.Printf("Proxy error (%s): %v", reason, err)
+
+	if s.takeRetry(r, reason, err) {
+		return
+	}
+
+	if reason == "connect_timeout" || reason == "response_timeout" {
+		http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
 	http.Error(w, "Bad gateway", http.StatusBadGateway)
 }
 
+var errResponseHeaderLimit = errors.New("backend response headers exceed limit")
+
+// checkResponseHeaders enforces the limits on the number of backend response
+// header fields and their total size, counted as on the wire. A limit of 0
+// disables that check.
+func checkResponseHeaders(header http.Header, maxHeaders int, maxBytes int64) error {
+	count, size := 0, int64(0)
+	for name, values := range header {
+		count += len(values)
+		for _, value := range values {
+			// * "Name: value\r\n"
+			size += int64(len(name) + len(value) + 4)
+		}
+	}
+
+	if maxHeaders > 0 && count > maxHeaders {
+		return fmt.Errorf("%w: %d fields, limit %d", errResponseHeaderLimit, count, maxHeaders)
+	}
+	if maxBytes > 0 && size > maxBytes {
+		return fmt.Errorf("%w: %d bytes, limit %d", errResponseHeaderLimit, size, maxBytes)
+	}
+	return nil
+}
+
+// classifyProxyError tells "can't reach" apart from "slow to respond".
+func classifyProxyError(err error) string {
+	if errors.Is(err, errResponseHeaderLimit) || strings.Contains(err.Error(), "response headers exceeded") {
+		return "response_header_limit"
+	}
+	if errors.Is(err, errUpstreamTruncated) {
+		return "truncated"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if opErr.Timeout() {
+			return "connect_timeout"
+		}
+		return "connect_error"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "response_timeout"
+	}
+
+	return "other"
+}
+
+// tlsVerificationProblem reports whether err is a backend certificate that
+// failed verification, and why.
+func tlsVerificationProblem(err error) (string, bool) {
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		if invalidErr.Reason == x509.Expired {
+			return "expired", true
+		}
+		return "invalid", true
+	}
+
+	var authorityErr x509.UnknownAuthorityError
+	if errors.As(err, &authorityErr) {
+		return "unknown_authority", true
+	}
+
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return "hostname_mismatch", true
+	}
+
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return "invalid", true
+	}
+
+	return "", false
+}
+
+func (s *Server) handleBackendTLSError(r *http.Request, problem string, err error) {
+	backend := r.URL.Scheme + "://" + r.URL.Host
+	metrics.BackendTLSErrors.WithLabelValues(backend, problem).Inc()
+	log.Printf("TLS verification failed for backend %s (%s): %v", backend, problem, err)
+
+	s.mu.RLock()
+	markUnhealthy := s.config.HealthCheck.UnhealthyOnTLSError
+	s.mu.RUnlock()
+
+	// * the health check fails the same way, so it stays out until the cert is fixed
+	if markUnhealthy {
+		s.healthChecker.MarkUnhealthy(backend)
+	}
+}
+
 func (s *Server) modifyResponse(resp *http.Response) error {
+	// * headers arrived, try_timeout no longer applies to the body
+	if state := retryStateFrom(resp.Request.Context()); state != nil && state.tryTimer != nil {
+		state.tryTimer.Stop()
+	}
+
+	s.mu.RLock()
+	maxHeaders, maxHeaderBytes := s.config.Transport.MaxResponseHeaders, s.config.Transport.MaxResponseHeaderBytes
+	s.mu.RUnlock()
+
+	if err := checkResponseHeaders(resp.Header, maxHeaders, maxHeaderBytes); err != nil {
+		return err
+	}
+
+	if s.takeStatusRetry(resp) {
+		return errRetryStatus
+	}
+
+	if err := s.guardResponseBody(resp); err != nil {
+		return err
+	}
+
 	resp.Header.Add("X-Proxy", "FluxGate")
+
+	info := requestInfoFrom(resp.Request.Context())
+	if info == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	serviceCfg := s.config.Service(info.service)
+	s.mu.RUnlock()
+
+	if location := resp.Header.Get("Location"); location != "" && serviceCfg.RewriteLocation {
+		resp.Header.Set("Location", rewriteLocation(location, resp.Request.URL.Host, info))
+	}
+
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 && serviceCfg.RewriteCookies {
+		resp.Header.Del("Set-Cookie")
+		for _, cookie := range cookies {
+			resp.Header.Add("Set-Cookie", rewriteSetCookie(cookie, info, serviceCfg.CookieDomain))
+		}
+	}
+
+	if serviceCfg.LoadHeader != "" {
+		s.recordBackendLoad(info.service, resp, serviceCfg.LoadHeader)
+	}
+
+	if serviceCfg.OnUnavailable.Action == "stale" && info.staleKey != "" {
+		s.recordStale(resp, info.staleKey)
+	}
+
+	// * some backends gzip regardless of Accept-Encoding
+	if serviceCfg.DecodeResponses && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") &&
+		!acceptsGzip(resp.Request.Header.Get("Accept-Encoding")) {
+		if err := decodeGzipResponse(resp); err != nil {
+			return fmt.Errorf("decoding gzip response: %w", err)
+		}
+	}
+
+	if compression := serviceCfg.Compression; compression != nil &&
+		shouldCompress(resp, compression, resp.Request.Header.Get("Accept-Encoding")) {
+		if err := compressResponse(resp, compression.Level); err != nil {
+			return fmt.Errorf("compressing response: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -215,16 +891,28 @@ func (s *Server) UpdateConfig(cfg *config.Config) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.syncABTestRoutes(s.config.ABTests, cfg.ABTests)
+	previous := s.config
 	s.config = cfg
 
+	for serviceName, lb := range s.loadBalancers {
+		if s.lbAlgorithms[serviceName] != s.algorithmFor(serviceName) {
+			lb = s.switchLoadBalancer(serviceName, lb)
+		}
+		if previous.Transport.Scheme != cfg.Transport.Scheme || !maps.Equal(previous.Service(serviceName).Weights, cfg.Service(serviceName).Weights) {
+			s.reconcileService(serviceName, lb, s.instances[serviceName])
+		}
+	}
+
 	if err := s.tlsManager.UpdateConfig(cfg.TLS); err != nil {
 		log.Printf("Failed to update TLS configuration: %v", err)
 	}
 
-	s.transport.DialContext = (&net.Dialer{
-		Timeout:   cfg.Timeouts.Read,
-		KeepAlive: 30 * time.Second,
-	}).DialContext
+	// * rebuilding drops every pooled backend connection, only do it when needed
+	if transportChanged(previous, cfg) {
+		s.transport = newBaseTransport(cfg)
+		s.dropAllProxies()
+	}
 
 	metrics.ConfigReloads.Inc()
 	log.Printf("Server configuration reloaded successfully")
@@ -232,16 +920,56 @@ func (s *Server) UpdateConfig(cfg *config.Config) error {
 	return nil
 }
 
+// responseWriter records the status sent to the client. It passes Flush and
+// Hijack through so streaming and upgrades work behind it.
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
+	// * interim 1xx responses may precede the final status
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil && !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (s *Server) GetLoadBalancer(serviceName string) loadbalancer.LoadBalancer {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -261,55 +989,328 @@ func (s *Server) subscribeToServiceChanges() {
 			totalInstances += len(instances)
 		}
 		metrics.GossipNodes.Set(float64(totalInstances))
+
+		if s.discovery.Synced() {
+			s.synced.Store(true)
+		}
 	})
+
+	// * state merged while joining predates the subscription
+	if s.discovery.Synced() {
+		for serviceName, instances := range s.discovery.GetAllServices() {
+			s.updateLoadBalancerBackends(serviceName, instances)
+		}
+		s.synced.Store(true)
+	}
 }
 
 func (s *Server) updateLoadBalancerBackends(serviceName string, instances []discovery.ServiceInstance) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	methods := routeMethods(instances)
+	paths := routePaths(serviceName, instances)
+
 	lb, exists := s.loadBalancers[serviceName]
+	if budget := s.routeBudget(serviceName); budget >= 0 && len(paths) > budget {
+		metrics.LimitRejections.WithLabelValues("routes").Inc()
+		log.Printf("Route limit of %d reached, service %s gets %d of its %d routes", s.config.Limits.MaxRoutes, serviceName, budget, len(paths))
+		paths = paths[:budget]
+		if len(paths) == 0 && !exists {
+			return
+		}
+	}
 	if !exists {
 		log.Printf("Creating new load balancer for discovered service: %s", serviceName)
-		lb = loadbalancer.NewRoundRobin()
+		lb = s.newLoadBalancer(serviceName)
 		s.loadBalancers[serviceName] = lb
-
-		s.router.AddRoute("/"+serviceName+"/*", serviceName, []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"})
-		log.Printf("Added dynamic route for service: %s -> /%s/*", serviceName, serviceName)
+		log.Printf("Added dynamic route for service: %s -> %v %v", serviceName, paths, methods)
+	} else if s.lbAlgorithms[serviceName] != s.algorithmFor(serviceName) {
+		lb = s.switchLoadBalancer(serviceName, lb)
 	}
+	// * routes are published after the load balancer, both under s.mu, so a
+	// * matched route always finds it
+	s.router.SetMediaTypes(serviceName, routeMediaTypes(instances, "content_types"), routeMediaTypes(instances, "accept"))
+	s.router.SetPaths(serviceName, paths, methods)
+	s.instances[serviceName] = instances
+
+	active := s.reconcileService(serviceName, lb, instances)
+	log.Printf("Updated load balancer for service %s with %d instances", serviceName, active)
+}
 
-	var newLB loadbalancer.LoadBalancer
-	switch lb.(type) {
-	case *loadbalancer.LeastConnection:
-		newLB = loadbalancer.NewLeastConnection()
-	default:
-		newLB = loadbalancer.NewRoundRobin()
+// reconcileService makes the backends of lb, and of the service's rollout if
+// one is in progress, match instances. It returns how many instances lb
+// serves. The caller must hold s.mu.
+func (s *Server) reconcileService(serviceName string, lb loadbalancer.LoadBalancer, instances []discovery.ServiceInstance) int {
+	if generation, pinned := s.generations[serviceName]; pinned {
+		var excluded []discovery.ServiceInstance
+		excluded, instances = partitionGeneration(instances, generation)
+		for _, instance := range excluded {
+			log.Printf("Excluding instance %s of service %s: generation %q, service is pinned to %q since its rollout",
+				instance.ID, serviceName, instance.Metadata["generation"], generation)
+		}
 	}
+	if ro := s.rollouts[serviceName]; ro != nil {
+		var next []discovery.ServiceInstance
+		instances, next = partitionGeneration(instances, ro.Generation)
+		s.reconcileBackends(serviceName, ro.lb, next, true)
+	}
+	s.reconcileBackends(serviceName, lb, instances, false)
+	return len(instances)
+}
 
+// reconcileBackends makes the backends of lb match instances. With probeFirst,
+// new backends only join the rotation once a health probe passes. The caller
+// must hold s.mu.
+func (s *Server) reconcileBackends(serviceName string, lb loadbalancer.LoadBalancer, instances []discovery.ServiceInstance, probeFirst bool) {
+	desired := make(map[string]*loadbalancer.Backend, len(instances))
+	probes := make(map[string]HealthProbe, len(instances))
+	remote := make(map[string]bool)
 	for _, instance := range instances {
-		backendURL := fmt.Sprintf("http://%s:%d", instance.Address, instance.Port)
-		parsedURL, err := url.Parse(backendURL)
+		backend, err := s.backendFromInstance(instance)
 		if err != nil {
-			log.Printf("Invalid backend URL for service %s: %s", serviceName, backendURL)
+			log.Printf("Invalid backend for service %s: %v", serviceName, err)
 			continue
 		}
+		desired[backend.URL.String()] = backend
+		probes[backend.URL.String()] = s.healthProbe(instance)
+		remote[backend.URL.String()] = s.discovery != nil && !s.discovery.IsLocal(instance.ID)
+	}
 
-		weight := 1 // * Default weight
-		if w, exists := instance.Metadata["weight"]; exists {
-			if parsedWeight, err := strconv.Atoi(w); err == nil {
-				weight = parsedWeight
+	// * reconcile in place so selection and health state survive updates
+	for _, current := range lb.Backends() {
+		key := current.URL.String()
+		backend, keep := desired[key]
+		switch {
+		case !keep:
+			lb.Remove(current.URL)
+			delete(s.remoteWeights[serviceName], current.URL.Host)
+			s.healthChecker.RemoveEndpoint(key)
+			s.dropProxy(key)
+		case backend.MaxConnections != current.MaxConnections || backend.Pool != current.Pool:
+			lb.Remove(current.URL)
+			lb.Add(backend)
+			s.healthChecker.AddEndpoint(backend, lb, probes[key])
+			delete(desired, key)
+		default:
+			if backend.Weight != current.Weight {
+				lb.SetWeight(current.URL, backend.Weight)
+				log.Printf("Updated weight of backend %s for service %s: %d -> %d", key, serviceName, current.Weight, backend.Weight)
 			}
+			s.healthChecker.SetProbe(key, probes[key])
+			delete(desired, key)
 		}
+	}
 
-		newLB.Add(&loadbalancer.Backend{
-			URL:    parsedURL,
-			Weight: weight,
-			Active: true,
-		})
+	grace := s.config.HealthCheck.WarmupGrace
+	preDial := s.config.Service(serviceName).PreDial
+	for key, backend := range desired {
+		if probeFirst {
+			backend.Active = false
+			lb.Add(backend)
+			s.healthChecker.AddPendingEndpoint(backend, lb, probes[key], 0)
+			if preDial > 0 {
+				go s.preDial(serviceName, backend, probes[key], preDial, false)
+			}
+			continue
+		}
+		if grace > 0 && remote[key] {
+			// * learned from another node, wait for a local probe before routing
+			backend.Active = false
+			lb.Add(backend)
+			s.healthChecker.AddPendingEndpoint(backend, lb, probes[key], grace)
+			if preDial > 0 {
+				go s.preDial(serviceName, backend, probes[key], preDial, false)
+			}
+			continue
+		}
+		if preDial > 0 {
+			// * out of rotation until its connections are up
+			backend.Active = false
+			lb.Add(backend)
+			s.healthChecker.AddEndpoint(backend, lb, probes[key])
+			go s.preDial(serviceName, backend, probes[key], preDial, true)
+			continue
+		}
+		lb.Add(backend)
+		s.healthChecker.AddEndpoint(backend, lb, probes[key])
+	}
+}
+
+// algorithmFor describes the configured balancing of a service: its algorithm,
+// followed by its failover pools if it has any. A change means the load
+// balancer must be replaced. The caller must hold s.mu.
+func (s *Server) algorithmFor(serviceName string) string {
+	algorithm := s.config.Service(serviceName).LoadBalancer
+	if algorithm == "" {
+		algorithm = s.config.LoadBalancer.Algorithm
+	}
+	if pools := s.config.Service(serviceName).Pools; len(pools) > 0 {
+		algorithm += " failover " + strings.Join(pools, ",")
+	}
+	return algorithm
+}
+
+// newLoadBalancer creates the service's configured load balancer, falling back
+// to round robin for unknown algorithms. The caller must hold s.mu.
+func (s *Server) newLoadBalancer(serviceName string) loadbalancer.LoadBalancer {
+	s.lbAlgorithms[serviceName] = s.algorithmFor(serviceName)
+
+	svc := s.config.Service(serviceName)
+	algorithm := svc.LoadBalancer
+	if algorithm == "" {
+		algorithm = s.config.LoadBalancer.Algorithm
+	}
+	factory := func() loadbalancer.LoadBalancer {
+		lb, err := loadbalancer.New(algorithm)
+		if err != nil {
+			log.Printf("Load balancer for service %s: %v, using round_robin", serviceName, err)
+			return loadbalancer.NewRoundRobin()
+		}
+		return lb
+	}
+
+	if len(svc.Pools) > 0 {
+		return loadbalancer.NewFailover(svc.Pools, factory)
+	}
+	return factory()
+}
+
+// recordBackendLoad feeds the load a backend reported in header into its
+// moving average. Missing or malformed values are ignored.
+func (s *Server) recordBackendLoad(serviceName string, resp *http.Response, header string) {
+	value := strings.TrimSpace(resp.Header.Get(header))
+	if value == "" {
+		return
+	}
+	load, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+
+	lb := s.GetLoadBalancer(serviceName)
+	if lb == nil {
+		return
+	}
+	for _, backend := range lb.Backends() {
+		if backend.URL.Scheme == resp.Request.URL.Scheme && backend.URL.Host == resp.Request.URL.Host {
+			backend.ReportLoad(load)
+			return
+		}
+	}
+}
+
+// switchLoadBalancer moves a service's backends onto a load balancer for its
+// newly configured algorithm. The caller must hold s.mu.
+func (s *Server) switchLoadBalancer(serviceName string, old loadbalancer.LoadBalancer) loadbalancer.LoadBalancer {
+	lb := s.newLoadBalancer(serviceName)
+	for _, backend := range old.Backends() {
+		lb.Add(backend)
+		s.healthChecker.SetLoadBalancer(backend.URL.String(), lb)
+	}
+	s.loadBalancers[serviceName] = lb
+
+	log.Printf("Switched load balancer for service %s to %s", serviceName, s.lbAlgorithms[serviceName])
+	return lb
+}
+
+// healthProbe returns how an instance is health checked. Instances can
+// override the global path with metadata["health_path"], the method with
+// metadata["health_method"] and the status with metadata["health_expected_code"].
+// The caller must hold s.mu.
+func (s *Server) healthProbe(instance discovery.ServiceInstance) HealthProbe {
+	probe := HealthProbe{
+		Path:         s.config.HealthCheck.Path,
+		Paths:        s.config.HealthCheck.Paths,
+		RequireAll:   s.config.HealthCheck.Require == "all",
+		Method:       http.MethodGet,
+		ExpectedCode: http.StatusOK,
+	}
+
+	if path := instance.Metadata["health_path"]; strings.HasPrefix(path, "/") {
+		probe.Path, probe.Paths = path, nil
+	}
+	if paths := instance.Metadata["health_paths"]; paths != "" {
+		var valid []string
+		for _, path := range strings.Split(paths, ",") {
+			if path = strings.TrimSpace(path); strings.HasPrefix(path, "/") {
+				valid = append(valid, path)
+			}
+		}
+		if len(valid) > 0 {
+			probe.Paths = valid
+		}
+	}
+	switch instance.Metadata["health_require"] {
+	case "any":
+		probe.RequireAll = false
+	case "all":
+		probe.RequireAll = true
+	}
+	if method := strings.ToUpper(instance.Metadata["health_method"]); method == http.MethodGet || method == http.MethodHead {
+		probe.Method = method
+	}
+	if code, err := strconv.Atoi(instance.Metadata["health_expected_code"]); err == nil && code >= 100 && code <= 599 {
+		probe.ExpectedCode = code
+	}
+	return probe
+}
+
+// instanceHost is the host:port of an instance, with IPv6 addresses bracketed
+// whether or not they were registered with brackets.
+func instanceHost(instance discovery.ServiceInstance) string {
+	return net.JoinHostPort(strings.Trim(instance.Address, "[]"), strconv.Itoa(instance.Port))
+}
+
+func (s *Server) backendFromInstance(instance discovery.ServiceInstance) (*loadbalancer.Backend, error) {
+	scheme := "http"
+	switch instance.Metadata["scheme"] {
+	case "http", "https":
+		scheme = instance.Metadata["scheme"]
+	default:
+		if s.config.Transport.Scheme == "https" {
+			scheme = "https"
+		}
+	}
+
+	// * a zone in an IPv6 literal must be escaped in URLs, e.g. [fe80::1%25eth0]
+	backendURL := scheme + "://" + strings.Replace(instanceHost(instance), "%", "%25", 1)
+	parsedURL, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing backend URL %s: %w", backendURL, err)
+	}
+
+	weight := 1 // * Default weight
+	if w, exists := instance.Metadata["weight"]; exists {
+		if parsedWeight, err := strconv.Atoi(w); err == nil && parsedWeight >= 0 {
+			weight = parsedWeight
+		}
+	}
+	if override, exists := s.remoteWeights[instance.Service][parsedURL.Host]; exists {
+		if override.seen == instance.Metadata["weight"] {
+			weight = override.weight
+		} else {
+			delete(s.remoteWeights[instance.Service], parsedURL.Host)
+		}
+	}
+	if override, exists := s.config.Service(instance.Service).Weights[parsedURL.Host]; exists {
+		weight = override
+	}
+
+	maxConns := int64(s.config.LoadBalancer.MaxConnections)
+	if m, exists := instance.Metadata["max_connections"]; exists {
+		if parsedMax, err := strconv.ParseInt(m, 10, 64); err == nil && parsedMax >= 0 {
+			maxConns = parsedMax
+		}
 	}
 
-	s.loadBalancers[serviceName] = newLB
-	log.Printf("Updated load balancer for service %s with %d instances", serviceName, len(instances))
+	return &loadbalancer.Backend{
+		URL:            parsedURL,
+		Weight:         weight,
+		Active:         true,
+		MaxConnections: maxConns,
+		Pool:           instance.Metadata["pool"],
+	}, nil
 }
 
 func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -324,32 +1325,66 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 		"status":    "ok",
 		"timestamp": time.Now().Unix(),
 		"services":  len(s.loadBalancers),
+		"metrics":   metrics.ServerState(),
 	})
 }
 
 func (s *Server) handleServiceRegistration(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
+		rejectRegistration(r, discovery.ServiceInstance{}, "method-not-allowed")
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.readOnly() {
+		rejectRegistration(r, discovery.ServiceInstance{}, "read-only")
+		http.Error(w, "Discovery is read-only on this node", http.StatusForbidden)
+		return
+	}
 
 	var instance discovery.ServiceInstance
 	if err := json.NewDecoder(r.Body).Decode(&instance); err != nil {
+		rejectRegistration(r, discovery.ServiceInstance{}, "invalid-json")
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
 	if instance.ID == "" || instance.Service == "" || instance.Address == "" || instance.Port == 0 {
+		rejectRegistration(r, instance, "missing-field")
 		http.Error(w, "Missing required fields: id, service, address, port", http.StatusBadRequest)
 		return
 	}
 
-	if isReservedServiceName(instance.Service) {
-		http.Error(w, fmt.Sprintf("Service name '%s' is reserved", instance.Service), http.StatusBadRequest)
+	if !validAddress(instance.Address) || instance.Port < 0 || instance.Port > 65535 {
+		rejectRegistration(r, instance, "invalid-address")
+		http.Error(w, "Invalid address or port", http.StatusBadRequest)
+		return
+	}
+
+	if conflict, reserved := s.reservedServiceName(instance.Service); reserved {
+		rejectRegistration(r, instance, "reserved-name")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":    fmt.Sprintf("Service name '%s' is reserved", instance.Service),
+			"service":  instance.Service,
+			"conflict": conflict,
+		})
+		return
+	}
+
+	if s.exceedsRouteLimit(instance) {
+		metrics.LimitRejections.WithLabelValues("routes").Inc()
+		rejectRegistration(r, instance, "route-limit")
+		http.Error(w, "Route limit reached", http.StatusInsufficientStorage)
 		return
 	}
 
 	if err := s.discovery.Register(instance); err != nil {
+		if errors.Is(err, discovery.ErrServiceLimit) {
+			rejectRegistration(r, instance, "service-limit")
+			http.Error(w, "Service limit reached", http.StatusInsufficientStorage)
+			return
+		}
 		log.Printf("Failed to register service: %v", err)
 		http.Error(w, "Registration failed", http.StatusInternalServerError)
 		return
@@ -372,6 +1407,10 @@ func (s *Server) handleServiceDeregistration(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.readOnly() {
+		http.Error(w, "Discovery is read-only on this node", http.StatusForbidden)
+		return
+	}
 
 	serviceID := r.URL.Query().Get("id")
 	if serviceID == "" {
@@ -395,6 +1434,36 @@ func (s *Server) handleServiceDeregistration(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// readOnly reports whether this node refuses to change discovery state.
+func (s *Server) readOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Cluster.ReadOnly
+}
+
+// handleCluster describes this node's view of the cluster. Gossip keys are
+// identified by fingerprint, never returned.
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	primary, keys := s.discovery.KeyFingerprints()
+	encryption := map[string]any{"enabled": primary != ""}
+	if primary != "" {
+		encryption["primary_key"] = primary
+		encryption["keys"] = keys
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"members":    s.discovery.NumMembers(),
+		"encryption": encryption,
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
 func (s *Server) handleServiceList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -410,6 +1479,7 @@ func (s *Server) handleServiceList(w http.ResponseWriter, r *http.Request) {
 			"service":   serviceName,
 			"instances": instances,
 			"route":     "/" + serviceName + "/*",
+			"disabled":  s.isDisabled(serviceName),
 			"timestamp": time.Now().Unix(),
 		})
 		return
@@ -422,6 +1492,7 @@ func (s *Server) handleServiceList(w http.ResponseWriter, r *http.Request) {
 		servicesWithRoutes[serviceName] = map[string]any{
 			"instances": instances,
 			"route":     "/" + serviceName + "/*",
+			"disabled":  s.isDisabled(serviceName),
 		}
 	}
 
@@ -431,3 +1502,162 @@ func (s *Server) handleServiceList(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().Unix(),
 	})
 }
+
+func (s *Server) handleRouteList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	routes := s.router.Routes()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"routes":    routes,
+		"total":     len(routes),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// handleRouteMatch reports which route a described request would match, and
+// why every other route didn't, without proxying anything.
+func (s *Server) handleRouteMatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Host    string            `json:"host"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !strings.HasPrefix(req.Path, "/") {
+		http.Error(w, "Missing required field: path (must start with /)", http.StatusBadRequest)
+		return
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	if req.Host == "" {
+		req.Host = "localhost"
+	}
+
+	sample, err := http.NewRequest(strings.ToUpper(req.Method), "http://"+req.Host+req.Path, nil)
+	if err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, value := range req.Headers {
+		sample.Header.Set(name, value)
+	}
+
+	route, trace := s.router.MatchTrace(sample)
+
+	resp := map[string]any{
+		"matched":   route != nil,
+		"trace":     trace,
+		"timestamp": time.Now().Unix(),
+	}
+	if route != nil {
+		resp["route"] = route
+		resp["service"] = route.ServiceName
+		resp["params"] = route.Params
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleBackendWeight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Service string `json:"service"`
+		Backend string `json:"backend"`
+		Weight  *int   `json:"weight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Service == "" || req.Backend == "" || req.Weight == nil {
+		http.Error(w, "Missing required fields: service, backend, weight", http.StatusBadRequest)
+		return
+	}
+	if *req.Weight < 0 {
+		http.Error(w, "Weight cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	parsedURL, err := url.Parse(req.Backend)
+	if err != nil || parsedURL.Host == "" {
+		http.Error(w, "Invalid backend URL", http.StatusBadRequest)
+		return
+	}
+	backendURL := &url.URL{Scheme: parsedURL.Scheme, Host: parsedURL.Host}
+
+	s.mu.RLock()
+	_, overridden := s.config.Service(req.Service).Weights[backendURL.Host]
+	s.mu.RUnlock()
+	if overridden {
+		http.Error(w, "Weight is set by services."+req.Service+".weights in the gateway config", http.StatusConflict)
+		return
+	}
+
+	lb := s.GetLoadBalancer(req.Service)
+	if lb == nil || !lb.SetWeight(backendURL, *req.Weight) {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+
+	// * persist into the metadata of instances this node owns so discovery
+	// * updates keep the new weight; other nodes' instances keep it in memory,
+	// * rather than this node gossiping their state as its own
+	s.mu.RLock()
+	instances := s.instances[req.Service]
+	s.mu.RUnlock()
+	for _, instance := range instances {
+		if instanceHost(instance) != backendURL.Host {
+			continue
+		}
+		if s.discovery == nil || s.readOnly() || !s.discovery.IsLocal(instance.ID) {
+			s.mu.Lock()
+			if s.remoteWeights[req.Service] == nil {
+				s.remoteWeights[req.Service] = make(map[string]remoteWeight)
+			}
+			s.remoteWeights[req.Service][backendURL.Host] = remoteWeight{weight: *req.Weight, seen: instance.Metadata["weight"]}
+			s.mu.Unlock()
+			continue
+		}
+		metadata := make(map[string]string, len(instance.Metadata)+1)
+		for k, v := range instance.Metadata {
+			metadata[k] = v
+		}
+		metadata["weight"] = strconv.Itoa(*req.Weight)
+		instance.Metadata = metadata
+		if err := s.discovery.Register(instance); err != nil {
+			log.Printf("Failed to persist weight for %s: %v", instance.ID, err)
+		}
+	}
+
+	log.Printf("Backend weight updated: %s %s -> %d", req.Service, req.Backend, *req.Weight)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    "updated",
+		"service":   req.Service,
+		"backend":   req.Backend,
+		"weight":    *req.Weight,
+		"timestamp": time.Now().Unix(),
+	})
+}
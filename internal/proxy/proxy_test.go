@@ -0,0 +1,2080 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg, err := config.Load("non-existent-file.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+
+	s, err := New(cfg, nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return s
+}
+
+func backendInstance(t *testing.T, service, rawURL string) discovery.ServiceInstance {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Failed to parse backend URL: %v", err)
+	}
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("Failed to split backend host: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	return discovery.ServiceInstance{ID: service + "-1", Service: service, Address: host, Port: port}
+}
+
+func TestClientDisconnectCancelsBackend(t *testing.T) {
+	received := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("slow", []discovery.ServiceInstance{backendInstance(t, "slow", backend.URL)})
+
+	before := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("slow", "GET", "499"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/slow/work", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		s.Handler().ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Backend did not receive the request")
+	}
+
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Backend request was not cancelled after the client hung up")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Proxy handler did not return after the client hung up")
+	}
+
+	after := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("slow", "GET", "499"))
+	if after-before != 1 {
+		t.Errorf("Expected one request recorded with status 499, got %v", after-before)
+	}
+}
+
+func TestServiceUpdateKeepsBackendState(t *testing.T) {
+	s := newTestServer(t)
+
+	instance := discovery.ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{instance})
+
+	lb := s.GetLoadBalancer("api")
+	before := lb.Backends()[0]
+	lb.MarkUnhealthy(before)
+
+	instance.Metadata = map[string]string{"weight": "3"}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{instance})
+
+	if s.GetLoadBalancer("api") != lb {
+		t.Fatal("Expected the load balancer to be updated in place")
+	}
+	after := lb.Backends()
+	if len(after) != 1 || after[0] != before {
+		t.Fatal("Expected the existing backend to be kept")
+	}
+	if after[0].Weight != 3 {
+		t.Errorf("Expected weight 3, got %d", after[0].Weight)
+	}
+	if after[0].Active {
+		t.Error("Expected health state to survive the weight update")
+	}
+
+	s.UpdateServiceInstances("api", nil)
+	if len(lb.Backends()) != 0 {
+		t.Error("Expected removed instances to be dropped from the load balancer")
+	}
+}
+
+func TestBackendWeightEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080},
+	})
+
+	body := strings.NewReader(`{"service":"api","backend":"http://10.0.0.1:8080","weight":4}`)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/weight", body))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if weight := s.GetLoadBalancer("api").Backends()[0].Weight; weight != 4 {
+		t.Errorf("Expected weight 4, got %d", weight)
+	}
+
+	body = strings.NewReader(`{"service":"api","backend":"http://10.0.0.9:8080","weight":4}`)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/weight", body))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown backend, got %d", rec.Code)
+	}
+}
+
+func TestBackendWeightKeepsRemoteOwnership(t *testing.T) {
+	disc, err := discovery.New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer disc.Leave(time.Second)
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	s, err := New(cfg, disc, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	disc.Register(discovery.ServiceInstance{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080})
+	disc.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+	s.UpdateServiceInstances("api", disc.GetInstances("api"))
+
+	for _, backend := range []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"} {
+		body := strings.NewReader(`{"service":"api","backend":"` + backend + `","weight":4}`)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/weight", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", backend, rec.Code, rec.Body.String())
+		}
+	}
+
+	metadata := make(map[string]string)
+	for _, instance := range disc.GetInstances("api") {
+		metadata[instance.ID] = instance.Metadata["weight"]
+	}
+	if metadata["api-1"] != "4" || metadata["api-2"] != "" {
+		t.Errorf("Expected only the local instance's weight persisted, got %v", metadata)
+	}
+	if disc.IsLocal("api-2") {
+		t.Error("Expected the remote instance to stay owned by its node")
+	}
+
+	// * the remote weight survives discovery updates
+	s.UpdateServiceInstances("api", disc.GetInstances("api"))
+	for _, backend := range s.GetLoadBalancer("api").Backends() {
+		if backend.Weight != 4 {
+			t.Errorf("Expected %s to keep weight 4, got %d", backend.URL, backend.Weight)
+		}
+	}
+
+	// * the owner re-registering with a new weight takes over again
+	disc.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080,"metadata":{"weight":"7"}}]}`), false)
+	s.UpdateServiceInstances("api", disc.GetInstances("api"))
+	weights := make(map[string]int)
+	for _, backend := range s.GetLoadBalancer("api").Backends() {
+		weights[backend.URL.Host] = backend.Weight
+	}
+	if weights["10.0.0.1:8080"] != 4 || weights["10.0.0.2:8080"] != 7 {
+		t.Errorf("Expected the owner's new weight to replace the override, got %v", weights)
+	}
+
+	// * and the override doesn't come back if the owner returns to the old one
+	disc.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+	s.UpdateServiceInstances("api", disc.GetInstances("api"))
+	for _, backend := range s.GetLoadBalancer("api").Backends() {
+		if backend.URL.Host == "10.0.0.2:8080" && backend.Weight != 1 {
+			t.Errorf("Expected the dropped override to stay dropped, got weight %d", backend.Weight)
+		}
+	}
+}
+
+func TestConfigWeightOverrides(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {Weights: map[string]int{"10.0.0.1:8080": 5}}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080, Metadata: map[string]string{"weight": "2"}},
+		{ID: "api-2", Service: "api", Address: "10.0.0.2", Port: 8080, Metadata: map[string]string{"weight": "2"}},
+	})
+
+	weights := func() map[string]int {
+		weights := make(map[string]int)
+		for _, b := range s.GetLoadBalancer("api").Backends() {
+			weights[b.URL.Host] = b.Weight
+		}
+		return weights
+	}
+	if got := weights(); got["10.0.0.1:8080"] != 5 || got["10.0.0.2:8080"] != 2 {
+		t.Errorf("Expected the config weight to override metadata, got %v", got)
+	}
+
+	body := strings.NewReader(`{"service":"api","backend":"http://10.0.0.1:8080","weight":1}`)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/weight", body))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a backend weighted by config, got %d", rec.Code)
+	}
+
+	cfg := *s.config
+	cfg.Services = map[string]config.ServiceConfig{"api": {Weights: map[string]int{"10.0.0.2:8080": 0}}}
+	s.UpdateConfig(&cfg)
+
+	if got := weights(); got["10.0.0.1:8080"] != 2 || got["10.0.0.2:8080"] != 0 {
+		t.Errorf("Expected reload to retune weights, got %v", got)
+	}
+}
+
+func TestDefaultBackendScheme(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Transport.Scheme = "https"
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8443},
+		{ID: "api-2", Service: "api", Address: "10.0.0.2", Port: 8080, Metadata: map[string]string{"scheme": "http"}},
+	})
+
+	schemes := func() map[string]string {
+		schemes := make(map[string]string)
+		for _, b := range s.GetLoadBalancer("api").Backends() {
+			schemes[b.URL.Host] = b.URL.Scheme
+		}
+		return schemes
+	}
+	if got := schemes(); got["10.0.0.1:8443"] != "https" || got["10.0.0.2:8080"] != "http" {
+		t.Errorf("Expected the default scheme unless metadata overrides it, got %v", got)
+	}
+
+	cfg := *s.config
+	cfg.Transport.Scheme = "http"
+	s.UpdateConfig(&cfg)
+	if got := schemes(); got["10.0.0.1:8443"] != "http" || len(got) != 2 {
+		t.Errorf("Expected reload to switch backends to the new default, got %v", got)
+	}
+}
+
+func TestResponseTimeoutIsDistinctFromConnectFailure(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.transport.ResponseHeaderTimeout = 50 * time.Millisecond
+	s.UpdateServiceInstances("slow", []discovery.ServiceInstance{backendInstance(t, "slow", backend.URL)})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/slow/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 on response timeout, got %d", rec.Code)
+	}
+
+	host := strings.TrimPrefix(backend.URL, "http://")
+	if got := testutil.ToFloat64(metrics.BackendErrors.WithLabelValues(host, "response_timeout")); got != 1 {
+		t.Errorf("Expected one response_timeout error, got %v", got)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+	s.UpdateServiceInstances("down", []discovery.ServiceInstance{backendInstance(t, "down", closedURL)})
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/down/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 on connect failure, got %d", rec.Code)
+	}
+
+	host = strings.TrimPrefix(closedURL, "http://")
+	if got := testutil.ToFloat64(metrics.BackendErrors.WithLabelValues(host, "connect_error")); got != 1 {
+		t.Errorf("Expected one connect_error, got %v", got)
+	}
+}
+
+func TestTraceparentPropagation(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/api/", nil)
+	req.Header.Set("traceparent", incoming)
+	req.Header.Set("tracestate", "vendor=value")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	header := <-received
+	if header.Get("traceparent") != incoming {
+		t.Errorf("Expected valid traceparent to be forwarded, got %q", header.Get("traceparent"))
+	}
+	if header.Get("tracestate") != "vendor=value" {
+		t.Errorf("Expected tracestate to be forwarded, got %q", header.Get("tracestate"))
+	}
+	if rec.Header().Get("X-Request-ID") != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected trace ID as request ID, got %q", rec.Header().Get("X-Request-ID"))
+	}
+
+	req = httptest.NewRequest("GET", "/api/", nil)
+	req.Header.Set("traceparent", "garbage")
+	req.Header.Set("tracestate", "vendor=value")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	header = <-received
+	if _, ok := parseTraceparent(header.Get("traceparent")); !ok {
+		t.Errorf("Expected a generated valid traceparent, got %q", header.Get("traceparent"))
+	}
+	if header.Get("tracestate") != "" {
+		t.Error("Expected tracestate to be dropped with an invalid traceparent")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+	}
+
+	for _, tt := range tests {
+		if _, ok := parseTraceparent(tt.value); ok != tt.valid {
+			t.Errorf("parseTraceparent(%q) valid = %v, want %v", tt.value, ok, tt.valid)
+		}
+	}
+}
+
+func TestMaxPoolAgeRecyclesBackendPool(t *testing.T) {
+	s := newTestServer(t)
+	target, _ := url.Parse("http://10.0.0.1:8080")
+
+	first := s.getOrCreateProxy(target)
+	if s.getOrCreateProxy(target) != first {
+		t.Fatal("Expected the proxy to be reused without a max pool age")
+	}
+
+	s.config.Transport.MaxPoolAge = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+
+	if s.getOrCreateProxy(target) == first {
+		t.Error("Expected the proxy to be recycled after max pool age")
+	}
+}
+
+func TestUpdateConfigKeepsBackendPools(t *testing.T) {
+	s := newTestServer(t)
+	target, _ := url.Parse("http://10.0.0.1:8080")
+	first := s.getOrCreateProxy(target)
+
+	// * unrelated settings leave the pooled connections alone
+	cfg := *s.config
+	cfg.Logging.Level = "debug"
+	s.UpdateConfig(&cfg)
+	if s.getOrCreateProxy(target) != first {
+		t.Error("Expected a reload without transport changes to keep the backend pool")
+	}
+
+	dialed := *s.config
+	dialed.Dial.KeepAlive = 42 * time.Second
+	s.UpdateConfig(&dialed)
+	if s.getOrCreateProxy(target) == first {
+		t.Error("Expected a dial setting change to rebuild the backend pool")
+	}
+}
+
+func TestBackendTLSVerificationError(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.HealthCheck.UnhealthyOnTLSError = true
+	instance := backendInstance(t, "secure", backend.URL)
+	instance.Metadata = map[string]string{"scheme": "https"}
+	s.UpdateServiceInstances("secure", []discovery.ServiceInstance{instance})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/secure/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 on TLS verification failure, got %d", rec.Code)
+	}
+
+	if got := testutil.ToFloat64(metrics.BackendTLSErrors.WithLabelValues(backend.URL, "unknown_authority")); got != 1 {
+		t.Errorf("Expected one unknown_authority TLS error, got %v", got)
+	}
+	if s.GetLoadBalancer("secure").Backends()[0].Active {
+		t.Error("Expected the backend to be marked unhealthy")
+	}
+}
+
+func TestServiceStaticResponder(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Static = map[string]config.StaticConfig{
+		"maintenance": {Status: http.StatusServiceUnavailable, ContentType: "text/html", Body: "<h1>Back soon</h1>"},
+	}
+	s.config.Services = map[string]config.ServiceConfig{"api": {Static: "maintenance"}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080},
+	})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/anything", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("Expected configured content type, got %q", rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != "<h1>Back soon</h1>" {
+		t.Errorf("Expected configured body, got %q", rec.Body.String())
+	}
+}
+
+func TestServiceHostHeader(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Host
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	tests := []struct {
+		hostHeader string
+		want       string
+	}{
+		{"", "gateway.example.com"},
+		{"preserve", "gateway.example.com"},
+		{"backend", strings.TrimPrefix(backend.URL, "http://")},
+		{"internal.example.com", "internal.example.com"},
+	}
+
+	for _, tt := range tests {
+		s.config.Services = map[string]config.ServiceConfig{"api": {HostHeader: tt.hostHeader}}
+
+		req := httptest.NewRequest("GET", "http://gateway.example.com/api/", nil)
+		s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := <-received; got != tt.want {
+			t.Errorf("host_header %q: expected Host %q, got %q", tt.hostHeader, tt.want, got)
+		}
+	}
+}
+
+func TestConnectFailureRetriedOnAnotherBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {Retries: 1}}
+	live := backendInstance(t, "api", backend.URL)
+	dead := backendInstance(t, "api", closedURL)
+	dead.ID = "api-2"
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{live, dead})
+
+	before := testutil.ToFloat64(metrics.Retries.WithLabelValues("api", "attempted"))
+
+	// * round robin sends at least one of the requests to the dead backend first
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 after retrying, got %d", rec.Code)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.Retries.WithLabelValues("api", "attempted")) - before; got < 1 {
+		t.Errorf("Expected a retry, got %v", got)
+	}
+}
+
+func TestRetryOnStatus(t *testing.T) {
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Overloaded", "true")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("overloaded"))
+	}))
+	defer overloaded.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api":  {Retries: 1, RetryOn: []int{502, 503, 504}},
+		"solo": {Retries: 1, RetryOn: []int{503}},
+	}
+	live := backendInstance(t, "api", backend.URL)
+	busy := backendInstance(t, "api", overloaded.URL)
+	busy.ID = "api-2"
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{live, busy})
+	s.UpdateServiceInstances("solo", []discovery.ServiceInstance{backendInstance(t, "solo", overloaded.URL)})
+
+	// * round robin sends at least one of the requests to the overloaded backend first
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" || rec.Header().Get("X-Overloaded") != "" {
+			t.Errorf("Expected only the retried response, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/solo/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "overloaded" {
+		t.Errorf("Expected the last attempt's response to pass through, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRetrySkipsTriedBackends(t *testing.T) {
+	var calls [2]atomic.Int32
+	var backends [2]*httptest.Server
+	for i := range backends {
+		backends[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls[i].Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer backends[i].Close()
+	}
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {Retries: 5, RetryOn: []int{503}}}
+	first := backendInstance(t, "api", backends[0].URL)
+	second := backendInstance(t, "api", backends[1].URL)
+	second.ID = "api-2"
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{first, second})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the last backend's 503 to pass through, got %d", rec.Code)
+	}
+	if a, b := calls[0].Load(), calls[1].Load(); a != 1 || b != 1 {
+		t.Errorf("Expected each backend to be tried once, got %d and %d attempts", a, b)
+	}
+}
+
+func TestRetryAndTryTimeouts(t *testing.T) {
+	var slowCalls atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api":   {Retries: 1, TryTimeout: 50 * time.Millisecond},
+		"stuck": {Retries: 5, TryTimeout: 50 * time.Millisecond, RetryTimeout: 120 * time.Millisecond},
+	}
+	live := backendInstance(t, "api", backend.URL)
+	hung := backendInstance(t, "api", slow.URL)
+	hung.ID = "api-2"
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{live, hung})
+	s.UpdateServiceInstances("stuck", []discovery.ServiceInstance{backendInstance(t, "stuck", slow.URL)})
+
+	// * round robin sends at least one of the requests to the hung backend first
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("Expected a timed out attempt to be retried, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	before := slowCalls.Load()
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/stuck/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 once the retry timeout was spent, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected retries to stop at the retry timeout, took %v", elapsed)
+	}
+	if attempts := slowCalls.Load() - before; attempts > 3 {
+		t.Errorf("Expected retries left unused once the retry timeout was spent, got %d attempts", attempts)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	cfg := config.RetryBudgetConfig{Ratio: 0.5, Window: time.Minute, MinRetries: 1}
+	b := &retryBudget{}
+
+	b.recordRequest(cfg)
+	if !b.allowRetry(cfg) {
+		t.Fatal("Expected min_retries to allow a retry")
+	}
+	if b.allowRetry(cfg) {
+		t.Fatal("Expected the budget to be exhausted")
+	}
+
+	for i := 0; i < 3; i++ {
+		b.recordRequest(cfg)
+	}
+	if !b.allowRetry(cfg) {
+		t.Error("Expected more traffic to grow the budget")
+	}
+	if b.allowRetry(cfg) {
+		t.Error("Expected retries to be capped at the ratio")
+	}
+}
+
+func TestServicePathAliases(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	instance := backendInstance(t, "users", backend.URL)
+	instance.Metadata = map[string]string{"prefixes": "/v1/users, /api/users"}
+	s.UpdateServiceInstances("users", []discovery.ServiceInstance{instance})
+
+	for _, path := range []string{"/users/42", "/v1/users/42"} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", path, rec.Code)
+		}
+		if got := <-received; got != "/42" {
+			t.Errorf("Expected %s to be forwarded as /42, got %s", path, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/users/42", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected reserved alias to be ignored, got %d", rec.Code)
+	}
+}
+
+func TestRouteParamHeaders(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"users": {ParamHeaders: "X-Route-Param-{name}"}}
+	instance := backendInstance(t, "users", backend.URL)
+	instance.Metadata = map[string]string{"prefixes": "/accounts/:id"}
+	s.UpdateServiceInstances("users", []discovery.ServiceInstance{instance})
+
+	req := httptest.NewRequest("GET", "/accounts/123/orders", nil)
+	req.Header.Set("X-Route-Param-Role", "admin")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	got := <-received
+	if got.URL.Path != "/orders" {
+		t.Errorf("Expected the matched prefix to be stripped, got %s", got.URL.Path)
+	}
+	if id := got.Header.Get("X-Route-Param-id"); id != "123" {
+		t.Errorf("Expected X-Route-Param-id 123, got %q", id)
+	}
+	if role := got.Header.Get("X-Route-Param-Role"); role != "" {
+		t.Errorf("Expected client-supplied param header to be removed, got %q", role)
+	}
+}
+
+func TestHeadOnGetOnlyRoute(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Method
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	instance := backendInstance(t, "reports", backend.URL)
+	instance.Metadata = map[string]string{"methods": "GET"}
+	s.UpdateServiceInstances("reports", []discovery.ServiceInstance{instance})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("HEAD", "/reports/daily", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for HEAD on a GET route, got %d", rec.Code)
+	}
+	if method := <-received; method != "HEAD" {
+		t.Errorf("Expected the request to be forwarded as HEAD, got %s", method)
+	}
+}
+
+func TestReadOnlyDiscovery(t *testing.T) {
+	disc, err := discovery.New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer disc.Leave(time.Second)
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	cfg.Cluster.ReadOnly = true
+	s, err := New(cfg, disc, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	body := `{"id":"api-1","service":"api","address":"10.0.0.1","port":8080}`
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/services/register", strings.NewReader(body)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for registration, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/services/deregister?id=api-1", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for deregistration, got %d", rec.Code)
+	}
+
+	// * gossiped state is still merged and listed
+	disc.MergeRemoteState([]byte(`{"api":[{"id":"api-2","service":"api","address":"10.0.0.2","port":8080}]}`), false)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/services?service=api", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "api-2") {
+		t.Errorf("Expected the gossiped instance to be listed, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.UpdateServiceInstances("stats-svc", []discovery.ServiceInstance{
+		{ID: "stats-1", Service: "stats-svc", Address: "10.0.0.1", Port: 8080},
+		{ID: "stats-2", Service: "stats-svc", Address: "10.0.0.2", Port: 8080},
+	})
+	lb := s.GetLoadBalancer("stats-svc")
+	lb.MarkUnhealthy(lb.Backends()[0])
+	metrics.RequestsTotal.WithLabelValues("stats-svc", "GET", "200").Add(3)
+	metrics.RequestsTotal.WithLabelValues("stats-svc", "GET", "502").Inc()
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var stats struct {
+		Services map[string]serviceStats `json:"services"`
+		Cluster  int                     `json:"cluster_size"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+
+	got := stats.Services["stats-svc"]
+	if len(got.Backends) != 2 || got.Backends[0].EffectiveWeight != 1 {
+		t.Errorf("Expected two backends with effective weight 1, got %+v", got.Backends)
+	}
+	got.Backends = nil
+	want := serviceStats{Requests: 4, Errors: 1, HealthyBackends: 1, TotalBackends: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if stats.Cluster != 1 {
+		t.Errorf("Expected a cluster size of 1 without discovery, got %d", stats.Cluster)
+	}
+}
+
+func TestServiceRequestSigning(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	signing := &config.SigningConfig{Secret: "shared", Algorithm: "sha256"}
+	s.config.Services = map[string]config.ServiceConfig{"api": {Signing: signing}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	req := httptest.NewRequest("POST", "/api/orders?id=7", nil)
+	req.Header.Set("X-FluxGate-Signature", "forged")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	got := <-received
+	timestamp := got.Header.Get("X-FluxGate-Timestamp")
+	if timestamp == "" {
+		t.Fatal("Expected a timestamp header")
+	}
+
+	mac := hmac.New(sha256.New, []byte("shared"))
+	mac.Write([]byte("POST\n/orders?id=7\n" + timestamp))
+	if want := hex.EncodeToString(mac.Sum(nil)); got.Header.Get("X-FluxGate-Signature") != want {
+		t.Errorf("Expected signature %s, got %s", want, got.Header.Get("X-FluxGate-Signature"))
+	}
+}
+
+func TestUpgradeRequestSigning(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	signing := &config.SigningConfig{Secret: "shared", Algorithm: "sha256"}
+	s.config.Services = map[string]config.ServiceConfig{"ws": {Signing: signing, HostHeader: "backend"}}
+	s.UpdateServiceInstances("ws", []discovery.ServiceInstance{backendInstance(t, "ws", backend.URL)})
+
+	req := httptest.NewRequest("GET", "/ws/socket?room=7", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("X-FluxGate-Signature", "forged")
+	req.Header.Set("X-FluxGate-Timestamp", "1")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	got := <-received
+	if got.Host != strings.TrimPrefix(backend.URL, "http://") {
+		t.Errorf("Expected host_header to apply to the upgrade, got Host %s", got.Host)
+	}
+	timestamp := got.Header.Get("X-FluxGate-Timestamp")
+	if timestamp == "" || timestamp == "1" {
+		t.Fatalf("Expected a fresh timestamp header, got %q", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte("shared"))
+	mac.Write([]byte("GET\n/socket?room=7\n" + timestamp))
+	if want := hex.EncodeToString(mac.Sum(nil)); got.Header.Get("X-FluxGate-Signature") != want {
+		t.Errorf("Expected signature %s, got %s", want, got.Header.Get("X-FluxGate-Signature"))
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Timeouts.Request = 50 * time.Millisecond
+	s.config.Services = map[string]config.ServiceConfig{"events": {Streaming: true}}
+	s.UpdateServiceInstances("slow", []discovery.ServiceInstance{backendInstance(t, "slow", backend.URL)})
+	s.UpdateServiceInstances("events", []discovery.ServiceInstance{backendInstance(t, "events", backend.URL)})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/slow/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the request deadline passed, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "deadline exceeded") {
+		t.Errorf("Expected a deadline message, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/events/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected streaming service to be exempt, got %d", rec.Code)
+	}
+}
+
+func TestServiceLoadBalancerAlgorithm(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"api": {LoadBalancer: "least_connection"}}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080},
+	})
+
+	if _, ok := s.GetLoadBalancer("api").(*loadbalancer.LeastConnection); !ok {
+		t.Fatalf("Expected least_connection, got %T", s.GetLoadBalancer("api"))
+	}
+
+	cfg := *s.config
+	cfg.Services = map[string]config.ServiceConfig{"api": {LoadBalancer: "weighted_random"}}
+	s.UpdateConfig(&cfg)
+
+	lb, ok := s.GetLoadBalancer("api").(*loadbalancer.WeightedRandom)
+	if !ok {
+		t.Fatalf("Expected weighted_random after reload, got %T", s.GetLoadBalancer("api"))
+	}
+	if len(lb.Backends()) != 1 {
+		t.Error("Expected backends to be carried over to the new load balancer")
+	}
+}
+
+func TestBackendBreakerEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080},
+	})
+	backend := s.GetLoadBalancer("api").Backends()[0]
+
+	breaker := func(action string) (int, map[string]any) {
+		body := strings.NewReader(`{"service":"api","backend":"http://10.0.0.1:8080","action":"` + action + `"}`)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/breaker", body))
+		var resp map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := breaker("open")
+	if code != http.StatusOK || resp["state"] != "open" {
+		t.Fatalf("Expected open breaker, got %d %v", code, resp)
+	}
+	if backend.Active {
+		t.Error("Expected backend to be ejected")
+	}
+
+	// * probes can't bring an ejected backend back
+	endpoint := s.healthChecker.endpoints[backend.URL.String()]
+	s.healthChecker.markHealthy(endpoint)
+	if backend.Active {
+		t.Error("Expected backend to stay ejected after a passing probe")
+	}
+
+	if code, resp = breaker("close"); code != http.StatusOK || resp["state"] != "closed" {
+		t.Fatalf("Expected closed breaker, got %d %v", code, resp)
+	}
+	if backend.Active {
+		t.Error("Expected backend to wait for a passing probe after close")
+	}
+	s.healthChecker.markHealthy(endpoint)
+	if !backend.Active {
+		t.Error("Expected passing probe to restore backend after close")
+	}
+
+	breaker("open")
+	if code, resp = breaker("reset"); code != http.StatusOK || resp["state"] != "closed" {
+		t.Fatalf("Expected reset breaker, got %d %v", code, resp)
+	}
+	if !backend.Active {
+		t.Error("Expected reset to restore backend immediately")
+	}
+
+	if code, _ = breaker("toggle"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown action, got %d", code)
+	}
+
+	body := strings.NewReader(`{"service":"api","backend":"http://10.0.0.9:8080","action":"open"}`)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/backends/breaker", body))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown backend, got %d", rec.Code)
+	}
+}
+
+func TestResponseHeaderLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 20; i++ {
+			w.Header().Add("X-Filler", strings.Repeat("x", 100))
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 within default limits, got %d", rec.Code)
+	}
+
+	for _, limits := range []config.TransportConfig{
+		{MaxResponseHeaders: 10, MaxResponseHeaderBytes: 1 << 20},
+		{MaxResponseHeaderBytes: 1024},
+	} {
+		before := testutil.ToFloat64(metrics.BackendErrors.WithLabelValues(host, "response_header_limit"))
+		s.config.Transport.MaxResponseHeaders = limits.MaxResponseHeaders
+		s.config.Transport.MaxResponseHeaderBytes = limits.MaxResponseHeaderBytes
+
+		rec = httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/", nil))
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("Expected 502 with limits %+v, got %d", limits, rec.Code)
+		}
+		if rec.Header().Get("X-Filler") != "" {
+			t.Errorf("Expected backend headers to be dropped with limits %+v", limits)
+		}
+		if got := testutil.ToFloat64(metrics.BackendErrors.WithLabelValues(host, "response_header_limit")); got != before+1 {
+			t.Errorf("Expected response_header_limit error to be counted with limits %+v, got %v", limits, got-before)
+		}
+	}
+}
+
+func TestStartupGrace(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Cluster.StartupGrace = time.Hour
+	s.config.Cluster.StartupReject = true
+	s.synced.Store(false)
+	s.UpdateServiceInstances("users", []discovery.ServiceInstance{
+		{ID: "users-1", Service: "users", Address: "10.0.0.1", Port: 8080},
+	})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After before sync, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/users/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected proxied request to be rejected for the grace, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// * without startup_reject only readiness reflects the grace
+	s.config.Cluster.StartupReject = false
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/missing/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected request to be routed, got %d", rec.Code)
+	}
+
+	s.started = time.Now().Add(-2 * time.Hour)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected ready once the grace expired, got %d", rec.Code)
+	}
+
+	s.started = time.Now()
+	s.synced.Store(true)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected ready once synced, got %d", rec.Code)
+	}
+}
+
+func TestCriticalServiceReadiness(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Readiness = config.ReadinessConfig{CriticalServices: []string{"payments"}, MaxUnhealthyPercent: 50}
+
+	ready := func() (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/ready", nil))
+		var body map[string]any
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	// * a critical service without backends is fully unhealthy
+	if code, body := ready(); code != http.StatusServiceUnavailable || body["status"] != "degraded" {
+		t.Errorf("Expected degraded without payments backends, got %d %v", code, body)
+	}
+
+	var instances []discovery.ServiceInstance
+	for i := 1; i <= 4; i++ {
+		instances = append(instances, discovery.ServiceInstance{
+			ID: fmt.Sprintf("payments-%d", i), Service: "payments", Address: fmt.Sprintf("10.0.0.%d", i), Port: 8080,
+		})
+	}
+	s.UpdateServiceInstances("payments", instances)
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("Expected ready with every backend healthy, got %d", code)
+	}
+	if got := testutil.ToFloat64(metrics.Ready); got != 1 {
+		t.Errorf("Expected the ready metric to be 1, got %v", got)
+	}
+
+	s.healthChecker.SetEjected("http://10.0.0.1:8080", true)
+	s.healthChecker.SetEjected("http://10.0.0.2:8080", true)
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("Expected ready at exactly the threshold, got %d", code)
+	}
+
+	s.healthChecker.SetEjected("http://10.0.0.3:8080", true)
+	code, body := ready()
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(body["degraded_services"], []any{"payments"}) {
+		t.Errorf("Expected payments to degrade readiness, got %d %v", code, body)
+	}
+	if got := testutil.ToFloat64(metrics.CriticalUnhealthy.WithLabelValues("payments")); got != 0.75 {
+		t.Errorf("Expected an unhealthy ratio of 0.75, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.Ready); got != 0 {
+		t.Errorf("Expected the ready metric to be 0, got %v", got)
+	}
+}
+
+func TestIPv6Backend(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgradeRequest(r) {
+			conn, buffered, _ := http.NewResponseController(w).Hijack()
+			defer conn.Close()
+			buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			buffered.Flush()
+			line, _ := buffered.ReadString('\n')
+			conn.Write([]byte("echo " + line))
+			return
+		}
+		w.Write([]byte("v6"))
+	}))
+	backend.Listener.Close()
+	backend.Listener = ln
+	backend.Start()
+	defer backend.Close()
+
+	s := newTestServer(t)
+	instance := backendInstance(t, "v6", backend.URL)
+	if instance.Address != "::1" {
+		t.Fatalf("Expected an unbracketed IPv6 address, got %q", instance.Address)
+	}
+	bracketed := instance
+	bracketed.ID, bracketed.Address = "v6-2", "[::1]"
+	s.UpdateServiceInstances("v6", []discovery.ServiceInstance{instance, bracketed})
+
+	backends := s.GetLoadBalancer("v6").Backends()
+	if len(backends) != 1 || backends[0].URL.Host != ln.Addr().String() {
+		t.Fatalf("Expected both registrations to map to backend %s, got %v", ln.Addr(), backends)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/v6/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "v6" {
+		t.Errorf("Expected the IPv6 backend to answer, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	gateway := httptest.NewServer(s.Handler())
+	defer gateway.Close()
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /v6/socket HTTP/1.1\r\nHost: gateway\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected the WebSocket upgrade to reach the IPv6 backend, got %v %v", resp, err)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "echo ping\n" {
+		t.Errorf("Expected the tunnel to relay frames, got %q", line)
+	}
+}
+
+func TestIPv6ZoneBackendURL(t *testing.T) {
+	s := newTestServer(t)
+	backend, err := s.backendFromInstance(discovery.ServiceInstance{ID: "z-1", Service: "z", Address: "fe80::1%eth0", Port: 8080})
+	if err != nil {
+		t.Fatalf("Failed to build backend: %v", err)
+	}
+	if backend.URL.Host != "[fe80::1%eth0]:8080" || backend.URL.String() != "http://[fe80::1%25eth0]:8080" {
+		t.Errorf("Expected a bracketed host with an escaped zone, got %q %q", backend.URL.Host, backend.URL.String())
+	}
+}
+
+func TestPreDial(t *testing.T) {
+	for _, algorithm := range []string{"round_robin", "least_connection"} {
+		t.Run(algorithm, func(t *testing.T) {
+			testPreDial(t, algorithm)
+		})
+	}
+}
+
+func testPreDial(t *testing.T, algorithm string) {
+	var dialed atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// * slow probes keep the pre-dial requests on separate connections
+		if r.URL.Path == "/health" {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dialed.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.HealthCheck.Path = "/health"
+	s.config.LoadBalancer.Algorithm = algorithm
+	s.config.Services = map[string]config.ServiceConfig{"warm": {PreDial: 3}}
+	s.UpdateServiceInstances("warm", []discovery.ServiceInstance{backendInstance(t, "warm", backend.URL)})
+
+	// * Next reads the health state under the balancer's lock, unlike Backends
+	lb := s.GetLoadBalancer("warm")
+	if selectable(lb) {
+		t.Fatalf("Expected the backend out of rotation while pre-dialing")
+	}
+	if !waitForSelectable(lb) {
+		t.Fatalf("Expected the backend to join the rotation once pre-dialed")
+	}
+	if got := dialed.Load(); got != 3 {
+		t.Fatalf("Expected 3 pre-dialed connections, got %d", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/warm/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+	if got := dialed.Load(); got != 3 {
+		t.Errorf("Expected requests to reuse the pre-dialed connections, got %d connections", got)
+	}
+}
+
+func TestMethodRewrite(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"legacy": {MethodRewrite: map[string]string{"PUT": "POST"}}}
+	s.UpdateServiceInstances("legacy", []discovery.ServiceInstance{backendInstance(t, "legacy", backend.URL)})
+
+	before := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("legacy", "PUT", "200"))
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("PUT", "/legacy/items/1", strings.NewReader("{}")))
+	if rec.Code != http.StatusOK || got != "POST" {
+		t.Errorf("Expected PUT to be forwarded as POST, got %d %s", rec.Code, got)
+	}
+	if after := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("legacy", "PUT", "200")); after != before+1 {
+		t.Errorf("Expected the request to be counted under the client method PUT")
+	}
+
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/legacy/items/1", nil))
+	if got != "DELETE" {
+		t.Errorf("Expected methods without a rule to pass through, got %s", got)
+	}
+}
+
+func TestHeaderCase(t *testing.T) {
+	// * Go's server canonicalizes what it parses, so the backend reads raw lines
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+		received <- lines
+	}()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"legacy": {HeaderCase: []string{"SOAPAction", "x-api-key"}}}
+	s.UpdateServiceInstances("legacy", []discovery.ServiceInstance{backendInstance(t, "legacy", "http://"+ln.Addr().String())})
+
+	req := httptest.NewRequest("POST", "/legacy/service", strings.NewReader("<xml/>"))
+	req.Header.Set("Soapaction", "urn:GetQuote")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Other", "kept")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	lines := <-received
+	for _, want := range []string{"SOAPAction: urn:GetQuote", "x-api-key: secret", "X-Other: kept"} {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("Expected header line %q, got %q", want, lines)
+		}
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	var hops string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops = r.Header.Get("X-FluxGate-Hops")
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Server.MaxHops = 3
+	s.UpdateServiceInstances("users", []discovery.ServiceInstance{backendInstance(t, "users", backend.URL)})
+
+	req := httptest.NewRequest("GET", "/users/", nil)
+	req.Header.Set("X-FluxGate-Hops", "2")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || hops != "3" {
+		t.Errorf("Expected request forwarded with 3 hops, got %d %q", rec.Code, hops)
+	}
+
+	req = httptest.NewRequest("GET", "/users/", nil)
+	req.Header.Set("X-FluxGate-Hops", "3")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusLoopDetected {
+		t.Errorf("Expected 508 at the hop limit, got %d", rec.Code)
+	}
+
+	// * disabled, the header is left alone
+	s.config.Server.MaxHops = 0
+	hops = ""
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	if hops != "3" {
+		t.Errorf("Expected hop header untouched when disabled, got %q", hops)
+	}
+}
+
+func TestRouteMatchEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.router.AddRoute("/admin", "admin", []string{"POST"})
+	s.router.AddRoute("/orders/:id", "orders", nil)
+
+	match := func(body string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/routes/match", strings.NewReader(body)))
+		var resp map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := match(`{"path":"/orders/42","host":"shop.example.com","headers":{"X-Test":"1"}}`)
+	if code != http.StatusOK || resp["matched"] != true || resp["service"] != "orders" {
+		t.Fatalf("Expected orders match, got %d %v", code, resp)
+	}
+	if params, _ := resp["params"].(map[string]any); params["id"] != "42" {
+		t.Errorf("Expected id param 42, got %v", resp["params"])
+	}
+	trace, _ := resp["trace"].([]any)
+	if len(trace) == 0 || trace[0].(map[string]any)["result"] != "method_not_allowed" {
+		t.Errorf("Expected admin route rejected on method, got %v", trace)
+	}
+
+	if code, resp = match(`{"method":"GET","path":"/nowhere"}`); code != http.StatusOK || resp["matched"] != false {
+		t.Errorf("Expected no match, got %d %v", code, resp)
+	}
+	if code, _ = match(`{"method":"GET"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a path, got %d", code)
+	}
+}
+
+func TestBackendLoadHeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Load", r.URL.Query().Get("load"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"queue": {LoadBalancer: "least_load", LoadHeader: "X-Backend-Load"}}
+	s.UpdateServiceInstances("queue", []discovery.ServiceInstance{backendInstance(t, "queue", backend.URL)})
+
+	for _, load := range []string{"10", "20", "bogus"} {
+		s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/queue/jobs?load="+load, nil))
+	}
+
+	b := s.GetLoadBalancer("queue").Backends()[0]
+	if load := b.Load(); load < 12.99 || load > 13.01 {
+		t.Errorf("Expected load average 13, got %v", load)
+	}
+	if gauge := testutil.ToFloat64(metrics.BackendLoad.WithLabelValues(b.URL.String())); gauge != b.Load() {
+		t.Errorf("Expected backend_load gauge %v, got %v", b.Load(), gauge)
+	}
+}
+
+func TestMediaTypeRoutingFromMetadata(t *testing.T) {
+	v1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1"))
+	}))
+	defer v1.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v2"))
+	}))
+	defer v2.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{backendInstance(t, "orders", v1.URL)})
+	instance := backendInstance(t, "orders-v2", v2.URL)
+	instance.Metadata = map[string]string{"prefixes": "/orders", "content_types": "application/vnd.myapp.v2+json"}
+	s.UpdateServiceInstances("orders-v2", []discovery.ServiceInstance{instance})
+
+	for contentType, want := range map[string]string{
+		"application/vnd.myapp.v2+json": "v2",
+		"application/json":              "v1",
+	} {
+		req := httptest.NewRequest("POST", "/orders/", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Body.String() != want {
+			t.Errorf("Content-Type %s: expected %s, got %q", contentType, want, rec.Body.String())
+		}
+	}
+}
+
+func TestRouteAndServiceLimits(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Limits.MaxRoutes = 2
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{
+		{ID: "api-1", Service: "api", Address: "10.0.0.1", Port: 8080, Metadata: map[string]string{"prefixes": "/v1,/v2"}},
+	})
+	if routes := s.router.Routes(); len(routes) != 2 {
+		t.Errorf("Expected the route table capped at 2, got %v", routes)
+	}
+	s.UpdateServiceInstances("web", []discovery.ServiceInstance{
+		{ID: "web-1", Service: "web", Address: "10.0.0.2", Port: 8080},
+	})
+	if s.GetLoadBalancer("web") != nil {
+		t.Error("Expected a service without room for a route not to be created")
+	}
+
+	disc, err := discovery.New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer disc.Leave(time.Second)
+	disc.SetMaxServices(1)
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	s, err = New(cfg, disc, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"id":"orders-1","service":"orders","address":"10.0.0.1","port":8080}`, http.StatusCreated},
+		{`{"id":"web-1","service":"web","address":"10.0.0.2","port":8080}`, http.StatusInsufficientStorage},
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/services/register", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.body, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestServiceDisableEnable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"orders": {DisabledStatus: http.StatusGone}}
+	s.UpdateServiceInstances("catalog", []discovery.ServiceInstance{backendInstance(t, "catalog", backend.URL)})
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{backendInstance(t, "orders", backend.URL)})
+
+	toggle := func(service, action string) int {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/services/"+service+"/"+action, nil))
+		return rec.Code
+	}
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	if code := toggle("catalog", "disable"); code != http.StatusOK {
+		t.Fatalf("Expected 200 disabling the service, got %d", code)
+	}
+	toggle("orders", "disable")
+	if code := get("/catalog/items"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 from a disabled service, got %d", code)
+	}
+	if code := get("/orders/1"); code != http.StatusGone {
+		t.Errorf("Expected the configured disabled_status, got %d", code)
+	}
+
+	// * discovery updates don't bring it back
+	s.UpdateServiceInstances("catalog", []discovery.ServiceInstance{backendInstance(t, "catalog", backend.URL)})
+	if code := get("/catalog/items"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the service to stay disabled across updates, got %d", code)
+	}
+
+	toggle("catalog", "enable")
+	if code := get("/catalog/items"); code != http.StatusOK {
+		t.Errorf("Expected 200 once re-enabled, got %d", code)
+	}
+
+	if code := toggle("unknown", "disable"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown service, got %d", code)
+	}
+	if code := toggle("catalog", "pause"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown action, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/services/catalog/disable", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestReservedServiceNames(t *testing.T) {
+	disc, err := discovery.New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer disc.Leave(time.Second)
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	cfg.Cluster.ReservedNames = []string{"admin"}
+	cfg.Cluster.ReservedPrefixes = []string{"internal-"}
+	s, err := New(cfg, disc, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	register := func(service string) (int, map[string]any) {
+		body := `{"id":"` + service + `-1","service":"` + service + `","address":"10.0.0.1","port":8080}`
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/services/register", strings.NewReader(body)))
+		var resp map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	for service, want := range map[string]map[string]any{
+		"api":              {"type": "name", "value": "api"},
+		"_private":         {"type": "prefix", "value": "_"},
+		"admin":            {"type": "name", "value": "admin"},
+		"internal-billing": {"type": "prefix", "value": "internal-"},
+	} {
+		code, resp := register(service)
+		if code != http.StatusBadRequest || resp["service"] != service || !reflect.DeepEqual(resp["conflict"], want) {
+			t.Errorf("Expected %s to be rejected with conflict %v, got %d %v", service, want, code, resp)
+		}
+	}
+
+	if code, _ := register("billing"); code != http.StatusCreated {
+		t.Errorf("Expected an unreserved name to register, got %d", code)
+	}
+}
+
+func TestRegistrationRejectedMetric(t *testing.T) {
+	disc, err := discovery.New(0, "")
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	defer disc.Leave(time.Second)
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	s, err := New(cfg, disc, 0)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	register := func(method, body string) int {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(method, "/api/v1/services/register", strings.NewReader(body)))
+		return rec.Code
+	}
+	expectRejected := func(method, body string, status int, reason string) {
+		t.Helper()
+		before := testutil.ToFloat64(metrics.RegistrationRejected.WithLabelValues(reason))
+		if code := register(method, body); code != status {
+			t.Errorf("%s %s: expected %d, got %d", method, body, status, code)
+		}
+		if got := testutil.ToFloat64(metrics.RegistrationRejected.WithLabelValues(reason)) - before; got != 1 {
+			t.Errorf("%s %s: expected one %s rejection, got %v", method, body, reason, got)
+		}
+	}
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+		reason string
+	}{
+		{"GET", "", http.StatusMethodNotAllowed, "method-not-allowed"},
+		{"POST", `{"id":`, http.StatusBadRequest, "invalid-json"},
+		{"POST", `{"id":"api-1","service":"api","address":"10.0.0.1","port":8080}`, http.StatusBadRequest, "reserved-name"},
+		{"POST", `{"id":"orders-1","service":"orders","port":8080}`, http.StatusBadRequest, "missing-field"},
+		{"POST", `{"id":"orders-1","service":"orders","address":"http://10.0.0.1","port":8080}`, http.StatusBadRequest, "invalid-address"},
+		{"POST", `{"id":"orders-1","service":"orders","address":"10.0.0.1:8080","port":8080}`, http.StatusBadRequest, "invalid-address"},
+		{"POST", `{"id":"orders-1","service":"orders","address":"[::1","port":8080}`, http.StatusBadRequest, "invalid-address"},
+		{"POST", `{"id":"orders-1","service":"orders","address":"10.0.0.1","port":70000}`, http.StatusBadRequest, "invalid-address"},
+	}
+	for _, tt := range tests {
+		expectRejected(tt.method, tt.body, tt.status, tt.reason)
+	}
+
+	addresses := []string{"10.0.0.1", "fd00::1", "[::1]", "fe80::1%eth0", "[fe80::1%eth0]", "orders.internal", "orders_v2"}
+	for i, address := range addresses {
+		body := `{"id":"orders-` + strconv.Itoa(i) + `","service":"orders","address":"` + address + `","port":8080}`
+		if code := register("POST", body); code != http.StatusCreated {
+			t.Errorf("Expected %s to register, got %d", address, code)
+		}
+	}
+
+	// * the route limit counts the orders route once discovery has published it
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.router.Routes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	billing := `{"id":"billing-1","service":"billing","address":"10.0.0.2","port":8080}`
+	s.mu.Lock()
+	s.config.Limits.MaxRoutes = 1
+	s.mu.Unlock()
+	expectRejected("POST", billing, http.StatusInsufficientStorage, "route-limit")
+
+	s.mu.Lock()
+	s.config.Cluster.ReadOnly = true
+	s.mu.Unlock()
+	expectRejected("POST", billing, http.StatusForbidden, "read-only")
+}
+
+func TestUpgradeToOtherProtocols(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "custom/1" {
+			w.Header().Set("X-Refused", r.Header.Get("Upgrade"))
+			w.Header().Set("Upgrade", "custom/1")
+			w.Header().Set("Connection", "Upgrade, X-Hop")
+			w.Header().Set("X-Hop", "backend")
+			http.Error(w, "unsupported protocol", http.StatusBadRequest)
+			return
+		}
+		conn, buffered, _ := http.NewResponseController(w).Hijack()
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: custom/1\r\nConnection: Upgrade\r\nX-Session: 42\r\n\r\nhello\n")
+		buffered.Flush()
+		line, _ := buffered.ReadString('\n')
+		conn.Write([]byte("echo " + line))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.UpdateServiceInstances("tunnel", []discovery.ServiceInstance{backendInstance(t, "tunnel", backend.URL)})
+	gateway := httptest.NewServer(s.Handler())
+	defer gateway.Close()
+
+	upgrade := func(protocol string) (*http.Response, *bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET /tunnel/ HTTP/1.1\r\nHost: gateway\r\nUpgrade: "+protocol+"\r\nConnection: keep-alive, Upgrade\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read the upgrade response: %v", err)
+		}
+		return resp, reader, conn
+	}
+
+	resp, reader, conn := upgrade("custom/1")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("X-Session") != "42" {
+		t.Fatalf("Expected the backend's 101 to be relayed, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if line, _ := reader.ReadString('\n'); line != "hello\n" {
+		t.Errorf("Expected data sent with the 101 to reach the client, got %q", line)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "echo ping\n" {
+		t.Errorf("Expected the tunnel to relay data, got %q", line)
+	}
+
+	resp, _, refused := upgrade("h2c")
+	defer refused.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("X-Refused") != "h2c" || !strings.Contains(string(body), "unsupported protocol") {
+		t.Errorf("Expected a refused upgrade to pass through, got %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+	if resp.Header.Get("Upgrade") != "" || resp.Header.Get("X-Hop") != "" {
+		t.Errorf("Expected the backend's hop-by-hop headers to be stripped, got %v", resp.Header)
+	}
+}
+
+func TestRouteWithoutLoadBalancer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	withTest := *s.config
+	withTest.ABTests = map[string]config.ABTestConfig{"checkout": {Buckets: []config.ABBucket{{Name: "variant", Service: "checkout-v2", Weight: 1}}}}
+	withoutTest := *s.config
+	handler := s.Handler()
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	// * the bucket's service hasn't registered, so there is nothing to route to
+	s.UpdateConfig(&withTest)
+	if code := get("/checkout/cart"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a service without a load balancer, got %d", code)
+	}
+
+	// * routes and load balancers change while requests are in flight
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			service := fmt.Sprintf("svc-%d", i)
+			s.UpdateServiceInstances(service, []discovery.ServiceInstance{backendInstance(t, service, backend.URL)})
+			if i%2 == 0 {
+				s.UpdateConfig(&withoutTest)
+			} else {
+				s.UpdateConfig(&withTest)
+			}
+		}
+		close(done)
+	}()
+
+	var failures atomic.Int64
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				path := fmt.Sprintf("/svc-%d/", (i+w)%50)
+				if i%2 == 0 {
+					path = "/checkout/cart"
+				}
+				if code := get(path); code == http.StatusInternalServerError {
+					failures.Add(1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("Expected no 500s while routes and load balancers change, got %d", n)
+	}
+}
+
+func TestNoRouteAndNoBackendMetrics(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Metrics.Hosts = []string{"shop.example.com"}
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{backendInstance(t, "orders", "http://127.0.0.1:1")})
+	s.GetLoadBalancer("orders").Backends()[0].Active = false
+	s.router.SetMethods("orders", []string{"GET"})
+
+	noRoute := metrics.NoRoute.WithLabelValues("no_match", "shop.example.com")
+	noRouteOther := metrics.NoRoute.WithLabelValues("no_match", "other")
+	noMethod := metrics.NoRoute.WithLabelValues("method_not_allowed", "other")
+	noBackend := metrics.NoBackend.WithLabelValues("orders")
+	noRouteBefore, noBackendBefore := testutil.ToFloat64(noRoute), testutil.ToFloat64(noBackend)
+	noRouteOtherBefore, noMethodBefore := testutil.ToFloat64(noRouteOther), testutil.ToFloat64(noMethod)
+
+	// * unlisted hosts share one series, whatever the client sends
+	for _, target := range []string{"http://Shop.example.com:8080/nowhere/else", "http://scan-1.invalid/a", "http://scan-2.invalid/b"} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", target, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/orders/1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a method the route lacks, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/orders/1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+
+	if got := testutil.ToFloat64(noRoute) - noRouteBefore; got != 1 {
+		t.Errorf("Expected one unrouted request to the listed host, got %v", got)
+	}
+	if got := testutil.ToFloat64(noRouteOther) - noRouteOtherBefore; got != 2 {
+		t.Errorf("Expected two unrouted requests to other hosts, got %v", got)
+	}
+	if got := testutil.ToFloat64(noMethod) - noMethodBefore; got != 1 {
+		t.Errorf("Expected one request with a method no route allows, got %v", got)
+	}
+	if got := testutil.ToFloat64(noBackend) - noBackendBefore; got != 1 {
+		t.Errorf("Expected one request without a backend, got %v", got)
+	}
+}
+
+func TestConfigDryRunEndpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fluxgate.yaml")
+	if err := os.WriteFile(path, []byte("timeouts:\n  read: 30s\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	manager := config.NewManager()
+	if err := manager.Load(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	s := newTestServer(t)
+	dryRun := func() (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/config/dryrun", nil))
+		var body map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	if code, _ := dryRun(); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a config manager, got %d", code)
+	}
+	s.SetConfigManager(manager)
+
+	os.WriteFile(path, []byte("timeouts:\n  read: 45s\n"), 0644)
+	code, body := dryRun()
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	changes, _ := body["changes"].([]any)
+	if len(changes) != 1 || changes[0].(map[string]any)["path"] != "timeouts.read" {
+		t.Errorf("Expected the read timeout change, got %v", body["changes"])
+	}
+
+	os.WriteFile(path, []byte("timeouts:\n  read: -1s\n"), 0644)
+	if code, body := dryRun(); code != http.StatusUnprocessableEntity || body["valid"] != false {
+		t.Errorf("Expected 422 for an invalid candidate, got %d %v", code, body)
+	}
+}
+
+func TestPerListenerTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	freePort := func() int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to find a free port: %v", err)
+		}
+		defer ln.Close()
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	plainPort, tlsPort := freePort(), freePort()
+
+	cfg, _ := config.Load("non-existent-file.yaml")
+	cfg.TLS = &config.TLS{
+		CertPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		KeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+	cfg.Server.Listeners = []config.ListenerConfig{{Port: plainPort}, {Port: tlsPort, TLS: true}}
+	s, err := New(cfg, nil, cfg.Server.Port)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, Timeout: time.Second}
+	get := func(url string) (*http.Response, error) {
+		var resp *http.Response
+		var err error
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if resp, err = client.Get(url); err == nil {
+				resp.Body.Close()
+				return resp, nil
+			}
+		}
+		return nil, err
+	}
+
+	resp, err := get(fmt.Sprintf("http://127.0.0.1:%d/api/v1/health", plainPort))
+	if err != nil || resp.TLS != nil {
+		t.Errorf("Expected plaintext on port %d, got %v", plainPort, err)
+	}
+	resp, err = get(fmt.Sprintf("https://127.0.0.1:%d/api/v1/health", tlsPort))
+	if err != nil || resp.TLS == nil {
+		t.Errorf("Expected TLS on port %d, got %v", tlsPort, err)
+	}
+	if resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/api/v1/health", tlsPort)); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected plaintext to the TLS listener to be refused, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestUpstreamTruncated(t *testing.T) {
+	dropAfter := func(raw string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			buf.WriteString(raw)
+			buf.Flush()
+			conn.Close()
+		}))
+	}
+	headersOnly := dropAfter("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+	defer headersOnly.Close()
+	partial := dropAfter("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial")
+	defer partial.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"retried": {Retries: 1}}
+	s.UpdateServiceInstances("flaky", []discovery.ServiceInstance{backendInstance(t, "flaky", headersOnly.URL)})
+	s.UpdateServiceInstances("partial", []discovery.ServiceInstance{backendInstance(t, "partial", partial.URL)})
+	dropping, working := backendInstance(t, "retried", headersOnly.URL), backendInstance(t, "retried", healthy.URL)
+	working.ID = "retried-2"
+	s.UpdateServiceInstances("retried", []discovery.ServiceInstance{dropping, working})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	beforeBody := metrics.UpstreamTruncated.WithLabelValues("flaky", "before_body")
+	midBody := metrics.UpstreamTruncated.WithLabelValues("partial", "mid_body")
+	beforeBodyStart, midBodyStart := testutil.ToFloat64(beforeBody), testutil.ToFloat64(midBody)
+
+	if rec := get("/flaky/"); rec.Code != http.StatusBadGateway || strings.Contains(rec.Body.String(), "partial") {
+		t.Errorf("Expected a clean 502 when no body was sent, got %d %q", rec.Code, rec.Body.String())
+	}
+	if got := testutil.ToFloat64(beforeBody) - beforeBodyStart; got != 1 {
+		t.Errorf("Expected one truncation before the body, got %v", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get("/retried/"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("Expected the GET retried on the healthy backend, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	// * once the body started there is nothing left to do but report it
+	if rec := get("/partial/"); rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("Expected the partial response as sent, got %d %q", rec.Code, rec.Body.String())
+	}
+	if got := testutil.ToFloat64(midBody) - midBodyStart; got != 1 {
+		t.Errorf("Expected one truncation mid-body, got %v", got)
+	}
+}
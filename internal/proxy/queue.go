@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+var (
+	errQueueFull    = errors.New("request queue full")
+	errQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// requestQueue is the bounded queue of requests waiting for a connection slot
+// on one service's backends. Each released connection wakes the waiting
+// request with the earliest virtual finish time: with fair_queue, requests
+// of a tenant finish 1/weight apart, so tenants are woken in proportion to
+// their weights however many requests each has queued. Without it, every
+// request is the same tenant and the queue is FIFO.
+type requestQueue struct {
+	service string
+	mu      sync.Mutex
+	waiters []*queuedRequest
+	// virtual is the finish time of the last woken request, lastFinish that
+	// of each tenant's newest queued request
+	virtual    float64
+	lastFinish map[string]float64
+	// depths counts waiters by tenant label for the metrics
+	depths map[string]int
+}
+
+// queuedRequest is a request waiting in a requestQueue. label is the tenant
+// as reported in metrics, empty without fair_queue.
+type queuedRequest struct {
+	wake   chan struct{}
+	tenant string
+	label  string
+	finish float64
+}
+
+func newRequestQueue(service string) *requestQueue {
+	return &requestQueue{
+		service:    service,
+		lastFinish: make(map[string]float64),
+		depths:     make(map[string]int),
+	}
+}
+
+// report publishes the queue depth, the caller holds q.mu.
+func (q *requestQueue) report(label string, delta int) {
+	metrics.QueueDepth.WithLabelValues(q.service).Set(float64(len(q.waiters)))
+	if label == "" {
+		return
+	}
+	q.depths[label] += delta
+	metrics.TenantQueueDepth.WithLabelValues(q.service, label).Set(float64(q.depths[label]))
+	if q.depths[label] == 0 {
+		delete(q.depths, label)
+	}
+}
+
+func (q *requestQueue) push(req *queuedRequest, weight int, maxDepth int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) >= maxDepth {
+		return false
+	}
+	req.finish = max(q.virtual, q.lastFinish[req.tenant]) + 1/float64(weight)
+	q.lastFinish[req.tenant] = req.finish
+	q.waiters = append(q.waiters, req)
+	q.report(req.label, 1)
+	return true
+}
+
+// pushFront puts a woken waiter that lost the slot back at the head, keeping
+// its finish time.
+func (q *requestQueue) pushFront(req *queuedRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waiters = append([]*queuedRequest{req}, q.waiters...)
+	q.report(req.label, 1)
+}
+
+func (q *requestQueue) remove(req *queuedRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiter := range q.waiters {
+		if waiter == req {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.report(req.label, -1)
+			return
+		}
+	}
+}
+
+// notify wakes the waiter with the earliest finish time, if any.
+func (q *requestQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) == 0 {
+		return
+	}
+	next := 0
+	for i, waiter := range q.waiters {
+		if waiter.finish < q.waiters[next].finish {
+			next = i
+		}
+	}
+	req := q.waiters[next]
+	q.waiters = append(q.waiters[:next], q.waiters[next+1:]...)
+	q.virtual = max(q.virtual, req.finish)
+	// * tenants with nothing queued past now start afresh
+	for tenant, finish := range q.lastFinish {
+		if finish <= q.virtual {
+			delete(q.lastFinish, tenant)
+		}
+	}
+	q.report(req.label, -1)
+	req.wake <- struct{}{}
+}
+
+func (s *Server) queueFor(serviceName string) *requestQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, exists := s.queues[serviceName]
+	if !exists {
+		q = newRequestQueue(serviceName)
+		s.queues[serviceName] = q
+	}
+	return q
+}
+
+// releaseBackend returns a connection slot and hands it to the next queued
+// request of the service.
+func (s *Server) releaseBackend(serviceName string, lb loadbalancer.LoadBalancer, backend *loadbalancer.Backend) {
+	lb.ReleaseConnection(backend)
+
+	s.mu.RLock()
+	q := s.queues[serviceName]
+	s.mu.RUnlock()
+
+	if q != nil {
+		q.notify()
+	}
+}
+
+// saturated reports whether a backend of lb is at its connection cap, which is
+// the only case where waiting for a slot can help.
+func saturated(lb loadbalancer.LoadBalancer) bool {
+	for _, backend := range lb.Backends() {
+		if backend.AtCapacity() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForBackend queues r until a backend of the service has a free slot. It
+// returns nil without an error when the service doesn't queue or queueing
+// can't help.
+func (s *Server) waitForBackend(r *http.Request, serviceName string, lb loadbalancer.LoadBalancer) (*loadbalancer.Backend, error) {
+	s.mu.RLock()
+	queueCfg := s.config.QueueFor(serviceName)
+	fairQueue := s.config.Service(serviceName).FairQueue
+	s.mu.RUnlock()
+
+	if queueCfg.MaxDepth == 0 || !saturated(lb) {
+		return nil, nil
+	}
+
+	req := &queuedRequest{wake: make(chan struct{}, 1)}
+	weight := 1
+	if fairQueue != nil {
+		req.tenant = r.Header.Get(fairQueue.Header)
+		req.label = fairQueue.TenantLabel(req.tenant)
+		weight = fairQueue.Weight(req.tenant)
+	}
+
+	start := time.Now()
+	observe := func(result string) {
+		metrics.QueueWait.WithLabelValues(serviceName, result).Observe(time.Since(start).Seconds())
+	}
+
+	q := s.queueFor(serviceName)
+	if !q.push(req, weight, queueCfg.MaxDepth) {
+		observe("rejected")
+		return nil, errQueueFull
+	}
+
+	defer func() {
+		q.remove(req)
+		// * pass on a wake-up that arrived as we gave up
+		select {
+		case <-req.wake:
+			q.notify()
+		default:
+		}
+	}()
+
+	timer := time.NewTimer(queueCfg.Timeout)
+	defer timer.Stop()
+
+	// * a slot may have freed up before we were queued
+	if backend := lb.Next(); backend != nil {
+		observe("admitted")
+		return backend, nil
+	}
+
+	for {
+		select {
+		case <-req.wake:
+			if backend := lb.Next(); backend != nil {
+				observe("admitted")
+				return backend, nil
+			}
+			q.pushFront(req)
+		case <-timer.C:
+			observe("timeout")
+			return nil, errQueueTimeout
+		case <-r.Context().Done():
+			observe("cancelled")
+			return nil, r.Context().Err()
+		}
+	}
+}
+
+// countTenantAdmission counts a request of a service with fair_queue that got
+// a backend connection slot, by tenant.
+func (s *Server) countTenantAdmission(r *http.Request, serviceName string) {
+	s.mu.RLock()
+	fairQueue := s.config.Service(serviceName).FairQueue
+	s.mu.RUnlock()
+
+	if fairQueue != nil {
+		metrics.TenantAdmitted.WithLabelValues(serviceName, fairQueue.TenantLabel(r.Header.Get(fairQueue.Header))).Inc()
+	}
+}
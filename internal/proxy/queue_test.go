@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestRequestQueue(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Queue.MaxDepth = 1
+	s.config.Queue.Timeout = 5 * time.Second
+	instance := backendInstance(t, "queued", backend.URL)
+	instance.Metadata = map[string]string{"max_connections": "1"}
+	s.UpdateServiceInstances("queued", []discovery.ServiceInstance{instance})
+
+	serve := func(path string) <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			code <- rec.Code
+		}()
+		return code
+	}
+	waitForDepth := func(depth int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			q := s.queueFor("queued")
+			q.mu.Lock()
+			n := len(q.waiters)
+			q.mu.Unlock()
+			if n == depth {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected queue depth %d, got %d", depth, n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	slow := serve("/queued/slow")
+	for !s.GetLoadBalancer("queued").Backends()[0].AtCapacity() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	queued := serve("/queued/fast")
+	waitForDepth(1)
+
+	if code := <-serve("/queued/fast"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with a full queue, got %d", code)
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("Expected 200 for the slow request, got %d", code)
+	}
+	if code := <-queued; code != http.StatusOK {
+		t.Errorf("Expected the queued request to proceed, got %d", code)
+	}
+}
+
+func TestRequestQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	s := newTestServer(t)
+	s.config.Queue.MaxDepth = 5
+	s.config.Queue.Timeout = 50 * time.Millisecond
+	instance := backendInstance(t, "queued", backend.URL)
+	instance.Metadata = map[string]string{"max_connections": "1"}
+	s.UpdateServiceInstances("queued", []discovery.ServiceInstance{instance})
+
+	go s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/queued/", nil))
+	for !s.GetLoadBalancer("queued").Backends()[0].AtCapacity() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/queued/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after the queue timeout, got %d", rec.Code)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Expected the request to wait for the timeout, waited %v", waited)
+	}
+}
+
+func TestFairQueueOrder(t *testing.T) {
+	q := newRequestQueue("shared")
+	weights := map[string]int{"bulk": 1, "interactive": 3}
+
+	// * bulk floods the queue before interactive arrives
+	var waiters []*queuedRequest
+	for _, tenant := range []string{"bulk", "bulk", "bulk", "bulk", "bulk", "interactive", "interactive", "interactive"} {
+		req := &queuedRequest{wake: make(chan struct{}, 1), tenant: tenant}
+		if !q.push(req, weights[tenant], 10) {
+			t.Fatalf("Expected room in the queue")
+		}
+		waiters = append(waiters, req)
+	}
+
+	var order []string
+	for range waiters {
+		q.notify()
+		for _, req := range waiters {
+			select {
+			case <-req.wake:
+				order = append(order, req.tenant)
+			default:
+			}
+		}
+	}
+
+	want := []string{"interactive", "interactive", "bulk", "interactive", "bulk", "bulk", "bulk", "bulk"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected tenants woken in weighted order %v, got %v", want, order)
+	}
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// ready reports whether the node is past its startup grace: discovery synced
+// with the cluster and its state reached the load balancers, or the grace
+// expired. Otherwise it also returns how much of the grace is left.
+func (s *Server) ready() (bool, time.Duration) {
+	s.mu.RLock()
+	grace := s.config.Cluster.StartupGrace
+	s.mu.RUnlock()
+
+	remaining := grace - time.Since(s.started)
+	if grace == 0 || remaining <= 0 || s.synced.Load() {
+		return true, 0
+	}
+	return false, remaining
+}
+
+// degradedServices returns the readiness critical services with more than
+// max_unhealthy_percent of their backends out of rotation, recording each
+// one's unhealthy share.
+func (s *Server) degradedServices() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	readiness := s.config.Readiness
+	// * services dropped from the list by a reload stop being reported
+	metrics.CriticalUnhealthy.Reset()
+	var degraded []string
+	for _, name := range readiness.CriticalServices {
+		unhealthy := 1.0
+		if lb, exists := s.loadBalancers[name]; exists {
+			if backends := lb.Backends(); len(backends) > 0 {
+				down := 0
+				for _, backend := range backends {
+					if !backend.Active {
+						down++
+					}
+				}
+				unhealthy = float64(down) / float64(len(backends))
+			}
+		}
+		metrics.CriticalUnhealthy.WithLabelValues(name).Set(unhealthy)
+		if unhealthy*100 > readiness.MaxUnhealthyPercent {
+			degraded = append(degraded, name)
+		}
+	}
+	return degraded
+}
+
+// readiness evaluates what /api/v1/ready reports: "ready", "starting" with
+// the startup grace left, or "degraded" with the critical services at fault.
+// It keeps the ready metric current and logs changes.
+func (s *Server) readiness() (string, time.Duration, []string) {
+	ready, remaining := s.ready()
+	degraded := s.degradedServices()
+
+	status := "ready"
+	switch {
+	case !ready:
+		status = "starting"
+	case len(degraded) > 0:
+		status = "degraded"
+	}
+
+	if status == "ready" {
+		metrics.Ready.Set(1)
+	} else {
+		metrics.Ready.Set(0)
+	}
+	if previous, _ := s.readinessStatus.Swap(status).(string); previous != "" && previous != status {
+		if status == "degraded" {
+			log.Printf("WARNING: Not ready, critical services degraded: %v", degraded)
+		} else {
+			log.Printf("Readiness changed from %s to %s", previous, status)
+		}
+	}
+	return status, remaining, degraded
+}
+
+// StartReadinessChecks re-evaluates readiness every health check interval
+// until ctx is cancelled, so the metric and log follow backend health without
+// anyone polling /api/v1/ready.
+func (s *Server) StartReadinessChecks(ctx context.Context) {
+	s.mu.RLock()
+	interval := s.config.HealthCheck.Interval
+	s.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.readiness()
+		}
+	}
+}
+
+// retryAfter formats d as whole seconds for a Retry-After header, at least 1.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// rejectUntilReady answers r with 503 while cluster.startup_reject holds
+// traffic back during the startup grace, reporting whether it did.
+func (s *Server) rejectUntilReady(w http.ResponseWriter) bool {
+	s.mu.RLock()
+	reject := s.config.Cluster.StartupReject
+	s.mu.RUnlock()
+
+	if !reject {
+		return false
+	}
+	ready, remaining := s.ready()
+	if ready {
+		return false
+	}
+
+	w.Header().Set("Retry-After", retryAfter(remaining))
+	http.Error(w, "Starting up", http.StatusServiceUnavailable)
+	return true
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, remaining, degraded := s.readiness()
+	body := map[string]any{
+		"status":    status,
+		"timestamp": time.Now().Unix(),
+	}
+	code := http.StatusOK
+	switch status {
+	case "starting":
+		code = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", retryAfter(remaining))
+	case "degraded":
+		code = http.StatusServiceUnavailable
+		body["degraded_services"] = degraded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
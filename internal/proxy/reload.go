@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// SetConfigManager lets POST /api/v1/config/dryrun preview the config files
+// manager loaded from. Without it the endpoint answers 404.
+func (s *Server) SetConfigManager(manager *config.Manager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configManager = manager
+}
+
+// handleConfigDryRun loads and validates the config files as they are on disk
+// and lists what reloading them would change, without applying anything. An
+// invalid candidate is answered with 422 and the error.
+func (s *Server) handleConfigDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	manager := s.configManager
+	s.mu.RUnlock()
+	if manager == nil {
+		http.Error(w, "No config files to preview", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	changes, err := manager.DryRun()
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"valid": false, "error": err.Error()})
+		return
+	}
+	if changes == nil {
+		changes = []config.Change{}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"valid": true, "changes": changes})
+}
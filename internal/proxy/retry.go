@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// retryBudget limits retries to a share of recent traffic so a widespread
+// outage doesn't multiply load on the backends that are left.
+type retryBudget struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+func (b *retryBudget) roll(now time.Time, window time.Duration) {
+	if now.Sub(b.windowStart) >= window {
+		b.windowStart = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+func (b *retryBudget) recordRequest(cfg config.RetryBudgetConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(time.Now(), cfg.Window)
+	b.requests++
+	b.report(cfg)
+}
+
+// allowRetry spends one retry from the budget when there is room for it.
+func (b *retryBudget) allowRetry(cfg config.RetryBudgetConfig) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(time.Now(), cfg.Window)
+	if b.retries >= b.allowed(cfg) {
+		return false
+	}
+	b.retries++
+	b.report(cfg)
+	return true
+}
+
+func (b *retryBudget) allowed(cfg config.RetryBudgetConfig) int {
+	allowed := int(float64(b.requests) * cfg.Ratio)
+	if allowed < cfg.MinRetries {
+		allowed = cfg.MinRetries
+	}
+	return allowed
+}
+
+func (b *retryBudget) report(cfg config.RetryBudgetConfig) {
+	if allowed := b.allowed(cfg); allowed > 0 {
+		metrics.RetryBudgetUtilization.Set(float64(b.retries) / float64(allowed))
+	}
+}
+
+type retryStateKey struct{}
+
+// retryState lets proxyErrorHandler hand a retryable failure back to the
+// request loop instead of writing the error response.
+type retryState struct {
+	service   string
+	retryable bool
+	err       error
+	// tryTimer cancels the attempt once its try_timeout passes without
+	// response headers
+	tryTimer *time.Timer
+}
+
+var (
+	// errRetryTimeout cancels a request whose retry_timeout is spent
+	errRetryTimeout = errors.New("retry timeout exceeded")
+	// errTryTimeout cancels an attempt that got no response headers within
+	// its try_timeout
+	errTryTimeout = errors.New("try timeout exceeded")
+)
+
+func retryStateFrom(ctx context.Context) *retryState {
+	state, _ := ctx.Value(retryStateKey{}).(*retryState)
+	return state
+}
+
+// takeRetry reports whether a failed attempt should be retried, which is only
+// the case when the request never reached the backend, or it is safe to repeat
+// and the backend dropped it before any of the response body, and budget is
+// left.
+func (s *Server) takeRetry(r *http.Request, reason string, err error) bool {
+	state := retryStateFrom(r.Context())
+	if state == nil || !state.retryable {
+		return false
+	}
+	switch reason {
+	case "connect_error", "connect_timeout", "try_timeout":
+	case "truncated":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			return false
+		}
+	default:
+		return false
+	}
+	return s.spendRetry(state, err)
+}
+
+// errRetryStatus hands a response with one of its service's retry_on statuses
+// back to the request loop before any of it is written to the client.
+var errRetryStatus = errors.New("backend answered with a retryable status")
+
+// takeStatusRetry reports whether resp should be discarded and its request
+// retried because the service retries on its status and budget is left.
+func (s *Server) takeStatusRetry(resp *http.Response) bool {
+	state := retryStateFrom(resp.Request.Context())
+	if state == nil || !state.retryable {
+		return false
+	}
+
+	s.mu.RLock()
+	retryOn := s.config.Service(state.service).RetryOn
+	s.mu.RUnlock()
+
+	if !slices.Contains(retryOn, resp.StatusCode) {
+		return false
+	}
+	if !s.spendRetry(state, fmt.Errorf("%w %d", errRetryStatus, resp.StatusCode)) {
+		return false
+	}
+	log.Printf("Retrying %s %s for service %s after status %d from %s", resp.Request.Method, resp.Request.URL.Path, state.service, resp.StatusCode, resp.Request.URL.Host)
+	return true
+}
+
+// spendRetry takes a retry from the budget and records err as the reason for it.
+func (s *Server) spendRetry(state *retryState, err error) bool {
+	s.mu.RLock()
+	budgetCfg := s.config.RetryBudget
+	s.mu.RUnlock()
+
+	if !s.retryBudget.allowRetry(budgetCfg) {
+		metrics.Retries.WithLabelValues(state.service, "budget_exhausted").Inc()
+		return false
+	}
+
+	metrics.Retries.WithLabelValues(state.service, "attempted").Inc()
+	state.err = err
+	return true
+}
+
+// hasUntried reports whether lb has a healthy backend not in tried.
+func hasUntried(lb loadbalancer.LoadBalancer, tried map[string]bool) bool {
+	for _, backend := range lb.Backends() {
+		if backend.Active && !tried[backend.URL.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// serveWithRetries proxies r to backend and, for bodyless requests of services
+// with retries configured, to backends it hasn't tried yet when connecting
+// fails, an attempt exceeds try_timeout or the backend answers with a retry_on
+// status, until retry_timeout is spent. It returns the backend of the last
+// attempt.
+func (s *Server) serveWithRetries(w *responseWriter, r *http.Request, serviceName string, lb loadbalancer.LoadBalancer, backend *loadbalancer.Backend) *loadbalancer.Backend {
+	s.mu.RLock()
+	serviceCfg := s.config.Service(serviceName)
+	budgetCfg := s.config.RetryBudget
+	s.mu.RUnlock()
+
+	retries := serviceCfg.Retries
+	s.retryBudget.recordRequest(budgetCfg)
+
+	// * a consumed body can't be replayed
+	if r.Body != nil && r.Body != http.NoBody {
+		retries = 0
+	}
+
+	if serviceCfg.RetryTimeout > 0 {
+		ctx, cancel := context.WithTimeoutCause(r.Context(), serviceCfg.RetryTimeout, errRetryTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// * retries go to backends this request hasn't failed on yet
+	tried := map[string]bool{backend.URL.String(): true}
+	for attempt := 0; ; attempt++ {
+		// * with no backend left to retry on, the attempt's own outcome stands
+		state := &retryState{service: serviceName, retryable: attempt < retries && hasUntried(lb, tried)}
+		ctx := context.WithValue(r.Context(), retryStateKey{}, state)
+		cancel := func(error) {}
+		if serviceCfg.TryTimeout > 0 {
+			ctx, cancel = context.WithCancelCause(ctx)
+			state.tryTimer = time.AfterFunc(serviceCfg.TryTimeout, func() { cancel(errTryTimeout) })
+		}
+		s.getOrCreateProxy(backend.URL).ServeHTTP(w, r.WithContext(ctx))
+		if state.tryTimer != nil {
+			state.tryTimer.Stop()
+		}
+		cancel(nil)
+
+		if state.err == nil {
+			return backend
+		}
+
+		if errors.Is(context.Cause(r.Context()), errRetryTimeout) {
+			metrics.Retries.WithLabelValues(serviceName, "timeout").Inc()
+			log.Printf("Retry timeout of %v spent on %s %s for service %s after %d attempts", serviceCfg.RetryTimeout, r.Method, r.URL.Path, serviceName, attempt+1)
+			http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+			return backend
+		}
+
+		next := loadbalancer.NextExcluding(lb, func(b *loadbalancer.Backend) bool { return tried[b.URL.String()] })
+		if next == nil {
+			http.Error(w, "No untried healthy backends", http.StatusServiceUnavailable)
+			return backend
+		}
+		backend = next
+		tried[backend.URL.String()] = true
+		defer s.releaseBackend(serviceName, lb, backend)
+
+		metrics.ActiveConnections.WithLabelValues(backend.URL.String()).Inc()
+		defer metrics.ActiveConnections.WithLabelValues(backend.URL.String()).Dec()
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fluxgate/fluxgate/internal/access"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo carries what response rewriting needs to know about the original
+// client request once the path has been rewritten for the backend.
+type requestInfo struct {
+	service string
+	prefix  string
+	host    string
+	scheme  string
+	// staleKey caches the response for on_unavailable "stale"
+	staleKey string
+}
+
+func (s *Server) withRequestInfo(r *http.Request, service, prefix string) *http.Request {
+	info := &requestInfo{service: service, prefix: prefix, host: r.Host, scheme: s.requestScheme(r)}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+// requestScheme is the scheme the client used, as responses rewritten for it
+// must point back at: the X-Forwarded-Proto of a trusted proxy, otherwise
+// that of the connection.
+func (s *Server) requestScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto"))
+	if proto != "http" && proto != "https" {
+		return scheme
+	}
+	s.mu.RLock()
+	trusted := s.config.Server.TrustedProxies
+	s.mu.RUnlock()
+	// * entries were validated with the config
+	proxies, err := access.NewPolicy("", trusted)
+	if err != nil || len(proxies.Allowed) == 0 || !proxies.Allows(r.RemoteAddr) {
+		return scheme
+	}
+	return proto
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// setOutboundHost applies the service's host_header setting. The single-host
+// director only rewrites the URL, so req.Host still carries the client's Host
+// when this runs.
+func (s *Server) setOutboundHost(req *http.Request) {
+	info := requestInfoFrom(req.Context())
+	if info == nil {
+		return
+	}
+
+	s.mu.RLock()
+	hostHeader := s.config.Service(info.service).HostHeader
+	s.mu.RUnlock()
+
+	switch hostHeader {
+	case "", "preserve":
+	case "backend":
+		req.Host = req.URL.Host
+	default:
+		req.Host = hostHeader
+	}
+}
+
+const hopsHeader = "X-FluxGate-Hops"
+
+// countHop increments the hop counter of r and reports whether it has passed
+// through FluxGate more than server.max_hops times. Unparseable counters count
+// as zero.
+func (s *Server) countHop(r *http.Request) bool {
+	s.mu.RLock()
+	maxHops := s.config.Server.MaxHops
+	s.mu.RUnlock()
+
+	if maxHops == 0 {
+		return false
+	}
+	hops, _ := strconv.Atoi(r.Header.Get(hopsHeader))
+	if hops < 0 {
+		hops = 0
+	}
+	if hops >= maxHops {
+		return true
+	}
+	r.Header.Set(hopsHeader, strconv.Itoa(hops+1))
+	return false
+}
+
+// rewriteMethod applies the service's method_rewrite to an outbound request.
+func (s *Server) rewriteMethod(req *http.Request) {
+	info := requestInfoFrom(req.Context())
+	if info == nil {
+		return
+	}
+
+	s.mu.RLock()
+	method, rewrite := s.config.Service(info.service).MethodRewrite[req.Method]
+	s.mu.RUnlock()
+
+	if rewrite {
+		req.Method = method
+	}
+}
+
+// setParamHeaders forwards captured path parameters as headers when the
+// service has param_headers set. Client-supplied headers matching the template
+// are removed first so backends can trust them.
+func (s *Server) setParamHeaders(r *http.Request, serviceName string, params map[string]string) {
+	s.mu.RLock()
+	template := s.config.Service(serviceName).ParamHeaders
+	s.mu.RUnlock()
+
+	if template == "" {
+		return
+	}
+
+	prefix, suffix, _ := strings.Cut(template, "{name}")
+	for key := range r.Header {
+		if len(key) > len(prefix)+len(suffix) &&
+			strings.EqualFold(key[:len(prefix)], prefix) &&
+			strings.EqualFold(key[len(key)-len(suffix):], suffix) {
+			r.Header.Del(key)
+		}
+	}
+
+	for name, value := range params {
+		r.Header.Set(strings.ReplaceAll(template, "{name}", name), value)
+	}
+}
+
+// rewriteLocation points a redirect at the backend (absolute or host-relative)
+// back at the gateway, restoring the stripped service prefix. Redirects to
+// other hosts are left alone.
+func rewriteLocation(location string, backendHost string, info *requestInfo) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if u.IsAbs() {
+		if !strings.EqualFold(u.Host, backendHost) {
+			return location
+		}
+		u.Scheme = info.scheme
+		u.Host = info.host
+	} else if !strings.HasPrefix(u.Path, "/") {
+		// * relative to the current path, already resolves under the prefix
+		return location
+	}
+
+	if u.Path != info.prefix && !strings.HasPrefix(u.Path, info.prefix+"/") {
+		u.Path = info.prefix + u.Path
+		if u.RawPath != "" {
+			u.RawPath = info.prefix + u.RawPath
+		}
+	}
+
+	return u.String()
+}
+
+// rewriteSetCookie adjusts the Path and Domain attributes of a Set-Cookie value
+// while leaving every other attribute as the backend sent it. Host-only cookies
+// stay host-only, which scopes them to the gateway host.
+func rewriteSetCookie(value string, info *requestInfo, domain string) string {
+	parts := strings.Split(value, ";")
+	rewritten := []string{parts[0]}
+	hasPath := false
+
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name, val, _ := strings.Cut(attr, "=")
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "path":
+			hasPath = true
+			attr = "Path=" + prefixCookiePath(strings.TrimSpace(val), info.prefix)
+		case "domain":
+			if domain == "" {
+				continue
+			}
+			attr = "Domain=" + domain
+		}
+		rewritten = append(rewritten, attr)
+	}
+
+	if !hasPath {
+		rewritten = append(rewritten, "Path="+info.prefix)
+	}
+
+	return strings.Join(rewritten, "; ")
+}
+
+func prefixCookiePath(path, prefix string) string {
+	if path == "" || path == "/" {
+		return prefix
+	}
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return path
+	}
+	return prefix + path
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	info := &requestInfo{service: "app", prefix: "/app", host: "gateway.example.com", scheme: "https"}
+
+	tests := []struct {
+		name     string
+		location string
+		want     string
+	}{
+		{"absolute backend URL", "http://backend1:8080/next?x=1", "https://gateway.example.com/app/next?x=1"},
+		{"host-relative path", "/login", "/app/login"},
+		{"already prefixed", "/app/login", "/app/login"},
+		{"external host untouched", "https://auth.example.com/login", "https://auth.example.com/login"},
+		{"relative path untouched", "next", "next"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteLocation(tt.location, "backend1:8080", info); got != tt.want {
+				t.Errorf("rewriteLocation(%q) = %q, want %q", tt.location, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteSetCookie(t *testing.T) {
+	info := &requestInfo{service: "app", prefix: "/app", host: "gateway.example.com", scheme: "https"}
+
+	tests := []struct {
+		name   string
+		cookie string
+		domain string
+		want   string
+	}{
+		{"root path", "session=abc; Path=/; HttpOnly", "", "session=abc; Path=/app; HttpOnly"},
+		{"nested path", "session=abc; Path=/admin; Secure", "", "session=abc; Path=/app/admin; Secure"},
+		{"missing path", "session=abc", "", "session=abc; Path=/app"},
+		{"domain removed", "session=abc; Domain=backend1; Path=/", "", "session=abc; Path=/app"},
+		{"domain replaced", "session=abc; Domain=backend1; Path=/app/x", "example.com", "session=abc; Domain=example.com; Path=/app/x"},
+		{"attributes preserved", "id=1; Max-Age=60; SameSite=Lax; Partitioned; Path=/", "", "id=1; Max-Age=60; SameSite=Lax; Partitioned; Path=/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteSetCookie(tt.cookie, info, tt.domain); got != tt.want {
+				t.Errorf("rewriteSetCookie(%q) = %q, want %q", tt.cookie, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Server.TrustedProxies = []string{"10.0.0.0/8"}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       string
+	}{
+		{"trusted proxy", "10.1.2.3:5000", false, "https", "https"},
+		{"trusted proxy, mixed case", "10.1.2.3:5000", false, "HTTPS", "https"},
+		{"trusted proxy downgrading", "10.1.2.3:5000", true, "http", "http"},
+		{"untrusted client", "203.0.113.7:5000", false, "https", "http"},
+		{"untrusted client over TLS", "203.0.113.7:5000", true, "http", "https"},
+		{"unknown scheme", "10.1.2.3:5000", false, "javascript", "http"},
+		{"no header", "10.1.2.3:5000", true, "", "https"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := s.requestScheme(r); got != tt.want {
+				t.Errorf("requestScheme() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// * without trusted proxies the header is never believed
+	s.config.Server.TrustedProxies = nil
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:5000"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got := s.requestScheme(r); got != "http" {
+		t.Errorf("Expected the header to be ignored without trusted proxies, got %q", got)
+	}
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+)
+
+const rolloutTick = time.Second
+
+// rollout replaces a service's backends with the instances of a new
+// generation (metadata["generation"]). The new generation gets its own load
+// balancer and no traffic until all of its backends are healthy, then its share
+// grows linearly over Ramp. Once at 100% it becomes the service's backends and
+// the old generation is dropped for good.
+type rollout struct {
+	Generation string
+	Ramp       time.Duration
+	Started    time.Time
+	// Promoted is when the new generation first was entirely healthy
+	Promoted time.Time
+	lb       loadbalancer.LoadBalancer
+}
+
+// share is the fraction of requests sent to the new generation at now.
+func (ro *rollout) share(now time.Time) float64 {
+	switch {
+	case ro.Promoted.IsZero():
+		return 0
+	case ro.Ramp <= 0:
+		return 1
+	}
+	share := float64(now.Sub(ro.Promoted)) / float64(ro.Ramp)
+	if share > 1 {
+		share = 1
+	}
+	return share
+}
+
+// healthy reports whether the new generation has backends and all pass their
+// health checks.
+func (ro *rollout) healthy() bool {
+	backends := ro.lb.Backends()
+	for _, backend := range backends {
+		if !backend.Active {
+			return false
+		}
+	}
+	return len(backends) > 0
+}
+
+// partitionGeneration splits instances into those not of generation and those of it.
+func partitionGeneration(instances []discovery.ServiceInstance, generation string) ([]discovery.ServiceInstance, []discovery.ServiceInstance) {
+	var others, matching []discovery.ServiceInstance
+	for _, instance := range instances {
+		if instance.Metadata["generation"] == generation {
+			matching = append(matching, instance)
+		} else {
+			others = append(others, instance)
+		}
+	}
+	return others, matching
+}
+
+// StartRollouts advances rollouts until ctx is cancelled.
+func (s *Server) StartRollouts(ctx context.Context) {
+	ticker := time.NewTicker(rolloutTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.advanceRollouts(time.Now())
+		}
+	}
+}
+
+func (s *Server) advanceRollouts(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for serviceName, ro := range s.rollouts {
+		if ro.Promoted.IsZero() {
+			if ro.healthy() {
+				ro.Promoted = now
+				log.Printf("Rollout of %s generation %s healthy, shifting traffic over %s", serviceName, ro.Generation, ro.Ramp)
+			}
+			continue
+		}
+		if ro.share(now) >= 1 {
+			s.completeRollout(serviceName, ro)
+		}
+	}
+}
+
+// completeRollout makes the new generation the service's load balancer and
+// drops the old backends. The caller must hold s.mu.
+func (s *Server) completeRollout(serviceName string, ro *rollout) {
+	if old, exists := s.loadBalancers[serviceName]; exists {
+		for _, backend := range old.Backends() {
+			key := backend.URL.String()
+			s.healthChecker.RemoveEndpoint(key)
+			s.dropProxy(key)
+		}
+	}
+
+	s.loadBalancers[serviceName] = ro.lb
+	s.generations[serviceName] = ro.Generation
+	delete(s.rollouts, serviceName)
+
+	log.Printf("Rollout of %s generation %s complete, old generation removed", serviceName, ro.Generation)
+}
+
+type rolloutStatus struct {
+	Service    string  `json:"service"`
+	Generation string  `json:"generation"`
+	Ramp       string  `json:"ramp"`
+	Phase      string  `json:"phase"`
+	Share      float64 `json:"share"`
+	Started    int64   `json:"started"`
+}
+
+func (s *Server) handleRollouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleRolloutList(w)
+	case http.MethodPost:
+		s.handleRolloutStart(w, r)
+	case http.MethodDelete:
+		s.handleRolloutUnpin(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRolloutList(w http.ResponseWriter) {
+	now := time.Now()
+
+	s.mu.RLock()
+	pinned := maps.Clone(s.generations)
+	rollouts := make([]rolloutStatus, 0, len(s.rollouts))
+	for serviceName, ro := range s.rollouts {
+		phase := "waiting_for_health"
+		if !ro.Promoted.IsZero() {
+			phase = "ramping"
+		}
+		rollouts = append(rollouts, rolloutStatus{
+			Service:    serviceName,
+			Generation: ro.Generation,
+			Ramp:       ro.Ramp.String(),
+			Phase:      phase,
+			Share:      ro.share(now),
+			Started:    ro.Started.Unix(),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(rollouts, func(i, j int) bool { return rollouts[i].Service < rollouts[j].Service })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"rollouts":    rollouts,
+		"generations": pinned,
+		"timestamp":   now.Unix(),
+	})
+}
+
+// handleRolloutUnpin clears the generation a completed rollout pinned
+// ?service= to, so instances of every generation are routed to again.
+func (s *Server) handleRolloutUnpin(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.URL.Query().Get("service")
+	if serviceName == "" {
+		http.Error(w, "Missing required parameter: service", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if _, running := s.rollouts[serviceName]; running {
+		s.mu.Unlock()
+		http.Error(w, "Rollout in progress", http.StatusConflict)
+		return
+	}
+	generation, pinned := s.generations[serviceName]
+	if !pinned {
+		s.mu.Unlock()
+		http.Error(w, "Service is not pinned to a generation", http.StatusNotFound)
+		return
+	}
+	delete(s.generations, serviceName)
+	active := 0
+	if lb, exists := s.loadBalancers[serviceName]; exists {
+		active = s.reconcileService(serviceName, lb, s.instances[serviceName])
+	}
+	s.mu.Unlock()
+
+	log.Printf("Generation pin of %s to %s cleared, %d instances routed", serviceName, generation, active)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "unpinned",
+		"service":    serviceName,
+		"generation": generation,
+		"instances":  active,
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
+func (s *Server) handleRolloutStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Service    string `json:"service"`
+		Generation string `json:"generation"`
+		Ramp       string `json:"ramp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Service == "" || req.Generation == "" {
+		http.Error(w, "Missing required fields: service, generation", http.StatusBadRequest)
+		return
+	}
+	var ramp time.Duration
+	if req.Ramp != "" {
+		var err error
+		if ramp, err = time.ParseDuration(req.Ramp); err != nil || ramp < 0 {
+			http.Error(w, "Invalid ramp duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	// * the new generation is only promoted once a probe passes, and only
+	// * active health checks retry a failed one
+	if !s.config.HealthCheck.Enabled {
+		s.mu.Unlock()
+		http.Error(w, "Rollouts need health_check.enabled", http.StatusConflict)
+		return
+	}
+	if _, exists := s.loadBalancers[req.Service]; !exists {
+		s.mu.Unlock()
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	if _, running := s.rollouts[req.Service]; running {
+		s.mu.Unlock()
+		http.Error(w, "Rollout already in progress", http.StatusConflict)
+		return
+	}
+	if s.generations[req.Service] == req.Generation {
+		s.mu.Unlock()
+		http.Error(w, "Generation is already live", http.StatusConflict)
+		return
+	}
+	s.rollouts[req.Service] = &rollout{
+		Generation: req.Generation,
+		Ramp:       ramp,
+		Started:    time.Now(),
+		lb:         s.newLoadBalancer(req.Service),
+	}
+	instances := s.instances[req.Service]
+	s.mu.Unlock()
+
+	// * move the new generation's instances onto the rollout's load balancer
+	s.updateLoadBalancerBackends(req.Service, instances)
+
+	log.Printf("Rollout started: %s -> generation %s over %s", req.Service, req.Generation, ramp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     "started",
+		"service":    req.Service,
+		"generation": req.Generation,
+		"ramp":       ramp.String(),
+		"timestamp":  time.Now().Unix(),
+	})
+}
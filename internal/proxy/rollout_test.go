@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestRollout(t *testing.T) {
+	serve := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+	}
+	oldBackend, newBackend := serve("old"), serve("new")
+	defer oldBackend.Close()
+	defer newBackend.Close()
+
+	s := newTestServer(t)
+	oldInstance := backendInstance(t, "app", oldBackend.URL)
+	newInstance := backendInstance(t, "app", newBackend.URL)
+	newInstance.ID = "app-2"
+	newInstance.Metadata = map[string]string{"generation": "v2"}
+	instances := []discovery.ServiceInstance{oldInstance, newInstance}
+	s.UpdateServiceInstances("app", []discovery.ServiceInstance{oldInstance})
+
+	get := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/app/", nil))
+		return rec.Body.String()
+	}
+
+	// * rollouts are gated on probes, which need active health checks
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/rollouts", strings.NewReader(`{"service":"app","generation":"v2","ramp":"1h"}`)))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 without health checks, got %d", rec.Code)
+	}
+	s.config.HealthCheck.Enabled = true
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/rollouts", strings.NewReader(`{"service":"app","generation":"v2","ramp":"1h"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	s.UpdateServiceInstances("app", instances)
+
+	for i := 0; i < 10; i++ {
+		if body := get(); body != "old" {
+			t.Fatalf("Expected the old generation before promotion, got %q", body)
+		}
+	}
+
+	// * wait for the new generation's first probe
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.healthChecker.mu.RLock()
+		endpoint := s.healthChecker.endpoints[newBackend.URL]
+		s.healthChecker.mu.RUnlock()
+		if endpoint != nil && !endpoint.pending.Load() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("New generation was never probed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	now := time.Now()
+	s.advanceRollouts(now)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/rollouts", nil))
+	var status struct {
+		Rollouts []rolloutStatus `json:"rollouts"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode rollouts: %v", err)
+	}
+	if len(status.Rollouts) != 1 || status.Rollouts[0].Phase != "ramping" {
+		t.Fatalf("Expected one ramping rollout, got %+v", status.Rollouts)
+	}
+
+	s.advanceRollouts(now.Add(2 * time.Hour))
+	for i := 0; i < 10; i++ {
+		if body := get(); body != "new" {
+			t.Fatalf("Expected the new generation after the ramp, got %q", body)
+		}
+	}
+
+	// * the old generation stays retired across discovery updates
+	s.UpdateServiceInstances("app", instances)
+	if backends := s.GetLoadBalancer("app").Backends(); len(backends) != 1 || backends[0].URL.String() != newBackend.URL {
+		t.Errorf("Expected only the new generation to remain, got %d backends", len(backends))
+	}
+
+	// * clearing the pin routes to every generation again
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/rollouts?service=app", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 clearing the pin, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if backends := s.GetLoadBalancer("app").Backends(); len(backends) != 2 {
+		t.Errorf("Expected both generations after clearing the pin, got %d backends", len(backends))
+	}
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/rollouts?service=app", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a service without a pin, got %d", rec.Code)
+	}
+}
+
+func TestRolloutProbeFirstUnderLeastConnection(t *testing.T) {
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "old")
+	}))
+	defer oldBackend.Close()
+	var probed atomic.Bool
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			probed.Store(true)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "new")
+	}))
+	defer newBackend.Close()
+
+	s := newTestServer(t)
+	s.config.LoadBalancer.Algorithm = "least_connection"
+	s.config.HealthCheck.Enabled = true
+	oldInstance := backendInstance(t, "app", oldBackend.URL)
+	newInstance := backendInstance(t, "app", newBackend.URL)
+	newInstance.ID = "app-2"
+	newInstance.Metadata = map[string]string{"generation": "v2"}
+	s.UpdateServiceInstances("app", []discovery.ServiceInstance{oldInstance})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/rollouts", strings.NewReader(`{"service":"app","generation":"v2","ramp":"1h"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	s.UpdateServiceInstances("app", []discovery.ServiceInstance{oldInstance, newInstance})
+
+	// * before and after a failing first probe, the new generation isn't promoted
+	now := time.Now()
+	s.advanceRollouts(now)
+	deadline := time.Now().Add(2 * time.Second)
+	for !probed.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.advanceRollouts(now.Add(2 * time.Hour))
+
+	s.mu.RLock()
+	ro := s.rollouts["app"]
+	s.mu.RUnlock()
+	if ro == nil || !ro.Promoted.IsZero() {
+		t.Fatal("Expected the rollout to wait for a passing probe")
+	}
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/app/", nil))
+		if body := rec.Body.String(); body != "old" {
+			t.Fatalf("Expected the old generation while the new one fails its probe, got %q", body)
+		}
+	}
+}
+
+func TestRolloutShare(t *testing.T) {
+	now := time.Now()
+	ro := &rollout{Ramp: 10 * time.Minute}
+	if share := ro.share(now); share != 0 {
+		t.Errorf("Expected no traffic before promotion, got %v", share)
+	}
+
+	ro.Promoted = now
+	if share := ro.share(now.Add(5 * time.Minute)); share != 0.5 {
+		t.Errorf("Expected half the traffic halfway through the ramp, got %v", share)
+	}
+	if share := ro.share(now.Add(time.Hour)); share != 1 {
+		t.Errorf("Expected all traffic after the ramp, got %v", share)
+	}
+}
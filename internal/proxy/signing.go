@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+const (
+	signatureHeader = "X-FluxGate-Signature"
+	timestampHeader = "X-FluxGate-Timestamp"
+)
+
+// signRequest applies the service's signing config to an outbound request.
+// Client-supplied signature headers are always dropped so they can't be forged
+// through the gateway.
+func (s *Server) signRequest(req *http.Request) {
+	req.Header.Del(signatureHeader)
+	req.Header.Del(timestampHeader)
+
+	info := requestInfoFrom(req.Context())
+	if info == nil {
+		return
+	}
+
+	s.mu.RLock()
+	signing := s.config.Service(info.service).Signing
+	s.mu.RUnlock()
+
+	if signing == nil {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, requestSignature(signing, req.Method, req.URL.RequestURI(), timestamp))
+}
+
+// requestSignature computes the hex HMAC of "METHOD\nREQUEST_URI\nTIMESTAMP".
+func requestSignature(signing *config.SigningConfig, method, requestURI, timestamp string) string {
+	var newHash func() hash.Hash = sha256.New
+	if signing.Algorithm == "sha512" {
+		newHash = sha512.New
+	}
+
+	mac := hmac.New(newHash, signing.Key())
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
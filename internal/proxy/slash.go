@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// slashPath applies a trailing_slash policy to path. The root path is left
+// alone, as is everything under "preserve".
+func slashPath(path, policy string) string {
+	if path == "" || path == "/" {
+		return path
+	}
+	switch policy {
+	case "strip":
+		if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+			return trimmed
+		}
+		return "/"
+	case "add":
+		if !strings.HasSuffix(path, "/") {
+			return path + "/"
+		}
+	}
+	return path
+}
+
+// redirectTrailingSlash redirects requests whose path isn't in the service's
+// canonical trailing slash form, reporting whether it answered the request.
+// GET and HEAD get 301, other methods 308 so the method and body are kept.
+func (s *Server) redirectTrailingSlash(w http.ResponseWriter, r *http.Request, serviceName string) bool {
+	s.mu.RLock()
+	serviceCfg := s.config.Service(serviceName)
+	s.mu.RUnlock()
+
+	if !serviceCfg.TrailingSlashRedirect {
+		return false
+	}
+	canonical := slashPath(r.URL.Path, serviceCfg.TrailingSlash)
+	// * a leading // would make Location protocol-relative, an open redirect
+	if canonical == r.URL.Path || strings.HasPrefix(canonical, "//") {
+		return false
+	}
+
+	status := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+	location := (&url.URL{Path: canonical, RawQuery: r.URL.RawQuery}).String()
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(status)).Inc()
+	http.Redirect(w, r, location, status)
+	return true
+}
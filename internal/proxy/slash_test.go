@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func TestTrailingSlashPolicy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"stripped": {TrailingSlash: "strip"},
+		"added":    {TrailingSlash: "add"},
+		"kept":     {},
+	}
+	for name := range s.config.Services {
+		s.UpdateServiceInstances(name, []discovery.ServiceInstance{backendInstance(t, name, backend.URL)})
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/stripped/items/", "/items"},
+		{"/stripped/items", "/items"},
+		{"/stripped/", "/"},
+		{"/added/items", "/items/"},
+		{"/added/items/", "/items/"},
+		{"/kept/items/", "/items/"},
+		{"/kept/items", "/items"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Body.String() != tt.want {
+			t.Errorf("%s: expected the backend to get %q, got %q", tt.path, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {TrailingSlash: "strip", TrailingSlashRedirect: true},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	tests := []struct {
+		method   string
+		path     string
+		status   int
+		location string
+	}{
+		{"GET", "/api/items/?page=2", http.StatusMovedPermanently, "/api/items?page=2"},
+		{"POST", "/api/items/", http.StatusPermanentRedirect, "/api/items"},
+		{"GET", "/api/items", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s: expected %d to %q, got %d to %q", tt.method, tt.path, tt.status, tt.location, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// latencyWindowSize bounds the samples kept per backend, the oldest are
+// dropped first on busy backends.
+const latencyWindowSize = 2048
+
+// sloTick is how often backends are compared against their latency SLO.
+const sloTick = 5 * time.Second
+
+// sloRecovery is the part of the way back to full weight a deprioritized
+// backend regains each tick it has too few samples to be judged on.
+const sloRecovery = 0.25
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyTracker keeps the recent upstream latencies of each backend, keyed
+// by backend URL, for services with a latency_slo.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples map[string][]latencySample
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make(map[string][]latencySample)}
+}
+
+func (t *latencyTracker) record(backend string, now time.Time, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := append(t.samples[backend], latencySample{at: now, latency: latency})
+	if len(samples) > latencyWindowSize {
+		samples = samples[len(samples)-latencyWindowSize:]
+	}
+	t.samples[backend] = samples
+}
+
+// percentile returns the p-th percentile of the backend's latencies newer
+// than since, and how many there were. Older samples are dropped.
+func (t *latencyTracker) percentile(backend string, since time.Time, p float64) (time.Duration, int) {
+	t.mu.Lock()
+	samples := t.samples[backend]
+	start := 0
+	for start < len(samples) && samples[start].at.Before(since) {
+		start++
+	}
+	samples = samples[start:]
+	t.samples[backend] = samples
+	latencies := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		latencies[i] = sample.latency
+	}
+	t.mu.Unlock()
+
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	slices.Sort(latencies)
+	rank := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+	return latencies[max(rank, 0)], len(latencies)
+}
+
+// retain forgets the backends not in keep.
+func (t *latencyTracker) retain(keep map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for backend := range t.samples {
+		if !keep[backend] {
+			delete(t.samples, backend)
+		}
+	}
+}
+
+// recordLatency notes how long backend took to respond to a request of
+// serviceName, when the service has a latency_slo.
+func (s *Server) recordLatency(serviceName, backend string, latency time.Duration) {
+	s.mu.RLock()
+	slo := s.config.Service(serviceName).LatencySLO
+	s.mu.RUnlock()
+
+	if slo != nil {
+		s.latencies.record(backend, time.Now(), latency)
+	}
+}
+
+// StartLatencySLO re-weights backends against their service's latency_slo
+// until ctx is cancelled.
+func (s *Server) StartLatencySLO(ctx context.Context) {
+	ticker := time.NewTicker(sloTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkLatencySLO(time.Now())
+		}
+	}
+}
+
+func (s *Server) checkLatencySLO(now time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tracked := make(map[string]bool)
+	for serviceName, lb := range s.loadBalancers {
+		slo := s.config.Service(serviceName).LatencySLO
+		for _, backend := range lb.Backends() {
+			if slo == nil {
+				// * the service may have dropped its latency_slo on reload
+				backend.SetSLOFactor(1)
+				metrics.BackendSLOCompliant.DeleteLabelValues(backend.URL.String())
+				continue
+			}
+			tracked[backend.URL.String()] = true
+			applyLatencySLO(serviceName, backend, slo, s.latencies, now)
+		}
+	}
+	s.latencies.retain(tracked)
+}
+
+// applyLatencySLO scales backend's weight by how far its latency percentile
+// is above the SLO threshold, restoring it once the backend is back within.
+// Without enough samples the weight drifts back towards full.
+func applyLatencySLO(serviceName string, backend *loadbalancer.Backend, slo *config.LatencySLOConfig, latencies *latencyTracker, now time.Time) {
+	key := backend.URL.String()
+	latency, samples := latencies.percentile(key, now.Add(-slo.Window), slo.Percentile)
+	if samples < slo.MinSamples || samples == 0 {
+		// * a deprioritized backend may get too little traffic to ever prove
+		// * itself again, so it isn't held at its reduced weight
+		if factor := backend.SLOFactor(); factor < 1 {
+			factor += (1 - factor) * sloRecovery
+			if factor > 0.99 {
+				factor = 1
+				log.Printf("Backend %s of service %s back to full weight, too few samples to judge its latency SLO", key, serviceName)
+			}
+			backend.SetSLOFactor(factor)
+			metrics.BackendEffectiveWeight.WithLabelValues(key).Set(backend.EffectiveWeight())
+		}
+		return
+	}
+
+	factor, compliant := 1.0, 1.0
+	if latency > slo.Threshold {
+		factor = max(float64(slo.Threshold)/float64(latency), slo.MinWeightFactor)
+		compliant = 0
+	}
+	if (factor < 1) != (backend.SLOFactor() < 1) {
+		if factor < 1 {
+			log.Printf("Backend %s of service %s misses its latency SLO: p%g %s > %s, weight factor %.2f", key, serviceName, slo.Percentile, latency, slo.Threshold, factor)
+		} else {
+			log.Printf("Backend %s of service %s is back within its latency SLO", key, serviceName)
+		}
+	}
+	backend.SetSLOFactor(factor)
+	metrics.BackendSLOCompliant.WithLabelValues(key).Set(compliant)
+	metrics.BackendEffectiveWeight.WithLabelValues(key).Set(backend.EffectiveWeight())
+}
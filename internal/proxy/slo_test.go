@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLatencyTrackerPercentile(t *testing.T) {
+	tracker := newLatencyTracker()
+	start := time.Now()
+	for i := 1; i <= 100; i++ {
+		tracker.record("http://a", start.Add(time.Duration(i)*time.Second), time.Duration(i)*time.Millisecond)
+	}
+
+	if got, n := tracker.percentile("http://a", start, 99); got != 99*time.Millisecond || n != 100 {
+		t.Errorf("Expected p99 of 99ms over 100 samples, got %s over %d", got, n)
+	}
+	// * samples older than the window are dropped
+	if got, n := tracker.percentile("http://a", start.Add(91*time.Second), 50); got != 95*time.Millisecond || n != 10 {
+		t.Errorf("Expected p50 of 95ms over the last 10 samples, got %s over %d", got, n)
+	}
+
+	tracker.retain(map[string]bool{})
+	if _, n := tracker.percentile("http://a", start, 99); n != 0 {
+		t.Errorf("Expected a removed backend to be forgotten, got %d samples", n)
+	}
+}
+
+func TestLatencySLO(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	slowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+	defer slowBackend.Close()
+	fastBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fastBackend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{"orders": {
+		LatencySLO: &config.LatencySLOConfig{Threshold: 20 * time.Millisecond, Percentile: 99, Window: time.Minute, MinSamples: 5, MinWeightFactor: 0.1},
+	}}
+	slowInstance := backendInstance(t, "orders", slowBackend.URL)
+	fastInstance := backendInstance(t, "orders", fastBackend.URL)
+	fastInstance.ID = "orders-2"
+	s.UpdateServiceInstances("orders", []discovery.ServiceInstance{slowInstance, fastInstance})
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/", nil))
+		}
+	}
+	backendsByURL := func() map[string]float64 {
+		factors := make(map[string]float64)
+		for _, backend := range s.GetLoadBalancer("orders").Backends() {
+			factors[backend.URL.String()] = backend.SLOFactor()
+		}
+		return factors
+	}
+
+	send(12)
+	s.checkLatencySLO(time.Now())
+	factors := backendsByURL()
+	if factor := factors[slowBackend.URL]; factor >= 1 || factor < 0.1 {
+		t.Errorf("Expected the slow backend to be deprioritized, got factor %v", factor)
+	}
+	if factor := factors[fastBackend.URL]; factor != 1 {
+		t.Errorf("Expected the fast backend to keep its weight, got factor %v", factor)
+	}
+	if got := testutil.ToFloat64(metrics.BackendSLOCompliant.WithLabelValues(slowBackend.URL)); got != 0 {
+		t.Errorf("Expected the slow backend to be reported non-compliant, got %v", got)
+	}
+
+	// * without samples the reduced weight drifts back instead of sticking
+	deprioritized := factors[slowBackend.URL]
+	quiet := time.Now().Add(2 * time.Minute)
+	s.checkLatencySLO(quiet)
+	if factor := backendsByURL()[slowBackend.URL]; factor <= deprioritized || factor >= 1 {
+		t.Errorf("Expected the factor to move part of the way back from %v, got %v", deprioritized, factor)
+	}
+	for range 20 {
+		s.checkLatencySLO(quiet)
+	}
+	if factor := backendsByURL()[slowBackend.URL]; factor != 1 {
+		t.Errorf("Expected the factor to reach full weight without samples, got %v", factor)
+	}
+	send(12)
+	s.checkLatencySLO(time.Now())
+	if factor := backendsByURL()[slowBackend.URL]; factor >= 1 {
+		t.Errorf("Expected fresh slow samples to deprioritize the backend again, got factor %v", factor)
+	}
+
+	// * once it recovers, only samples in the window count
+	recovered := time.Now()
+	slow.Store(false)
+	send(12)
+	s.checkLatencySLO(recovered.Add(time.Minute))
+	if factor := backendsByURL()[slowBackend.URL]; factor != 1 {
+		t.Errorf("Expected the recovered backend's weight to be restored, got factor %v", factor)
+	}
+	if got := testutil.ToFloat64(metrics.BackendSLOCompliant.WithLabelValues(slowBackend.URL)); got != 1 {
+		t.Errorf("Expected the recovered backend to be reported compliant, got %v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// staticFor returns the static responder serving a service: the one its
+// service config points at, or a static responder of the same name.
+func (s *Server) staticFor(serviceName string) (config.StaticConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if name := s.config.Service(serviceName).Static; name != "" {
+		static, exists := s.config.Static[name]
+		return static, exists
+	}
+	static, exists := s.config.Static[serviceName]
+	return static, exists
+}
+
+func (s *Server) serveStatic(w http.ResponseWriter, r *http.Request, serviceName string, static config.StaticConfig, start time.Time, traceID string) {
+	requestPath := r.URL.Path
+
+	w.Header().Set("Content-Type", static.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(static.Body)))
+	w.WriteHeader(static.Status)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, static.Body)
+	}
+
+	metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(time.Since(start).Seconds())
+	metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(static.Status)).Inc()
+	s.logAccess(serviceName, r, requestPath, static.Status, time.Since(start), traceID)
+}
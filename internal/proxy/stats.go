@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+type serviceStats struct {
+	Requests        float64        `json:"requests"`
+	Errors          float64        `json:"errors"`
+	HealthyBackends int            `json:"healthy_backends"`
+	TotalBackends   int            `json:"total_backends"`
+	Backends        []backendStats `json:"backends"`
+}
+
+type backendStats struct {
+	URL             string  `json:"url"`
+	Healthy         bool    `json:"healthy"`
+	Weight          int     `json:"weight"`
+	EffectiveWeight float64 `json:"effective_weight"`
+	Connections     int64   `json:"connections"`
+}
+
+// handleStats summarizes runtime state for operators who want one JSON
+// document instead of the Prometheus exposition.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	services := make(map[string]*serviceStats)
+	var totalRequests float64
+	for name, count := range metrics.RequestCounts() {
+		totalRequests += count.Requests
+		services[name] = &serviceStats{Requests: count.Requests, Errors: count.Errors}
+	}
+
+	var activeConnections int64
+	s.mu.RLock()
+	for name, lb := range s.loadBalancers {
+		stats, exists := services[name]
+		if !exists {
+			stats = &serviceStats{}
+			services[name] = stats
+		}
+		for _, backend := range lb.Backends() {
+			stats.TotalBackends++
+			if backend.Active {
+				stats.HealthyBackends++
+			}
+			connections := atomic.LoadInt64(&backend.Connections)
+			activeConnections += connections
+			stats.Backends = append(stats.Backends, backendStats{
+				URL:             backend.URL.String(),
+				Healthy:         backend.Active,
+				Weight:          backend.Weight,
+				EffectiveWeight: backend.EffectiveWeight(),
+				Connections:     connections,
+			})
+		}
+	}
+	s.mu.RUnlock()
+
+	clusterSize := 1
+	if s.discovery != nil {
+		clusterSize = s.discovery.NumMembers()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"total_requests":     totalRequests,
+		"services":           services,
+		"active_connections": activeConnections,
+		"cluster_size":       clusterSize,
+		"uptime_seconds":     int64(time.Since(s.started).Seconds()),
+		"goroutines":         runtime.NumGoroutine(),
+		"timestamp":          time.Now().Unix(),
+	})
+}
@@ -2,27 +2,41 @@ package proxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/fluxgate/fluxgate/internal/config"
 )
 
+// defaultCipherSuites apply when tls.cipher_suites isn't set.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
 type TLSManager struct {
-	config    *config.TLS
-	cert      *tls.Certificate
-	mu        sync.RWMutex
-	onChange  []func(*tls.Config)
+	config       *config.TLS
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+	stapleExpiry time.Time
+	certChanged  chan struct{}
+	mu           sync.RWMutex
+	onChange     []func(*tls.Config)
 }
 
 func NewTLSManager(tlsConfig *config.TLS) (*TLSManager, error) {
 	m := &TLSManager{
-		config:   tlsConfig,
-		onChange: make([]func(*tls.Config), 0),
+		config:      tlsConfig,
+		certChanged: make(chan struct{}, 1),
+		onChange:    make([]func(*tls.Config), 0),
 	}
 
-	if tlsConfig != nil && tlsConfig.CertFile != "" && tlsConfig.KeyFile != "" {
+	if tlsConfig != nil {
 		if err := m.loadCertificate(); err != nil {
 			return nil, err
 		}
@@ -32,16 +46,21 @@ func NewTLSManager(tlsConfig *config.TLS) (*TLSManager, error) {
 }
 
 func (m *TLSManager) loadCertificate() error {
-	cert, err := tls.LoadX509KeyPair(m.config.CertFile, m.config.KeyFile)
+	cert, err := m.config.KeyPair()
 	if err != nil {
 		return fmt.Errorf("loading TLS certificate: %w", err)
 	}
+	clientCAs, err := m.config.ClientCAs()
+	if err != nil {
+		return fmt.Errorf("loading TLS client CAs: %w", err)
+	}
 
 	m.mu.Lock()
 	m.cert = &cert
+	m.clientCAs = clientCAs
 	m.mu.Unlock()
 
-	log.Printf("Loaded TLS certificate from %s", m.config.CertFile)
+	log.Printf("Loaded TLS certificate from %s", m.config.Source())
 	return nil
 }
 
@@ -49,46 +68,76 @@ func (m *TLSManager) GetTLSConfig() *tls.Config {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	return m.tlsConfig()
+}
+
+// tlsConfig builds the server TLS config, callers hold m.mu.
+func (m *TLSManager) tlsConfig() *tls.Config {
 	if m.cert == nil {
 		return nil
 	}
 
+	cipherSuites := m.config.CipherSuiteIDs()
+	if cipherSuites == nil {
+		cipherSuites = defaultCipherSuites
+	}
+
+	// * served through GetCertificate so refreshed OCSP staples and reloaded
+	// certificates apply to running listeners
 	return &tls.Config{
-		Certificates: []tls.Certificate{*m.cert},
-		MinVersion:   tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
+		GetCertificate:           m.getCertificate,
+		MinVersion:               m.config.Version(),
+		CipherSuites:             cipherSuites,
 		PreferServerCipherSuites: true,
 		NextProtos:               []string{"h2", "http/1.1"},
+		ClientAuth:               m.config.ClientAuthType(),
+		ClientCAs:                m.clientCAs,
+	}
+}
+
+func (m *TLSManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
 	}
+	return m.cert, nil
 }
 
 func (m *TLSManager) UpdateConfig(tlsConfig *config.TLS) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if tlsConfig == nil || tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+	if tlsConfig == nil {
 		m.config = nil
 		m.cert = nil
+		m.clientCAs = nil
 		log.Printf("TLS disabled")
 		m.notifyListeners()
 		return nil
 	}
 
-	m.config = tlsConfig
-	
-	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	cert, err := tlsConfig.KeyPair()
 	if err != nil {
 		return fmt.Errorf("loading new TLS certificate: %w", err)
 	}
+	clientCAs, err := tlsConfig.ClientCAs()
+	if err != nil {
+		return fmt.Errorf("loading new TLS client CAs: %w", err)
+	}
 
+	m.config = tlsConfig
 	m.cert = &cert
-	log.Printf("Updated TLS certificate from %s", tlsConfig.CertFile)
+	m.clientCAs = clientCAs
+	m.stapleExpiry = time.Time{}
+	log.Printf("Updated TLS certificate from %s", tlsConfig.Source())
 	m.notifyListeners()
+
+	select {
+	case m.certChanged <- struct{}{}:
+	default:
+	}
 	
 	return nil
 }
@@ -100,7 +149,7 @@ func (m *TLSManager) Subscribe(fn func(*tls.Config)) {
 }
 
 func (m *TLSManager) notifyListeners() {
-	tlsConfig := m.GetTLSConfig()
+	tlsConfig := m.tlsConfig()
 	for _, fn := range m.onChange {
 		go fn(tlsConfig)
 	}
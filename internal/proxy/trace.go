@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// traceparent is a parsed W3C Trace Context traceparent header.
+type traceparent struct {
+	version  string
+	traceID  string
+	parentID string
+	flags    string
+}
+
+func (t traceparent) String() string {
+	return t.version + "-" + t.traceID + "-" + t.parentID + "-" + t.flags
+}
+
+func parseTraceparent(value string) (traceparent, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return traceparent{}, false
+	}
+
+	tp := traceparent{version: parts[0], traceID: parts[1], parentID: parts[2], flags: parts[3]}
+	// * only version 00 has a fixed layout, later versions may append fields
+	if tp.version == "00" && len(parts) != 4 {
+		return traceparent{}, false
+	}
+	if !isLowerHex(tp.version, 2) || tp.version == "ff" ||
+		!isLowerHex(tp.traceID, 32) || tp.traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(tp.parentID, 16) || tp.parentID == strings.Repeat("0", 16) ||
+		!isLowerHex(tp.flags, 2) {
+		return traceparent{}, false
+	}
+
+	return tp, true
+}
+
+func newTraceparent() traceparent {
+	return traceparent{
+		version:  "00",
+		traceID:  randomHex(16),
+		parentID: randomHex(8),
+		flags:    "00",
+	}
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// propagateRequestID makes sure the request carries a correlation ID according
+// to the configured propagation mode and returns it. In w3c mode a valid incoming
+// traceparent is forwarded untouched and a new one is generated otherwise; the
+// trace ID doubles as the request ID.
+func (s *Server) propagateRequestID(w http.ResponseWriter, r *http.Request) string {
+	s.mu.RLock()
+	cfg := s.config.Tracing
+	s.mu.RUnlock()
+
+	var id string
+	switch cfg.Propagation {
+	case "none":
+		return ""
+	case "w3c":
+		tp, ok := parseTraceparent(r.Header.Get(traceparentHeader))
+		if !ok {
+			tp = newTraceparent()
+			r.Header.Del(tracestateHeader)
+		}
+		r.Header.Set(traceparentHeader, tp.String())
+		id = tp.traceID
+	}
+
+	if existing := r.Header.Get(cfg.RequestIDHeader); existing != "" {
+		if id == "" {
+			id = existing
+		}
+	} else {
+		if id == "" {
+			id = randomHex(16)
+		}
+		r.Header.Set(cfg.RequestIDHeader, id)
+	}
+
+	w.Header().Set(cfg.RequestIDHeader, r.Header.Get(cfg.RequestIDHeader))
+	return id
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+)
+
+// backendProxy pairs a backend's reverse proxy with its own connection pool so
+// the pool can be recycled without touching other backends.
+type backendProxy struct {
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	created   time.Time
+}
+
+func newBaseTransport(cfg *config.Config) *http.Transport {
+	expectContinue := cfg.Timeouts.ExpectContinue
+	if expectContinue < 0 {
+		expectContinue = 0
+	}
+
+	return &http.Transport{
+		MaxIdleConns:          cfg.Transport.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.Transport.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.Transport.IdleConnTimeout,
+		DisableCompression:    true,
+		DialContext:           newBackendDialer(cfg.Dial, cfg.Timeouts.Connect).DialContext,
+		ResponseHeaderTimeout: cfg.Timeouts.Response,
+		// * lets backends turn down an upload before the client sends it
+		ExpectContinueTimeout: expectContinue,
+		// * stops reading headers early, modifyResponse enforces the exact limits
+		MaxResponseHeaderBytes: cfg.Transport.MaxResponseHeaderBytes,
+	}
+}
+
+// transportChanged reports whether a reload changes any setting
+// newBaseTransport builds backend connections from, so pools must be rebuilt.
+func transportChanged(previous, cfg *config.Config) bool {
+	return previous.Transport.MaxIdleConns != cfg.Transport.MaxIdleConns ||
+		previous.Transport.MaxIdleConnsPerHost != cfg.Transport.MaxIdleConnsPerHost ||
+		previous.Transport.IdleConnTimeout != cfg.Transport.IdleConnTimeout ||
+		previous.Transport.MaxResponseHeaderBytes != cfg.Transport.MaxResponseHeaderBytes ||
+		previous.Dial.KeepAlive != cfg.Dial.KeepAlive ||
+		previous.Dial.TCPNoDelay() != cfg.Dial.TCPNoDelay() ||
+		previous.Dial.FallbackDelay != cfg.Dial.FallbackDelay ||
+		previous.Timeouts.Connect != cfg.Timeouts.Connect ||
+		previous.Timeouts.Response != cfg.Timeouts.Response ||
+		previous.Timeouts.ExpectContinue != cfg.Timeouts.ExpectContinue
+}
+
+func (s *Server) getOrCreateProxy(target *url.URL) *httputil.ReverseProxy {
+	key := target.String()
+
+	s.mu.RLock()
+	bp, exists := s.reverseProxies[key]
+	maxAge := s.config.Transport.MaxPoolAge
+	s.mu.RUnlock()
+
+	if exists && (maxAge == 0 || time.Since(bp.created) < maxAge) {
+		return bp.proxy
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// * another request may have recycled the pool while we waited for the lock
+	if bp, exists := s.reverseProxies[key]; exists {
+		if maxAge == 0 || time.Since(bp.created) < maxAge {
+			return bp.proxy
+		}
+		// * in-flight requests keep their connections, only idle ones are closed
+		bp.transport.CloseIdleConnections()
+	}
+
+	transport := s.transport.Clone()
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		s.setOutboundHost(req)
+		s.setClientCertHeaders(req)
+		s.rewriteMethod(req)
+		s.signRequest(req)
+	}
+	proxy.Transport = &capturingTransport{s: s, next: transport}
+	proxy.ErrorHandler = s.proxyErrorHandler
+	proxy.ModifyResponse = s.modifyResponse
+	s.reverseProxies[key] = &backendProxy{proxy: proxy, transport: transport, created: time.Now()}
+
+	return proxy
+}
+
+// healthTransport is the health checker's transport hook: with
+// health_check.use_traffic_pool the connection pool of the backend at target,
+// otherwise nil for the checker's own client.
+func (s *Server) healthTransport(target *url.URL) http.RoundTripper {
+	s.mu.RLock()
+	shared := s.config.HealthCheck.UseTrafficPool
+	s.mu.RUnlock()
+	if !shared {
+		return nil
+	}
+
+	s.getOrCreateProxy(target)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if bp, exists := s.reverseProxies[target.String()]; exists {
+		return bp.transport
+	}
+	return nil
+}
+
+// dropProxy forgets a backend's proxy and closes its idle connections. The
+// caller must hold s.mu.
+func (s *Server) dropProxy(key string) {
+	if bp, exists := s.reverseProxies[key]; exists {
+		bp.transport.CloseIdleConnections()
+		delete(s.reverseProxies, key)
+	}
+}
+
+// dropAllProxies makes every backend pick up the current transport settings on
+// its next request. The caller must hold s.mu.
+func (s *Server) dropAllProxies() {
+	for key := range s.reverseProxies {
+		s.dropProxy(key)
+	}
+}
+
+// CloseIdleConnections closes idle connections to every backend.
+func (s *Server) CloseIdleConnections() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, bp := range s.reverseProxies {
+		bp.transport.CloseIdleConnections()
+	}
+}
+
+// StartIdleFlush closes idle backend connections every transport
+// idle_flush_interval until ctx is cancelled. The interval is re-read after
+// each tick so reloads take effect.
+func (s *Server) StartIdleFlush(ctx context.Context) {
+	for {
+		s.mu.RLock()
+		interval := s.config.Transport.IdleFlushInterval
+		s.mu.RUnlock()
+
+		enabled := interval > 0
+		if !enabled {
+			// * disabled, check again later in case a reload enables it
+			interval = time.Minute
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if enabled {
+			s.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+// errUpstreamTruncated marks a backend that dropped the connection after its
+// response headers but before any of the body.
+var errUpstreamTruncated = errors.New("backend closed the connection before the response body")
+
+// guardResponseBody waits for the first byte of resp's body, so a backend that
+// drops the connection before sending any is answered with a clean 502, or
+// retried, instead of a truncated response. Streaming responses are passed on
+// without waiting. The body is wrapped to report a connection lost later.
+func (s *Server) guardResponseBody(resp *http.Response) error {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.ContentLength == 0 || resp.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+
+	service := "unknown"
+	if state := retryStateFrom(resp.Request.Context()); state != nil {
+		service = state.service
+	}
+	s.mu.RLock()
+	streaming := s.config.Service(service).Streaming
+	s.mu.RUnlock()
+
+	body := &truncationReader{body: resp.Body, reader: resp.Body, resp: resp, service: service}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !streaming && mediaType != "text/event-stream" {
+		buffered := bufio.NewReader(resp.Body)
+		if _, err := buffered.Peek(1); err != nil && err != io.EOF && resp.Request.Context().Err() == nil {
+			metrics.UpstreamTruncated.WithLabelValues(service, "before_body").Inc()
+			log.Printf("Upstream truncated response: %s %s for service %s, %s closed the connection after status %d, before the body: %v",
+				resp.Request.Method, resp.Request.URL.Path, service, resp.Request.URL.Host, resp.StatusCode, err)
+			return fmt.Errorf("%w: %v", errUpstreamTruncated, err)
+		}
+		body.reader = buffered
+	}
+	resp.Body = body
+	return nil
+}
+
+// truncationReader reports a backend connection lost partway through a
+// response body, when part of it has already reached the client.
+type truncationReader struct {
+	body     io.ReadCloser
+	reader   io.Reader
+	resp     *http.Response
+	service  string
+	read     int64
+	reported bool
+}
+
+func (t *truncationReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	t.read += int64(n)
+	// * a client that went away cancels the read, that is not the backend's doing
+	if err != nil && err != io.EOF && !t.reported && t.resp.Request.Context().Err() == nil {
+		t.reported = true
+		metrics.UpstreamTruncated.WithLabelValues(t.service, "mid_body").Inc()
+		log.Printf("Upstream truncated response: %s %s for service %s, %s closed the connection after %d body bytes: %v",
+			t.resp.Request.Method, t.resp.Request.URL.Path, t.service, t.resp.Request.URL.Host, t.read, err)
+	}
+	return n, err
+}
+
+func (t *truncationReader) Close() error {
+	return t.body.Close()
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/metrics"
+)
+
+const (
+	// staleCacheEntries bounds the responses kept for on_unavailable "stale",
+	// the oldest stored is evicted first
+	staleCacheEntries = 1000
+	// staleBodyLimit skips caching responses with larger bodies
+	staleBodyLimit = 1 << 20
+)
+
+type staleEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// staleCache keeps the last successful GET response per service and URI, to
+// answer with while the service has no healthy backend.
+type staleCache struct {
+	mu      sync.Mutex
+	entries map[string]*staleEntry
+	order   []string
+}
+
+func newStaleCache() *staleCache {
+	return &staleCache{entries: make(map[string]*staleEntry)}
+}
+
+func (c *staleCache) put(key string, entry *staleEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists {
+		c.order = append(c.order, key)
+		if len(c.order) > staleCacheEntries {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.entries[key] = entry
+}
+
+func (c *staleCache) get(key string, maxAge time.Duration) *staleEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[key]
+	if entry == nil || time.Since(entry.stored) > maxAge {
+		return nil
+	}
+	return entry
+}
+
+// staleKey identifies a cached response by service and original request URI.
+func staleKey(serviceName string, r *http.Request) string {
+	return serviceName + " " + r.URL.RequestURI()
+}
+
+// cacheable reports whether resp may be replayed to other clients later.
+func cacheable(resp *http.Response) bool {
+	cacheControl := strings.ToLower(resp.Header.Get("Cache-Control"))
+	return resp.Request.Method == http.MethodGet &&
+		resp.StatusCode == http.StatusOK &&
+		resp.Header.Get("Content-Encoding") == "" &&
+		len(resp.Header.Values("Set-Cookie")) == 0 &&
+		!strings.Contains(cacheControl, "no-store") &&
+		!strings.Contains(cacheControl, "private") &&
+		(resp.ContentLength < 0 || resp.ContentLength <= staleBodyLimit)
+}
+
+// recordingBody copies a response body as it is read and stores it in the
+// stale cache once fully read, unless it outgrew staleBodyLimit.
+type recordingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	overflow bool
+	done     func(body []byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > staleBodyLimit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+// recordStale arranges for resp to be cached under key once its body has been
+// fully forwarded.
+func (s *Server) recordStale(resp *http.Response, key string) {
+	if !cacheable(resp) {
+		return
+	}
+
+	header := resp.Header.Clone()
+	status := resp.StatusCode
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		done: func(body []byte) {
+			s.stale.put(key, &staleEntry{status: status, header: header, body: body, stored: time.Now()})
+		},
+	}
+}
+
+// fallbackFor returns the fallback service of serviceName and a backend of it,
+// when on_unavailable is "fallback" and the fallback has a free backend.
+func (s *Server) fallbackFor(serviceName string) (string, loadbalancer.LoadBalancer, *loadbalancer.Backend) {
+	s.mu.RLock()
+	unavailable := s.config.Service(serviceName).OnUnavailable
+	lb := s.loadBalancers[unavailable.Fallback]
+	s.mu.RUnlock()
+
+	if unavailable.Action != "fallback" || lb == nil {
+		return "", nil, nil
+	}
+	backend := lb.Next()
+	if backend == nil {
+		return "", nil, nil
+	}
+	log.Printf("No healthy backends for service %s, falling back to %s", serviceName, unavailable.Fallback)
+	return unavailable.Fallback, lb, backend
+}
+
+// serveUnavailable answers r with the service's stale or static on_unavailable
+// response, reporting whether it did.
+func (s *Server) serveUnavailable(w http.ResponseWriter, r *http.Request, serviceName string, start time.Time, traceID string) bool {
+	s.mu.RLock()
+	unavailable := s.config.Service(serviceName).OnUnavailable
+	static, staticExists := s.config.Static[unavailable.Static]
+	s.mu.RUnlock()
+
+	switch unavailable.Action {
+	case "static":
+		if !staticExists {
+			return false
+		}
+		s.serveStatic(w, r, serviceName, static, start, traceID)
+		return true
+	case "stale":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return false
+		}
+		entry := s.stale.get(staleKey(serviceName, r), unavailable.StaleMaxAge)
+		if entry == nil {
+			return false
+		}
+
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+		w.Header().Set("X-FluxGate-Stale", "true")
+		w.WriteHeader(entry.status)
+		if r.Method != http.MethodHead {
+			w.Write(entry.body)
+		}
+
+		metrics.RequestDuration.WithLabelValues(serviceName, r.Method).Observe(time.Since(start).Seconds())
+		metrics.RequestsTotal.WithLabelValues(serviceName, r.Method, strconv.Itoa(entry.status)).Inc()
+		s.logAccess(serviceName, r, r.URL.Path, entry.status, time.Since(start), traceID)
+		return true
+	}
+	return false
+}
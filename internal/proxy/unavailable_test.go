@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+)
+
+func markAllUnhealthy(s *Server, serviceName string) {
+	lb := s.GetLoadBalancer(serviceName)
+	for _, backend := range lb.Backends() {
+		lb.MarkUnhealthy(backend)
+	}
+}
+
+func TestUnavailableServesStale(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("fresh " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Services = map[string]config.ServiceConfig{
+		"api": {OnUnavailable: config.UnavailableConfig{Action: "stale", StaleMaxAge: time.Hour}},
+	}
+	s.UpdateServiceInstances("api", []discovery.ServiceInstance{backendInstance(t, "api", backend.URL)})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	if rec := get("/api/page?id=1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 while healthy, got %d", rec.Code)
+	}
+	markAllUnhealthy(s, "api")
+
+	rec := get("/api/page?id=1")
+	if rec.Code != http.StatusOK || rec.Body.String() != "fresh /page" || rec.Header().Get("X-FluxGate-Stale") != "true" {
+		t.Errorf("Expected the cached response, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected cached headers, got %v", rec.Header())
+	}
+
+	if rec := get("/api/page?id=2"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for an uncached URI, got %d", rec.Code)
+	}
+
+	s.config.Services["api"] = config.ServiceConfig{
+		OnUnavailable: config.UnavailableConfig{Action: "stale", StaleMaxAge: time.Nanosecond},
+	}
+	if rec := get("/api/page?id=1"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the cached response is too old, got %d", rec.Code)
+	}
+}
+
+func TestUnavailableStaticAndFallback(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t)
+	s.config.Static = map[string]config.StaticConfig{
+		"maintenance": {Status: http.StatusOK, ContentType: "text/html", Body: "<h1>Back soon</h1>"},
+	}
+	s.config.Services = map[string]config.ServiceConfig{
+		"shop":   {OnUnavailable: config.UnavailableConfig{Action: "static", Static: "maintenance"}},
+		"search": {OnUnavailable: config.UnavailableConfig{Action: "fallback", Fallback: "search-dr"}},
+	}
+	s.UpdateServiceInstances("shop", []discovery.ServiceInstance{
+		{ID: "shop-1", Service: "shop", Address: "10.0.0.1", Port: 8080},
+	})
+	s.UpdateServiceInstances("search", []discovery.ServiceInstance{
+		{ID: "search-1", Service: "search", Address: "10.0.0.2", Port: 8080},
+	})
+	s.UpdateServiceInstances("search-dr", []discovery.ServiceInstance{backendInstance(t, "search-dr", backend.URL)})
+	markAllUnhealthy(s, "shop")
+	markAllUnhealthy(s, "search")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/shop/cart", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Back soon</h1>" {
+		t.Errorf("Expected the static responder, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/search/q", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "fallback" {
+		t.Errorf("Expected the fallback service to answer, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	markAllUnhealthy(s, "search-dr")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/search/q", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the fallback is down too, got %d", rec.Code)
+	}
+}
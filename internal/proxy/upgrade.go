@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isUpgradeRequest reports whether r asks to switch protocols, e.g. to
+// WebSocket or h2c, which is tunneled rather than reverse proxied.
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hopHeaders are the hop-by-hop headers, as httputil.ReverseProxy removes
+// them, which only apply to the connection they were sent on.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes the hop-by-hop headers from header, including those
+// listed in its Connection header.
+func removeHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// headRecorder keeps what is written to it until stopped.
+type headRecorder struct {
+	bytes.Buffer
+	stopped bool
+}
+
+func (h *headRecorder) Write(p []byte) (int, error) {
+	if h.stopped {
+		return len(p), nil
+	}
+	return h.Buffer.Write(p)
+}
+
+// handleUpgrade forwards an upgrade request to targetURL and returns the
+// backend's status. A 101 Switching Protocols is relayed byte for byte and the
+// connection tunneled in both directions until either side closes it; any
+// other response is passed through as a regular one. A status of 0 means
+// nothing was written to the client.
+func (s *Server) handleUpgrade(w http.ResponseWriter, r *http.Request, targetURL *url.URL) (int, error) {
+	s.mu.RLock()
+	dialer := newBackendDialer(s.config.Dial, s.config.Timeouts.Connect)
+	s.mu.RUnlock()
+
+	targetConn, err := dialer.DialContext(r.Context(), "tcp", targetURL.Host)
+	if err != nil {
+		return 0, err
+	}
+	defer targetConn.Close()
+
+	// * the same outbound hooks as the reverse proxy's director, applied to a
+	// * copy addressed at the backend
+	out := r.Clone(r.Context())
+	out.URL.Scheme = targetURL.Scheme
+	out.URL.Host = targetURL.Host
+	s.setOutboundHost(out)
+	s.setClientCertHeaders(out)
+	s.rewriteMethod(out)
+	s.signRequest(out)
+	if err := out.Write(targetConn); err != nil {
+		return 0, err
+	}
+
+	// * everything read from the backend is kept so the response reaches the
+	// client exactly as sent, along with any data following it
+	var head headRecorder
+	resp, err := http.ReadResponse(bufio.NewReader(io.TeeReader(targetConn, &head)), out)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// * relayed as a regular response, the body needn't be kept as well
+		head.stopped = true
+		head.Reset()
+		defer resp.Body.Close()
+		removeHopHeaders(resp.Header)
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	clientConn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return 0, err
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write(head.Bytes()); err != nil {
+		return resp.StatusCode, err
+	}
+
+	errChan := make(chan error, 2)
+
+	go func() {
+		// * the client may have sent data along with the request
+		_, err := io.Copy(targetConn, buffered)
+		errChan <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(clientConn, targetConn)
+		errChan <- err
+	}()
+
+	<-errChan
+	return resp.StatusCode, nil
+}
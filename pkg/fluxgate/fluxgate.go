@@ -0,0 +1,81 @@
+// Package fluxgate exposes the FluxGate proxy for embedding in other Go programs.
+//
+// The proxy pipeline is available as an http.Handler through Server.Handler, so it
+// can be mounted on a caller-owned http.Server with its own listener and TLS:
+//
+//	cfg, _ := fluxgate.LoadConfig("fluxgate.yaml")
+//	srv, _ := fluxgate.NewServer(cfg, nil)
+//	srv.UpdateServiceInstances("users", []fluxgate.ServiceInstance{
+//		{ID: "users-1", Service: "users", Address: "10.0.0.5", Port: 8080},
+//	})
+//	mux.Handle("/", srv.Handler())
+//	srv.StartBackground(ctx)
+//
+// StartBackground runs health checks, rollouts and the other background work
+// Server.Start would, until ctx is cancelled.
+package fluxgate
+
+import (
+	"github.com/fluxgate/fluxgate/internal/config"
+	"github.com/fluxgate/fluxgate/internal/discovery"
+	"github.com/fluxgate/fluxgate/internal/loadbalancer"
+	"github.com/fluxgate/fluxgate/internal/logging"
+	"github.com/fluxgate/fluxgate/internal/proxy"
+)
+
+type (
+	Config          = config.Config
+	Server          = proxy.Server
+	Discovery       = discovery.Service
+	ServiceInstance = discovery.ServiceInstance
+	LoadBalancer    = loadbalancer.LoadBalancer
+	Backend         = loadbalancer.Backend
+	LoggingConfig   = config.LoggingConfig
+	AccessLogSink   = logging.Sink
+	SinkConfig      = config.AccessLogSinkConfig
+)
+
+// LoadConfig reads and validates a configuration file, falling back to
+// defaults when the file does not exist.
+func LoadConfig(filename string) (*Config, error) {
+	return config.Load(filename)
+}
+
+// NewDiscovery starts a gossip discovery node on port, joining joinAddr if set.
+// With keys, gossip is encrypted with the first and any of them is accepted;
+// Discovery.SetKeys rotates them later.
+func NewDiscovery(port int, joinAddr string, keys ...[]byte) (*Discovery, error) {
+	return discovery.New(port, joinAddr, keys...)
+}
+
+// NewServer creates a proxy server. disc may be nil, in which case the
+// management API is disabled and backends are supplied with
+// Server.UpdateServiceInstances.
+func NewServer(cfg *Config, disc *Discovery) (*Server, error) {
+	return proxy.New(cfg, disc, cfg.Server.Port)
+}
+
+// RegisterLoadBalancer makes a custom balancing algorithm selectable by name
+// through load_balancer.algorithm or services.<name>.load_balancer. Register
+// before creating the server.
+func RegisterLoadBalancer(name string, factory func() LoadBalancer) {
+	loadbalancer.Register(name, factory)
+}
+
+// ConfigureLogging applies cfg's log outputs, format and access log sink, as
+// the fluxgate binary does on start and reload. Call CloseAccessLogSink on
+// shutdown to flush the sink.
+func ConfigureLogging(cfg LoggingConfig) error {
+	return logging.Configure(cfg)
+}
+
+// CloseAccessLogSink flushes and stops the access log sink, if any.
+func CloseAccessLogSink() error {
+	return logging.CloseSink()
+}
+
+// RegisterAccessLogSink makes a custom access log sink selectable by name
+// through logging.access_log_sink.type. Register before ConfigureLogging.
+func RegisterAccessLogSink(name string, factory func(cfg SinkConfig) (AccessLogSink, error)) {
+	logging.RegisterSink(name, factory)
+}
@@ -0,0 +1,74 @@
+package fluxgate_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fluxgate/fluxgate/pkg/fluxgate"
+)
+
+func TestEmbeddedGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "users "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	cfg, err := fluxgate.LoadConfig("non-existent-file.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	srv, err := fluxgate.NewServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	u, _ := url.Parse(backend.URL)
+	host, portStr, _ := net.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(portStr)
+	srv.UpdateServiceInstances("users", []fluxgate.ServiceInstance{
+		{ID: "users-1", Service: "users", Address: host, Port: port},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.StartBackground(ctx)
+
+	// * mounted on a caller-owned server and listener, as the package doc shows
+	mux := http.NewServeMux()
+	mux.Handle("/", srv.Handler())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	gateway := &http.Server{Handler: mux}
+	served := make(chan error, 1)
+	go func() { served <- gateway.Serve(ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/users/42")
+	if err != nil {
+		t.Fatalf("Request through the embedded gateway failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "users /42" {
+		t.Errorf("Expected the backend's response, got %d %q", resp.StatusCode, body)
+	}
+
+	shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	if err := gateway.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Expected the gateway to stop with ErrServerClosed, got %v", err)
+	}
+	srv.CloseIdleConnections()
+}
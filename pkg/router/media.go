@@ -0,0 +1,125 @@
+package router
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mediaTypes are the Content-Type and Accept matchers of a service's routes.
+type mediaTypes struct {
+	contentTypes []string
+	accept       []string
+}
+
+// SetMediaTypes restricts every route of serviceName, current and future, to
+// requests whose Content-Type matches one of contentTypes and whose Accept
+// names one of accept. Either may be empty to not match on that header.
+// Patterns are media types, optionally with parameters the request must carry
+// (e.g. "application/json; version=2") or wildcards ("application/*"); the
+// request's own wildcards such as "Accept: */*" never match a pattern.
+//
+// Routes with media types are tried before routes without, so a request
+// matching none of them falls through to an unrestricted route on the same path.
+func (r *Router) SetMediaTypes(serviceName string, contentTypes, accept []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	media := mediaTypes{contentTypes: contentTypes, accept: accept}
+	if len(contentTypes) == 0 && len(accept) == 0 {
+		delete(r.media, serviceName)
+	} else {
+		r.media[serviceName] = media
+	}
+	for i := range r.routes {
+		if r.routes[i].ServiceName == serviceName {
+			r.routes[i].ContentTypes = contentTypes
+			r.routes[i].Accept = accept
+		}
+	}
+	r.sortByMediaTypes()
+}
+
+// sortByMediaTypes moves routes with media types ahead of the others, keeping
+// the order within each group. The caller must hold r.mu.
+func (r *Router) sortByMediaTypes() {
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].hasMediaTypes() && !r.routes[j].hasMediaTypes()
+	})
+}
+
+func (route Route) hasMediaTypes() bool {
+	return len(route.ContentTypes) > 0 || len(route.Accept) > 0
+}
+
+// matchMediaTypes checks the route's Content-Type and Accept matchers.
+func matchMediaTypes(req *http.Request, route *Route) bool {
+	if len(route.ContentTypes) > 0 {
+		contentType := req.Header.Get("Content-Type")
+		if contentType == "" || !matchAnyMediaType(route.ContentTypes, contentType) {
+			return false
+		}
+	}
+	if len(route.Accept) > 0 {
+		accepted := false
+		for _, header := range req.Header.Values("Accept") {
+			for _, value := range strings.Split(header, ",") {
+				if acceptable(value) && matchAnyMediaType(route.Accept, value) {
+					accepted = true
+				}
+			}
+		}
+		if !accepted {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptable reports whether an Accept entry wasn't refused with q=0.
+func acceptable(value string) bool {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+	q, err := strconv.ParseFloat(params["q"], 64)
+	return err != nil || q > 0
+}
+
+func matchAnyMediaType(patterns []string, value string) bool {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matchMediaType(pattern, mediaType, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMediaType matches a type/subtype, either of which may be * in pattern,
+// and requires every parameter of pattern to be present with an equal value.
+func matchMediaType(pattern, mediaType string, params map[string]string) bool {
+	want, wantParams, err := mime.ParseMediaType(pattern)
+	if err != nil {
+		return false
+	}
+	wantType, wantSub, _ := strings.Cut(want, "/")
+	gotType, gotSub, _ := strings.Cut(mediaType, "/")
+	if gotType == "*" || gotSub == "*" {
+		return false
+	}
+	if (wantType != "*" && wantType != gotType) || (wantSub != "*" && wantSub != gotSub) {
+		return false
+	}
+	for name, value := range wantParams {
+		if !strings.EqualFold(params[name], value) {
+			return false
+		}
+	}
+	return true
+}
@@ -6,20 +6,57 @@ import (
 	"sync"
 )
 
+// Route maps a path to a service. Path segments written as :name match any
+// single non-empty segment and are captured into Params when the route matches.
 type Route struct {
-	Path        string
-	ServiceName string
-	Methods     []string
+	Path        string   `json:"path"`
+	ServiceName string   `json:"service"`
+	Methods     []string `json:"methods"`
+	// ContentTypes and Accept restrict the route to requests with a matching
+	// Content-Type and Accept header, see Router.SetMediaTypes
+	ContentTypes []string `json:"content_types,omitempty"`
+	Accept       []string `json:"accept,omitempty"`
+	// Params holds the captured path parameters of a route returned by Match
+	Params map[string]string `json:"-"`
+}
+
+// Prefix returns the path prefix a wildcard route matches under, which is what
+// gets stripped before forwarding. Exact routes return their path.
+func (route Route) Prefix() string {
+	prefix := strings.TrimSuffix(route.Path, "*")
+	if prefix != "/" {
+		prefix = strings.TrimSuffix(prefix, "/")
+	}
+	return prefix
+}
+
+// MatchedPrefix returns the part of requestPath the route prefix matched, which
+// differs from Prefix when the route has path parameters.
+func (route Route) MatchedPrefix(requestPath string) string {
+	prefix := route.Prefix()
+	if !isParameterized(prefix) {
+		return prefix
+	}
+
+	n := len(segments(prefix))
+	parts := segments(requestPath)
+	if len(parts) < n {
+		return prefix
+	}
+	return "/" + strings.Join(parts[:n], "/")
 }
 
 type Router struct {
 	routes []Route
-	mu     sync.RWMutex
+	// media holds the media types set per service with SetMediaTypes
+	media map[string]mediaTypes
+	mu    sync.RWMutex
 }
 
 func New() *Router {
 	return &Router{
 		routes: make([]Route, 0),
+		media:  make(map[string]mediaTypes),
 	}
 }
 
@@ -27,26 +64,260 @@ func (r *Router) AddRoute(path, serviceName string, methods []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	media := r.media[serviceName]
 	r.routes = append(r.routes, Route{
-		Path:        path,
-		ServiceName: serviceName,
-		Methods:     methods,
+		Path:         path,
+		ServiceName:  serviceName,
+		Methods:      methods,
+		ContentTypes: media.contentTypes,
+		Accept:       media.accept,
 	})
+	r.sortByMediaTypes()
 }
 
+func (r *Router) RemoveRoutes(serviceName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := make([]Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		if route.ServiceName != serviceName {
+			routes = append(routes, route)
+		}
+	}
+	r.routes = routes
+}
+
+// RemoveRoute removes the routes to serviceName at path, leaving the service's
+// other routes in place.
+func (r *Router) RemoveRoute(path, serviceName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := make([]Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		if route.ServiceName != serviceName || route.Path != path {
+			routes = append(routes, route)
+		}
+	}
+	r.routes = routes
+}
+
+// SetPaths makes serviceName reachable under exactly the given route paths with
+// the given methods. Routes that stay keep their position in the match order,
+// new ones are appended.
+func (r *Router) SetPaths(serviceName string, paths []string, methods []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+
+	routes := make([]Route, 0, len(r.routes)+len(paths))
+	for _, route := range r.routes {
+		if route.ServiceName != serviceName {
+			routes = append(routes, route)
+			continue
+		}
+		if wanted[route.Path] {
+			route.Methods = methods
+			routes = append(routes, route)
+			delete(wanted, route.Path)
+		}
+	}
+	media := r.media[serviceName]
+	for _, path := range paths {
+		if wanted[path] {
+			routes = append(routes, Route{
+				Path:         path,
+				ServiceName:  serviceName,
+				Methods:      methods,
+				ContentTypes: media.contentTypes,
+				Accept:       media.accept,
+			})
+			delete(wanted, path)
+		}
+	}
+	r.routes = routes
+	r.sortByMediaTypes()
+}
+
+// SetMethods replaces the allowed methods of every route pointing at serviceName.
+func (r *Router) SetMethods(serviceName string, methods []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.routes {
+		if r.routes[i].ServiceName == serviceName {
+			r.routes[i].Methods = methods
+		}
+	}
+}
+
+// Routes returns a snapshot of the route table in match order.
+func (r *Router) Routes() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]Route, len(r.routes))
+	for i, route := range r.routes {
+		route.Methods = append([]string(nil), route.Methods...)
+		route.ContentTypes = append([]string(nil), route.ContentTypes...)
+		route.Accept = append([]string(nil), route.Accept...)
+		routes[i] = route
+	}
+	return routes
+}
+
+// Match results recorded in a MatchStep.
+const (
+	MatchMatched          = "matched"
+	MatchMethodNotAllowed = "method_not_allowed"
+	MatchPathMismatch     = "path_mismatch"
+	// MatchMediaTypeMismatch routes match the path but not the request's
+	// Content-Type or Accept header
+	MatchMediaTypeMismatch = "media_type_mismatch"
+	// MatchShadowed routes would match but come after the route that did
+	MatchShadowed = "shadowed"
+)
+
+// MatchStep records how one route fared against a request in MatchTrace.
+type MatchStep struct {
+	Route  Route  `json:"route"`
+	Result string `json:"result"`
+}
+
+// Match returns the first route matching req, nil if none does.
 func (r *Router) Match(req *http.Request) *Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.match(req, nil)
+}
+
+// MatchAnyMethod is Match ignoring the request method, for answering OPTIONS
+// on behalf of a route that does not list it.
+func (r *Router) MatchAnyMethod(req *http.Request) *Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	for _, route := range r.routes {
-		if r.matchPath(req.URL.Path, route.Path) && r.matchMethod(req.Method, route.Methods) {
+		methods := route.Methods
+		route.Methods = nil
+		if r.matchRoute(req, &route) == MatchMatched {
+			route.Methods = append([]string(nil), methods...)
 			return &route
 		}
 	}
-
 	return nil
 }
 
+// MatchTrace is Match that also reports, in match order, how every route
+// fared against req, for debugging the route table.
+func (r *Router) MatchTrace(req *http.Request) (*Route, []MatchStep) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	trace := make([]MatchStep, 0, len(r.routes))
+	return r.match(req, &trace), trace
+}
+
+// match finds the first matching route. With a trace it keeps evaluating the
+// remaining routes to record them. The caller must hold r.mu.
+func (r *Router) match(req *http.Request, trace *[]MatchStep) *Route {
+	var matched *Route
+	for _, route := range r.routes {
+		result := r.matchRoute(req, &route)
+		if result == MatchMatched && matched != nil {
+			result = MatchShadowed
+		}
+		if result == MatchMatched {
+			found := route
+			matched = &found
+		}
+
+		if trace == nil {
+			if matched != nil {
+				return matched
+			}
+			continue
+		}
+		step := route
+		step.Methods = append([]string(nil), route.Methods...)
+		step.ContentTypes = append([]string(nil), route.ContentTypes...)
+		step.Accept = append([]string(nil), route.Accept...)
+		*trace = append(*trace, MatchStep{Route: step, Result: result})
+	}
+
+	return matched
+}
+
+// matchRoute checks one route against req, filling in its Params on a match.
+func (r *Router) matchRoute(req *http.Request, route *Route) string {
+	if !r.matchMethod(req.Method, route.Methods) {
+		return MatchMethodNotAllowed
+	}
+	if isParameterized(route.Path) {
+		params, ok := matchParams(req.URL.Path, route.Path)
+		if !ok {
+			return MatchPathMismatch
+		}
+		if !matchMediaTypes(req, route) {
+			return MatchMediaTypeMismatch
+		}
+		route.Params = params
+		return MatchMatched
+	}
+	if !r.matchPath(req.URL.Path, route.Path) {
+		return MatchPathMismatch
+	}
+	if !matchMediaTypes(req, route) {
+		return MatchMediaTypeMismatch
+	}
+	return MatchMatched
+}
+
+func isParameterized(path string) bool {
+	return strings.Contains(path, "/:")
+}
+
+func segments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// matchParams matches segment by segment, capturing :name segments. A
+// trailing * lets the route match any deeper path.
+func matchParams(requestPath, routePath string) (map[string]string, bool) {
+	wildcard := strings.HasSuffix(routePath, "*")
+	want := segments(strings.TrimSuffix(routePath, "*"))
+	got := segments(requestPath)
+
+	if len(got) < len(want) || (!wildcard && len(got) != len(want)) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range want {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			if got[i] == "" {
+				return nil, false
+			}
+			params[name] = got[i]
+			continue
+		}
+		if segment != got[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
 func (r *Router) matchPath(requestPath, routePath string) bool {
 	if strings.HasSuffix(routePath, "*") {
 		base := strings.TrimSuffix(routePath, "*")
@@ -78,6 +349,10 @@ func (r *Router) matchMethod(requestMethod string, allowedMethods []string) bool
 		if strings.EqualFold(requestMethod, method) {
 			return true
 		}
+		// * HEAD is GET without a body, so it is allowed wherever GET is
+		if strings.EqualFold(requestMethod, http.MethodHead) && strings.EqualFold(method, http.MethodGet) {
+			return true
+		}
 	}
 
 	return false
@@ -87,4 +362,5 @@ func (r *Router) Clear() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.routes = make([]Route, 0)
+	r.media = make(map[string]mediaTypes)
 }
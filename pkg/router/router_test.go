@@ -120,6 +120,31 @@ func TestRouter(t *testing.T) {
 			expectedResult:  true,
 			expectedService: "test-service",
 		},
+		{
+			name: "HEAD matches GET-only route",
+			routes: []struct {
+				path, service string
+				methods       []string
+			}{
+				{"/api/test", "test-service", []string{"GET"}},
+			},
+			requestPath:     "/api/test",
+			requestMethod:   "HEAD",
+			expectedResult:  true,
+			expectedService: "test-service",
+		},
+		{
+			name: "HEAD does not match POST-only route",
+			routes: []struct {
+				path, service string
+				methods       []string
+			}{
+				{"/api/test", "test-service", []string{"POST"}},
+			},
+			requestPath:    "/api/test",
+			requestMethod:  "HEAD",
+			expectedResult: false,
+		},
 		{
 			name: "root path wildcard",
 			routes: []struct {
@@ -215,6 +240,97 @@ func TestRouterClear(t *testing.T) {
 	}
 }
 
+func TestRouterRemoveRoutes(t *testing.T) {
+	r := New()
+
+	r.AddRoute("/api/*", "api-service", nil)
+	r.AddRoute("/health", "health-service", nil)
+
+	r.RemoveRoutes("api-service")
+
+	if result := r.Match(httptest.NewRequest("GET", "/api/test", nil)); result != nil {
+		t.Error("Expected removed service routes not to match")
+	}
+	if result := r.Match(httptest.NewRequest("GET", "/health", nil)); result == nil {
+		t.Error("Expected other service routes to remain")
+	}
+}
+
+func TestRouterRemoveRoute(t *testing.T) {
+	r := New()
+
+	r.AddRoute("/api/*", "api-service", nil)
+	r.AddRoute("/v1/api/*", "api-service", nil)
+	r.AddRoute("/api/*", "other-service", nil)
+
+	r.RemoveRoute("/api/*", "api-service")
+
+	routes := r.Routes()
+	if len(routes) != 2 || routes[0].Path != "/v1/api/*" || routes[1].ServiceName != "other-service" {
+		t.Errorf("Expected only the route at that path to be removed, got %v", routes)
+	}
+}
+
+func TestRouterSetMethods(t *testing.T) {
+	r := New()
+
+	r.AddRoute("/api/*", "api-service", []string{"GET", "POST"})
+	r.SetMethods("api-service", []string{"GET", "HEAD"})
+
+	if result := r.Match(httptest.NewRequest("HEAD", "/api/test", nil)); result == nil {
+		t.Error("Expected HEAD to match after updating methods")
+	}
+	if result := r.Match(httptest.NewRequest("POST", "/api/test", nil)); result != nil {
+		t.Error("Expected POST not to match after updating methods")
+	}
+}
+
+func TestRouterSetPaths(t *testing.T) {
+	r := New()
+
+	r.AddRoute("/users/*", "user-service", []string{"GET"})
+	r.AddRoute("/orders/*", "order-service", []string{"GET"})
+	r.SetPaths("user-service", []string{"/users/*", "/v1/users/*"}, []string{"GET", "POST"})
+
+	result := r.Match(httptest.NewRequest("POST", "/v1/users/42", nil))
+	if result == nil || result.ServiceName != "user-service" {
+		t.Fatal("Expected alias to route to user-service")
+	}
+	if result.Prefix() != "/v1/users" {
+		t.Errorf("Expected prefix /v1/users, got %s", result.Prefix())
+	}
+
+	routes := r.Routes()
+	if len(routes) != 3 || routes[0].Path != "/users/*" || routes[2].Path != "/v1/users/*" {
+		t.Errorf("Expected kept routes in place and new ones appended, got %v", routes)
+	}
+
+	r.SetPaths("user-service", []string{"/v1/users/*"}, []string{"GET"})
+	if result := r.Match(httptest.NewRequest("GET", "/users/42", nil)); result != nil {
+		t.Error("Expected dropped path to stop matching")
+	}
+}
+
+func TestRouterRoutesSnapshot(t *testing.T) {
+	r := New()
+
+	r.AddRoute("/api/*", "api-service", []string{"GET"})
+	r.AddRoute("/health", "health-service", nil)
+
+	routes := r.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+	if routes[0].ServiceName != "api-service" || routes[1].ServiceName != "health-service" {
+		t.Errorf("Expected routes in match order, got %+v", routes)
+	}
+
+	routes[0].Methods[0] = "DELETE"
+	if result := r.Match(httptest.NewRequest("GET", "/api/test", nil)); result == nil {
+		t.Error("Expected snapshot mutation not to affect the router")
+	}
+}
+
 func TestPathMatching(t *testing.T) {
 	tests := []struct {
 		routePath   string
@@ -232,6 +348,12 @@ func TestPathMatching(t *testing.T) {
 		{"/exact/", "/exact/", true},
 		{"/*", "/anything", true},
 		{"/*", "/", true},
+		{"/users/:id", "/users/42", true},
+		{"/users/:id", "/users/42/orders", false},
+		{"/users/:id", "/users", false},
+		{"/users/:id/*", "/users/42/orders", true},
+		{"/users/:id/*", "/users/42", true},
+		{"/users/:id/*", "/accounts/42", false},
 	}
 
 	for _, tt := range tests {
@@ -250,3 +372,97 @@ func TestPathMatching(t *testing.T) {
 		})
 	}
 }
+
+func TestRouteParams(t *testing.T) {
+	router := New()
+	router.AddRoute("/orgs/:org/users/:id/*", "users", nil)
+
+	route := router.Match(httptest.NewRequest("GET", "/orgs/acme/users/42/profile", nil))
+	if route == nil {
+		t.Fatal("Expected a match")
+	}
+	if route.Params["org"] != "acme" || route.Params["id"] != "42" {
+		t.Errorf("Unexpected params %v", route.Params)
+	}
+	if prefix := route.MatchedPrefix("/orgs/acme/users/42/profile"); prefix != "/orgs/acme/users/42" {
+		t.Errorf("Expected matched prefix /orgs/acme/users/42, got %s", prefix)
+	}
+}
+
+func TestMatchTrace(t *testing.T) {
+	router := New()
+	router.AddRoute("/admin", "admin", []string{"POST"})
+	router.AddRoute("/users/:id", "users", nil)
+	router.AddRoute("/api", "api", nil)
+	router.AddRoute("/*", "default", nil)
+
+	route, trace := router.MatchTrace(httptest.NewRequest("GET", "/users/7", nil))
+	if route == nil || route.ServiceName != "users" || route.Params["id"] != "7" {
+		t.Fatalf("Expected users route with id 7, got %+v", route)
+	}
+
+	want := []string{MatchMethodNotAllowed, MatchMatched, MatchPathMismatch, MatchShadowed}
+	if len(trace) != len(want) {
+		t.Fatalf("Expected %d trace steps, got %d", len(want), len(trace))
+	}
+	for i, step := range trace {
+		if step.Result != want[i] {
+			t.Errorf("Route %s: expected %s, got %s", step.Route.Path, want[i], step.Result)
+		}
+	}
+
+	if plain := router.Match(httptest.NewRequest("GET", "/users/7", nil)); plain == nil || plain.ServiceName != route.ServiceName {
+		t.Errorf("Expected Match to agree with MatchTrace, got %+v", plain)
+	}
+}
+
+func TestMediaTypeRouting(t *testing.T) {
+	router := New()
+	router.AddRoute("/orders/*", "orders", nil)
+	router.AddRoute("/orders/*", "orders-v2", nil)
+	router.SetMediaTypes("orders-v2", []string{"application/vnd.myapp.v2+json"}, nil)
+	router.AddRoute("/reports/*", "reports", nil)
+	router.AddRoute("/reports/*", "reports-csv", nil)
+	router.SetMediaTypes("reports-csv", nil, []string{"text/csv; header=present"})
+
+	tests := []struct {
+		path        string
+		contentType string
+		accept      string
+		want        string
+	}{
+		{"/orders/1", "application/vnd.myapp.v2+json; charset=utf-8", "", "orders-v2"},
+		{"/orders/1", "application/json", "", "orders"},
+		{"/orders/1", "", "", "orders"},
+		{"/reports/q1", "", "text/html, text/csv;header=present;q=0.8", "reports-csv"},
+		{"/reports/q1", "", "text/csv", "reports"},
+		{"/reports/q1", "", "text/csv; header=present; q=0", "reports"},
+		{"/reports/q1", "", "*/*", "reports"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		route := router.Match(req)
+		if route == nil || route.ServiceName != tt.want {
+			t.Errorf("%s (Content-Type %q, Accept %q): expected %s, got %+v", tt.path, tt.contentType, tt.accept, tt.want, route)
+		}
+	}
+
+	// * routes added later for a service with media types keep them
+	router.SetPaths("orders-v2", []string{"/orders/*", "/v2/orders/*"}, nil)
+	req := httptest.NewRequest("GET", "/v2/orders/1", nil)
+	if route := router.Match(req); route != nil {
+		t.Errorf("Expected the new alias to require the v2 media type, got %+v", route)
+	}
+
+	router.SetMediaTypes("orders-v2", nil, nil)
+	req = httptest.NewRequest("GET", "/v2/orders/1", nil)
+	if route := router.Match(req); route == nil || route.ServiceName != "orders-v2" {
+		t.Errorf("Expected clearing media types to match any request, got %+v", route)
+	}
+}